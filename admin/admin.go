@@ -20,15 +20,19 @@ package admin
 //TODO: Consider SO_PEERCRED with Unix Sockets
 
 import (
+	"context"
 	"encoding/json"
 	"io"
 	"net"
 	"os"
 	"reflect"
+	"strings"
 	"sync"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/varddum/syndication/database"
+	"github.com/varddum/syndication/opml"
+	syndsync "github.com/varddum/syndication/sync"
 )
 
 type (
@@ -40,6 +44,7 @@ type (
 		socketPath  string
 		State       chan state
 		db          *database.DB
+		sync        *syndsync.Sync
 		lock        sync.Mutex
 		cmdHandlers map[string]reflect.Value
 		connections []*net.UnixConn
@@ -230,6 +235,61 @@ func (a *Admin) ChangeUserPassword(args args, r *Response) error {
 	return nil
 }
 
+// EnableFever allows a user to sign in to the Fever API with
+// their username and the given password.
+func (a *Admin) EnableFever(args args, r *Response) error {
+	r.Status = BadArgument
+
+	aVal := reflect.ValueOf(args["userID"])
+	if aVal.Kind() != reflect.String {
+		r.Error = "Bad first argument"
+		return nil
+	}
+
+	bVal := reflect.ValueOf(args["password"])
+	if bVal.Kind() != reflect.String {
+		r.Error = "Bad second argument"
+		return nil
+	}
+
+	err := a.db.EnableFever(aVal.String(), bVal.String())
+	if err != nil {
+		dbError := err.(database.DBError)
+		r.Status = DatabaseError
+		r.Error = dbError.Error()
+		return nil
+	}
+
+	r.Status = OK
+	r.Error = "OK"
+
+	return nil
+}
+
+// DisableFever prevents a user from signing in to the Fever API.
+func (a *Admin) DisableFever(args args, r *Response) error {
+	r.Status = BadArgument
+
+	aVal := reflect.ValueOf(args["userID"])
+	if aVal.Kind() != reflect.String {
+		r.Error = "Bad first argument"
+		return nil
+	}
+
+	err := a.db.DisableFever(aVal.String())
+	if err != nil {
+		dbError := err.(database.DBError)
+		r.Status = DatabaseError
+		r.Error = dbError.Error()
+		return nil
+	}
+
+	r.Status = OK
+	r.Error = "OK"
+
+	return nil
+}
+
 // GetUsers returns a list of all existing users.
 func (a *Admin) GetUsers(args args, r *Response) error {
 	r.Status = OK
@@ -267,10 +327,112 @@ func (a *Admin) GetUser(args args, r *Response) error {
 	return nil
 }
 
+// ImportOPML subscribes a user to the feeds in an OPML document.
+func (a *Admin) ImportOPML(args args, r *Response) error {
+	r.Status = BadArgument
+
+	aVal := reflect.ValueOf(args["userID"])
+	if aVal.Kind() != reflect.String {
+		r.Error = "Bad first argument"
+		return nil
+	}
+
+	bVal := reflect.ValueOf(args["opml"])
+	if bVal.Kind() != reflect.String {
+		r.Error = "Bad second argument"
+		return nil
+	}
+
+	user, err := a.db.UserWithAPIID(aVal.String())
+	if err != nil {
+		dbError := err.(database.DBError)
+		r.Status = DatabaseError
+		r.Error = dbError.Error()
+		return nil
+	}
+
+	doc, err := opml.Parse(strings.NewReader(bVal.String()))
+	if err != nil {
+		r.Error = err.Error()
+		return nil
+	}
+
+	r.Result = opml.Import(a.db, doc, &user)
+	r.Status = OK
+	r.Error = "OK"
+
+	return nil
+}
+
+// ExportOPML returns an OPML document of a user's subscriptions.
+func (a *Admin) ExportOPML(args args, r *Response) error {
+	r.Status = BadArgument
+
+	aVal := reflect.ValueOf(args["userID"])
+	if aVal.Kind() != reflect.String {
+		r.Error = "Bad first argument"
+		return nil
+	}
+
+	user, err := a.db.UserWithAPIID(aVal.String())
+	if err != nil {
+		dbError := err.(database.DBError)
+		r.Status = DatabaseError
+		r.Error = dbError.Error()
+		return nil
+	}
+
+	b, err := opml.Marshal(opml.Export(a.db, &user))
+	if err != nil {
+		return err
+	}
+
+	r.Result = string(b)
+	r.Status = OK
+	r.Error = "OK"
+
+	return nil
+}
+
+// GetSyncStats returns the fetch statistics of the sync component.
+func (a *Admin) GetSyncStats(args args, r *Response) error {
+	r.Result = a.sync.Stats()
+	r.Status = OK
+	r.Error = "OK"
+
+	return nil
+}
+
+// PreviewPurge reports how many entries a purge would remove.
+func (a *Admin) PreviewPurge(args args, r *Response) error {
+	return a.purge(true, r)
+}
+
+// PurgeEntries removes the entries that fall outside of their retention policy.
+func (a *Admin) PurgeEntries(args args, r *Response) error {
+	return a.purge(false, r)
+}
+
+func (a *Admin) purge(dryRun bool, r *Response) error {
+	result, err := a.sync.Purge(context.Background(), dryRun)
+	if err != nil {
+		r.Status = DatabaseError
+		r.Error = err.Error()
+		return nil
+	}
+
+	r.Result = result
+	r.Status = OK
+	r.Error = "OK"
+
+	return nil
+}
+
 // NewAdmin creates a new Admin socket and initializes administration handlers
-func NewAdmin(db *database.DB, socketPath string) (a *Admin, err error) {
+func NewAdmin(db *database.DB, sync *syndsync.Sync, socketPath string) (a *Admin, err error) {
 	a = &Admin{
 		db:    db,
+		sync:  sync,
 		State: make(chan state),
 	}
 
@@ -303,6 +465,13 @@ func NewAdmin(db *database.DB, socketPath string) (a *Admin, err error) {
 		"GetUser":            aVal.MethodByName("GetUser"),
 		"ChangeUserName":     aVal.MethodByName("ChangeUserName"),
 		"ChangeUserPassword": aVal.MethodByName("ChangeUserPassword"),
+		"EnableFever":        aVal.MethodByName("EnableFever"),
+		"DisableFever":       aVal.MethodByName("DisableFever"),
+		"ImportOPML":         aVal.MethodByName("ImportOPML"),
+		"ExportOPML":         aVal.MethodByName("ExportOPML"),
+		"GetSyncStats":       aVal.MethodByName("GetSyncStats"),
+		"PreviewPurge":       aVal.MethodByName("PreviewPurge"),
+		"PurgeEntries":       aVal.MethodByName("PurgeEntries"),
 	}
 
 	return
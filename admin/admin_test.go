@@ -29,6 +29,7 @@ import (
 	"github.com/varddum/syndication/config"
 	"github.com/varddum/syndication/database"
 	"github.com/varddum/syndication/models"
+	syndsync "github.com/varddum/syndication/sync"
 )
 
 type (
@@ -54,7 +55,7 @@ func (suite *AdminTestSuite) SetupTest() {
 	suite.Nil(err)
 
 	suite.socketPath = "/tmp/syndication.socket"
-	suite.admin, err = NewAdmin(suite.db, suite.socketPath)
+	suite.admin, err = NewAdmin(suite.db, syndsync.NewSync(suite.db, config.DefaultSyncConfig), suite.socketPath)
 	suite.Require().NotNil(suite.admin)
 	suite.Require().Nil(err)
 
@@ -490,6 +491,69 @@ func (suite *AdminTestSuite) TestChangeUserPassword() {
 	suite.NotEmpty(user.APIID)
 }
 
+func (suite *AdminTestSuite) TestEnableFever() {
+	err := suite.db.NewUser("GoTest", "testtesttest")
+	suite.Require().Nil(err)
+
+	user, err := suite.db.UserWithName("GoTest")
+	suite.Require().Nil(err)
+
+	req := Request{
+		Command: "EnableFever",
+		Arguments: map[string]interface{}{
+			"userID":   user.APIID,
+			"password": "gopher",
+		},
+	}
+
+	b, err := json.Marshal(req)
+	size, err := suite.conn.Write(b)
+	suite.Require().Nil(err)
+	suite.Equal(len(b), size)
+
+	buff := make([]byte, 512)
+	size, err = suite.conn.Read(buff)
+	suite.Require().Nil(err)
+
+	buff = buff[:size]
+
+	resp := &Response{}
+	err = json.Unmarshal(buff, resp)
+	suite.Require().Nil(err)
+	suite.Equal(OK, resp.Status)
+
+	// md5("GoTest:gopher")
+	feverUser, err := suite.db.UserWithFeverKey("dd3f6968042280f4e23130f6f8e8e314")
+	suite.Nil(err)
+	suite.Equal(user.APIID, feverUser.APIID)
+
+	req = Request{
+		Command: "DisableFever",
+		Arguments: map[string]interface{}{
+			"userID": user.APIID,
+		},
+	}
+
+	b, err = json.Marshal(req)
+	size, err = suite.conn.Write(b)
+	suite.Require().Nil(err)
+	suite.Equal(len(b), size)
+
+	buff = make([]byte, 512)
+	size, err = suite.conn.Read(buff)
+	suite.Require().Nil(err)
+
+	buff = buff[:size]
+
+	resp = &Response{}
+	err = json.Unmarshal(buff, resp)
+	suite.Require().Nil(err)
+	suite.Equal(OK, resp.Status)
+
+	_, err = suite.db.UserWithFeverKey("dd3f6968042280f4e23130f6f8e8e314")
+	suite.NotNil(err)
+}
+
 func (suite *AdminTestSuite) TestChangeUserPasswordFirstArgument() {
 	err := suite.db.NewUser("GoTest", "testtesttest")
 	suite.Require().Nil(err)
@@ -554,6 +618,134 @@ func (suite *AdminTestSuite) TestChangeUserPasswordSecondArgument() {
 	suite.Equal("Bad second argument", resp.Error)
 }
 
+func (suite *AdminTestSuite) TestImportOPML() {
+	err := suite.db.NewUser("GoTest", "testtesttest")
+	suite.Require().Nil(err)
+
+	user, err := suite.db.UserWithName("GoTest")
+	suite.Require().Nil(err)
+
+	cmd := Request{
+		Command: "ImportOPML",
+		Arguments: map[string]interface{}{
+			"userID": user.APIID,
+			"opml": `<opml version="2.0"><body>
+				<outline text="Example" type="rss" xmlUrl="http://example.com/feed.xml"/>
+			</body></opml>`,
+		},
+	}
+
+	b, err := json.Marshal(cmd)
+	size, err := suite.conn.Write(b)
+	suite.Require().Nil(err)
+	suite.Equal(len(b), size)
+
+	buff := make([]byte, 4096)
+	size, err = suite.conn.Read(buff)
+	suite.Require().Nil(err)
+
+	resp := &Response{}
+	err = json.Unmarshal(buff[:size], resp)
+	suite.Require().Nil(err)
+	suite.Equal(OK, resp.Status)
+
+	feeds := suite.db.Feeds(&user)
+	suite.Require().Len(feeds, 1)
+	suite.Equal("http://example.com/feed.xml", feeds[0].Subscription)
+}
+
+func (suite *AdminTestSuite) TestExportOPML() {
+	err := suite.db.NewUser("GoTest", "testtesttest")
+	suite.Require().Nil(err)
+
+	user, err := suite.db.UserWithName("GoTest")
+	suite.Require().Nil(err)
+
+	feed := models.Feed{
+		Title:        "Example",
+		Subscription: "http://example.com/feed.xml",
+	}
+
+	err = suite.db.NewFeed(&feed, &user)
+	suite.Require().Nil(err)
+
+	cmd := Request{
+		Command: "ExportOPML",
+		Arguments: map[string]interface{}{
+			"userID": user.APIID,
+		},
+	}
+
+	b, err := json.Marshal(cmd)
+	size, err := suite.conn.Write(b)
+	suite.Require().Nil(err)
+	suite.Equal(len(b), size)
+
+	buff := make([]byte, 4096)
+	size, err = suite.conn.Read(buff)
+	suite.Require().Nil(err)
+
+	resp := &Response{}
+	err = json.Unmarshal(buff[:size], resp)
+	suite.Require().Nil(err)
+	suite.Equal(OK, resp.Status)
+	suite.Contains(resp.Result, "http://example.com/feed.xml")
+}
+
+func (suite *AdminTestSuite) TestGetSyncStats() {
+	message := `{
+		"command": "GetSyncStats"
+	}
+	`
+
+	size, err := suite.conn.Write([]byte(message))
+	suite.Require().Nil(err)
+	suite.Equal(len(message), size)
+
+	buff := make([]byte, 512)
+	size, err = suite.conn.Read(buff)
+	suite.Require().Nil(err)
+
+	type StatsResult struct {
+		Status StatusCode     `json:"status"`
+		Error  string         `json:"Error"`
+		Result syndsync.Stats `json:"result"`
+	}
+
+	result := &StatsResult{}
+	err = json.Unmarshal(buff[:size], result)
+	suite.Require().Nil(err)
+	suite.Equal(OK, result.Status)
+	suite.Zero(result.Result.Fetches)
+}
+
+func (suite *AdminTestSuite) TestPreviewPurge() {
+	message := `{
+		"command": "PreviewPurge"
+	}
+	`
+
+	size, err := suite.conn.Write([]byte(message))
+	suite.Require().Nil(err)
+	suite.Equal(len(message), size)
+
+	buff := make([]byte, 512)
+	size, err = suite.conn.Read(buff)
+	suite.Require().Nil(err)
+
+	type PurgeResult struct {
+		Status StatusCode           `json:"status"`
+		Error  string               `json:"Error"`
+		Result database.PurgeResult `json:"result"`
+	}
+
+	result := &PurgeResult{}
+	err = json.Unmarshal(buff[:size], result)
+	suite.Require().Nil(err)
+	suite.Equal(OK, result.Status)
+	suite.Zero(result.Result.Entries)
+}
+
 func TestAdminTestSuite(t *testing.T) {
 	suite.Run(t, new(AdminTestSuite))
 }
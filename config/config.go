@@ -21,6 +21,7 @@ import (
 	"github.com/BurntSushi/toml"
 	log "github.com/sirupsen/logrus"
 	"io"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
@@ -77,6 +78,47 @@ type (
 	Sync struct {
 		SyncTime     time.Time `toml:"time"`
 		SyncInterval Duration  `toml:"interval"`
+		MinInterval  Duration  `toml:"min_interval"`
+		MaxInterval  Duration  `toml:"max_interval"`
+		DeadAfter    int       `toml:"dead_after"`
+
+		// Default retention policy, where zero keeps entries forever.
+		KeepReadDays      int      `toml:"keep_read_days"`
+		MaxEntriesPerFeed int      `toml:"max_entries_per_feed"`
+		PurgeInterval     Duration `toml:"purge_interval"`
+
+		// WebSubCallback is the public URL of the server that WebSub hubs
+		// push updates to. Feeds are only polled when it is empty.
+		WebSubCallback string `toml:"websub_callback"`
+
+		// Feed icons are cached in IconCacheDir. Icons larger than
+		// IconMaxSize bytes are ignored and the oldest icons are removed
+		// once the cache holds more than IconCacheSize bytes.
+		IconCacheDir  string `toml:"icon_cache_dir"`
+		IconMaxSize   int64  `toml:"icon_max_size"`
+		IconCacheSize int64  `toml:"icon_cache_size"`
+
+		// Fetches from a single host are limited to MaxRequestsPerHost at a
+		// time and start at least HostRequestSpacing apart. Spacing defaults
+		// to a second when it is not set and is turned off when it is set to
+		// zero. Hosts that answer with a Retry-After header are left alone
+		// for at most MaxRetryAfter.
+		MaxRequestsPerHost int       `toml:"max_requests_per_host"`
+		HostRequestSpacing *Duration `toml:"host_request_spacing"`
+		MaxRetryAfter      Duration  `toml:"max_retry_after"`
+
+		// Every fetch has to connect within ConnectTimeout and be read in
+		// full within ReadTimeout. Feeds larger than MaxFeedSize bytes are
+		// rejected. Stopping waits at most StopTimeout for syncs to return.
+		ConnectTimeout Duration `toml:"connect_timeout"`
+		ReadTimeout    Duration `toml:"read_timeout"`
+		MaxFeedSize    int64    `toml:"max_feed_size"`
+		StopTimeout    Duration `toml:"stop_timeout"`
+
+		// Users can ask for at most MaxRefreshes refreshes of their
+		// feeds within RefreshWindow.
+		MaxRefreshes  int      `toml:"max_refreshes"`
+		RefreshWindow Duration `toml:"refresh_window"`
 	}
 
 	// Admin represents configurations applicable to Syndication's admin component.
@@ -123,6 +165,27 @@ var (
 	// DefaultSyncConfig represents the minimum configuration necessary for the sync component.
 	DefaultSyncConfig = Sync{
 		SyncInterval: Duration{time.Minute * 15},
+		MinInterval:  Duration{time.Minute * 5},
+		MaxInterval:  Duration{time.Hour * 24},
+		DeadAfter:    10,
+
+		PurgeInterval: Duration{time.Hour * 24},
+
+		IconCacheDir:  "/var/syndication/icons",
+		IconMaxSize:   256 << 10,
+		IconCacheSize: 64 << 20,
+
+		MaxRequestsPerHost: 2,
+		HostRequestSpacing: &Duration{time.Second},
+		MaxRetryAfter:      Duration{time.Hour * 6},
+
+		ConnectTimeout: Duration{time.Second * 10},
+		ReadTimeout:    Duration{time.Second * 30},
+		MaxFeedSize:    10 << 20,
+		StopTimeout:    Duration{time.Second * 10},
+
+		MaxRefreshes:  5,
+		RefreshWindow: Duration{time.Minute},
 	}
 
 	// DefaultConfig collects all minimum default configurations.
@@ -239,6 +302,122 @@ func (c *Config) parseSync() error {
 		return InvalidFieldValue{"Sync interval should be 5 minutes or greater"}
 	}
 
+	if c.Sync.MinInterval.Duration == 0 {
+		c.Sync.MinInterval = DefaultSyncConfig.MinInterval
+		if c.Sync.MinInterval.Duration > c.Sync.SyncInterval.Duration {
+			c.Sync.MinInterval = c.Sync.SyncInterval
+		}
+	} else if c.Sync.MinInterval.Duration < time.Minute {
+		return InvalidFieldValue{"Minimum sync interval should be 1 minute or greater"}
+	} else if c.Sync.MinInterval.Duration > c.Sync.SyncInterval.Duration {
+		return InvalidFieldValue{"Minimum sync interval should not be greater than the sync interval"}
+	}
+
+	if c.Sync.MaxInterval.Duration == 0 {
+		c.Sync.MaxInterval = DefaultSyncConfig.MaxInterval
+		if c.Sync.MaxInterval.Duration < c.Sync.SyncInterval.Duration {
+			c.Sync.MaxInterval = c.Sync.SyncInterval
+		}
+	} else if c.Sync.MaxInterval.Duration < c.Sync.SyncInterval.Duration {
+		return InvalidFieldValue{"Maximum sync interval should not be less than the sync interval"}
+	}
+
+	if c.Sync.DeadAfter == 0 {
+		c.Sync.DeadAfter = DefaultSyncConfig.DeadAfter
+	} else if c.Sync.DeadAfter < 0 {
+		return InvalidFieldValue{"Sync dead_after should be greater than zero"}
+	}
+
+	if c.Sync.KeepReadDays < 0 || c.Sync.MaxEntriesPerFeed < 0 {
+		return InvalidFieldValue{"Sync retention limits should not be negative"}
+	}
+
+	if c.Sync.PurgeInterval.Duration == 0 {
+		c.Sync.PurgeInterval = DefaultSyncConfig.PurgeInterval
+	} else if c.Sync.PurgeInterval.Duration < time.Minute {
+		return InvalidFieldValue{"Purge interval should be 1 minute or greater"}
+	}
+
+	if c.Sync.WebSubCallback != "" {
+		callback, err := url.Parse(c.Sync.WebSubCallback)
+		if err != nil || (callback.Scheme != "http" && callback.Scheme != "https") || callback.Host == "" {
+			return InvalidFieldValue{"Sync websub_callback should be an absolute http or https URL"}
+		}
+	}
+
+	if c.Sync.IconCacheDir == "" {
+		c.Sync.IconCacheDir = DefaultSyncConfig.IconCacheDir
+	} else if !filepath.IsAbs(c.Sync.IconCacheDir) {
+		return InvalidFieldValue{"Sync icon_cache_dir must be absolute"}
+	}
+
+	if c.Sync.IconMaxSize == 0 {
+		c.Sync.IconMaxSize = DefaultSyncConfig.IconMaxSize
+	}
+
+	if c.Sync.IconCacheSize == 0 {
+		c.Sync.IconCacheSize = DefaultSyncConfig.IconCacheSize
+	}
+
+	if c.Sync.IconMaxSize < 0 || c.Sync.IconCacheSize < c.Sync.IconMaxSize {
+		return InvalidFieldValue{"Sync icon_cache_size should not be less than icon_max_size"}
+	}
+
+	if c.Sync.MaxRequestsPerHost == 0 {
+		c.Sync.MaxRequestsPerHost = DefaultSyncConfig.MaxRequestsPerHost
+	} else if c.Sync.MaxRequestsPerHost < 0 {
+		return InvalidFieldValue{"Sync max_requests_per_host should be greater than zero"}
+	}
+
+	if c.Sync.HostRequestSpacing == nil {
+		spacing := *DefaultSyncConfig.HostRequestSpacing
+		c.Sync.HostRequestSpacing = &spacing
+	} else if c.Sync.HostRequestSpacing.Duration < 0 {
+		return InvalidFieldValue{"Sync host_request_spacing should not be negative"}
+	}
+
+	if c.Sync.MaxRetryAfter.Duration == 0 {
+		c.Sync.MaxRetryAfter = DefaultSyncConfig.MaxRetryAfter
+	} else if c.Sync.MaxRetryAfter.Duration < time.Minute {
+		return InvalidFieldValue{"Sync max_retry_after should be 1 minute or greater"}
+	}
+
+	if c.Sync.ConnectTimeout.Duration == 0 {
+		c.Sync.ConnectTimeout = DefaultSyncConfig.ConnectTimeout
+	}
+
+	if c.Sync.ReadTimeout.Duration == 0 {
+		c.Sync.ReadTimeout = DefaultSyncConfig.ReadTimeout
+	}
+
+	if c.Sync.ConnectTimeout.Duration < 0 || c.Sync.ReadTimeout.Duration < 0 {
+		return InvalidFieldValue{"Sync connect_timeout and read_timeout should not be negative"}
+	}
+
+	if c.Sync.MaxFeedSize == 0 {
+		c.Sync.MaxFeedSize = DefaultSyncConfig.MaxFeedSize
+	} else if c.Sync.MaxFeedSize < 0 {
+		return InvalidFieldValue{"Sync max_feed_size should be greater than zero"}
+	}
+
+	if c.Sync.StopTimeout.Duration == 0 {
+		c.Sync.StopTimeout = DefaultSyncConfig.StopTimeout
+	} else if c.Sync.StopTimeout.Duration < 0 {
+		return InvalidFieldValue{"Sync stop_timeout should not be negative"}
+	}
+
+	if c.Sync.MaxRefreshes == 0 {
+		c.Sync.MaxRefreshes = DefaultSyncConfig.MaxRefreshes
+	}
+
+	if c.Sync.RefreshWindow.Duration == 0 {
+		c.Sync.RefreshWindow = DefaultSyncConfig.RefreshWindow
+	}
+
+	if c.Sync.MaxRefreshes < 0 || c.Sync.RefreshWindow.Duration < 0 {
+		return InvalidFieldValue{"Sync max_refreshes and refresh_window should not be negative"}
+	}
+
 	return nil
 }
 
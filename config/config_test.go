@@ -145,11 +145,63 @@ func (suite *ConfigTestSuite) TestSyncConfig() {
 	suite.Require().Nil(err)
 }
 
+func (suite *ConfigTestSuite) TestHostRequestSpacing() {
+	config, err := NewConfig("simple_sync.toml")
+	suite.Require().Nil(err)
+	suite.Require().NotNil(config.Sync.HostRequestSpacing)
+	suite.Equal(DefaultSyncConfig.HostRequestSpacing.Duration, config.Sync.HostRequestSpacing.Duration)
+
+	config, err = NewConfig("sync_no_spacing.toml")
+	suite.Require().Nil(err)
+	suite.Require().NotNil(config.Sync.HostRequestSpacing)
+	suite.Zero(config.Sync.HostRequestSpacing.Duration)
+}
+
 func (suite *ConfigTestSuite) TestShortSyncInterval() {
 	_, err := NewConfig("invalid_sync.toml")
 	suite.Require().NotNil(err)
 }
 
+func (suite *ConfigTestSuite) TestInvalidSyncIntervalBounds() {
+	_, err := NewConfig("invalid_sync_bounds.toml")
+	suite.Require().NotNil(err)
+}
+
+func (suite *ConfigTestSuite) TestInvalidSyncDeadAfter() {
+	_, err := NewConfig("invalid_sync_dead_after.toml")
+	suite.Require().NotNil(err)
+}
+
+func (suite *ConfigTestSuite) TestInvalidSyncRetention() {
+	_, err := NewConfig("invalid_sync_retention.toml")
+	suite.Require().NotNil(err)
+}
+
+func (suite *ConfigTestSuite) TestInvalidSyncWebSubCallback() {
+	_, err := NewConfig("invalid_sync_websub.toml")
+	suite.Require().NotNil(err)
+}
+
+func (suite *ConfigTestSuite) TestInvalidSyncIconCache() {
+	_, err := NewConfig("invalid_sync_icons.toml")
+	suite.Require().NotNil(err)
+}
+
+func (suite *ConfigTestSuite) TestInvalidSyncHostLimits() {
+	_, err := NewConfig("invalid_sync_hosts.toml")
+	suite.Require().NotNil(err)
+}
+
+func (suite *ConfigTestSuite) TestInvalidSyncTimeouts() {
+	_, err := NewConfig("invalid_sync_timeouts.toml")
+	suite.Require().NotNil(err)
+}
+
+func (suite *ConfigTestSuite) TestInvalidSyncRefreshes() {
+	_, err := NewConfig("invalid_sync_refreshes.toml")
+	suite.Require().NotNil(err)
+}
+
 func (suite *ConfigTestSuite) TestInvalidAdmin() {
 	_, err := NewConfig("invalid_admin.toml")
 	suite.Require().NotNil(err)
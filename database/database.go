@@ -25,6 +25,7 @@ import (
 	"io"
 	mathRand "math/rand"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
@@ -45,10 +46,23 @@ const (
 	PWHashBytes = 64
 )
 
+// maxGUIDBatch bounds the number of guids looked up in one query,
+// keeping it below the number of variables SQLite allows.
+const maxGUIDBatch = 500
+
 // DB represents a connectin to a SQL database
 type DB struct {
 	db     *gorm.DB
 	config config.Database
+	search searchIndex
+}
+
+// Page describes a window into a list of Entries. A zero Limit
+// returns every remaining entry and an empty Cursor starts
+// from the first entry.
+type Page struct {
+	Limit  int
+	Cursor string
 }
 
 // DBError identifies error caused by database queries
@@ -90,26 +104,43 @@ type (
 )
 
 // NewDB creates a new DB instance
-func NewDB(conf config.Database) (db *DB, err error) {
+func NewDB(conf config.Database) (*DB, error) {
 	gormDB, err := gorm.Open(conf.Type, conf.Connection)
 	if err != nil {
-		return
+		return nil, err
+	}
+
+	err = gormDB.AutoMigrate(
+		&models.Feed{},
+		&models.Category{},
+		&models.User{},
+		&models.Entry{},
+		&models.Tag{},
+		&models.APIKey{},
+		&models.PurgedEntry{},
+		&models.Publication{},
+		&models.Rule{},
+		&models.Enclosure{},
+	).Error
+	if err != nil {
+		gormDB.Close()
+		return nil, err
 	}
 
-	db = &DB{
+	db := &DB{
+		db:     gormDB,
 		config: conf,
+		search: newSearchIndex(conf.Type),
 	}
 
-	gormDB.AutoMigrate(&models.Feed{})
-	gormDB.AutoMigrate(&models.Category{})
-	gormDB.AutoMigrate(&models.User{})
-	gormDB.AutoMigrate(&models.Entry{})
-	gormDB.AutoMigrate(&models.Tag{})
-	gormDB.AutoMigrate(&models.APIKey{})
-
-	db.db = gormDB
+	if db.search != nil {
+		if err = db.search.create(gormDB); err != nil {
+			gormDB.Close()
+			return nil, err
+		}
+	}
 
-	return
+	return db, nil
 }
 
 var lastTimeIDWasCreated int64
@@ -261,7 +292,7 @@ func (db *DB) UserWithAPIID(apiID string) (user models.User, err error) {
 
 // EntryWithAPIID returns an Entry with id that belongs to user
 func (db *DB) EntryWithAPIID(apiID string, user *models.User) (entry models.Entry, err error) {
-	if db.db.Model(user).First(&entry, "api_id = ?", apiID).RecordNotFound() {
+	if db.db.Model(user).Where("api_id = ?", apiID).Related(&entry).RecordNotFound() {
 		err = NotFound{"Entry does not exist"}
 	}
 	return
@@ -383,6 +414,7 @@ func (db *DB) DeleteFeed(id string, user *models.User) error {
 	foundFeed := &models.Feed{}
 	if !db.db.Model(user).Where("api_id = ?", id).Related(foundFeed).RecordNotFound() {
 		db.db.Delete(foundFeed)
+		db.db.Where("feed_id = ?", foundFeed.ID).Delete(&models.PurgedEntry{})
 		return nil
 	}
 	return NotFound{"Feed does not exist"}
@@ -399,6 +431,87 @@ func (db *DB) EditFeed(feed *models.Feed, user *models.User) error {
 	return NotFound{"Feed does not exist"}
 }
 
+// EditFeedSyncState saves the attributes of a Feed owned by user
+// that are maintained by the sync component.
+func (db *DB) EditFeedSyncState(feed *models.Feed, user *models.User) error {
+	foundFeed := &models.Feed{}
+	if db.db.Model(user).Where("api_id = ?", feed.APIID).Related(foundFeed).RecordNotFound() {
+		return NotFound{"Feed does not exist"}
+	}
+
+	db.db.Model(foundFeed).Updates(map[string]interface{}{
+		"subscription":   feed.Subscription,
+		"status":         feed.Status,
+		"description":    feed.Description,
+		"source":         feed.Source,
+		"ttl":            feed.TTL,
+		"etag":           feed.Etag,
+		"last_modified":  feed.LastModified,
+		"content_length": feed.ContentLength,
+		"last_updated":   feed.LastUpdated,
+		"next_sync":      feed.NextSync,
+		"sync_interval":  feed.SyncInterval,
+
+		"last_fetched":     feed.LastFetched,
+		"last_success":     feed.LastSuccess,
+		"failures":         feed.Failures,
+		"last_status_code": feed.LastStatusCode,
+		"last_error":       feed.LastError,
+
+		"hub":               feed.Hub,
+		"hub_topic":         feed.HubTopic,
+		"hub_callback":      feed.HubCallback,
+		"hub_secret":        feed.HubSecret,
+		"hub_lease_expires": feed.HubLeaseExpires,
+		"hub_lease_renews":  feed.HubLeaseRenews,
+		"hub_retry_at":      feed.HubRetryAt,
+
+		"image_url":       feed.ImageURL,
+		"icon":            feed.Icon,
+		"icon_type":       feed.IconType,
+		"icon_checked_at": feed.IconCheckedAt,
+	})
+	return nil
+}
+
+// FeedWithHubCallback returns the Feed that receives WebSub pushes
+// at callback and the User that owns it
+func (db *DB) FeedWithHubCallback(callback string) (feed models.Feed, user models.User, err error) {
+	if callback == "" || db.db.First(&feed, "hub_callback = ?", callback).RecordNotFound() {
+		err = NotFound{"Feed does not exist"}
+		return
+	}
+
+	if db.db.First(&user, feed.UserID).RecordNotFound() {
+		err = NotFound{"User does not exist"}
+	}
+	return
+}
+
+// ResetFeed clears the failures of a Feed owned by user, revives it
+// if it was dead and makes it due for syncing.
+func (db *DB) ResetFeed(id string, user *models.User) (feed models.Feed, err error) {
+	if db.db.Model(user).Where("api_id = ?", id).Related(&feed).RecordNotFound() {
+		err = NotFound{"Feed does not exist"}
+		return
+	}
+
+	updates := map[string]interface{}{
+		"failures":      0,
+		"last_error":    "",
+		"next_sync":     time.Time{},
+		"sync_interval": 0,
+	}
+
+	if feed.Status == models.Dead {
+		updates["status"] = ""
+	}
+
+	db.db.Model(&feed).Updates(updates)
+	db.db.Model(&feed).Related(&feed.Category)
+	return
+}
+
 // NewCategory creates a new Category object owned by user
 func (db *DB) NewCategory(ctg *models.Category, user *models.User) error {
 	if ctg.Name == "" {
@@ -494,6 +607,7 @@ func (db *DB) NewEntry(entry *models.Entry, user *models.User) error {
 	entry.APIID = createAPIID()
 	entry.Feed = feed
 	entry.FeedID = feed.ID
+	prepareEnclosures(entry, user)
 
 	db.db.Model(user).Association("Entries").Append(entry)
 	db.db.Model(&feed).Association("Entries").Append(entry)
@@ -502,7 +616,8 @@ func (db *DB) NewEntry(entry *models.Entry, user *models.User) error {
 }
 
 // NewEntries creates multiple new Entry objects which
-// are all owned by feed with feedAPIID and user
+// are all owned by feed with feedAPIID and user. The entries
+// are inserted in a single transaction.
 func (db *DB) NewEntries(entries []models.Entry, feed *models.Feed, user *models.User) error {
 	if feed.APIID == "" {
 		return BadRequest{"Entry should have a feed"}
@@ -516,11 +631,27 @@ func (db *DB) NewEntries(entries []models.Entry, feed *models.Feed, user *models
 		return NotFound{"Feed does not exist"}
 	}
 
+	now := time.Now()
+	tx := db.db.Begin()
 	for _, entry := range entries {
 		entry.APIID = createAPIID()
+		entry.UserID = user.ID
+		entry.FeedID = feed.ID
+		prepareEnclosures(&entry, user)
 
-		db.db.Model(user).Association("Entries").Append(&entry)
-		db.db.Model(feed).Association("Entries").Append(&entry)
+		// Entries that rules marked as read were read as they arrived.
+		if entry.Mark == models.Read {
+			entry.MarkedAt = now
+		}
+
+		if err := tx.Create(&entry).Error; err != nil {
+			tx.Rollback()
+			return InternalError{"Failed to store the entries of the feed"}
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return InternalError{"Failed to store the entries of the feed"}
 	}
 
 	return nil
@@ -534,21 +665,71 @@ func (db *DB) Entry(id string, user *models.User) (entry models.Entry, err error
 	}
 
 	db.db.Model(&entry).Related(&entry.Feed)
+	db.db.Model(&entry).Related(&entry.Enclosures)
 	return
 }
 
-// EntryWithGUIDExists returns true if an Entry exists with the given guid and is owned by user
+// EntryWithGUIDExists returns true if an Entry with the given guid is owned by user or was purged from the feed
 func (db *DB) EntryWithGUIDExists(guid string, feedID string, user *models.User) (bool, error) {
 	feed := &models.Feed{}
 	if db.db.Model(user).Where("api_id = ?", feedID).Related(feed).RecordNotFound() {
 		return true, NotFound{"Feed does not exist"}
 	}
 
-	return !db.db.Model(user).Where("guid = ? AND feed_id = ?", guid, feed.ID).Related(&models.Entry{}).RecordNotFound(), nil
+	if !db.db.Model(user).Where("guid = ? AND feed_id = ?", guid, feed.ID).Related(&models.Entry{}).RecordNotFound() {
+		return true, nil
+	}
+
+	return !db.db.Where("guid = ? AND feed_id = ?", guid, feed.ID).First(&models.PurgedEntry{}).RecordNotFound(), nil
+}
+
+// EntryGUIDs returns the guids, out of guids, of the Entries owned by user
+// in the feed with feedID or purged from it. It makes a query per batch
+// of guids instead of one per guid like EntryWithGUIDExists.
+func (db *DB) EntryGUIDs(guids []string, feedID string, user *models.User) (map[string]bool, error) {
+	feed := &models.Feed{}
+	if db.db.Model(user).Where("api_id = ?", feedID).Related(feed).RecordNotFound() {
+		return nil, NotFound{"Feed does not exist"}
+	}
+
+	found := make(map[string]bool, len(guids))
+	for start := 0; start < len(guids); start += maxGUIDBatch {
+		end := start + maxGUIDBatch
+		if end > len(guids) {
+			end = len(guids)
+		}
+
+		var stored, purged []string
+		err := db.db.Model(&models.Entry{}).
+			Where("user_id = ? AND feed_id = ? AND guid in (?)", user.ID, feed.ID, guids[start:end]).
+			Pluck("guid", &stored).Error
+		if err == nil {
+			err = db.db.Model(&models.PurgedEntry{}).
+				Where("feed_id = ? AND guid in (?)", feed.ID, guids[start:end]).
+				Pluck("guid", &purged).Error
+		}
+
+		if err != nil {
+			return nil, InternalError{"Failed to look up the entries of the feed"}
+		}
+
+		for _, guid := range append(stored, purged...) {
+			found[guid] = true
+		}
+	}
+
+	return found, nil
 }
 
 // Entries returns a list of all entries owned by user
 func (db *DB) Entries(orderByNewest bool, marker models.Marker, user *models.User) (entries []models.Entry, err error) {
+	entries, _, err = db.EntriesPage(Page{}, orderByNewest, marker, false, user)
+	return
+}
+
+// EntriesPage returns a page of entries owned by user and the cursor of the following page.
+// The returned cursor is empty when there are no more entries.
+func (db *DB) EntriesPage(page Page, orderByNewest bool, marker models.Marker, savedOnly bool, user *models.User) (entries []models.Entry, next string, err error) {
 	if marker == models.None {
 		err = BadRequest{"Request should include a valid marker"}
 		return
@@ -559,18 +740,31 @@ func (db *DB) Entries(orderByNewest bool, marker models.Marker, user *models.Use
 		query = query.Where("mark = ?", marker)
 	}
 
-	if orderByNewest {
-		query = query.Order("published DESC")
-	} else {
-		query = query.Order("published ASC")
+	if savedOnly {
+		query = query.Where("saved = ?", true)
+	}
+
+	query, err = paginate(query, page, orderByNewest)
+	if err != nil {
+		return
 	}
 
 	query.Association("Entries").Find(&entries)
+
+	entries, next = nextPage(entries, page)
+	db.loadEnclosures(entries)
 	return
 }
 
 // EntriesFromFeed returns all Entries that belong to a feed with feedID
 func (db *DB) EntriesFromFeed(feedID string, orderByNewest bool, marker models.Marker, user *models.User) (entries []models.Entry, err error) {
+	entries, _, err = db.EntriesFromFeedPage(feedID, Page{}, orderByNewest, marker, false, user)
+	return
+}
+
+// EntriesFromFeedPage returns a page of Entries that belong to a feed with feedID
+// and the cursor of the following page.
+func (db *DB) EntriesFromFeedPage(feedID string, page Page, orderByNewest bool, marker models.Marker, savedOnly bool, user *models.User) (entries []models.Entry, next string, err error) {
 	if marker == models.None {
 		err = BadRequest{"Request should include a valid marker"}
 		return
@@ -587,19 +781,31 @@ func (db *DB) EntriesFromFeed(feedID string, orderByNewest bool, marker models.M
 		query = query.Where("mark = ?", marker)
 	}
 
-	if orderByNewest {
-		query = query.Order("published DESC")
-	} else {
-		query = query.Order("published ASC")
+	if savedOnly {
+		query = query.Where("saved = ?", true)
+	}
+
+	query, err = paginate(query, page, orderByNewest)
+	if err != nil {
+		return
 	}
 
 	query.Where("feed_id = ?", feed.ID).Association("Entries").Find(&entries)
 
+	entries, next = nextPage(entries, page)
+	db.loadEnclosures(entries)
 	return
 }
 
 // EntriesFromCategory returns all Entries that are related to a Category with categoryID by the entries' owning Feed
 func (db *DB) EntriesFromCategory(categoryID string, orderByNewest bool, marker models.Marker, user *models.User) (entries []models.Entry, err error) {
+	entries, _, err = db.EntriesFromCategoryPage(categoryID, Page{}, orderByNewest, marker, false, user)
+	return
+}
+
+// EntriesFromCategoryPage returns a page of Entries that are related to a Category with categoryID
+// and the cursor of the following page.
+func (db *DB) EntriesFromCategoryPage(categoryID string, page Page, orderByNewest bool, marker models.Marker, savedOnly bool, user *models.User) (entries []models.Entry, next string, err error) {
 	if marker == models.None {
 		err = BadRequest{"Request should include a valid marker"}
 		return
@@ -619,10 +825,13 @@ func (db *DB) EntriesFromCategory(categoryID string, orderByNewest bool, marker
 		query = query.Where("mark = ?", marker)
 	}
 
-	if orderByNewest {
-		query = query.Order("published DESC")
-	} else {
-		query = query.Order("published ASC")
+	if savedOnly {
+		query = query.Where("saved = ?", true)
+	}
+
+	query, err = paginate(query, page, orderByNewest)
+	if err != nil {
+		return
 	}
 
 	feedIds := make([]uint, len(feeds))
@@ -631,6 +840,89 @@ func (db *DB) EntriesFromCategory(categoryID string, orderByNewest bool, marker
 	}
 
 	query.Where("feed_id in (?)", feedIds).Association("Entries").Find(&entries)
+
+	entries, next = nextPage(entries, page)
+	db.loadEnclosures(entries)
+	return
+}
+
+// paginate orders query by published time and ID and restricts it to the page
+// that follows page.Cursor. One extra entry is requested so that nextPage can
+// tell whether another page exists.
+func paginate(query *gorm.DB, page Page, orderByNewest bool) (*gorm.DB, error) {
+	if page.Limit < 0 {
+		return nil, BadRequest{"Page limit should not be negative"}
+	}
+
+	if orderByNewest {
+		query = query.Order("published DESC").Order("id DESC")
+	} else {
+		query = query.Order("published ASC").Order("id ASC")
+	}
+
+	if page.Cursor != "" {
+		published, id, err := decodeCursor(page.Cursor)
+		if err != nil {
+			return nil, err
+		}
+
+		if orderByNewest {
+			query = query.Where("published < ? OR (published = ? AND id < ?)", published, published, id)
+		} else {
+			query = query.Where("published > ? OR (published = ? AND id > ?)", published, published, id)
+		}
+	}
+
+	if page.Limit > 0 {
+		query = query.Limit(page.Limit + 1)
+	}
+
+	return query, nil
+}
+
+// nextPage trims the extra entry requested by paginate and
+// returns the cursor that points past the last entry kept.
+func nextPage(entries []models.Entry, page Page) ([]models.Entry, string) {
+	if page.Limit == 0 || len(entries) <= page.Limit {
+		return entries, ""
+	}
+
+	entries = entries[:page.Limit]
+	last := entries[len(entries)-1]
+	return entries, encodeCursor(last.Published, last.ID)
+}
+
+func encodeCursor(published time.Time, id uint) string {
+	value := published.Format(time.RFC3339Nano) + "|" + strconv.FormatUint(uint64(id), 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(value))
+}
+
+func decodeCursor(cursor string) (published time.Time, id uint, err error) {
+	value, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		err = BadRequest{"Invalid page cursor"}
+		return
+	}
+
+	parts := strings.SplitN(string(value), "|", 2)
+	if len(parts) != 2 {
+		err = BadRequest{"Invalid page cursor"}
+		return
+	}
+
+	published, err = time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		err = BadRequest{"Invalid page cursor"}
+		return
+	}
+
+	parsedID, err := strconv.ParseUint(parts[1], 10, 32)
+	if err != nil {
+		err = BadRequest{"Invalid page cursor"}
+		return
+	}
+
+	id = uint(parsedID)
 	return
 }
 
@@ -693,8 +985,40 @@ func (db *DB) TagEntries(tagID string, entries []string, user *models.User) erro
 	return nil
 }
 
+// UntagEntries removes the tag with tagID from entries
+func (db *DB) UntagEntries(tagID string, entries []string, user *models.User) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	tag := &models.Tag{}
+	if db.db.Model(user).Where("api_id = ?", tagID).Related(tag).RecordNotFound() {
+		return NotFound{"Tag does not exist"}
+	}
+
+	dbEntries := make([]models.Entry, len(entries))
+	for i, entry := range entries {
+		dbEntry, err := db.EntryWithAPIID(entry, user)
+		if err != nil {
+			return err
+		}
+
+		dbEntries[i] = dbEntry
+	}
+
+	db.db.Model(tag).Association("Entries").Delete(dbEntries)
+	return nil
+}
+
 // EntriesFromTag returns all Entries which are tagged with tagID
 func (db *DB) EntriesFromTag(tagID string, marker models.Marker, orderByNewest bool, user *models.User) (entries []models.Entry, err error) {
+	entries, _, err = db.EntriesFromTagPage(tagID, Page{}, marker, false, orderByNewest, user)
+	return
+}
+
+// EntriesFromTagPage returns a page of Entries which are tagged with tagID
+// and the cursor of the following page.
+func (db *DB) EntriesFromTagPage(tagID string, page Page, marker models.Marker, savedOnly bool, orderByNewest bool, user *models.User) (entries []models.Entry, next string, err error) {
 	if marker == models.None {
 		err = BadRequest{"Request should include a valid marker"}
 		return
@@ -711,14 +1035,19 @@ func (db *DB) EntriesFromTag(tagID string, marker models.Marker, orderByNewest b
 		query = query.Where("mark = ?", marker)
 	}
 
-	if orderByNewest {
-		query = query.Order("published DESC")
-	} else {
-		query = query.Order("published ASC")
+	if savedOnly {
+		query = query.Where("saved = ?", true)
+	}
+
+	query, err = paginate(query, page, orderByNewest)
+	if err != nil {
+		return
 	}
 
 	query.Association("Entries").Find(&entries)
 
+	entries, next = nextPage(entries, page)
+	db.loadEnclosures(entries)
 	return
 }
 
@@ -841,42 +1170,45 @@ func (db *DB) Stats(user *models.User) (stats models.Stats) {
 
 // MarkFeed applies marker to a Feed with id and owned by user
 func (db *DB) MarkFeed(id string, marker models.Marker, user *models.User) error {
-	feed, err := db.Feed(id, user)
-	if err != nil {
-		return err
-	}
-
-	db.db.Model(&models.Entry{}).Where("user_id = ? AND feed_id = ?", user.ID, feed.ID).Update(models.Entry{Mark: marker})
-	return nil
+	_, err := db.MarkFeedEntries(id, marker, MarkBounds{}, user)
+	return err
 }
 
 // MarkCategory applies marker to a category with id and owned by user
 func (db *DB) MarkCategory(id string, marker models.Marker, user *models.User) error {
-	ctg, err := db.Category(id, user)
+	_, err := db.MarkCategoryEntries(id, marker, MarkBounds{}, user)
+	return err
+}
+
+// MarkEntry applies marker to an entry with id and owned by user
+func (db *DB) MarkEntry(id string, marker models.Marker, user *models.User) error {
+	entry, err := db.Entry(id, user)
 	if err != nil {
 		return err
 	}
 
-	var feeds []models.Feed
-	db.db.Model(&ctg).Association("Feeds").Find(&feeds)
+	db.db.Model(&entry).Update(models.Entry{Mark: marker, MarkedAt: time.Now()})
+	return nil
+}
 
-	feedIds := make([]uint, len(feeds))
-	for i, feed := range feeds {
-		feedIds[i] = feed.ID
-	}
+// SaveEntry marks an Entry owned by user as saved. Saved
+// entries are kept regardless of their age.
+func (db *DB) SaveEntry(id string, user *models.User) error {
+	return db.changeEntrySaved(id, true, user)
+}
 
-	db.db.Model(&models.Entry{}).Where("user_id = ?", user.ID).Where("feed_id in (?)", feedIds).Update(models.Entry{Mark: marker})
-	return nil
+// UnsaveEntry removes the saved mark of an Entry owned by user.
+func (db *DB) UnsaveEntry(id string, user *models.User) error {
+	return db.changeEntrySaved(id, false, user)
 }
 
-// MarkEntry applies marker to an entry with id and owned by user
-func (db *DB) MarkEntry(id string, marker models.Marker, user *models.User) error {
+func (db *DB) changeEntrySaved(id string, saved bool, user *models.User) error {
 	entry, err := db.Entry(id, user)
 	if err != nil {
 		return err
 	}
 
-	db.db.Model(&entry).Update(models.Entry{Mark: marker})
+	db.db.Model(&entry).Update("saved", saved)
 	return nil
 }
 
@@ -888,6 +1220,10 @@ func (db *DB) DeleteAll() {
 	db.db.Delete(&models.Entry{})
 	db.db.Delete(&models.Tag{})
 	db.db.Delete(&models.APIKey{})
+	db.db.Delete(&models.PurgedEntry{})
+	db.db.Delete(&models.Publication{})
+	db.db.Delete(&models.Rule{})
+	db.db.Delete(&models.Enclosure{})
 }
 
 func (e Conflict) Error() string {
@@ -18,8 +18,10 @@
 package database
 
 import (
+	"context"
 	"os"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -334,6 +336,65 @@ func (suite *DatabaseTestSuite) TestFeeds() {
 	suite.Len(feeds, 5)
 }
 
+func (suite *DatabaseTestSuite) TestSharedFeeds() {
+	err := suite.db.NewUser("other", "golang")
+	suite.Require().Nil(err)
+
+	other, err := suite.db.UserWithName("other")
+	suite.Require().Nil(err)
+
+	for _, user := range []*models.User{&suite.user, &other} {
+		err = suite.db.NewFeed(&models.Feed{Title: "Shared", Subscription: "http://example.com/shared.xml"}, user)
+		suite.Require().Nil(err)
+	}
+
+	err = suite.db.NewFeed(&models.Feed{Title: "Own", Subscription: "http://example.com/own.xml"}, &other)
+	suite.Require().Nil(err)
+
+	shared := suite.db.SharedFeeds()
+	suite.Require().Len(shared, 2)
+
+	suite.Equal("http://example.com/own.xml", shared[0].Subscription)
+	suite.Require().Len(shared[0].Subscribers, 1)
+	suite.Equal(other.ID, shared[0].Subscribers[0].User.ID)
+
+	suite.Equal("http://example.com/shared.xml", shared[1].Subscription)
+	suite.Require().Len(shared[1].Subscribers, 2)
+	suite.Equal(suite.user.ID, shared[1].Subscribers[0].User.ID)
+	suite.Equal(other.ID, shared[1].Subscribers[1].User.ID)
+	suite.NotEqual(shared[1].Subscribers[0].Feed.APIID, shared[1].Subscribers[1].Feed.APIID)
+}
+
+func (suite *DatabaseTestSuite) TestHubSubscribers() {
+	err := suite.db.NewUser("other", "golang")
+	suite.Require().Nil(err)
+
+	other, err := suite.db.UserWithName("other")
+	suite.Require().Nil(err)
+
+	for _, user := range []*models.User{&suite.user, &other} {
+		feed := models.Feed{Title: "Shared", Subscription: "http://example.com/shared.xml"}
+		err = suite.db.NewFeed(&feed, user)
+		suite.Require().Nil(err)
+
+		feed.HubCallback = "callback"
+		err = suite.db.EditFeedSyncState(&feed, user)
+		suite.Require().Nil(err)
+	}
+
+	subscribers, err := suite.db.HubSubscribers("callback")
+	suite.Require().Nil(err)
+	suite.Require().Len(subscribers, 2)
+	suite.Equal(suite.user.ID, subscribers[0].User.ID)
+	suite.Equal(other.ID, subscribers[1].User.ID)
+
+	_, err = suite.db.HubSubscribers("bogus")
+	suite.IsType(NotFound{}, err)
+
+	_, err = suite.db.HubSubscribers("")
+	suite.IsType(NotFound{}, err)
+}
+
 func (suite *DatabaseTestSuite) TestEditFeed() {
 	feed := models.Feed{
 		Title:        "Test site",
@@ -474,6 +535,60 @@ func (suite *DatabaseTestSuite) TestTagEntries() {
 	suite.Len(taggedEntries, 5)
 }
 
+func (suite *DatabaseTestSuite) TestUntagEntries() {
+	feed := models.Feed{
+		Title:        "Test site",
+		Subscription: "http://example.com",
+	}
+
+	err := suite.db.NewFeed(&feed, &suite.user)
+	suite.Require().Nil(err)
+
+	var entries []models.Entry
+	for i := 0; i < 5; i++ {
+		entry := models.Entry{
+			Title:  "Test Entry",
+			Author: "varddum",
+			Link:   "http://example.com",
+			Mark:   models.Unread,
+			Feed:   feed,
+		}
+
+		entries = append(entries, entry)
+	}
+
+	err = suite.db.NewEntries(entries, &feed, &suite.user)
+	suite.Require().Nil(err)
+
+	entries, err = suite.db.EntriesFromFeed(feed.APIID, true, models.Any, &suite.user)
+	suite.Require().Nil(err)
+
+	entryAPIIDs := make([]string, len(entries))
+	for i, entry := range entries {
+		entryAPIIDs[i] = entry.APIID
+	}
+
+	tag := models.Tag{
+		Name: "Tech",
+	}
+
+	err = suite.db.NewTag(&tag, &suite.user)
+	suite.Require().Nil(err)
+
+	err = suite.db.TagEntries(tag.APIID, entryAPIIDs, &suite.user)
+	suite.Require().Nil(err)
+
+	err = suite.db.UntagEntries(tag.APIID, entryAPIIDs[:2], &suite.user)
+	suite.Nil(err)
+
+	taggedEntries, err := suite.db.EntriesFromTag(tag.APIID, models.Any, true, &suite.user)
+	suite.Nil(err)
+	suite.Len(taggedEntries, 3)
+
+	err = suite.db.UntagEntries("bogus", entryAPIIDs, &suite.user)
+	suite.IsType(NotFound{}, err)
+}
+
 func (suite *DatabaseTestSuite) TestTagMultipleEntries() {
 	feed := models.Feed{
 		Title:        "Test site",
@@ -850,7 +965,7 @@ func (suite *DatabaseTestSuite) TestEntriesFromFeedWithNoneMarker() {
 	suite.NotNil(err)
 }
 
-func (suite *DatabaseTestSuite) TestEntryWithGUIDExists() {
+func (suite *DatabaseTestSuite) TestEntriesFromFeedPage() {
 	feed := models.Feed{
 		Title:        "Test site",
 		Subscription: "http://example.com",
@@ -859,192 +974,583 @@ func (suite *DatabaseTestSuite) TestEntryWithGUIDExists() {
 	err := suite.db.NewFeed(&feed, &suite.user)
 	suite.Require().Nil(err)
 
-	entry := models.Entry{
-		Title:     "Test Entry",
-		GUID:      "entry@test",
-		Feed:      feed,
-		Published: time.Now(),
+	published := time.Now()
+	for i := 0; i < 5; i++ {
+		entry := models.Entry{
+			Title:     "Test Entry " + strconv.Itoa(i),
+			Mark:      models.Unread,
+			Feed:      feed,
+			Published: published,
+		}
+
+		err = suite.db.NewEntry(&entry, &suite.user)
+		suite.Require().Nil(err)
 	}
 
-	err = suite.db.NewEntry(&entry, &suite.user)
+	var seen []models.Entry
+	page := Page{Limit: 2}
+	for {
+		entries, next, err := suite.db.EntriesFromFeedPage(feed.APIID, page, true, models.Any, false, &suite.user)
+		suite.Require().Nil(err)
+		suite.Require().True(len(entries) <= 2)
 
-	suite.True(suite.db.EntryWithGUIDExists(entry.GUID, feed.APIID, &suite.user))
+		seen = append(seen, entries...)
+		if next == "" {
+			break
+		}
+
+		page.Cursor = next
+	}
+
+	suite.Require().Len(seen, 5)
+	for i := 1; i < len(seen); i++ {
+		suite.True(seen[i-1].ID > seen[i].ID)
+	}
 }
 
-func (suite *DatabaseTestSuite) TestEntryWithGUIDDoesNotExists() {
+func (suite *DatabaseTestSuite) TestEntriesPageWithBadCursor() {
+	_, _, err := suite.db.EntriesPage(Page{Limit: 2, Cursor: "bogus"}, true, models.Any, false, &suite.user)
+	suite.IsType(BadRequest{}, err)
+}
+
+func (suite *DatabaseTestSuite) newSearchFeed(title string, ctg models.Category, entries ...models.Entry) (models.Feed, []models.Entry) {
 	feed := models.Feed{
-		Title:        "Test site",
-		Subscription: "http://example.com",
+		Title:        title,
+		Subscription: "http://example.com/" + title,
+		Category:     ctg,
 	}
 
 	err := suite.db.NewFeed(&feed, &suite.user)
 	suite.Require().Nil(err)
 
-	entry := models.Entry{
-		Title:     "Test Entry",
-		Feed:      feed,
-		Published: time.Now(),
+	for i := range entries {
+		entries[i].Feed = feed
+		entries[i].Mark = models.Unread
+		err = suite.db.NewEntry(&entries[i], &suite.user)
+		suite.Require().Nil(err)
 	}
 
-	err = suite.db.NewEntry(&entry, &suite.user)
-
-	suite.False(suite.db.EntryWithGUIDExists("item@test", feed.APIID, &suite.user))
+	return feed, entries
 }
 
-func (suite *DatabaseTestSuite) TestEntriesFromCategory() {
-	firstCtg := models.Category{
-		Name: "News",
-	}
+func (suite *DatabaseTestSuite) TestSearchEntries() {
+	ctg := models.Category{Name: "Programming"}
+	err := suite.db.NewCategory(&ctg, &suite.user)
+	suite.Require().Nil(err)
 
-	secondCtg := models.Category{
-		Name: "Tech",
-	}
+	now := time.Now()
+	goFeed, goEntries := suite.newSearchFeed("golang", ctg,
+		models.Entry{Title: "Generics are here", Content: "Type parameters landed", Published: now},
+		models.Entry{Title: "Release notes", Author: "Gopher", Published: now.Add(-time.Hour)},
+		models.Entry{Title: "Weather", Summary: "Sunny with a chance of generics", Published: now.Add(-time.Hour * 2)},
+	)
+	_, _ = suite.newSearchFeed("news", models.Category{},
+		models.Entry{Title: "Generics explained", Published: now.Add(-time.Hour * 3)},
+	)
+
+	entries, err := suite.db.SearchEntries(Search{Text: "generics", Marker: models.Any}, &suite.user)
+	suite.Require().Nil(err)
+	suite.Len(entries, 3)
 
-	err := suite.db.NewCategory(&firstCtg, &suite.user)
+	entries, err = suite.db.SearchEntries(Search{Text: "parameters", Marker: models.Any}, &suite.user)
 	suite.Require().Nil(err)
-	suite.NotEmpty(firstCtg.APIID)
+	suite.Require().Len(entries, 1)
+	suite.Equal(goEntries[0].APIID, entries[0].APIID)
 
-	err = suite.db.NewCategory(&secondCtg, &suite.user)
+	entries, err = suite.db.SearchEntries(Search{Text: "gopher", Marker: models.Any}, &suite.user)
 	suite.Require().Nil(err)
-	suite.NotEmpty(secondCtg.APIID)
+	suite.Require().Len(entries, 1)
+	suite.Equal(goEntries[1].APIID, entries[0].APIID)
 
-	firstFeed := models.Feed{
-		Title:        "Test site",
-		Subscription: "http://example.com",
-		Category:     firstCtg,
-		CategoryID:   firstCtg.ID,
-	}
+	entries, err = suite.db.SearchEntries(Search{Text: "generics", FeedID: goFeed.APIID, Marker: models.Any}, &suite.user)
+	suite.Require().Nil(err)
+	suite.Len(entries, 2)
 
-	secondFeed := models.Feed{
-		Title:        "Test site",
-		Subscription: "http://example.com",
-		Category:     secondCtg,
-		CategoryID:   secondCtg.ID,
-	}
+	entries, err = suite.db.SearchEntries(Search{Text: "generics", CategoryID: ctg.APIID, Marker: models.Any}, &suite.user)
+	suite.Require().Nil(err)
+	suite.Len(entries, 2)
 
-	thirdFeed := models.Feed{
-		Title:        "Test site",
-		Subscription: "http://example.com",
-		Category:     secondCtg,
-		CategoryID:   secondCtg.ID,
-	}
+	tag := models.Tag{Name: "Later"}
+	err = suite.db.NewTag(&tag, &suite.user)
+	suite.Require().Nil(err)
 
-	err = suite.db.NewFeed(&firstFeed, &suite.user)
+	err = suite.db.TagEntries(tag.APIID, []string{goEntries[2].APIID}, &suite.user)
 	suite.Require().Nil(err)
-	suite.NotEmpty(firstFeed.APIID)
 
-	err = suite.db.NewFeed(&secondFeed, &suite.user)
+	entries, err = suite.db.SearchEntries(Search{Text: "generics", TagID: tag.APIID, Marker: models.Any}, &suite.user)
 	suite.Require().Nil(err)
-	suite.NotEmpty(secondFeed.APIID)
+	suite.Require().Len(entries, 1)
+	suite.Equal(goEntries[2].APIID, entries[0].APIID)
 
-	err = suite.db.NewFeed(&thirdFeed, &suite.user)
+	entries, err = suite.db.SearchEntries(Search{Text: "generics", Marker: models.Read}, &suite.user)
 	suite.Require().Nil(err)
-	suite.NotEmpty(secondFeed.APIID)
+	suite.Empty(entries)
 
-	for i := 0; i < 10; i++ {
-		var entry models.Entry
-		if i <= 4 {
-			entry = models.Entry{
-				Title:     "First Feed Test Entry " + strconv.Itoa(i),
-				Author:    "varddum",
-				Link:      "http://example.com",
-				Mark:      models.Unread,
-				Feed:      firstFeed,
-				Published: time.Now(),
-			}
-		} else {
-			if i < 7 {
-				entry = models.Entry{
-					Title:     "Second Feed Test Entry " + strconv.Itoa(i),
-					Author:    "varddum",
-					Link:      "http://example.com",
-					Mark:      models.Unread,
-					Feed:      secondFeed,
-					Published: time.Now(),
-				}
-			} else {
-				entry = models.Entry{
-					Title:     "Third Feed Test Entry " + strconv.Itoa(i),
-					Author:    "varddum",
-					Link:      "http://example.com",
-					Mark:      models.Unread,
-					Feed:      thirdFeed,
-					Published: time.Now(),
-				}
-			}
-		}
+	entries, err = suite.db.SearchEntries(Search{Text: "generics", Marker: models.Any, Order: ByOldest, Limit: 2}, &suite.user)
+	suite.Require().Nil(err)
+	suite.Require().Len(entries, 2)
+	suite.Equal("Generics explained", entries[0].Title)
+}
 
-		err = suite.db.NewEntry(&entry, &suite.user)
-		suite.Require().Nil(err)
-	}
+func (suite *DatabaseTestSuite) TestSearchEntriesByRelevance() {
+	now := time.Now()
+	_, entries := suite.newSearchFeed("relevance", models.Category{},
+		models.Entry{Title: "Mentions syndication once", Published: now},
+		models.Entry{Title: "Syndication", Content: "Syndication and more syndication", Published: now.Add(-time.Hour)},
+	)
 
-	entries, err := suite.db.EntriesFromCategory(firstCtg.APIID, false, models.Unread, &suite.user)
-	suite.Nil(err)
-	suite.NotEmpty(entries)
-	suite.Len(entries, 5)
-	suite.Equal(entries[0].Title, "First Feed Test Entry 0")
+	found, err := suite.db.SearchEntries(Search{Text: "syndication", Marker: models.Any}, &suite.user)
+	suite.Require().Nil(err)
+	suite.Require().Len(found, 2)
+	suite.Equal(entries[1].APIID, found[0].APIID)
 
-	entries, err = suite.db.EntriesFromCategory(secondCtg.APIID, true, models.Unread, &suite.user)
-	suite.Nil(err)
-	suite.NotEmpty(entries)
-	suite.Len(entries, 5)
-	suite.Equal(entries[0].Title, "Third Feed Test Entry 9")
-	suite.Equal(entries[len(entries)-1].Title, "Second Feed Test Entry 5")
+	found, err = suite.db.SearchEntries(Search{Text: "syndication", Marker: models.Any, Order: ByNewest}, &suite.user)
+	suite.Require().Nil(err)
+	suite.Require().Len(found, 2)
+	suite.Equal(entries[0].APIID, found[0].APIID)
 }
 
-func (suite *DatabaseTestSuite) TestEntriesFromCategoryWithtNoneMarker() {
-	_, err := suite.db.EntriesFromCategory("bogus", true, models.None, &suite.user)
-	suite.NotNil(err)
-}
+func (suite *DatabaseTestSuite) TestSearchEntriesWithBadRequests() {
+	_, err := suite.db.SearchEntries(Search{Text: "  ", Marker: models.Any}, &suite.user)
+	suite.IsType(BadRequest{}, err)
 
-func (suite *DatabaseTestSuite) TestEntriesFromNonExistingCategory() {
-	_, err := suite.db.EntriesFromCategory(createAPIID(), true, models.Unread, &suite.user)
+	_, err = suite.db.SearchEntries(Search{Text: "go", FeedID: "a", TagID: "b", Marker: models.Any}, &suite.user)
+	suite.IsType(BadRequest{}, err)
+
+	_, err = suite.db.SearchEntries(Search{Text: "go", FeedID: "bogus", Marker: models.Any}, &suite.user)
 	suite.IsType(NotFound{}, err)
-}
 
-func (suite *DatabaseTestSuite) TestMarkCategory() {
-	firstCtg := models.Category{
-		Name: "News",
-	}
+	_, err = suite.db.SearchEntries(Search{Text: `"unbalanced AND (`, Marker: models.Any}, &suite.user)
+	suite.Nil(err)
+}
 
-	secondCtg := models.Category{
-		Name: "Tech",
-	}
+func (suite *DatabaseTestSuite) TestSavedEntries() {
+	_, entries := suite.newSearchFeed("saved", models.Category{},
+		models.Entry{Title: "Keep this", Published: time.Now()},
+		models.Entry{Title: "Skip this", Published: time.Now()},
+	)
 
-	err := suite.db.NewCategory(&firstCtg, &suite.user)
+	err := suite.db.SaveEntry(entries[0].APIID, &suite.user)
 	suite.Require().Nil(err)
-	suite.NotEmpty(firstCtg.APIID)
 
-	err = suite.db.NewCategory(&secondCtg, &suite.user)
+	saved, _, err := suite.db.EntriesPage(Page{}, true, models.Any, true, &suite.user)
 	suite.Require().Nil(err)
-	suite.NotEmpty(secondCtg.APIID)
+	suite.Require().Len(saved, 1)
+	suite.Equal(entries[0].APIID, saved[0].APIID)
+	suite.True(saved[0].Saved)
 
-	firstFeed := models.Feed{
-		Title:        "Test site",
-		Subscription: "http://example.com",
-		Category:     firstCtg,
-		CategoryID:   firstCtg.ID,
-	}
+	found, err := suite.db.SearchEntries(Search{Text: "this", Marker: models.Any, Saved: true}, &suite.user)
+	suite.Require().Nil(err)
+	suite.Len(found, 1)
 
-	secondFeed := models.Feed{
-		Title:        "Test site",
-		Subscription: "http://example.com",
-		Category:     secondCtg,
-		CategoryID:   secondCtg.ID,
-	}
+	suite.Equal(1, suite.db.Stats(&suite.user).Saved)
 
-	err = suite.db.NewFeed(&firstFeed, &suite.user)
+	err = suite.db.UnsaveEntry(entries[0].APIID, &suite.user)
 	suite.Require().Nil(err)
-	suite.NotEmpty(firstFeed.APIID)
 
-	err = suite.db.NewFeed(&secondFeed, &suite.user)
+	saved, _, err = suite.db.EntriesPage(Page{}, true, models.Any, true, &suite.user)
 	suite.Require().Nil(err)
-	suite.NotEmpty(secondFeed.APIID)
+	suite.Empty(saved)
 
-	for i := 0; i < 10; i++ {
-		var entry models.Entry
-		if i <= 4 {
-			entry = models.Entry{
+	err = suite.db.SaveEntry("bogus", &suite.user)
+	suite.IsType(NotFound{}, err)
+}
+
+func (suite *DatabaseTestSuite) TestPurgeReadEntries() {
+	now := time.Now()
+	old := now.AddDate(0, 0, -40)
+	feed, entries := suite.newSearchFeed("retention", models.Category{},
+		models.Entry{Title: "Old read", GUID: "old-read", Published: old},
+		models.Entry{Title: "Old saved", GUID: "old-saved", Published: old, Saved: true},
+		models.Entry{Title: "Old tagged", GUID: "old-tagged", Published: old},
+		models.Entry{Title: "Recent read", GUID: "recent-read", Published: now},
+		models.Entry{Title: "Old unread", GUID: "old-unread", Published: old},
+	)
+
+	for _, entry := range entries[:4] {
+		err := suite.db.MarkEntry(entry.APIID, models.Read, &suite.user)
+		suite.Require().Nil(err)
+	}
+
+	tag := models.Tag{Name: "Keep"}
+	err := suite.db.NewTag(&tag, &suite.user)
+	suite.Require().Nil(err)
+
+	err = suite.db.TagEntries(tag.APIID, []string{entries[2].APIID}, &suite.user)
+	suite.Require().Nil(err)
+
+	defaults := models.RetentionPolicy{KeepReadDays: 30}
+
+	// Old entries that were just read are kept as long as recent ones
+	result, err := suite.db.PurgeEntries(context.Background(), defaults, true, now)
+	suite.Require().Nil(err)
+	suite.Zero(result.Entries)
+
+	later := now.AddDate(0, 0, 31)
+	result, err = suite.db.PurgeEntries(context.Background(), defaults, true, later)
+	suite.Require().Nil(err)
+	suite.Equal(PurgeResult{Feeds: 1, Entries: 2}, result)
+
+	remaining, err := suite.db.EntriesFromFeed(feed.APIID, true, models.Any, &suite.user)
+	suite.Require().Nil(err)
+	suite.Len(remaining, 5)
+
+	result, err = suite.db.PurgeEntries(context.Background(), defaults, false, later)
+	suite.Require().Nil(err)
+	suite.Equal(PurgeResult{Feeds: 1, Entries: 2}, result)
+
+	remaining, err = suite.db.EntriesFromFeed(feed.APIID, true, models.Any, &suite.user)
+	suite.Require().Nil(err)
+	suite.Require().Len(remaining, 3)
+	for _, entry := range remaining {
+		suite.NotEqual("old-read", entry.GUID)
+		suite.NotEqual("recent-read", entry.GUID)
+	}
+
+	exists, err := suite.db.EntryWithGUIDExists("old-read", feed.APIID, &suite.user)
+	suite.Require().Nil(err)
+	suite.True(exists)
+
+	err = suite.db.EditFeedRetention(feed.APIID, models.RetentionPolicy{KeepReadDays: -1}, &suite.user)
+	suite.Require().Nil(err)
+
+	result, err = suite.db.PurgeEntries(context.Background(), models.RetentionPolicy{KeepReadDays: 1}, true, later.AddDate(0, 0, 2))
+	suite.Require().Nil(err)
+	suite.Zero(result.Entries)
+}
+
+func (suite *DatabaseTestSuite) TestPurgeKeepsEntriesBeingListenedTo() {
+	feed, _ := suite.newSearchFeed("listening", models.Category{})
+
+	old := time.Now().AddDate(0, 0, -40)
+	entries := []models.Entry{
+		{
+			Title:      "Started",
+			GUID:       "started",
+			Mark:       models.Read,
+			Published:  old,
+			Enclosures: []models.Enclosure{{URL: "http://example.com/started.mp3", Duration: 3600}},
+		},
+		{
+			Title:      "Finished",
+			GUID:       "finished",
+			Mark:       models.Read,
+			Published:  old,
+			Enclosures: []models.Enclosure{{URL: "http://example.com/finished.mp3", Duration: 60}},
+		},
+	}
+
+	err := suite.db.NewEntries(entries, &feed, &suite.user)
+	suite.Require().Nil(err)
+
+	found, err := suite.db.EntriesFromFeed(feed.APIID, true, models.Any, &suite.user)
+	suite.Require().Nil(err)
+	suite.Require().Len(found, 2)
+
+	for _, entry := range found {
+		position := int64(120)
+		if entry.GUID == "finished" {
+			position = 60
+		}
+
+		_, err = suite.db.EditEnclosureProgress(entry.Enclosures[0].APIID, position, false, &suite.user)
+		suite.Require().Nil(err)
+	}
+
+	result, err := suite.db.PurgeEntries(context.Background(), models.RetentionPolicy{KeepReadDays: 30}, false, time.Now().AddDate(0, 0, 31))
+	suite.Require().Nil(err)
+	suite.Equal(1, result.Entries)
+
+	remaining, err := suite.db.EntriesFromFeed(feed.APIID, true, models.Any, &suite.user)
+	suite.Require().Nil(err)
+	suite.Require().Len(remaining, 1)
+	suite.Equal("started", remaining[0].GUID)
+}
+
+func (suite *DatabaseTestSuite) TestPurgeEntriesOverLimit() {
+	ctg := models.Category{Name: "Limited"}
+	err := suite.db.NewCategory(&ctg, &suite.user)
+	suite.Require().Nil(err)
+
+	now := time.Now()
+	feed, _ := suite.newSearchFeed("limited", ctg,
+		models.Entry{Title: "First", GUID: "first", Published: now.Add(-time.Hour * 3)},
+		models.Entry{Title: "Second", GUID: "second", Published: now.Add(-time.Hour * 2)},
+		models.Entry{Title: "Third", GUID: "third", Published: now.Add(-time.Hour)},
+		models.Entry{Title: "Fourth", GUID: "fourth", Published: now},
+	)
+
+	err = suite.db.EditCategoryRetention(ctg.APIID, models.RetentionPolicy{MaxEntries: 2}, &suite.user)
+	suite.Require().Nil(err)
+
+	result, err := suite.db.PurgeEntries(context.Background(), models.RetentionPolicy{MaxEntries: 3}, false, now)
+	suite.Require().Nil(err)
+	suite.Equal(2, result.Entries)
+
+	remaining, err := suite.db.EntriesFromFeed(feed.APIID, true, models.Any, &suite.user)
+	suite.Require().Nil(err)
+	suite.Require().Len(remaining, 2)
+	suite.Equal("fourth", remaining[0].GUID)
+	suite.Equal("third", remaining[1].GUID)
+
+	err = suite.db.EditCategoryRetention(ctg.APIID, models.RetentionPolicy{MaxEntries: -2}, &suite.user)
+	suite.IsType(BadRequest{}, err)
+
+	err = suite.db.EditFeedRetention("bogus", models.RetentionPolicy{}, &suite.user)
+	suite.IsType(NotFound{}, err)
+}
+
+func (suite *DatabaseTestSuite) TestPurgeEntriesOverLimitKeepsSaved() {
+	now := time.Now()
+	feed, _ := suite.newSearchFeed("saved", models.Category{},
+		models.Entry{Title: "First", GUID: "first", Published: now.Add(-time.Hour * 3)},
+		models.Entry{Title: "Second", GUID: "second", Published: now.Add(-time.Hour * 2)},
+		models.Entry{Title: "Third", GUID: "third", Published: now.Add(-time.Hour), Saved: true},
+		models.Entry{Title: "Fourth", GUID: "fourth", Published: now, Saved: true},
+	)
+
+	result, err := suite.db.PurgeEntries(context.Background(), models.RetentionPolicy{MaxEntries: 1}, false, now)
+	suite.Require().Nil(err)
+	suite.Equal(1, result.Entries)
+
+	remaining, err := suite.db.EntriesFromFeed(feed.APIID, true, models.Any, &suite.user)
+	suite.Require().Nil(err)
+	suite.Require().Len(remaining, 3)
+	suite.Equal("fourth", remaining[0].GUID)
+	suite.Equal("third", remaining[1].GUID)
+	suite.Equal("second", remaining[2].GUID)
+}
+
+func (suite *DatabaseTestSuite) TestEntryWithGUIDExists() {
+	feed := models.Feed{
+		Title:        "Test site",
+		Subscription: "http://example.com",
+	}
+
+	err := suite.db.NewFeed(&feed, &suite.user)
+	suite.Require().Nil(err)
+
+	entry := models.Entry{
+		Title:     "Test Entry",
+		GUID:      "entry@test",
+		Feed:      feed,
+		Published: time.Now(),
+	}
+
+	err = suite.db.NewEntry(&entry, &suite.user)
+
+	suite.True(suite.db.EntryWithGUIDExists(entry.GUID, feed.APIID, &suite.user))
+}
+
+func (suite *DatabaseTestSuite) TestEntryWithGUIDDoesNotExists() {
+	feed := models.Feed{
+		Title:        "Test site",
+		Subscription: "http://example.com",
+	}
+
+	err := suite.db.NewFeed(&feed, &suite.user)
+	suite.Require().Nil(err)
+
+	entry := models.Entry{
+		Title:     "Test Entry",
+		Feed:      feed,
+		Published: time.Now(),
+	}
+
+	err = suite.db.NewEntry(&entry, &suite.user)
+
+	suite.False(suite.db.EntryWithGUIDExists("item@test", feed.APIID, &suite.user))
+}
+
+func (suite *DatabaseTestSuite) TestEntryGUIDs() {
+	feed := models.Feed{
+		Title:        "Test site",
+		Subscription: "http://example.com",
+	}
+
+	err := suite.db.NewFeed(&feed, &suite.user)
+	suite.Require().Nil(err)
+
+	var entries []models.Entry
+	for i := 0; i < 3; i++ {
+		entries = append(entries, models.Entry{
+			Title:     "Test Entry",
+			GUID:      "stored-" + strconv.Itoa(i),
+			Published: time.Now(),
+		})
+	}
+
+	err = suite.db.NewEntries(entries, &feed, &suite.user)
+	suite.Require().Nil(err)
+
+	guids := []string{"stored-0", "stored-2"}
+	for i := 0; i < maxGUIDBatch; i++ {
+		guids = append(guids, "new-"+strconv.Itoa(i))
+	}
+	guids = append(guids, "stored-1")
+
+	found, err := suite.db.EntryGUIDs(guids, feed.APIID, &suite.user)
+	suite.Require().Nil(err)
+	suite.Equal(map[string]bool{"stored-0": true, "stored-1": true, "stored-2": true}, found)
+
+	_, err = suite.db.EntryGUIDs(guids, "bogus", &suite.user)
+	suite.IsType(NotFound{}, err)
+}
+
+func (suite *DatabaseTestSuite) TestEntriesFromCategory() {
+	firstCtg := models.Category{
+		Name: "News",
+	}
+
+	secondCtg := models.Category{
+		Name: "Tech",
+	}
+
+	err := suite.db.NewCategory(&firstCtg, &suite.user)
+	suite.Require().Nil(err)
+	suite.NotEmpty(firstCtg.APIID)
+
+	err = suite.db.NewCategory(&secondCtg, &suite.user)
+	suite.Require().Nil(err)
+	suite.NotEmpty(secondCtg.APIID)
+
+	firstFeed := models.Feed{
+		Title:        "Test site",
+		Subscription: "http://example.com",
+		Category:     firstCtg,
+		CategoryID:   firstCtg.ID,
+	}
+
+	secondFeed := models.Feed{
+		Title:        "Test site",
+		Subscription: "http://example.com",
+		Category:     secondCtg,
+		CategoryID:   secondCtg.ID,
+	}
+
+	thirdFeed := models.Feed{
+		Title:        "Test site",
+		Subscription: "http://example.com",
+		Category:     secondCtg,
+		CategoryID:   secondCtg.ID,
+	}
+
+	err = suite.db.NewFeed(&firstFeed, &suite.user)
+	suite.Require().Nil(err)
+	suite.NotEmpty(firstFeed.APIID)
+
+	err = suite.db.NewFeed(&secondFeed, &suite.user)
+	suite.Require().Nil(err)
+	suite.NotEmpty(secondFeed.APIID)
+
+	err = suite.db.NewFeed(&thirdFeed, &suite.user)
+	suite.Require().Nil(err)
+	suite.NotEmpty(secondFeed.APIID)
+
+	for i := 0; i < 10; i++ {
+		var entry models.Entry
+		if i <= 4 {
+			entry = models.Entry{
+				Title:     "First Feed Test Entry " + strconv.Itoa(i),
+				Author:    "varddum",
+				Link:      "http://example.com",
+				Mark:      models.Unread,
+				Feed:      firstFeed,
+				Published: time.Now(),
+			}
+		} else {
+			if i < 7 {
+				entry = models.Entry{
+					Title:     "Second Feed Test Entry " + strconv.Itoa(i),
+					Author:    "varddum",
+					Link:      "http://example.com",
+					Mark:      models.Unread,
+					Feed:      secondFeed,
+					Published: time.Now(),
+				}
+			} else {
+				entry = models.Entry{
+					Title:     "Third Feed Test Entry " + strconv.Itoa(i),
+					Author:    "varddum",
+					Link:      "http://example.com",
+					Mark:      models.Unread,
+					Feed:      thirdFeed,
+					Published: time.Now(),
+				}
+			}
+		}
+
+		err = suite.db.NewEntry(&entry, &suite.user)
+		suite.Require().Nil(err)
+	}
+
+	entries, err := suite.db.EntriesFromCategory(firstCtg.APIID, false, models.Unread, &suite.user)
+	suite.Nil(err)
+	suite.NotEmpty(entries)
+	suite.Len(entries, 5)
+	suite.Equal(entries[0].Title, "First Feed Test Entry 0")
+
+	entries, err = suite.db.EntriesFromCategory(secondCtg.APIID, true, models.Unread, &suite.user)
+	suite.Nil(err)
+	suite.NotEmpty(entries)
+	suite.Len(entries, 5)
+	suite.Equal(entries[0].Title, "Third Feed Test Entry 9")
+	suite.Equal(entries[len(entries)-1].Title, "Second Feed Test Entry 5")
+}
+
+func (suite *DatabaseTestSuite) TestEntriesFromCategoryWithtNoneMarker() {
+	_, err := suite.db.EntriesFromCategory("bogus", true, models.None, &suite.user)
+	suite.NotNil(err)
+}
+
+func (suite *DatabaseTestSuite) TestEntriesFromNonExistingCategory() {
+	_, err := suite.db.EntriesFromCategory(createAPIID(), true, models.Unread, &suite.user)
+	suite.IsType(NotFound{}, err)
+}
+
+func (suite *DatabaseTestSuite) TestMarkCategory() {
+	firstCtg := models.Category{
+		Name: "News",
+	}
+
+	secondCtg := models.Category{
+		Name: "Tech",
+	}
+
+	err := suite.db.NewCategory(&firstCtg, &suite.user)
+	suite.Require().Nil(err)
+	suite.NotEmpty(firstCtg.APIID)
+
+	err = suite.db.NewCategory(&secondCtg, &suite.user)
+	suite.Require().Nil(err)
+	suite.NotEmpty(secondCtg.APIID)
+
+	firstFeed := models.Feed{
+		Title:        "Test site",
+		Subscription: "http://example.com",
+		Category:     firstCtg,
+		CategoryID:   firstCtg.ID,
+	}
+
+	secondFeed := models.Feed{
+		Title:        "Test site",
+		Subscription: "http://example.com",
+		Category:     secondCtg,
+		CategoryID:   secondCtg.ID,
+	}
+
+	err = suite.db.NewFeed(&firstFeed, &suite.user)
+	suite.Require().Nil(err)
+	suite.NotEmpty(firstFeed.APIID)
+
+	err = suite.db.NewFeed(&secondFeed, &suite.user)
+	suite.Require().Nil(err)
+	suite.NotEmpty(secondFeed.APIID)
+
+	for i := 0; i < 10; i++ {
+		var entry models.Entry
+		if i <= 4 {
+			entry = models.Entry{
 				Title:     "First Feed Test Entry " + strconv.Itoa(i),
 				Author:    "varddum",
 				Link:      "http://example.com",
@@ -1143,35 +1649,101 @@ func (suite *DatabaseTestSuite) TestMarkFeed() {
 		suite.Require().Nil(err)
 	}
 
-	suite.Require().Equal(suite.db.db.Model(&suite.user).Association("Entries").Count(), 10)
-	suite.Require().Equal(suite.db.db.Model(&suite.user).Where("mark = ?", models.Read).Association("Entries").Count(), 5)
-	suite.Require().Equal(suite.db.db.Model(&suite.user).Where("mark = ?", models.Unread).Association("Entries").Count(), 5)
+	suite.Require().Equal(suite.db.db.Model(&suite.user).Association("Entries").Count(), 10)
+	suite.Require().Equal(suite.db.db.Model(&suite.user).Where("mark = ?", models.Read).Association("Entries").Count(), 5)
+	suite.Require().Equal(suite.db.db.Model(&suite.user).Where("mark = ?", models.Unread).Association("Entries").Count(), 5)
+
+	err = suite.db.MarkFeed(firstFeed.APIID, models.Read, &suite.user)
+	suite.Nil(err)
+
+	entries, err := suite.db.EntriesFromFeed(firstFeed.APIID, true, models.Read, &suite.user)
+	suite.Nil(err)
+	suite.Len(entries, 5)
+
+	suite.Equal(suite.db.db.Model(&suite.user).Where("mark = ?", models.Read).Association("Entries").Count(), 10)
+
+	for _, entry := range entries {
+		suite.EqualValues(entry.Mark, models.Read)
+	}
+
+	err = suite.db.MarkFeed(secondFeed.APIID, models.Unread, &suite.user)
+	suite.Nil(err)
+
+	suite.Equal(suite.db.db.Model(&suite.user).Where("mark = ?", models.Unread).Association("Entries").Count(), 5)
+
+	entries, err = suite.db.EntriesFromFeed(secondFeed.APIID, true, models.Unread, &suite.user)
+	suite.Nil(err)
+	suite.Len(entries, 5)
+
+	for _, entry := range entries {
+		suite.EqualValues(entry.Mark, models.Unread)
+	}
+}
+
+func (suite *DatabaseTestSuite) TestMarkEntriesWithinBounds() {
+	ctg := models.Category{Name: "Bounded"}
+	err := suite.db.NewCategory(&ctg, &suite.user)
+	suite.Require().Nil(err)
+
+	feed, entries := suite.newSearchFeed("bounded", ctg,
+		models.Entry{Title: "First", GUID: "first"},
+		models.Entry{Title: "Second", GUID: "second"},
+		models.Entry{Title: "Third", GUID: "third"},
+	)
+
+	// The first entry was added an hour before the others
+	suite.db.db.Model(&entries[0]).Update("created_at", time.Now().Add(-time.Hour))
+
+	count, err := suite.db.MarkFeedEntries(feed.APIID, models.Read, MarkBounds{MaxID: entries[1].APIID}, &suite.user)
+	suite.Require().Nil(err)
+	suite.Equal(int64(2), count)
+
+	count, err = suite.db.MarkFeedEntries(feed.APIID, models.Read, MarkBounds{MaxID: entries[1].APIID}, &suite.user)
+	suite.Require().Nil(err)
+	suite.Zero(count)
+
+	unread, err := suite.db.EntriesFromFeed(feed.APIID, true, models.Unread, &suite.user)
+	suite.Require().Nil(err)
+	suite.Require().Len(unread, 1)
+	suite.Equal("Third", unread[0].Title)
+
+	count, err = suite.db.MarkCategoryEntries(ctg.APIID, models.Unread, MarkBounds{Before: time.Now().Add(-time.Minute)}, &suite.user)
+	suite.Require().Nil(err)
+	suite.Equal(int64(1), count)
+
+	count, err = suite.db.MarkAllEntries(models.Read, MarkBounds{}, &suite.user)
+	suite.Require().Nil(err)
+	suite.Equal(int64(2), count)
 
-	err = suite.db.MarkFeed(firstFeed.APIID, models.Read, &suite.user)
-	suite.Nil(err)
+	tag := models.Tag{Name: "Bounded"}
+	err = suite.db.NewTag(&tag, &suite.user)
+	suite.Require().Nil(err)
 
-	entries, err := suite.db.EntriesFromFeed(firstFeed.APIID, true, models.Read, &suite.user)
-	suite.Nil(err)
-	suite.Len(entries, 5)
+	err = suite.db.TagEntries(tag.APIID, []string{entries[0].APIID, entries[2].APIID}, &suite.user)
+	suite.Require().Nil(err)
 
-	suite.Equal(suite.db.db.Model(&suite.user).Where("mark = ?", models.Read).Association("Entries").Count(), 10)
+	count, err = suite.db.MarkTagEntries(tag.APIID, models.Unread, MarkBounds{}, &suite.user)
+	suite.Require().Nil(err)
+	suite.Equal(int64(2), count)
 
-	for _, entry := range entries {
-		suite.EqualValues(entry.Mark, models.Read)
-	}
+	count, err = suite.db.MarkEntries([]string{entries[0].APIID, entries[1].APIID, "bogus"}, models.Read, &suite.user)
+	suite.Require().Nil(err)
+	suite.Equal(int64(1), count)
 
-	err = suite.db.MarkFeed(secondFeed.APIID, models.Unread, &suite.user)
-	suite.Nil(err)
+	_, err = suite.db.MarkAllEntries(models.Read, MarkBounds{MaxID: "bogus"}, &suite.user)
+	suite.IsType(NotFound{}, err)
 
-	suite.Equal(suite.db.db.Model(&suite.user).Where("mark = ?", models.Unread).Association("Entries").Count(), 5)
+	_, err = suite.db.MarkAllEntries(models.Any, MarkBounds{}, &suite.user)
+	suite.IsType(BadRequest{}, err)
 
-	entries, err = suite.db.EntriesFromFeed(secondFeed.APIID, true, models.Unread, &suite.user)
-	suite.Nil(err)
-	suite.Len(entries, 5)
+	_, err = suite.db.MarkEntries(nil, models.Read, &suite.user)
+	suite.IsType(BadRequest{}, err)
 
-	for _, entry := range entries {
-		suite.EqualValues(entry.Mark, models.Unread)
-	}
+	_, err = suite.db.MarkEntries(make([]string, maxMarkedEntries+1), models.Read, &suite.user)
+	suite.IsType(BadRequest{}, err)
+
+	_, err = suite.db.MarkTagEntries("bogus", models.Read, MarkBounds{}, &suite.user)
+	suite.IsType(NotFound{}, err)
 }
 
 func (suite *DatabaseTestSuite) TestMarkEntry() {
@@ -1409,6 +1981,445 @@ func (suite *DatabaseTestSuite) TestErrors() {
 	suite.Equal(unauthorizedErr.String(), "Unauthorized")
 }
 
+func (suite *DatabaseTestSuite) TestFeverKey() {
+	_, err := suite.db.UserWithFeverKey("")
+	suite.IsType(Unauthorized{}, err)
+
+	err = suite.db.EnableFever(suite.user.APIID, "")
+	suite.IsType(BadRequest{}, err)
+
+	err = suite.db.EnableFever(suite.user.APIID, "fever")
+	suite.Require().Nil(err)
+
+	// md5("test:fever")
+	user, err := suite.db.UserWithFeverKey("ed798c32099c3f3af43ef3d6ad384e52")
+	suite.Require().Nil(err)
+	suite.Equal(suite.user.APIID, user.APIID)
+
+	_, err = suite.db.UserWithFeverKey(feverKey("test", "golang"))
+	suite.IsType(Unauthorized{}, err)
+
+	user, err = suite.db.UserWithFeverKey(strings.ToUpper(feverKey("test", "fever")))
+	suite.Nil(err)
+	suite.Equal(suite.user.APIID, user.APIID)
+
+	err = suite.db.DisableFever(suite.user.APIID)
+	suite.Require().Nil(err)
+
+	_, err = suite.db.UserWithFeverKey(feverKey("test", "fever"))
+	suite.IsType(Unauthorized{}, err)
+
+	err = suite.db.EnableFever("bogus", "fever")
+	suite.IsType(NotFound{}, err)
+}
+
+func (suite *DatabaseTestSuite) TestEntriesInRange() {
+	feed := models.Feed{
+		Title:        "Test site",
+		Subscription: "http://example.com",
+	}
+
+	err := suite.db.NewFeed(&feed, &suite.user)
+	suite.Require().Nil(err)
+
+	var entries []models.Entry
+	for i := 0; i < 5; i++ {
+		entries = append(entries, models.Entry{
+			Title: "Test Entry " + strconv.Itoa(i),
+			Mark:  models.Unread,
+			Saved: i == 0,
+		})
+	}
+
+	err = suite.db.NewEntries(entries, &feed, &suite.user)
+	suite.Require().Nil(err)
+
+	ids, err := suite.db.EntryPrimaryKeys(models.Any, false, &suite.user)
+	suite.Require().Nil(err)
+	suite.Require().Len(ids, 5)
+
+	saved, err := suite.db.EntryPrimaryKeys(models.Any, true, &suite.user)
+	suite.Require().Nil(err)
+	suite.Len(saved, 1)
+
+	since, err := suite.db.EntriesInRange(EntryRange{SinceID: ids[1], Limit: 2}, &suite.user)
+	suite.Require().Nil(err)
+	suite.Require().Len(since, 2)
+	suite.Equal(ids[2], since[0].ID)
+	suite.Equal(ids[3], since[1].ID)
+
+	max, err := suite.db.EntriesInRange(EntryRange{MaxID: ids[3]}, &suite.user)
+	suite.Require().Nil(err)
+	suite.Require().Len(max, 3)
+	suite.Equal(ids[2], max[0].ID)
+
+	withIDs, err := suite.db.EntriesInRange(EntryRange{IDs: []uint{ids[4], ids[0]}}, &suite.user)
+	suite.Require().Nil(err)
+	suite.Require().Len(withIDs, 2)
+	suite.Equal(ids[0], withIDs[0].ID)
+
+	_, err = suite.db.EntriesInRange(EntryRange{Limit: -1}, &suite.user)
+	suite.IsType(BadRequest{}, err)
+}
+
+func (suite *DatabaseTestSuite) TestNewPublication() {
+	ctg := models.Category{
+		Name: "News",
+	}
+
+	err := suite.db.NewCategory(&ctg, &suite.user)
+	suite.Require().Nil(err)
+
+	pub := models.Publication{
+		Source:   models.CategorySource,
+		SourceID: ctg.APIID,
+	}
+
+	err = suite.db.NewPublication(&pub, &suite.user)
+	suite.Require().Nil(err)
+	suite.NotEmpty(pub.APIID)
+	suite.Len(pub.Token, 32)
+	suite.Equal("News", pub.Title)
+
+	query, err := suite.db.Publication(pub.APIID, &suite.user)
+	suite.Require().Nil(err)
+	suite.Equal(pub.Token, query.Token)
+	suite.NotZero(query.UserID)
+
+	err = suite.db.NewPublication(&models.Publication{
+		Source:   models.CategorySource,
+		SourceID: ctg.APIID,
+	}, &suite.user)
+	suite.IsType(Conflict{}, err)
+
+	saved := models.Publication{
+		Title:    "Reading list",
+		Source:   models.SavedSource,
+		SourceID: "ignored",
+	}
+
+	err = suite.db.NewPublication(&saved, &suite.user)
+	suite.Require().Nil(err)
+	suite.Equal("Reading list", saved.Title)
+	suite.Empty(saved.SourceID)
+	suite.NotEqual(pub.Token, saved.Token)
+
+	err = suite.db.NewPublication(&models.Publication{
+		Source:   models.TagSource,
+		SourceID: "bogus",
+	}, &suite.user)
+	suite.IsType(NotFound{}, err)
+
+	err = suite.db.NewPublication(&models.Publication{
+		Source: "feed",
+	}, &suite.user)
+	suite.IsType(BadRequest{}, err)
+
+	suite.Len(suite.db.Publications(&suite.user), 2)
+}
+
+func (suite *DatabaseTestSuite) TestPublicationWithToken() {
+	pub := models.Publication{
+		Source: models.SavedSource,
+	}
+
+	err := suite.db.NewPublication(&pub, &suite.user)
+	suite.Require().Nil(err)
+
+	query, user, err := suite.db.PublicationWithToken(pub.Token)
+	suite.Require().Nil(err)
+	suite.Equal(pub.APIID, query.APIID)
+	suite.Equal(suite.user.APIID, user.APIID)
+
+	_, _, err = suite.db.PublicationWithToken("")
+	suite.IsType(NotFound{}, err)
+
+	err = suite.db.DeletePublication(pub.APIID, &suite.user)
+	suite.Require().Nil(err)
+
+	_, _, err = suite.db.PublicationWithToken(pub.Token)
+	suite.IsType(NotFound{}, err)
+
+	err = suite.db.DeletePublication(pub.APIID, &suite.user)
+	suite.IsType(NotFound{}, err)
+}
+
+func (suite *DatabaseTestSuite) TestPublishedEntries() {
+	feed := models.Feed{
+		Title:        "Test site",
+		Subscription: "http://example.com",
+	}
+
+	err := suite.db.NewFeed(&feed, &suite.user)
+	suite.Require().Nil(err)
+
+	var entries []models.Entry
+	for i := 0; i < 5; i++ {
+		entries = append(entries, models.Entry{
+			Title:     "Test Entry " + strconv.Itoa(i),
+			Mark:      models.Unread,
+			Saved:     i < 2,
+			Published: time.Now().Add(time.Duration(i) * time.Minute),
+		})
+	}
+
+	err = suite.db.NewEntries(entries, &feed, &suite.user)
+	suite.Require().Nil(err)
+
+	tag := models.Tag{
+		Name: "tech",
+	}
+
+	err = suite.db.NewTag(&tag, &suite.user)
+	suite.Require().Nil(err)
+
+	tagged, err := suite.db.EntriesFromFeed(feed.APIID, true, models.Any, &suite.user)
+	suite.Require().Nil(err)
+
+	err = suite.db.TagEntries(tag.APIID, []string{tagged[0].APIID}, &suite.user)
+	suite.Require().Nil(err)
+
+	ctgPub := models.Publication{
+		Source:   models.CategorySource,
+		SourceID: suite.user.UncategorizedCategoryAPIID,
+	}
+	tagPub := models.Publication{
+		Source:   models.TagSource,
+		SourceID: tag.APIID,
+	}
+	savedPub := models.Publication{
+		Source: models.SavedSource,
+	}
+
+	for _, pub := range []*models.Publication{&ctgPub, &tagPub, &savedPub} {
+		err = suite.db.NewPublication(pub, &suite.user)
+		suite.Require().Nil(err)
+	}
+
+	published, err := suite.db.PublishedEntries(&ctgPub, 3, &suite.user)
+	suite.Require().Nil(err)
+	suite.Require().Len(published, 3)
+	suite.Equal("Test Entry 4", published[0].Title)
+
+	published, err = suite.db.PublishedEntries(&tagPub, 3, &suite.user)
+	suite.Require().Nil(err)
+	suite.Require().Len(published, 1)
+	suite.Equal(tagged[0].APIID, published[0].APIID)
+
+	published, err = suite.db.PublishedEntries(&savedPub, 3, &suite.user)
+	suite.Require().Nil(err)
+	suite.Require().Len(published, 2)
+	suite.Equal("Test Entry 1", published[0].Title)
+
+	err = suite.db.DeleteTag(tag.APIID, &suite.user)
+	suite.Require().Nil(err)
+
+	_, err = suite.db.PublishedEntries(&tagPub, 3, &suite.user)
+	suite.IsType(NotFound{}, err)
+}
+
+func (suite *DatabaseTestSuite) TestNewRule() {
+	feed := models.Feed{
+		Title:        "Test site",
+		Subscription: "http://example.com",
+	}
+
+	err := suite.db.NewFeed(&feed, &suite.user)
+	suite.Require().Nil(err)
+
+	tag := models.Tag{
+		Name: "sponsored",
+	}
+
+	err = suite.db.NewTag(&tag, &suite.user)
+	suite.Require().Nil(err)
+
+	rule := models.Rule{
+		FeedID:  feed.APIID,
+		Field:   models.TitleField,
+		Pattern: "(?i)sponsored",
+		Action:  models.TagAction,
+		Value:   tag.APIID,
+	}
+
+	err = suite.db.NewRule(&rule, &suite.user)
+	suite.Require().Nil(err)
+	suite.NotEmpty(rule.APIID)
+
+	query, err := suite.db.Rule(rule.APIID, &suite.user)
+	suite.Require().Nil(err)
+	suite.Equal(rule.Pattern, query.Pattern)
+	suite.NotZero(query.UserID)
+
+	invalid := []models.Rule{
+		{Field: models.TitleField, Pattern: "(", Action: models.DiscardAction},
+		{Field: "published", Pattern: "2017", Action: models.DiscardAction},
+		{Pattern: "sponsored", Action: models.DiscardAction},
+		{Action: models.DiscardAction},
+		{FeedID: "bogus", Action: models.DiscardAction},
+		{CategoryID: "bogus", Action: models.DiscardAction},
+		{FeedID: feed.APIID, Action: models.MarkAction, Value: "later"},
+		{FeedID: feed.APIID, Action: models.TagAction, Value: "bogus"},
+		{FeedID: feed.APIID, Action: "delete"},
+	}
+
+	for _, rule := range invalid {
+		suite.NotNil(suite.db.NewRule(&rule, &suite.user), rule)
+	}
+
+	suite.Len(suite.db.Rules(&suite.user), 1)
+}
+
+func (suite *DatabaseTestSuite) TestEditRule() {
+	rule := models.Rule{
+		CategoryID: suite.user.UncategorizedCategoryAPIID,
+		Action:     models.SaveAction,
+	}
+
+	err := suite.db.NewRule(&rule, &suite.user)
+	suite.Require().Nil(err)
+
+	edited := models.Rule{
+		APIID:   rule.APIID,
+		Field:   models.LinkField,
+		Pattern: "example\\.com",
+		Action:  models.MarkAction,
+		Value:   "read",
+	}
+
+	err = suite.db.EditRule(&edited, &suite.user)
+	suite.Require().Nil(err)
+
+	query, err := suite.db.Rule(rule.APIID, &suite.user)
+	suite.Require().Nil(err)
+	suite.Empty(query.CategoryID)
+	suite.Equal(models.LinkField, query.Field)
+	suite.Equal(models.MarkAction, query.Action)
+
+	edited.Action = "delete"
+	err = suite.db.EditRule(&edited, &suite.user)
+	suite.IsType(BadRequest{}, err)
+
+	err = suite.db.EditRule(&models.Rule{APIID: "bogus"}, &suite.user)
+	suite.IsType(NotFound{}, err)
+
+	err = suite.db.DeleteRule(rule.APIID, &suite.user)
+	suite.Require().Nil(err)
+
+	_, err = suite.db.Rule(rule.APIID, &suite.user)
+	suite.IsType(NotFound{}, err)
+
+	err = suite.db.DeleteRule(rule.APIID, &suite.user)
+	suite.IsType(NotFound{}, err)
+}
+
+func (suite *DatabaseTestSuite) TestEntriesWithEnclosures() {
+	feed, _ := suite.newSearchFeed("podcast", models.Category{})
+
+	entries := []models.Entry{
+		{
+			Title: "Episode",
+			GUID:  "episode",
+			Mark:  models.Unread,
+			Enclosures: []models.Enclosure{
+				{URL: "http://example.com/episode.mp3", Type: "audio/mpeg", Length: 1024, Duration: 60},
+				{URL: "http://example.com/episode.ogg", Type: "audio/ogg"},
+			},
+		},
+		{
+			Title: "Post",
+			GUID:  "post",
+			Mark:  models.Unread,
+		},
+	}
+
+	err := suite.db.NewEntries(entries, &feed, &suite.user)
+	suite.Require().Nil(err)
+
+	found, err := suite.db.EntriesFromFeed(feed.APIID, true, models.Any, &suite.user)
+	suite.Require().Nil(err)
+	suite.Require().Len(found, 2)
+
+	var episode models.Entry
+	for _, entry := range found {
+		if entry.GUID == "episode" {
+			episode = entry
+		} else {
+			suite.Empty(entry.Enclosures)
+		}
+	}
+
+	suite.Require().Len(episode.Enclosures, 2)
+	suite.Equal("http://example.com/episode.mp3", episode.Enclosures[0].URL)
+	suite.Equal(int64(1024), episode.Enclosures[0].Length)
+	suite.NotEmpty(episode.Enclosures[0].APIID)
+	suite.Equal(suite.user.ID, episode.Enclosures[0].UserID)
+
+	entry, err := suite.db.Entry(episode.APIID, &suite.user)
+	suite.Require().Nil(err)
+	suite.Len(entry.Enclosures, 2)
+
+	enclosure, err := suite.db.Enclosure(episode.Enclosures[1].APIID, &suite.user)
+	suite.Require().Nil(err)
+	suite.Equal("audio/ogg", enclosure.Type)
+
+	err = suite.db.NewUser("listener", "golang")
+	suite.Require().Nil(err)
+
+	other, err := suite.db.UserWithName("listener")
+	suite.Require().Nil(err)
+
+	_, err = suite.db.Enclosure(episode.Enclosures[1].APIID, &other)
+	suite.IsType(NotFound{}, err)
+}
+
+func (suite *DatabaseTestSuite) TestEditEnclosureProgress() {
+	feed, _ := suite.newSearchFeed("progress", models.Category{})
+
+	entries := []models.Entry{{
+		Title: "Episode",
+		GUID:  "episode",
+		Mark:  models.Unread,
+		Enclosures: []models.Enclosure{
+			{URL: "http://example.com/long.mp3", Duration: 3600},
+			{URL: "http://example.com/short.mp3", Duration: 60},
+		},
+	}}
+
+	err := suite.db.NewEntries(entries, &feed, &suite.user)
+	suite.Require().Nil(err)
+
+	found, err := suite.db.EntriesFromFeed(feed.APIID, true, models.Any, &suite.user)
+	suite.Require().Nil(err)
+	suite.Require().Len(found, 1)
+	suite.Require().Len(found[0].Enclosures, 2)
+	long, short := found[0].Enclosures[0], found[0].Enclosures[1]
+
+	suite.Empty(suite.db.EnclosuresInProgress(&suite.user))
+
+	enclosure, err := suite.db.EditEnclosureProgress(long.APIID, 120, false, &suite.user)
+	suite.Require().Nil(err)
+	suite.Equal(int64(120), enclosure.Position)
+	suite.False(enclosure.Completed)
+
+	// Reaching the end completes an enclosure
+	enclosure, err = suite.db.EditEnclosureProgress(short.APIID, 90, false, &suite.user)
+	suite.Require().Nil(err)
+	suite.Equal(int64(60), enclosure.Position)
+	suite.True(enclosure.Completed)
+
+	inProgress := suite.db.EnclosuresInProgress(&suite.user)
+	suite.Require().Len(inProgress, 1)
+	suite.Equal(long.APIID, inProgress[0].APIID)
+	suite.Equal(int64(120), inProgress[0].Position)
+
+	_, err = suite.db.EditEnclosureProgress(long.APIID, -1, false, &suite.user)
+	suite.IsType(BadRequest{}, err)
+
+	_, err = suite.db.EditEnclosureProgress("bogus", 1, false, &suite.user)
+	suite.IsType(NotFound{}, err)
+}
+
 func TestNewDB(t *testing.T) {
 	_, err := NewDB(config.Database{
 		Connection: TestDatabasePath,
@@ -1420,11 +2431,12 @@ func TestNewDB(t *testing.T) {
 }
 
 func TestNewDBWithBadOptions(t *testing.T) {
-	_, err := NewDB(config.Database{
+	db, err := NewDB(config.Database{
 		Connection: TestDatabasePath,
 		Type:       "bogus",
 	})
 	assert.NotNil(t, err)
+	assert.Nil(t, db)
 }
 
 func TestNewUser(t *testing.T) {
@@ -0,0 +1,97 @@
+/*
+Copyright (C) 2017 Jorge Martinez Hernandez
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package database
+
+import (
+	"github.com/varddum/syndication/models"
+)
+
+// Enclosure returns an Enclosure with id and owned by user
+func (db *DB) Enclosure(id string, user *models.User) (enclosure models.Enclosure, err error) {
+	if db.db.Model(user).Where("api_id = ?", id).Related(&enclosure).RecordNotFound() {
+		err = NotFound{"Enclosure does not exist"}
+	}
+	return
+}
+
+// EnclosuresInProgress returns the Enclosures owned by user that were
+// started but not completed, most recently listened to first.
+func (db *DB) EnclosuresInProgress(user *models.User) (enclosures []models.Enclosure) {
+	db.db.Model(user).
+		Where("position > ? AND completed = ?", 0, false).
+		Order("updated_at DESC").
+		Related(&enclosures)
+	return
+}
+
+// EditEnclosureProgress records how much of an Enclosure with id user has
+// listened to. Reaching the end of an Enclosure with a known duration completes it.
+func (db *DB) EditEnclosureProgress(id string, position int64, completed bool, user *models.User) (enclosure models.Enclosure, err error) {
+	if position < 0 {
+		err = BadRequest{"Position cannot be negative"}
+		return
+	}
+
+	if db.db.Model(user).Where("api_id = ?", id).Related(&enclosure).RecordNotFound() {
+		err = NotFound{"Enclosure does not exist"}
+		return
+	}
+
+	if enclosure.Duration > 0 && position >= enclosure.Duration {
+		position = enclosure.Duration
+		completed = true
+	}
+
+	enclosure.Position = position
+	enclosure.Completed = completed
+	db.db.Model(&enclosure).Updates(map[string]interface{}{
+		"position":  position,
+		"completed": completed,
+	})
+	return
+}
+
+// prepareEnclosures gives the Enclosures of a new entry their ids and owner.
+func prepareEnclosures(entry *models.Entry, user *models.User) {
+	for i := range entry.Enclosures {
+		entry.Enclosures[i].APIID = createAPIID()
+		entry.Enclosures[i].UserID = user.ID
+	}
+}
+
+// loadEnclosures attaches their Enclosures to entries with a single query.
+func (db *DB) loadEnclosures(entries []models.Entry) {
+	if len(entries) == 0 {
+		return
+	}
+
+	ids := make([]uint, 0, len(entries))
+	index := make(map[uint]int, len(entries))
+	for i, entry := range entries {
+		ids = append(ids, entry.ID)
+		index[entry.ID] = i
+	}
+
+	var enclosures []models.Enclosure
+	db.db.Where("entry_id in (?)", ids).Order("id").Find(&enclosures)
+
+	for _, enclosure := range enclosures {
+		i := index[enclosure.EntryID]
+		entries[i].Enclosures = append(entries[i].Enclosures, enclosure)
+	}
+}
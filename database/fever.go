@@ -0,0 +1,123 @@
+/*
+  Copyright (C) 2017 Jorge Martinez Hernandez
+
+  This program is free software: you can redistribute it and/or modify
+  it under the terms of the GNU Affero General Public License as published by
+  the Free Software Foundation, either version 3 of the License, or
+  (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU Affero General Public License for more details.
+
+  You should have received a copy of the GNU Affero General Public License
+  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package database
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"strings"
+
+	"github.com/varddum/syndication/models"
+)
+
+// EntryRange selects Entries by their primary keys. IDs takes
+// precedence over MaxID, which takes precedence over SinceID.
+type EntryRange struct {
+	SinceID uint
+	MaxID   uint
+	IDs     []uint
+	Limit   int
+}
+
+// EnableFever allows the user with userID to use the Fever API
+// by authenticating with password.
+func (db *DB) EnableFever(userID, password string) error {
+	if password == "" {
+		return BadRequest{"Password should not be empty"}
+	}
+
+	user := &models.User{}
+	if db.db.Where("api_id = ?", userID).First(user).RecordNotFound() {
+		return NotFound{"User does not exist"}
+	}
+
+	db.db.Model(user).Update("fever_key", feverKey(user.Username, password))
+	return nil
+}
+
+// DisableFever prevents the user with userID from using the Fever API.
+func (db *DB) DisableFever(userID string) error {
+	user := &models.User{}
+	if db.db.Where("api_id = ?", userID).First(user).RecordNotFound() {
+		return NotFound{"User does not exist"}
+	}
+
+	db.db.Model(user).Update("fever_key", "")
+	return nil
+}
+
+// UserWithFeverKey returns the user that owns a Fever API key
+func (db *DB) UserWithFeverKey(key string) (user models.User, err error) {
+	if key == "" || db.db.First(&user, "fever_key = ?", strings.ToLower(key)).RecordNotFound() {
+		err = Unauthorized{"Invalid Fever API key"}
+	}
+	return
+}
+
+// EntriesInRange returns the Entries owned by user that are selected by rng.
+func (db *DB) EntriesInRange(rng EntryRange, user *models.User) (entries []models.Entry, err error) {
+	if rng.Limit < 0 {
+		err = BadRequest{"Limit should not be negative"}
+		return
+	}
+
+	query := db.db.Model(user)
+	switch {
+	case len(rng.IDs) != 0:
+		query = query.Where("id IN (?)", rng.IDs).Order("id ASC")
+	case rng.MaxID != 0:
+		query = query.Where("id < ?", rng.MaxID).Order("id DESC")
+	default:
+		query = query.Where("id > ?", rng.SinceID).Order("id ASC")
+	}
+
+	if rng.Limit > 0 {
+		query = query.Limit(rng.Limit)
+	}
+
+	query.Association("Entries").Find(&entries)
+	return
+}
+
+// EntryPrimaryKeys returns the primary keys of all Entries owned by user
+// that have marker and, if savedOnly is set, that are saved.
+func (db *DB) EntryPrimaryKeys(marker models.Marker, savedOnly bool, user *models.User) (ids []uint, err error) {
+	if marker == models.None {
+		err = BadRequest{"Request should include a valid marker"}
+		return
+	}
+
+	query := db.db.Model(&models.Entry{}).Where("user_id = ?", user.ID)
+	if marker != models.Any {
+		query = query.Where("mark = ?", marker)
+	}
+
+	if savedOnly {
+		query = query.Where("saved = ?", true)
+	}
+
+	query.Order("id ASC").Pluck("id", &ids)
+	return
+}
+
+// feverKey returns the API key Fever clients derive from
+// the username and password they are configured with.
+func feverKey(username, password string) string {
+	sum := md5.Sum([]byte(username + ":" + password))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,134 @@
+/*
+Copyright (C) 2017 Jorge Martinez Hernandez
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package database
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	"github.com/varddum/syndication/models"
+)
+
+// maxMarkedEntries bounds the number of Entries that can be listed in one mark.
+const maxMarkedEntries = 500
+
+// MarkBounds limits a bulk mark to the Entries a client has already seen,
+// so that Entries added by a sync since are left alone. Zero values do
+// not limit the mark.
+type MarkBounds struct {
+	// Before excludes Entries added after this time.
+	Before time.Time
+
+	// MaxID excludes Entries added after the Entry with this id.
+	MaxID string
+}
+
+// MarkEntries applies marker to the Entries with ids and owned by user
+// and returns the number of Entries that changed. Unknown ids are ignored.
+func (db *DB) MarkEntries(ids []string, marker models.Marker, user *models.User) (int64, error) {
+	if len(ids) == 0 {
+		return 0, BadRequest{"Request should include entries"}
+	}
+
+	if len(ids) > maxMarkedEntries {
+		return 0, BadRequest{"Request includes too many entries"}
+	}
+
+	return db.markEntries(db.db.Where("api_id in (?)", ids), marker, MarkBounds{}, user)
+}
+
+// MarkAllEntries applies marker to the Entries owned by user within bounds
+// and returns the number of Entries that changed.
+func (db *DB) MarkAllEntries(marker models.Marker, bounds MarkBounds, user *models.User) (int64, error) {
+	return db.markEntries(db.db, marker, bounds, user)
+}
+
+// MarkFeedEntries applies marker to the Entries of a Feed with id within bounds
+// and returns the number of Entries that changed.
+func (db *DB) MarkFeedEntries(id string, marker models.Marker, bounds MarkBounds, user *models.User) (int64, error) {
+	feed, err := db.Feed(id, user)
+	if err != nil {
+		return 0, err
+	}
+
+	return db.markEntries(db.db.Where("feed_id = ?", feed.ID), marker, bounds, user)
+}
+
+// MarkCategoryEntries applies marker to the Entries of the Feeds in a Category
+// with id within bounds and returns the number of Entries that changed.
+func (db *DB) MarkCategoryEntries(id string, marker models.Marker, bounds MarkBounds, user *models.User) (int64, error) {
+	ctg, err := db.Category(id, user)
+	if err != nil {
+		return 0, err
+	}
+
+	var feeds []models.Feed
+	db.db.Model(&ctg).Association("Feeds").Find(&feeds)
+
+	feedIds := make([]uint, len(feeds))
+	for i, feed := range feeds {
+		feedIds[i] = feed.ID
+	}
+
+	if len(feedIds) == 0 {
+		return 0, nil
+	}
+
+	return db.markEntries(db.db.Where("feed_id in (?)", feedIds), marker, bounds, user)
+}
+
+// MarkTagEntries applies marker to the Entries tagged with a Tag with id
+// within bounds and returns the number of Entries that changed.
+func (db *DB) MarkTagEntries(id string, marker models.Marker, bounds MarkBounds, user *models.User) (int64, error) {
+	tag, err := db.Tag(id, user)
+	if err != nil {
+		return 0, err
+	}
+
+	return db.markEntries(db.db.Where("id in (select entry_id from entry_tags where tag_id = ?)", tag.ID), marker, bounds, user)
+}
+
+// markEntries applies marker to the Entries of user selected by query within bounds.
+func (db *DB) markEntries(query *gorm.DB, marker models.Marker, bounds MarkBounds, user *models.User) (int64, error) {
+	if marker != models.Read && marker != models.Unread {
+		return 0, BadRequest{"Request should include a valid marker"}
+	}
+
+	query = query.Model(&models.Entry{}).Where("user_id = ? AND mark <> ?", user.ID, marker)
+
+	if !bounds.Before.IsZero() {
+		query = query.Where("created_at <= ?", bounds.Before)
+	}
+
+	if bounds.MaxID != "" {
+		last := models.Entry{}
+		if db.db.Model(user).Where("api_id = ?", bounds.MaxID).Related(&last).RecordNotFound() {
+			return 0, NotFound{"Entry does not exist"}
+		}
+
+		query = query.Where("id <= ?", last.ID)
+	}
+
+	result := query.Update(models.Entry{Mark: marker, MarkedAt: time.Now()})
+	if result.Error != nil {
+		return 0, InternalError{"Marking entries failed"}
+	}
+
+	return result.RowsAffected, nil
+}
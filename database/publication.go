@@ -0,0 +1,157 @@
+/*
+Copyright (C) 2017 Jorge Martinez Hernandez
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package database
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"time"
+
+	"github.com/varddum/syndication/models"
+)
+
+// publicationTokenBytes is the number of random bytes in a Publication token.
+const publicationTokenBytes = 24
+
+// NewPublication creates a Publication owned by user for the Entries of its source.
+// Publications without a title are named after their source.
+func (db *DB) NewPublication(pub *models.Publication, user *models.User) error {
+	switch pub.Source {
+	case models.CategorySource:
+		ctg, err := db.Category(pub.SourceID, user)
+		if err != nil {
+			return err
+		}
+
+		if pub.Title == "" {
+			pub.Title = ctg.Name
+		}
+	case models.TagSource:
+		tag, err := db.Tag(pub.SourceID, user)
+		if err != nil {
+			return err
+		}
+
+		if pub.Title == "" {
+			pub.Title = tag.Name
+		}
+	case models.SavedSource:
+		pub.SourceID = ""
+		if pub.Title == "" {
+			pub.Title = "Saved entries"
+		}
+	default:
+		return BadRequest{"Publication source should be category, tag or saved"}
+	}
+
+	found := &models.Publication{}
+	if !db.db.Model(user).Where("source = ? AND source_id = ?", pub.Source, pub.SourceID).Related(found).RecordNotFound() {
+		return Conflict{"Publication already exists"}
+	}
+
+	token, err := createPublicationToken()
+	if err != nil {
+		return InternalError{"Could not create a publication token"}
+	}
+
+	pub.APIID = createAPIID()
+	pub.Token = token
+	db.db.Model(user).Association("Publications").Append(pub)
+	return nil
+}
+
+// Publication returns a Publication with id and owned by user
+func (db *DB) Publication(id string, user *models.User) (pub models.Publication, err error) {
+	if db.db.Model(user).Where("api_id = ?", id).Related(&pub).RecordNotFound() {
+		err = NotFound{"Publication does not exist"}
+	}
+	return
+}
+
+// Publications returns a list of all Publications owned by user
+func (db *DB) Publications(user *models.User) (pubs []models.Publication) {
+	db.db.Model(user).Association("Publications").Find(&pubs)
+	return
+}
+
+// DeletePublication with id and owned by user. Its token stops working immediately.
+func (db *DB) DeletePublication(id string, user *models.User) error {
+	pub := &models.Publication{}
+	if db.db.Model(user).Where("api_id = ?", id).Related(pub).RecordNotFound() {
+		return NotFound{"Publication does not exist"}
+	}
+
+	db.db.Delete(pub)
+	return nil
+}
+
+// PublicationWithToken returns the Publication that can be read with token and its owner
+func (db *DB) PublicationWithToken(token string) (pub models.Publication, user models.User, err error) {
+	if token == "" || db.db.First(&pub, "token = ?", token).RecordNotFound() {
+		err = NotFound{"Publication does not exist"}
+		return
+	}
+
+	if db.db.First(&user, pub.UserID).RecordNotFound() {
+		err = NotFound{"Publication does not exist"}
+	}
+	return
+}
+
+// EditPublicationContent records that the Entries of pub, identified by
+// digest, changed at updated.
+func (db *DB) EditPublicationContent(pub *models.Publication, digest string, updated time.Time) error {
+	err := db.db.Model(pub).UpdateColumns(map[string]interface{}{
+		"content_digest":     digest,
+		"content_updated_at": updated,
+	}).Error
+	if err != nil {
+		return InternalError{"Failed to update the publication"}
+	}
+
+	pub.ContentDigest = digest
+	pub.ContentUpdatedAt = updated
+	return nil
+}
+
+// PublishedEntries returns up to limit of the newest Entries from the source of pub
+func (db *DB) PublishedEntries(pub *models.Publication, limit int, user *models.User) (entries []models.Entry, err error) {
+	page := Page{Limit: limit}
+
+	switch pub.Source {
+	case models.CategorySource:
+		entries, _, err = db.EntriesFromCategoryPage(pub.SourceID, page, true, models.Any, false, user)
+	case models.TagSource:
+		entries, _, err = db.EntriesFromTagPage(pub.SourceID, page, models.Any, false, true, user)
+	case models.SavedSource:
+		entries, _, err = db.EntriesPage(page, true, models.Any, true, user)
+	default:
+		err = BadRequest{"Publication source should be category, tag or saved"}
+	}
+	return
+}
+
+func createPublicationToken() (string, error) {
+	b := make([]byte, publicationTokenBytes)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
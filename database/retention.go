@@ -0,0 +1,258 @@
+/*
+  Copyright (C) 2017 Jorge Martinez Hernandez
+
+  This program is free software: you can redistribute it and/or modify
+  it under the terms of the GNU Affero General Public License as published by
+  the Free Software Foundation, either version 3 of the License, or
+  (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU Affero General Public License for more details.
+
+  You should have received a copy of the GNU Affero General Public License
+  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	"github.com/varddum/syndication/models"
+)
+
+// purgeBatchSize bounds the number of entries deleted by a single
+// query to stay below the variable limits of the databases.
+const purgeBatchSize = 500
+
+// PurgeResult reports the Entries removed, or that would be
+// removed, by a purge.
+type PurgeResult struct {
+	Feeds   int `json:"feeds"`
+	Entries int `json:"entries"`
+}
+
+// effectivePolicy resolves the first non-zero value of each limit
+// in policies, which are ordered from the most specific one.
+func effectivePolicy(policies ...models.RetentionPolicy) models.RetentionPolicy {
+	var policy models.RetentionPolicy
+	for _, p := range policies {
+		if policy.KeepReadDays == 0 {
+			policy.KeepReadDays = p.KeepReadDays
+		}
+
+		if policy.MaxEntries == 0 {
+			policy.MaxEntries = p.MaxEntries
+		}
+	}
+
+	return policy
+}
+
+func verifyPolicy(policy models.RetentionPolicy) error {
+	if policy.KeepReadDays < -1 || policy.MaxEntries < -1 {
+		return BadRequest{"Retention limits should be -1 or greater"}
+	}
+
+	return nil
+}
+
+// EditFeedRetention changes the retention policy of a Feed owned by user.
+func (db *DB) EditFeedRetention(id string, policy models.RetentionPolicy, user *models.User) error {
+	if err := verifyPolicy(policy); err != nil {
+		return err
+	}
+
+	feed := &models.Feed{}
+	if db.db.Model(user).Where("api_id = ?", id).Related(feed).RecordNotFound() {
+		return NotFound{"Feed does not exist"}
+	}
+
+	db.db.Model(feed).Updates(map[string]interface{}{
+		"keep_read_days": policy.KeepReadDays,
+		"max_entries":    policy.MaxEntries,
+	})
+	return nil
+}
+
+// EditCategoryRetention changes the retention policy of a Category owned by user.
+func (db *DB) EditCategoryRetention(id string, policy models.RetentionPolicy, user *models.User) error {
+	if err := verifyPolicy(policy); err != nil {
+		return err
+	}
+
+	ctg := &models.Category{}
+	if db.db.Model(user).Where("api_id = ?", id).Related(ctg).RecordNotFound() {
+		return NotFound{"Category does not exist"}
+	}
+
+	db.db.Model(ctg).Updates(map[string]interface{}{
+		"keep_read_days": policy.KeepReadDays,
+		"max_entries":    policy.MaxEntries,
+	})
+	return nil
+}
+
+// PurgeEntries removes the Entries of every Feed that fall outside of the
+// retention policy of the Feed, its Category or, failing those, defaults.
+// Saved and tagged entries are always kept and the GUIDs of removed entries
+// are recorded so that syncing does not import them again. When dryRun is
+// set nothing is removed and the result reports what would be. The purge
+// stops before the next Feed once ctx is done and reports ctx's error.
+func (db *DB) PurgeEntries(ctx context.Context, defaults models.RetentionPolicy, dryRun bool, now time.Time) (result PurgeResult, err error) {
+	var categories []models.Category
+	if err = db.db.Find(&categories).Error; err != nil {
+		return
+	}
+
+	policies := make(map[uint]models.RetentionPolicy, len(categories))
+	for _, ctg := range categories {
+		policies[ctg.ID] = ctg.RetentionPolicy
+	}
+
+	var feeds []models.Feed
+	if err = db.db.Find(&feeds).Error; err != nil {
+		return
+	}
+
+	for _, feed := range feeds {
+		if err = ctx.Err(); err != nil {
+			return
+		}
+
+		policy := effectivePolicy(feed.RetentionPolicy, policies[feed.CategoryID], defaults)
+
+		var entries []models.Entry
+		entries, err = db.expiredEntries(feed, policy, now)
+		if err != nil {
+			return
+		}
+
+		if len(entries) == 0 {
+			continue
+		}
+
+		result.Feeds++
+		result.Entries += len(entries)
+
+		if dryRun {
+			continue
+		}
+
+		if err = db.purge(entries); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// expiredEntries returns the entries of feed that policy does not keep.
+// Read entries are kept for KeepReadDays after they were marked as read or,
+// for entries that were never marked, after they were published. Entries
+// with an enclosure that is still being listened to are never expired. Only
+// entries that could be expired count toward MaxEntries, so saved and tagged
+// entries are kept on top of the newest MaxEntries other ones.
+func (db *DB) expiredEntries(feed models.Feed, policy models.RetentionPolicy, now time.Time) ([]models.Entry, error) {
+	purgeable := func() *gorm.DB {
+		return db.db.Model(&models.Entry{}).
+			Select("id, guid, feed_id, user_id, published").
+			Where("feed_id = ? AND saved = ?", feed.ID, false).
+			Where("NOT EXISTS (SELECT 1 FROM entry_tags WHERE entry_tags.entry_id = entries.id)").
+			Where("NOT EXISTS (SELECT 1 FROM enclosures WHERE enclosures.entry_id = entries.id AND enclosures.position > ? AND enclosures.completed = ?)", 0, false)
+	}
+
+	expired := map[uint]models.Entry{}
+
+	if policy.KeepReadDays > 0 {
+		var entries []models.Entry
+		cutoff := now.AddDate(0, 0, -policy.KeepReadDays)
+		err := purgeable().
+			Where("mark = ? AND marked_at < ? AND published < ?", models.Read, cutoff, cutoff).
+			Find(&entries).Error
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range entries {
+			expired[entry.ID] = entry
+		}
+	}
+
+	if policy.MaxEntries > 0 {
+		// Every entry older than the newest MaxEntries ones is expired.
+		var last []models.Entry
+		err := purgeable().
+			Order("published DESC").Order("id DESC").
+			Offset(policy.MaxEntries - 1).Limit(1).
+			Find(&last).Error
+		if err != nil {
+			return nil, err
+		}
+
+		if len(last) > 0 {
+			var entries []models.Entry
+			err = purgeable().
+				Where("published < ? OR (published = ? AND id < ?)", last[0].Published, last[0].Published, last[0].ID).
+				Find(&entries).Error
+			if err != nil {
+				return nil, err
+			}
+
+			for _, entry := range entries {
+				expired[entry.ID] = entry
+			}
+		}
+	}
+
+	entries := make([]models.Entry, 0, len(expired))
+	for _, entry := range expired {
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// purge deletes entries after recording their GUIDs.
+func (db *DB) purge(entries []models.Entry) error {
+	tx := db.db.Begin()
+
+	for start := 0; start < len(entries); start += purgeBatchSize {
+		end := start + purgeBatchSize
+		if end > len(entries) {
+			end = len(entries)
+		}
+
+		ids := make([]uint, 0, end-start)
+		for _, entry := range entries[start:end] {
+			ids = append(ids, entry.ID)
+
+			err := tx.Create(&models.PurgedEntry{
+				GUID:   entry.GUID,
+				FeedID: entry.FeedID,
+				UserID: entry.UserID,
+			}).Error
+			if err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+
+		if err := tx.Where("entry_id in (?)", ids).Delete(&models.Enclosure{}).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		if err := tx.Where("id in (?)", ids).Delete(&models.Entry{}).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit().Error
+}
@@ -0,0 +1,134 @@
+/*
+Copyright (C) 2017 Jorge Martinez Hernandez
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package database
+
+import (
+	"regexp"
+
+	"github.com/varddum/syndication/models"
+)
+
+// NewRule creates a Rule owned by user
+func (db *DB) NewRule(rule *models.Rule, user *models.User) error {
+	if err := db.verifyRule(rule, user); err != nil {
+		return err
+	}
+
+	rule.APIID = createAPIID()
+	db.db.Model(user).Association("Rules").Append(rule)
+	return nil
+}
+
+// EditRule owned by user
+func (db *DB) EditRule(rule *models.Rule, user *models.User) error {
+	found := &models.Rule{}
+	if db.db.Model(user).Where("api_id = ?", rule.APIID).Related(found).RecordNotFound() {
+		return NotFound{"Rule does not exist"}
+	}
+
+	if err := db.verifyRule(rule, user); err != nil {
+		return err
+	}
+
+	found.Name = rule.Name
+	found.FeedID = rule.FeedID
+	found.CategoryID = rule.CategoryID
+	found.Field = rule.Field
+	found.Pattern = rule.Pattern
+	found.Action = rule.Action
+	found.Value = rule.Value
+	db.db.Save(found)
+
+	*rule = *found
+	return nil
+}
+
+// DeleteRule with id and owned by user
+func (db *DB) DeleteRule(id string, user *models.User) error {
+	rule := &models.Rule{}
+	if db.db.Model(user).Where("api_id = ?", id).Related(rule).RecordNotFound() {
+		return NotFound{"Rule does not exist"}
+	}
+
+	db.db.Delete(rule)
+	return nil
+}
+
+// Rule returns a Rule with id and owned by user
+func (db *DB) Rule(id string, user *models.User) (rule models.Rule, err error) {
+	if db.db.Model(user).Where("api_id = ?", id).Related(&rule).RecordNotFound() {
+		err = NotFound{"Rule does not exist"}
+	}
+	return
+}
+
+// Rules returns a list of all Rules owned by user in the order they were created
+func (db *DB) Rules(user *models.User) (rules []models.Rule) {
+	db.db.Model(user).Order("id ASC").Association("Rules").Find(&rules)
+	return
+}
+
+// verifyRule checks that rule can be applied and that the
+// Feed, Category and Tag it refers to are owned by user.
+func (db *DB) verifyRule(rule *models.Rule, user *models.User) error {
+	switch rule.Field {
+	case "":
+		if rule.Pattern != "" {
+			return BadRequest{"Rule with a pattern should have a field"}
+		}
+	case models.TitleField, models.AuthorField, models.ContentField, models.LinkField:
+		if _, err := regexp.Compile(rule.Pattern); err != nil {
+			return BadRequest{"Rule pattern is not a valid regular expression"}
+		}
+	default:
+		return BadRequest{"Rule field should be title, author, content or link"}
+	}
+
+	if rule.FeedID == "" && rule.CategoryID == "" && rule.Pattern == "" {
+		return BadRequest{"Rule should match a feed, a category or a pattern"}
+	}
+
+	if rule.FeedID != "" {
+		if _, err := db.Feed(rule.FeedID, user); err != nil {
+			return err
+		}
+	}
+
+	if rule.CategoryID != "" {
+		if _, err := db.Category(rule.CategoryID, user); err != nil {
+			return err
+		}
+	}
+
+	switch rule.Action {
+	case models.MarkAction:
+		if models.MarkerFromString(rule.Value) == models.None {
+			return BadRequest{"Mark rule value should be read or unread"}
+		}
+	case models.TagAction:
+		if _, err := db.Tag(rule.Value, user); err != nil {
+			return err
+		}
+	case models.SaveAction, models.DiscardAction:
+		rule.Value = ""
+	default:
+		return BadRequest{"Rule action should be mark, save, tag or discard"}
+	}
+
+	return nil
+}
@@ -0,0 +1,308 @@
+/*
+  Copyright (C) 2017 Jorge Martinez Hernandez
+
+  This program is free software: you can redistribute it and/or modify
+  it under the terms of the GNU Affero General Public License as published by
+  the Free Software Foundation, either version 3 of the License, or
+  (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU Affero General Public License for more details.
+
+  You should have received a copy of the GNU Affero General Public License
+  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package database
+
+import (
+	"strings"
+
+	"github.com/jinzhu/gorm"
+
+	"github.com/varddum/syndication/models"
+)
+
+// SearchOrder determines how the results of a search are ranked.
+type SearchOrder int
+
+// Search orders
+const (
+	ByRelevance SearchOrder = iota
+	ByNewest
+	ByOldest
+)
+
+// Search describes a full-text search over the title, author, summary and
+// content of the Entries owned by a user. At most one of FeedID, CategoryID
+// and TagID can restrict the search, Saved restricts it to saved Entries
+// and a zero Limit returns every match.
+type Search struct {
+	Text       string
+	FeedID     string
+	CategoryID string
+	TagID      string
+	Marker     models.Marker
+	Saved      bool
+	Order      SearchOrder
+	Limit      int
+}
+
+// searchIndex hides the full-text facility of each database behind
+// the operations that SearchEntries needs.
+type searchIndex interface {
+	// create builds the index if it does not exist yet.
+	create(db *gorm.DB) error
+
+	// match restricts query to the entries that match text and selects
+	// their relevance, higher being better, as a column named relevance.
+	match(query *gorm.DB, text string) *gorm.DB
+}
+
+func newSearchIndex(dbType string) searchIndex {
+	switch dbType {
+	case "sqlite3":
+		return &sqliteSearch{}
+	case "mysql":
+		return mysqlSearch{}
+	case "postgres":
+		return postgresSearch{}
+	}
+
+	return nil
+}
+
+// SearchEntries returns the Entries owned by user that match search.
+func (db *DB) SearchEntries(search Search, user *models.User) (entries []models.Entry, err error) {
+	if db.search == nil {
+		err = InternalError{"Search is not supported by this database"}
+		return
+	}
+
+	text := strings.TrimSpace(search.Text)
+	if text == "" {
+		err = BadRequest{"Search should include text"}
+		return
+	}
+
+	if search.Marker == models.None {
+		err = BadRequest{"Request should include a valid marker"}
+		return
+	}
+
+	if search.Limit < 0 {
+		err = BadRequest{"Search limit should not be negative"}
+		return
+	}
+
+	query := db.search.match(db.db.Model(&models.Entry{}), text).
+		Where("entries.user_id = ?", user.ID)
+
+	if search.Marker != models.Any {
+		query = query.Where("entries.mark = ?", search.Marker)
+	}
+
+	if search.Saved {
+		query = query.Where("entries.saved = ?", true)
+	}
+
+	query, err = db.restrictSearch(query, search, user)
+	if err != nil {
+		return
+	}
+
+	switch search.Order {
+	case ByNewest:
+		query = query.Order("entries.published DESC").Order("entries.id DESC")
+	case ByOldest:
+		query = query.Order("entries.published ASC").Order("entries.id ASC")
+	default:
+		query = query.Order("relevance DESC").Order("entries.published DESC")
+	}
+
+	if search.Limit > 0 {
+		query = query.Limit(search.Limit)
+	}
+
+	err = query.Find(&entries).Error
+	if err != nil {
+		err = InternalError{"Search failed"}
+		return
+	}
+
+	db.loadEnclosures(entries)
+
+	return
+}
+
+// restrictSearch limits query to the feed, category or tag named by search.
+func (db *DB) restrictSearch(query *gorm.DB, search Search, user *models.User) (*gorm.DB, error) {
+	restrictions := 0
+	for _, id := range []string{search.FeedID, search.CategoryID, search.TagID} {
+		if id != "" {
+			restrictions++
+		}
+	}
+
+	if restrictions > 1 {
+		return nil, BadRequest{"Search can only be restricted to one feed, category or tag"}
+	}
+
+	switch {
+	case search.FeedID != "":
+		feed := &models.Feed{}
+		if db.db.Model(user).Where("api_id = ?", search.FeedID).Related(feed).RecordNotFound() {
+			return nil, NotFound{"Feed not found"}
+		}
+
+		query = query.Where("entries.feed_id = ?", feed.ID)
+	case search.CategoryID != "":
+		category := &models.Category{}
+		if db.db.Model(user).Where("api_id = ?", search.CategoryID).Related(category).RecordNotFound() {
+			return nil, NotFound{"Category not found"}
+		}
+
+		var feeds []models.Feed
+		db.db.Model(category).Related(&feeds)
+
+		feedIds := make([]uint, len(feeds))
+		for i, feed := range feeds {
+			feedIds[i] = feed.ID
+		}
+
+		query = query.Where("entries.feed_id in (?)", feedIds)
+	case search.TagID != "":
+		tag := &models.Tag{}
+		if db.db.Model(user).Where("api_id = ?", search.TagID).Related(tag).RecordNotFound() {
+			return nil, NotFound{"Tag not found"}
+		}
+
+		query = query.Joins("JOIN entry_tags ON entry_tags.entry_id = entries.id").Where("entry_tags.tag_id = ?", tag.ID)
+	}
+
+	return query, nil
+}
+
+// sqliteSearch indexes entries in an FTS5 virtual table kept up to date by
+// triggers. FTS5 is only available when the sqlite3 driver is built with it,
+// so FTS4 is used in its place otherwise.
+type sqliteSearch struct {
+	fts5 bool
+}
+
+func (s *sqliteSearch) create(db *gorm.DB) error {
+	var module string
+	row := db.Raw("SELECT sql FROM sqlite_master WHERE type = 'table' AND name = 'entries_search'").Row()
+	if row.Scan(&module) == nil {
+		s.fts5 = strings.Contains(strings.ToLower(module), "fts5")
+	} else {
+		var enabled int
+		db.Raw("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Row().Scan(&enabled)
+		s.fts5 = enabled == 1
+
+		var err error
+		if s.fts5 {
+			err = db.Exec("CREATE VIRTUAL TABLE entries_search USING fts5(title, author, summary, content)").Error
+		} else {
+			err = db.Exec("CREATE VIRTUAL TABLE entries_search USING fts4(title, author, summary, content)").Error
+		}
+
+		if err != nil {
+			return err
+		}
+
+		err = db.Exec(`INSERT INTO entries_search (rowid, title, author, summary, content)
+			SELECT id, title, author, summary, content FROM entries`).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	triggers := []string{
+		`CREATE TRIGGER IF NOT EXISTS entries_search_insert AFTER INSERT ON entries BEGIN
+			INSERT INTO entries_search (rowid, title, author, summary, content)
+			VALUES (new.id, new.title, new.author, new.summary, new.content);
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS entries_search_update AFTER UPDATE OF title, author, summary, content ON entries BEGIN
+			UPDATE entries_search SET title = new.title, author = new.author, summary = new.summary, content = new.content
+			WHERE rowid = new.id;
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS entries_search_delete AFTER DELETE ON entries BEGIN
+			DELETE FROM entries_search WHERE rowid = old.id;
+		END`,
+	}
+
+	for _, trigger := range triggers {
+		if err := db.Exec(trigger).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *sqliteSearch) match(query *gorm.DB, text string) *gorm.DB {
+	// FTS5 ranks with bm25, where lower is better. FTS4 has no ranking function
+	// so the number of matched phrases reported by offsets is used instead.
+	relevance := "-bm25(entries_search)"
+	if !s.fts5 {
+		relevance = "length(offsets(entries_search))"
+	}
+
+	return query.
+		Select("entries.*, "+relevance+" AS relevance").
+		Joins("JOIN entries_search ON entries_search.rowid = entries.id").
+		Where("entries_search MATCH ?", sqliteMatchExpr(text))
+}
+
+// sqliteMatchExpr quotes every word of text so that it is matched
+// literally instead of being parsed as FTS query syntax.
+func sqliteMatchExpr(text string) string {
+	words := strings.Fields(text)
+	for i, word := range words {
+		words[i] = `"` + strings.Replace(word, `"`, `""`, -1) + `"`
+	}
+
+	return strings.Join(words, " ")
+}
+
+// mysqlSearch uses a FULLTEXT index over the searchable entry columns.
+type mysqlSearch struct{}
+
+const mysqlMatch = "MATCH (entries.title, entries.author, entries.summary, entries.content) AGAINST (? IN NATURAL LANGUAGE MODE)"
+
+func (mysqlSearch) create(db *gorm.DB) error {
+	var count int
+	err := db.Raw(`SELECT COUNT(*) FROM information_schema.statistics
+		WHERE table_schema = DATABASE() AND table_name = 'entries' AND index_name = 'idx_entries_search'`).Row().Scan(&count)
+	if err != nil || count > 0 {
+		return err
+	}
+
+	return db.Exec("CREATE FULLTEXT INDEX idx_entries_search ON entries (title, author, summary, content)").Error
+}
+
+func (mysqlSearch) match(query *gorm.DB, text string) *gorm.DB {
+	return query.
+		Select("entries.*, "+mysqlMatch+" AS relevance", text).
+		Where(mysqlMatch, text)
+}
+
+// postgresSearch uses a GIN index over the tsvector of the searchable entry
+// columns. Queries must use the exact expression the index was built on.
+type postgresSearch struct{}
+
+const postgresDocument = `to_tsvector('simple', coalesce(title, '') || ' ' || coalesce(author, '') || ' ' ||
+	coalesce(summary, '') || ' ' || coalesce(content, ''))`
+
+func (postgresSearch) create(db *gorm.DB) error {
+	return db.Exec("CREATE INDEX IF NOT EXISTS idx_entries_search ON entries USING GIN (" + postgresDocument + ")").Error
+}
+
+func (postgresSearch) match(query *gorm.DB, text string) *gorm.DB {
+	return query.
+		Select("entries.*, ts_rank("+postgresDocument+", plainto_tsquery('simple', ?)) AS relevance", text).
+		Where(postgresDocument+" @@ plainto_tsquery('simple', ?)", text)
+}
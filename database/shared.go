@@ -0,0 +1,92 @@
+/*
+Copyright (C) 2017 Jorge Martinez Hernandez
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package database
+
+import (
+	"github.com/varddum/syndication/models"
+)
+
+type (
+	// Subscriber is the feed a user keeps for a subscription.
+	Subscriber struct {
+		Feed models.Feed
+		User models.User
+	}
+
+	// SharedFeed is a subscription URL and every user subscribed to it.
+	// The users' feeds are separate rows, but the subscription only needs
+	// to be fetched once for all of them.
+	SharedFeed struct {
+		Subscription string
+		Subscribers  []Subscriber
+	}
+)
+
+// HubSubscribers returns the Feeds, and the Users that own them, that
+// receive WebSub pushes at callback. Feeds with the same subscription
+// URL share a callback.
+func (db *DB) HubSubscribers(callback string) (subscribers []Subscriber, err error) {
+	var feeds []models.Feed
+	if callback != "" {
+		err = db.db.Where("hub_callback = ?", callback).Order("id").Find(&feeds).Error
+		if err != nil {
+			return
+		}
+	}
+
+	for _, feed := range feeds {
+		var user models.User
+		if db.db.First(&user, feed.UserID).RecordNotFound() {
+			continue
+		}
+
+		subscribers = append(subscribers, Subscriber{Feed: feed, User: user})
+	}
+
+	if len(subscribers) == 0 {
+		err = NotFound{"Feed does not exist"}
+	}
+	return
+}
+
+// SharedFeeds returns the feeds of all users grouped by subscription URL.
+func (db *DB) SharedFeeds() (shared []SharedFeed) {
+	users := map[uint]models.User{}
+	for _, user := range db.Users() {
+		users[user.ID] = user
+	}
+
+	var feeds []models.Feed
+	db.db.Order("subscription, id").Find(&feeds)
+
+	for _, feed := range feeds {
+		user, ok := users[feed.UserID]
+		if !ok {
+			continue
+		}
+
+		if len(shared) == 0 || shared[len(shared)-1].Subscription != feed.Subscription {
+			shared = append(shared, SharedFeed{Subscription: feed.Subscription})
+		}
+
+		last := &shared[len(shared)-1]
+		last.Subscribers = append(last.Subscribers, Subscriber{Feed: feed, User: user})
+	}
+
+	return
+}
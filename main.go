@@ -94,7 +94,7 @@ func startApp(c *cli.Context) error {
 
 	sync := sync.NewSync(db, conf.Sync)
 
-	admin, err := admin.NewAdmin(db, conf.Admin.SocketPath)
+	admin, err := admin.NewAdmin(db, sync, conf.Admin.SocketPath)
 	if err != nil {
 		return err
 	}
@@ -41,6 +41,33 @@ const (
 	// Saved identifies an entity as permenantly saved.
 	// This only applies to Entries.
 	Saved = "saved"
+
+	// Dead identifies a Feed that failed too many consecutive syncs.
+	// This only applies to Feeds.
+	Dead = "dead"
+)
+
+// Sources a Publication draws its Entries from
+const (
+	CategorySource = "category"
+	TagSource      = "tag"
+	SavedSource    = "saved"
+)
+
+// Entry fields a Rule can match
+const (
+	TitleField   = "title"
+	AuthorField  = "author"
+	ContentField = "content"
+	LinkField    = "link"
+)
+
+// Actions a Rule can apply to the Entries it matches
+const (
+	MarkAction    = "mark"
+	SaveAction    = "save"
+	TagAction     = "tag"
+	DiscardAction = "discard"
 )
 
 // MarkerFromString converts a string to a Marker type
@@ -75,11 +102,18 @@ type (
 		APIKeys    []APIKey   `json:"-"`
 		Tags       []Tag      `json:"tags,omitempty"`
 
+		Publications []Publication `json:"-"`
+		Rules        []Rule        `json:"-"`
+
 		Username                   string `json:"username,required"`
 		Email                      string `json:"email,optional"`
 		PasswordHash               []byte `json:"-"`
 		PasswordSalt               []byte `json:"-"`
 		UncategorizedCategoryAPIID string `json:"-"`
+
+		// FeverKey is the MD5 API key used by Fever clients.
+		// The Fever API is disabled for the user when it is empty.
+		FeverKey string `json:"-" gorm:"index"`
 	}
 
 	// Category represents a container for Feed entities.
@@ -96,6 +130,8 @@ type (
 		Feeds []Feed `json:"-"`
 
 		Name string `json:"name"`
+
+		RetentionPolicy
 	}
 
 	// Feed represents an Atom or RSS feed subscription.
@@ -120,8 +156,48 @@ type (
 		Source       string    `json:"source,omitempty"`
 		TTL          int       `json:"ttl,omitempty"`
 		Etag         string    `json:"-"`
+		LastModified string    `json:"-"`
 		LastUpdated  time.Time `json:"-"`
 		Status       string    `json:"status,omitempty"`
+
+		// Health of the feed as seen by its most recent syncs.
+		LastFetched    time.Time `json:"last_fetched"`
+		LastSuccess    time.Time `json:"last_success"`
+		Failures       int       `json:"failures"`
+		LastStatusCode int       `json:"last_status_code,omitempty"`
+		LastError      string    `json:"last_error,omitempty"`
+
+		RetentionPolicy
+
+		// ContentLength is the size of the last full response served for
+		// the feed and is used to estimate what a 304 response saved.
+		ContentLength int64 `json:"-"`
+
+		// NextSync is the earliest time the feed should be fetched again and
+		// SyncInterval is the polling interval it was derived from.
+		NextSync     time.Time     `json:"-"`
+		SyncInterval time.Duration `json:"-"`
+
+		// WebSub subscription of the feed. Hub pushes are received at
+		// a callback identified by HubCallback and signed with HubSecret.
+		// The feed is pushed while its lease has not expired, the lease
+		// is renewed from HubLeaseRenews on and no subscription is
+		// requested before HubRetryAt.
+		Hub             string    `json:"-"`
+		HubTopic        string    `json:"-"`
+		HubCallback     string    `json:"-" gorm:"index"`
+		HubSecret       string    `json:"-"`
+		HubLeaseExpires time.Time `json:"-"`
+		HubLeaseRenews  time.Time `json:"-"`
+		HubRetryAt      time.Time `json:"-"`
+
+		// Icon of the feed. ImageURL is where the icon was found, Icon
+		// is its key in the icon cache and IconType its content type.
+		// Icons are looked up again once IconCheckedAt is old enough.
+		ImageURL      string    `json:"image_url,omitempty"`
+		Icon          string    `json:"-"`
+		IconType      string    `json:"-"`
+		IconCheckedAt time.Time `json:"-"`
 	}
 
 	// Tag represents an identifier object that can be applied to Entry objects.
@@ -156,13 +232,49 @@ type (
 
 		Tags []Tag `json:"tags" gorm:"many2many:entry_tags;"`
 
+		Enclosures []Enclosure `json:"enclosures,omitempty"`
+
 		GUID      string    `json:"-"`
 		Title     string    `json:"title"`
 		Link      string    `json:"link"`
 		Author    string    `json:"author"`
+		Summary   string    `json:"summary,omitempty" gorm:"type:text"`
+		Content   string    `json:"content,omitempty" gorm:"type:text"`
 		Published time.Time `json:"published"`
 		Saved     bool      `json:"isSaved"`
 		Mark      Marker    `json:"markedAs"`
+
+		// MarkedAt is when Mark last changed, which is zero for
+		// entries that kept the mark they were stored with.
+		MarkedAt time.Time `json:"-"`
+	}
+
+	// Enclosure represents a media file attached to an Entry, like a podcast
+	// episode. Duration and Position are in seconds. Position and Completed
+	// track how much of the enclosure its User has listened to.
+	Enclosure struct {
+		ID        uint      `json:"-" gorm:"primary_key"`
+		CreatedAt time.Time `json:"created_at"`
+		UpdatedAt time.Time `json:"updated_at"`
+
+		APIID string `json:"id"`
+
+		User   User `json:"-"`
+		UserID uint `json:"-"`
+
+		Entry   Entry `json:"-"`
+		EntryID uint  `json:"-" gorm:"index"`
+
+		URL      string `json:"url"`
+		Type     string `json:"type,omitempty"`
+		Length   int64  `json:"length,omitempty"`
+		Duration int64  `json:"duration,omitempty"`
+		Image    string `json:"image,omitempty"`
+		Subtitle string `json:"subtitle,omitempty"`
+		Explicit bool   `json:"explicit,omitempty"`
+
+		Position  int64 `json:"position"`
+		Completed bool  `json:"completed"`
 	}
 
 	// Stats represents statistics related to various attributes of Feed, Entry, and Category objects.
@@ -173,6 +285,72 @@ type (
 		Total  int `json:"total"`
 	}
 
+	// RetentionPolicy determines which Entries of a Feed are purged. Zero values
+	// inherit the policy of the owning Category or the configured defaults and
+	// negative values keep entries regardless of the inherited policy.
+	RetentionPolicy struct {
+		KeepReadDays int `json:"keep_read_days,omitempty"`
+		MaxEntries   int `json:"max_entries,omitempty"`
+	}
+
+	// PurgedEntry records the GUID of an Entry removed by a purge
+	// so that the entry is not imported again by the next sync.
+	PurgedEntry struct {
+		ID        uint      `json:"-" gorm:"primary_key"`
+		CreatedAt time.Time `json:"-"`
+
+		GUID string `json:"-" gorm:"index"`
+
+		FeedID uint `json:"-" gorm:"index"`
+		UserID uint `json:"-"`
+	}
+
+	// Publication exposes the Entries of a Category, a Tag, or the saved
+	// Entries of a User as a feed that can be read with its Token alone.
+	Publication struct {
+		ID        uint      `json:"-" gorm:"primary_key"`
+		CreatedAt time.Time `json:"created_at"`
+		UpdatedAt time.Time `json:"updated_at"`
+
+		APIID string `json:"id"`
+
+		User   User `json:"-"`
+		UserID uint `json:"-"`
+
+		Token    string `json:"token" gorm:"index"`
+		Title    string `json:"title,optional"`
+		Source   string `json:"source,required"`
+		SourceID string `json:"source_id,omitempty"`
+
+		// ContentDigest identifies the Entries the Publication was last
+		// served with and ContentUpdatedAt is when they last changed.
+		ContentDigest    string    `json:"-"`
+		ContentUpdatedAt time.Time `json:"-"`
+	}
+
+	// Rule acts on the Entries received by a sync before they are saved.
+	// A Rule can be limited to a Feed or a Category and matches Entries whose
+	// Field matches the regular expression Pattern. Value is the marker
+	// applied by a mark action or the id of the Tag applied by a tag action.
+	Rule struct {
+		ID        uint      `json:"-" gorm:"primary_key"`
+		CreatedAt time.Time `json:"created_at"`
+		UpdatedAt time.Time `json:"updated_at"`
+
+		APIID string `json:"id"`
+
+		User   User `json:"-"`
+		UserID uint `json:"-"`
+
+		Name       string `json:"name,optional"`
+		FeedID     string `json:"feed_id,omitempty"`
+		CategoryID string `json:"category_id,omitempty"`
+		Field      string `json:"field,omitempty"`
+		Pattern    string `json:"pattern,omitempty"`
+		Action     string `json:"action,required"`
+		Value      string `json:"value,omitempty"`
+	}
+
 	// APIKey represents an SQL schema for Java Web Tokens created for User objects.
 	APIKey struct {
 		ID        uint      `json:"-" gorm:"primary_key"`
@@ -0,0 +1,266 @@
+/*
+  Copyright (C) 2017 Jorge Martinez Hernandez
+
+  This program is free software: you can redistribute it and/or modify
+  it under the terms of the GNU Affero General Public License as published by
+  the Free Software Foundation, either version 3 of the License, or
+  (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU Affero General Public License for more details.
+
+  You should have received a copy of the GNU Affero General Public License
+  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package opml imports and exports a user's subscriptions as OPML 2.0 documents.
+// See http://dev.opml.org/spec2.html for more information on the format.
+package opml
+
+import (
+	"encoding/xml"
+	"io"
+	"net/url"
+	"time"
+
+	"github.com/varddum/syndication/database"
+	"github.com/varddum/syndication/models"
+)
+
+// Version is the OPML version produced by Export
+const Version = "2.0"
+
+type (
+	// OPML represents an OPML document.
+	OPML struct {
+		XMLName xml.Name `xml:"opml"`
+		Version string   `xml:"version,attr"`
+		Head    Head     `xml:"head"`
+		Body    Body     `xml:"body"`
+	}
+
+	// Head represents the metadata of an OPML document.
+	Head struct {
+		Title       string `xml:"title,omitempty"`
+		DateCreated string `xml:"dateCreated,omitempty"`
+	}
+
+	// Body contains the top level outlines of an OPML document.
+	Body struct {
+		Outlines []Outline `xml:"outline"`
+	}
+
+	// Outline represents either a feed subscription, when XMLURL is set,
+	// or a category containing other outlines.
+	Outline struct {
+		Text        string    `xml:"text,attr"`
+		Title       string    `xml:"title,attr,omitempty"`
+		Type        string    `xml:"type,attr,omitempty"`
+		XMLURL      string    `xml:"xmlUrl,attr,omitempty"`
+		HTMLURL     string    `xml:"htmlUrl,attr,omitempty"`
+		Description string    `xml:"description,attr,omitempty"`
+		Outlines    []Outline `xml:"outline"`
+	}
+
+	// ImportResult reports the outcome of importing an OPML document.
+	ImportResult struct {
+		Imported []models.Feed   `json:"imported"`
+		Skipped  []string        `json:"skipped"`
+		Failures []ImportFailure `json:"failures"`
+	}
+
+	// ImportFailure describes an outline that could not be imported.
+	ImportFailure struct {
+		Outline string `json:"outline"`
+		Reason  string `json:"reason"`
+	}
+)
+
+// BadRequest is returned when an OPML document cannot be parsed.
+type BadRequest struct {
+	msg string
+}
+
+func (e BadRequest) Error() string {
+	return e.msg
+}
+
+func (e BadRequest) String() string {
+	return "Bad Request"
+}
+
+// Code returns BadRequest's corresponding error code
+func (e BadRequest) Code() int {
+	return 400
+}
+
+// Parse decodes an OPML document from r.
+func Parse(r io.Reader) (doc OPML, err error) {
+	if err = xml.NewDecoder(r).Decode(&doc); err != nil {
+		err = BadRequest{"Document is not valid OPML"}
+	}
+	return
+}
+
+// Import creates Categories and Feeds owned by user from the outlines in doc.
+// Outlines that fail to import are reported in the result and do not stop the import.
+// Feeds the user is already subscribed to are skipped.
+func Import(db *database.DB, doc OPML, user *models.User) ImportResult {
+	result := ImportResult{
+		Imported: []models.Feed{},
+		Skipped:  []string{},
+		Failures: []ImportFailure{},
+	}
+
+	subscribed := map[string]bool{}
+	for _, feed := range db.Feeds(user) {
+		subscribed[feed.Subscription] = true
+	}
+
+	categories := map[string]string{}
+	for _, ctg := range db.Categories(user) {
+		categories[ctg.Name] = ctg.APIID
+	}
+
+	for _, outline := range doc.Body.Outlines {
+		if outline.XMLURL != "" {
+			importFeed(db, outline, user.UncategorizedCategoryAPIID, subscribed, &result, user)
+			continue
+		}
+
+		ctgID, ok := categories[outline.name()]
+		if !ok {
+			ctg := models.Category{
+				Name: outline.name(),
+			}
+
+			if err := db.NewCategory(&ctg, user); err != nil {
+				result.Failures = append(result.Failures, ImportFailure{
+					Outline: outline.name(),
+					Reason:  err.Error(),
+				})
+				continue
+			}
+
+			ctgID = ctg.APIID
+			categories[ctg.Name] = ctgID
+		}
+
+		for _, child := range outline.feeds() {
+			importFeed(db, child, ctgID, subscribed, &result, user)
+		}
+	}
+
+	return result
+}
+
+func importFeed(db *database.DB, outline Outline, ctgID string, subscribed map[string]bool, result *ImportResult, user *models.User) {
+	if subscribed[outline.XMLURL] {
+		result.Skipped = append(result.Skipped, outline.XMLURL)
+		return
+	}
+
+	subscription, err := url.Parse(outline.XMLURL)
+	if err != nil || (subscription.Scheme != "http" && subscription.Scheme != "https") {
+		result.Failures = append(result.Failures, ImportFailure{
+			Outline: outline.name(),
+			Reason:  "Feed has an invalid URL",
+		})
+		return
+	}
+
+	feed := models.Feed{
+		Title:        outline.name(),
+		Description:  outline.Description,
+		Subscription: outline.XMLURL,
+		Source:       outline.HTMLURL,
+		Category: models.Category{
+			APIID: ctgID,
+		},
+	}
+
+	if err = db.NewFeed(&feed, user); err != nil {
+		result.Failures = append(result.Failures, ImportFailure{
+			Outline: outline.name(),
+			Reason:  err.Error(),
+		})
+		return
+	}
+
+	subscribed[feed.Subscription] = true
+	result.Imported = append(result.Imported, feed)
+}
+
+// Export creates an OPML document from all Categories and Feeds owned by user.
+// Feeds without a category are placed at the top level of the document.
+func Export(db *database.DB, user *models.User) OPML {
+	doc := OPML{
+		Version: Version,
+		Head: Head{
+			Title:       user.Username + " subscriptions",
+			DateCreated: time.Now().Format(time.RFC1123Z),
+		},
+	}
+
+	feedsByCategory := map[uint][]Outline{}
+	for _, feed := range db.Feeds(user) {
+		feedsByCategory[feed.CategoryID] = append(feedsByCategory[feed.CategoryID], Outline{
+			Text:        feed.Title,
+			Title:       feed.Title,
+			Type:        "rss",
+			XMLURL:      feed.Subscription,
+			HTMLURL:     feed.Source,
+			Description: feed.Description,
+		})
+	}
+
+	for _, ctg := range db.Categories(user) {
+		feeds := feedsByCategory[ctg.ID]
+		if ctg.APIID == user.UncategorizedCategoryAPIID {
+			doc.Body.Outlines = append(doc.Body.Outlines, feeds...)
+			continue
+		}
+
+		doc.Body.Outlines = append(doc.Body.Outlines, Outline{
+			Text:     ctg.Name,
+			Title:    ctg.Name,
+			Outlines: feeds,
+		})
+	}
+
+	return doc
+}
+
+// Marshal encodes doc as an indented XML document with an XML header.
+func Marshal(doc OPML) ([]byte, error) {
+	b, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte(xml.Header), b...), nil
+}
+
+func (o Outline) name() string {
+	if o.Title != "" {
+		return o.Title
+	}
+
+	return o.Text
+}
+
+// feeds returns all feed outlines nested under o. OPML allows
+// arbitrary nesting, but categories cannot be nested so deeper
+// outlines are flattened into o.
+func (o Outline) feeds() (feeds []Outline) {
+	for _, child := range o.Outlines {
+		if child.XMLURL != "" {
+			feeds = append(feeds, child)
+		}
+
+		feeds = append(feeds, child.feeds()...)
+	}
+	return
+}
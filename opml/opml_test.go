@@ -0,0 +1,161 @@
+/*
+  Copyright (C) 2017 Jorge Martinez Hernandez
+
+  This program is free software: you can redistribute it and/or modify
+  it under the terms of the GNU Affero General Public License as published by
+  the Free Software Foundation, either version 3 of the License, or
+  (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU Affero General Public License for more details.
+
+  You should have received a copy of the GNU Affero General Public License
+  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package opml
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/varddum/syndication/config"
+	"github.com/varddum/syndication/database"
+	"github.com/varddum/syndication/models"
+)
+
+const TestDatabasePath = "/tmp/syndication-test-opml.db"
+
+const testOPML = `<?xml version="1.0" encoding="UTF-8"?>
+<opml version="2.0">
+  <head>
+    <title>Subscriptions</title>
+  </head>
+  <body>
+    <outline text="EFF Updates" type="rss" xmlUrl="https://www.eff.org/rss/updates.xml" htmlUrl="https://www.eff.org"/>
+    <outline text="News">
+      <outline text="Example News" type="rss" xmlUrl="http://example.com/news.xml"/>
+      <outline text="Nested">
+        <outline text="Example Tech" type="rss" xmlUrl="http://example.com/tech.xml"/>
+      </outline>
+    </outline>
+    <outline text="Broken" type="rss" xmlUrl="ftp://example.com/feed.xml"/>
+  </body>
+</opml>`
+
+type (
+	OPMLTestSuite struct {
+		suite.Suite
+
+		db   *database.DB
+		user models.User
+	}
+)
+
+func (suite *OPMLTestSuite) SetupTest() {
+	var err error
+	suite.db, err = database.NewDB(config.Database{
+		Type:       "sqlite3",
+		Connection: TestDatabasePath,
+	})
+	suite.Require().Nil(err)
+
+	err = suite.db.NewUser("test", "golang")
+	suite.Require().Nil(err)
+
+	suite.user, err = suite.db.UserWithName("test")
+	suite.Require().Nil(err)
+}
+
+func (suite *OPMLTestSuite) TearDownTest() {
+	err := suite.db.Close()
+	suite.Nil(err)
+	err = os.Remove(TestDatabasePath)
+	suite.Nil(err)
+}
+
+func (suite *OPMLTestSuite) TestParseInvalidDocument() {
+	_, err := Parse(strings.NewReader("not opml"))
+	suite.IsType(BadRequest{}, err)
+}
+
+func (suite *OPMLTestSuite) TestImport() {
+	doc, err := Parse(strings.NewReader(testOPML))
+	suite.Require().Nil(err)
+
+	result := Import(suite.db, doc, &suite.user)
+	suite.Len(result.Imported, 3)
+	suite.Empty(result.Skipped)
+	suite.Require().Len(result.Failures, 1)
+	suite.Equal("Broken", result.Failures[0].Outline)
+
+	feeds := suite.db.Feeds(&suite.user)
+	suite.Len(feeds, 3)
+
+	var news models.Category
+	for _, ctg := range suite.db.Categories(&suite.user) {
+		if ctg.Name == "News" {
+			news = ctg
+		}
+	}
+	suite.Require().NotEmpty(news.APIID)
+
+	ctgFeeds, err := suite.db.FeedsFromCategory(news.APIID, &suite.user)
+	suite.Require().Nil(err)
+	suite.Len(ctgFeeds, 2)
+}
+
+func (suite *OPMLTestSuite) TestImportSkipsExistingFeeds() {
+	feed := models.Feed{
+		Title:        "Example News",
+		Subscription: "http://example.com/news.xml",
+	}
+
+	err := suite.db.NewFeed(&feed, &suite.user)
+	suite.Require().Nil(err)
+
+	doc, err := Parse(strings.NewReader(testOPML))
+	suite.Require().Nil(err)
+
+	result := Import(suite.db, doc, &suite.user)
+	suite.Len(result.Imported, 2)
+	suite.Equal([]string{"http://example.com/news.xml"}, result.Skipped)
+
+	suite.Len(suite.db.Feeds(&suite.user), 3)
+}
+
+func (suite *OPMLTestSuite) TestExport() {
+	doc, err := Parse(strings.NewReader(testOPML))
+	suite.Require().Nil(err)
+
+	Import(suite.db, doc, &suite.user)
+
+	b, err := Marshal(Export(suite.db, &suite.user))
+	suite.Require().Nil(err)
+
+	exported, err := Parse(bytes.NewReader(b))
+	suite.Require().Nil(err)
+	suite.Equal(Version, exported.Version)
+
+	var feeds, categories int
+	for _, outline := range exported.Body.Outlines {
+		if outline.XMLURL != "" {
+			feeds++
+		} else {
+			categories++
+			feeds += len(outline.Outlines)
+		}
+	}
+
+	suite.Equal(3, feeds)
+	suite.Equal(1, categories)
+}
+
+func TestOPMLTestSuite(t *testing.T) {
+	suite.Run(t, new(OPMLTestSuite))
+}
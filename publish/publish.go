@@ -0,0 +1,328 @@
+/*
+  Copyright (C) 2017 Jorge Martinez Hernandez
+
+  This program is free software: you can redistribute it and/or modify
+  it under the terms of the GNU Affero General Public License as published by
+  the Free Software Foundation, either version 3 of the License, or
+  (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU Affero General Public License for more details.
+
+  You should have received a copy of the GNU Affero General Public License
+  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package publish renders lists of Entries as Atom, RSS 2.0 and JSON Feed documents.
+// See https://tools.ietf.org/html/rfc4287, http://www.rssboard.org/rss-specification
+// and https://jsonfeed.org/version/1.1 for more information on the formats.
+package publish
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"net/url"
+	"time"
+
+	"github.com/varddum/syndication/models"
+)
+
+// Formats a Feed can be rendered as
+const (
+	Atom     = "atom"
+	RSS      = "rss"
+	JSONFeed = "json"
+)
+
+// Content types of the rendered formats
+const (
+	AtomContentType     = "application/atom+xml; charset=UTF-8"
+	RSSContentType      = "application/rss+xml; charset=UTF-8"
+	JSONFeedContentType = "application/feed+json; charset=UTF-8"
+)
+
+// Feed describes a document generated from a list of Entries.
+// Link is the URL the document is served from.
+type Feed struct {
+	Title   string
+	Link    string
+	Updated time.Time
+	Entries []models.Entry
+}
+
+type (
+	atomFeed struct {
+		XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+		ID      string      `xml:"id"`
+		Title   string      `xml:"title"`
+		Updated string      `xml:"updated"`
+		Links   []atomLink  `xml:"link"`
+		Entries []atomEntry `xml:"entry"`
+	}
+
+	atomLink struct {
+		Href string `xml:"href,attr"`
+		Rel  string `xml:"rel,attr,omitempty"`
+		Type string `xml:"type,attr,omitempty"`
+	}
+
+	atomText struct {
+		Type string `xml:"type,attr"`
+		Body string `xml:",chardata"`
+	}
+
+	atomPerson struct {
+		Name string `xml:"name"`
+	}
+
+	atomEntry struct {
+		ID        string      `xml:"id"`
+		Title     string      `xml:"title"`
+		Updated   string      `xml:"updated"`
+		Published string      `xml:"published,omitempty"`
+		Links     []atomLink  `xml:"link"`
+		Author    *atomPerson `xml:"author"`
+		Summary   *atomText   `xml:"summary"`
+		Content   *atomText   `xml:"content"`
+	}
+
+	rssDocument struct {
+		XMLName xml.Name   `xml:"rss"`
+		Version string     `xml:"version,attr"`
+		DC      string     `xml:"xmlns:dc,attr"`
+		Atom    string     `xml:"xmlns:atom,attr"`
+		Channel rssChannel `xml:"channel"`
+	}
+
+	rssChannel struct {
+		Title         string    `xml:"title"`
+		Link          string    `xml:"link"`
+		Description   string    `xml:"description"`
+		LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+		Self          rssSelf   `xml:"atom:link"`
+		Items         []rssItem `xml:"item"`
+	}
+
+	rssSelf struct {
+		Href string `xml:"href,attr"`
+		Rel  string `xml:"rel,attr"`
+		Type string `xml:"type,attr"`
+	}
+
+	rssGUID struct {
+		IsPermaLink bool   `xml:"isPermaLink,attr"`
+		Value       string `xml:",chardata"`
+	}
+
+	rssItem struct {
+		Title       string  `xml:"title"`
+		Link        string  `xml:"link,omitempty"`
+		Description string  `xml:"description,omitempty"`
+		Creator     string  `xml:"dc:creator,omitempty"`
+		PubDate     string  `xml:"pubDate,omitempty"`
+		GUID        rssGUID `xml:"guid"`
+	}
+
+	jsonFeed struct {
+		Version string         `json:"version"`
+		Title   string         `json:"title"`
+		FeedURL string         `json:"feed_url"`
+		Items   []jsonFeedItem `json:"items"`
+	}
+
+	jsonFeedAuthor struct {
+		Name string `json:"name"`
+	}
+
+	jsonFeedItem struct {
+		ID            string           `json:"id"`
+		URL           string           `json:"url,omitempty"`
+		Title         string           `json:"title,omitempty"`
+		ContentHTML   string           `json:"content_html,omitempty"`
+		Summary       string           `json:"summary,omitempty"`
+		DatePublished string           `json:"date_published,omitempty"`
+		DateModified  string           `json:"date_modified,omitempty"`
+		Authors       []jsonFeedAuthor `json:"authors,omitempty"`
+	}
+)
+
+// BadRequest is returned when a Feed is rendered in an unknown format.
+type BadRequest struct {
+	msg string
+}
+
+func (e BadRequest) Error() string {
+	return e.msg
+}
+
+func (e BadRequest) String() string {
+	return "Bad Request"
+}
+
+// Code returns BadRequest's corresponding error code
+func (e BadRequest) Code() int {
+	return 400
+}
+
+// Render encodes feed in format and returns the document with its content type.
+func Render(format string, feed Feed) (body []byte, contentType string, err error) {
+	switch format {
+	case Atom:
+		body, err = renderAtom(feed)
+		contentType = AtomContentType
+	case RSS:
+		body, err = renderRSS(feed)
+		contentType = RSSContentType
+	case JSONFeed:
+		body, err = json.MarshalIndent(newJSONFeed(feed), "", "  ")
+		contentType = JSONFeedContentType
+	default:
+		err = BadRequest{"Format should be atom, rss or json"}
+	}
+	return
+}
+
+func renderAtom(feed Feed) ([]byte, error) {
+	doc := atomFeed{
+		ID:      feed.Link,
+		Title:   feed.Title,
+		Updated: feed.Updated.UTC().Format(time.RFC3339),
+		Links: []atomLink{
+			{Href: feed.Link, Rel: "self", Type: "application/atom+xml"},
+		},
+	}
+
+	for _, entry := range feed.Entries {
+		item := atomEntry{
+			ID:        entryID(entry),
+			Title:     entry.Title,
+			Updated:   entryUpdated(entry).UTC().Format(time.RFC3339),
+			Published: formatTime(entry.Published, time.RFC3339),
+		}
+
+		if entry.Link != "" {
+			item.Links = []atomLink{{Href: entry.Link, Rel: "alternate"}}
+		}
+
+		if entry.Author != "" {
+			item.Author = &atomPerson{entry.Author}
+		}
+
+		if entry.Summary != "" {
+			item.Summary = &atomText{"html", entry.Summary}
+		}
+
+		if entry.Content != "" {
+			item.Content = &atomText{"html", entry.Content}
+		}
+
+		doc.Entries = append(doc.Entries, item)
+	}
+
+	return marshalXML(doc)
+}
+
+func renderRSS(feed Feed) ([]byte, error) {
+	doc := rssDocument{
+		Version: "2.0",
+		DC:      "http://purl.org/dc/elements/1.1/",
+		Atom:    "http://www.w3.org/2005/Atom",
+		Channel: rssChannel{
+			Title:         feed.Title,
+			Link:          feed.Link,
+			Description:   feed.Title,
+			LastBuildDate: formatTime(feed.Updated, time.RFC1123Z),
+			Self:          rssSelf{feed.Link, "self", "application/rss+xml"},
+		},
+	}
+
+	for _, entry := range feed.Entries {
+		description := entry.Content
+		if description == "" {
+			description = entry.Summary
+		}
+
+		doc.Channel.Items = append(doc.Channel.Items, rssItem{
+			Title:       entry.Title,
+			Link:        entry.Link,
+			Description: description,
+			Creator:     entry.Author,
+			PubDate:     formatTime(entryUpdated(entry), time.RFC1123Z),
+			GUID:        rssGUID{false, entryID(entry)},
+		})
+	}
+
+	return marshalXML(doc)
+}
+
+func newJSONFeed(feed Feed) jsonFeed {
+	doc := jsonFeed{
+		Version: "https://jsonfeed.org/version/1.1",
+		Title:   feed.Title,
+		FeedURL: feed.Link,
+		Items:   []jsonFeedItem{},
+	}
+
+	for _, entry := range feed.Entries {
+		item := jsonFeedItem{
+			ID:            entryID(entry),
+			URL:           entry.Link,
+			Title:         entry.Title,
+			ContentHTML:   entry.Content,
+			Summary:       entry.Summary,
+			DatePublished: formatTime(entry.Published, time.RFC3339),
+			DateModified:  formatTime(entry.UpdatedAt, time.RFC3339),
+		}
+
+		// JSON Feed items need content, so summaries stand in for missing content.
+		if item.ContentHTML == "" {
+			item.ContentHTML = entry.Summary
+		}
+
+		if entry.Author != "" {
+			item.Authors = []jsonFeedAuthor{{entry.Author}}
+		}
+
+		doc.Items = append(doc.Items, item)
+	}
+
+	return doc
+}
+
+func marshalXML(doc interface{}) ([]byte, error) {
+	b, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte(xml.Header), b...), nil
+}
+
+// entryID returns a stable identifier for entry. The GUID of the
+// entry is used when it is an absolute URI so readers that saw the
+// original feed recognize the entry.
+func entryID(entry models.Entry) string {
+	if u, err := url.Parse(entry.GUID); err == nil && u.IsAbs() {
+		return entry.GUID
+	}
+
+	return "urn:syndication:entry:" + url.PathEscape(entry.APIID)
+}
+
+func entryUpdated(entry models.Entry) time.Time {
+	if entry.Published.IsZero() {
+		return entry.CreatedAt
+	}
+
+	return entry.Published
+}
+
+func formatTime(t time.Time, layout string) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	return t.UTC().Format(layout)
+}
@@ -0,0 +1,137 @@
+/*
+  Copyright (C) 2017 Jorge Martinez Hernandez
+
+  This program is free software: you can redistribute it and/or modify
+  it under the terms of the GNU Affero General Public License as published by
+  the Free Software Foundation, either version 3 of the License, or
+  (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU Affero General Public License for more details.
+
+  You should have received a copy of the GNU Affero General Public License
+  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package publish
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+	"github.com/stretchr/testify/suite"
+	"github.com/varddum/syndication/models"
+)
+
+type (
+	PublishTestSuite struct {
+		suite.Suite
+
+		feed Feed
+	}
+)
+
+func (suite *PublishTestSuite) SetupTest() {
+	published := time.Date(2017, 9, 7, 17, 24, 37, 0, time.UTC)
+
+	suite.feed = Feed{
+		Title:   "Saved entries",
+		Link:    "http://localhost:8080/published/token/atom",
+		Updated: published,
+		Entries: []models.Entry{
+			{
+				APIID:     "MTUwNDgwNTA3Nw==",
+				GUID:      "http://example.com/first",
+				Title:     "First",
+				Link:      "http://example.com/first",
+				Author:    "Jane",
+				Summary:   "First summary",
+				Content:   "<p>First content</p>",
+				Published: published,
+			},
+			{
+				APIID:     "MTUwNDgwNTA3OA==",
+				GUID:      "second",
+				Title:     "Second & more",
+				Link:      "http://example.com/second",
+				Summary:   "Second summary",
+				Published: published.Add(-time.Hour),
+			},
+		},
+	}
+}
+
+func (suite *PublishTestSuite) parse(format string) *gofeed.Feed {
+	body, _, err := Render(format, suite.feed)
+	suite.Require().Nil(err)
+
+	parsed, err := gofeed.NewParser().Parse(bytes.NewReader(body))
+	suite.Require().Nil(err)
+	suite.Require().Len(parsed.Items, 2)
+	return parsed
+}
+
+func (suite *PublishTestSuite) TestRenderAtom() {
+	parsed := suite.parse(Atom)
+	suite.Equal("atom", parsed.FeedType)
+	suite.Equal("Saved entries", parsed.Title)
+	suite.Equal(suite.feed.Link, parsed.FeedLink)
+
+	suite.Equal("http://example.com/first", parsed.Items[0].GUID)
+	suite.Equal("http://example.com/first", parsed.Items[0].Link)
+	suite.Equal("<p>First content</p>", parsed.Items[0].Content)
+	suite.Equal("Jane", parsed.Items[0].Author.Name)
+	suite.Equal("urn:syndication:entry:MTUwNDgwNTA3OA==", parsed.Items[1].GUID)
+	suite.Equal("Second & more", parsed.Items[1].Title)
+}
+
+func (suite *PublishTestSuite) TestRenderRSS() {
+	parsed := suite.parse(RSS)
+	suite.Equal("rss", parsed.FeedType)
+	suite.Equal("2.0", parsed.FeedVersion)
+	suite.Equal("Saved entries", parsed.Title)
+
+	suite.Equal("http://example.com/first", parsed.Items[0].GUID)
+	suite.Equal("<p>First content</p>", parsed.Items[0].Description)
+	suite.Equal("Jane", parsed.Items[0].Author.Name)
+	suite.Equal("Second summary", parsed.Items[1].Description)
+	suite.Equal(suite.feed.Entries[1].Published, *parsed.Items[1].PublishedParsed)
+}
+
+func (suite *PublishTestSuite) TestRenderJSONFeed() {
+	body, contentType, err := Render(JSONFeed, suite.feed)
+	suite.Require().Nil(err)
+	suite.Equal(JSONFeedContentType, contentType)
+	suite.Contains(string(body), `"version": "https://jsonfeed.org/version/1.1"`)
+	suite.Contains(string(body), `"feed_url": "http://localhost:8080/published/token/atom"`)
+	suite.Contains(string(body), `"content_html": "Second summary"`)
+	suite.Contains(string(body), `"authors": [`)
+}
+
+func (suite *PublishTestSuite) TestRenderEmptyFeed() {
+	suite.feed.Entries = nil
+
+	body, _, err := Render(JSONFeed, suite.feed)
+	suite.Require().Nil(err)
+	suite.Contains(string(body), `"items": []`)
+
+	body, _, err = Render(Atom, suite.feed)
+	suite.Require().Nil(err)
+
+	parsed, err := gofeed.NewParser().Parse(bytes.NewReader(body))
+	suite.Require().Nil(err)
+	suite.Empty(parsed.Items)
+}
+
+func (suite *PublishTestSuite) TestRenderUnknownFormat() {
+	_, _, err := Render("html", suite.feed)
+	suite.IsType(BadRequest{}, err)
+}
+
+func TestPublishTestSuite(t *testing.T) {
+	suite.Run(t, new(PublishTestSuite))
+}
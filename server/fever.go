@@ -0,0 +1,464 @@
+/*
+  Copyright (C) 2017 Jorge Martinez Hernandez
+
+  This program is free software: you can redistribute it and/or modify
+  it under the terms of the GNU Affero General Public License as published by
+  the Free Software Foundation, either version 3 of the License, or
+  (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU Affero General Public License for more details.
+
+  You should have received a copy of the GNU Affero General Public License
+  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package server
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/varddum/syndication/database"
+	"github.com/varddum/syndication/models"
+
+	"github.com/labstack/echo"
+	"github.com/labstack/echo/middleware"
+)
+
+const (
+	feverAPIVersion = 3
+
+	// feverPageSize is the number of items returned by a single request
+	// as set by the Fever API.
+	feverPageSize = 50
+)
+
+type (
+	feverGroup struct {
+		ID    uint   `json:"id"`
+		Title string `json:"title"`
+	}
+
+	feverFeed struct {
+		ID                uint   `json:"id"`
+		FaviconID         uint   `json:"favicon_id"`
+		Title             string `json:"title"`
+		URL               string `json:"url"`
+		SiteURL           string `json:"site_url"`
+		IsSpark           int    `json:"is_spark"`
+		LastUpdatedOnTime int64  `json:"last_updated_on_time"`
+	}
+
+	feverFeedsGroup struct {
+		GroupID uint   `json:"group_id"`
+		FeedIDs string `json:"feed_ids"`
+	}
+
+	feverFavicon struct {
+		ID   uint   `json:"id"`
+		Data string `json:"data"`
+	}
+
+	feverItem struct {
+		ID            uint   `json:"id"`
+		FeedID        uint   `json:"feed_id"`
+		Title         string `json:"title"`
+		Author        string `json:"author"`
+		HTML          string `json:"html"`
+		URL           string `json:"url"`
+		IsSaved       int    `json:"is_saved"`
+		IsRead        int    `json:"is_read"`
+		CreatedOnTime int64  `json:"created_on_time"`
+	}
+)
+
+// registerFeverAPI adds the Fever compatible API. Fever clients send
+// every request to a single endpoint and select what they want through
+// the query string.
+func (s *Server) registerFeverAPI() {
+	fever := s.handle.Group("/fever")
+	fever.Use(s.recoverer())
+
+	if s.config.EnableRequestLogs {
+		fever.Use(middleware.Logger())
+	}
+
+	fever.GET("", s.Fever)
+	fever.POST("", s.Fever)
+	fever.GET("/", s.Fever)
+	fever.POST("/", s.Fever)
+}
+
+// Fever handles every request made through the Fever API
+func (s *Server) Fever(c echo.Context) error {
+	resp := map[string]interface{}{
+		"api_version": feverAPIVersion,
+		"auth":        0,
+	}
+
+	// Fever clients expect a successful response
+	// even when their credentials are rejected.
+	user, err := s.db.UserWithFeverKey(c.FormValue("api_key"))
+	if err != nil {
+		return c.JSON(http.StatusOK, resp)
+	}
+
+	resp["auth"] = 1
+
+	feeds := s.db.Feeds(&user)
+
+	var lastRefreshed time.Time
+	for _, feed := range feeds {
+		if feed.LastFetched.After(lastRefreshed) {
+			lastRefreshed = feed.LastFetched
+		}
+	}
+	resp["last_refreshed_on_time"] = lastRefreshed.Unix()
+
+	if c.FormValue("mark") != "" {
+		if err := s.feverMark(c, feeds, resp, &user); err != nil {
+			return responseError(err, &c)
+		}
+	}
+
+	params := c.QueryParams()
+
+	if _, ok := params["groups"]; ok {
+		groups, feedsGroups := s.feverGroups(feeds, &user)
+		resp["groups"] = groups
+		resp["feeds_groups"] = feedsGroups
+	}
+
+	if _, ok := params["feeds"]; ok {
+		faviconIDs := feverFaviconIDs(feeds)
+
+		feverFeeds := make([]feverFeed, len(feeds))
+		for i, feed := range feeds {
+			feverFeeds[i] = feverFeed{
+				ID:                feed.ID,
+				FaviconID:         faviconIDs[feed.Icon],
+				Title:             feed.Title,
+				URL:               feed.Subscription,
+				SiteURL:           feed.Source,
+				LastUpdatedOnTime: feed.LastUpdated.Unix(),
+			}
+		}
+
+		_, feedsGroups := s.feverGroups(feeds, &user)
+		resp["feeds"] = feverFeeds
+		resp["feeds_groups"] = feedsGroups
+	}
+
+	if _, ok := params["favicons"]; ok {
+		resp["favicons"] = s.feverFavicons(feeds)
+	}
+
+	if _, ok := params["items"]; ok {
+		items, err := s.feverItems(c, &user)
+		if err != nil {
+			return responseError(err, &c)
+		}
+
+		resp["items"] = items
+		resp["total_items"] = s.db.Stats(&user).Total
+	}
+
+	if _, ok := params["links"]; ok {
+		// Hot links are not supported
+		resp["links"] = []interface{}{}
+	}
+
+	if _, ok := params["unread_item_ids"]; ok {
+		ids, err := s.db.EntryPrimaryKeys(models.Unread, false, &user)
+		if err != nil {
+			return newError(err, &c)
+		}
+
+		resp["unread_item_ids"] = joinFeverIDs(ids)
+	}
+
+	if _, ok := params["saved_item_ids"]; ok {
+		ids, err := s.db.EntryPrimaryKeys(models.Any, true, &user)
+		if err != nil {
+			return newError(err, &c)
+		}
+
+		resp["saved_item_ids"] = joinFeverIDs(ids)
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+// feverFaviconIDs identifies each cached icon by the
+// primary key of the first feed that uses it.
+func feverFaviconIDs(feeds []models.Feed) map[string]uint {
+	ids := map[string]uint{}
+	for _, feed := range feeds {
+		if _, ok := ids[feed.Icon]; !ok && feed.Icon != "" {
+			ids[feed.Icon] = feed.ID
+		}
+	}
+
+	return ids
+}
+
+// feverFavicons returns the cached icons of feeds as data URIs
+// without their "data:" prefix, as Fever clients expect.
+func (s *Server) feverFavicons(feeds []models.Feed) []feverFavicon {
+	ids := feverFaviconIDs(feeds)
+
+	favicons := []feverFavicon{}
+	for _, feed := range feeds {
+		if feed.Icon == "" || ids[feed.Icon] != feed.ID {
+			continue
+		}
+
+		icon, _, err := s.sync.FeedIcon(&feed)
+		if err != nil {
+			continue
+		}
+
+		content, err := ioutil.ReadAll(icon)
+		icon.Close()
+		if err != nil {
+			continue
+		}
+
+		favicons = append(favicons, feverFavicon{
+			ID:   feed.ID,
+			Data: feed.IconType + ";base64," + base64.StdEncoding.EncodeToString(content),
+		})
+	}
+
+	return favicons
+}
+
+// feverGroups returns a group for every Category, other than the
+// uncategorized one, and the feeds that belong to each group.
+func (s *Server) feverGroups(feeds []models.Feed, user *models.User) ([]feverGroup, []feverFeedsGroup) {
+	groups := []feverGroup{}
+	feedsGroups := []feverFeedsGroup{}
+
+	for _, ctg := range s.db.Categories(user) {
+		if ctg.Name == models.Uncategorized {
+			continue
+		}
+
+		var feedIDs []uint
+		for _, feed := range feeds {
+			if feed.CategoryID == ctg.ID {
+				feedIDs = append(feedIDs, feed.ID)
+			}
+		}
+
+		groups = append(groups, feverGroup{
+			ID:    ctg.ID,
+			Title: ctg.Name,
+		})
+
+		feedsGroups = append(feedsGroups, feverFeedsGroup{
+			GroupID: ctg.ID,
+			FeedIDs: joinFeverIDs(feedIDs),
+		})
+	}
+
+	return groups, feedsGroups
+}
+
+func (s *Server) feverItems(c echo.Context, user *models.User) ([]feverItem, error) {
+	rng := database.EntryRange{
+		Limit: feverPageSize,
+	}
+
+	var err error
+	if param := c.QueryParam("with_ids"); param != "" {
+		rng.IDs, err = splitFeverIDs(param)
+		if len(rng.IDs) > feverPageSize {
+			rng.IDs = rng.IDs[:feverPageSize]
+		}
+	} else if param := c.QueryParam("max_id"); param != "" {
+		var id uint64
+		id, err = strconv.ParseUint(param, 10, 64)
+		rng.MaxID = uint(id)
+	} else if param := c.QueryParam("since_id"); param != "" {
+		var id uint64
+		id, err = strconv.ParseUint(param, 10, 64)
+		rng.SinceID = uint(id)
+	}
+
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "Invalid item id")
+	}
+
+	entries, err := s.db.EntriesInRange(rng, user)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]feverItem, len(entries))
+	for i, entry := range entries {
+		content := entry.Content
+		if content == "" {
+			content = entry.Summary
+		}
+
+		created := entry.Published
+		if created.IsZero() {
+			created = entry.CreatedAt
+		}
+
+		items[i] = feverItem{
+			ID:            entry.ID,
+			FeedID:        entry.FeedID,
+			Title:         entry.Title,
+			Author:        entry.Author,
+			HTML:          content,
+			URL:           entry.Link,
+			CreatedOnTime: created.Unix(),
+		}
+
+		if entry.Saved {
+			items[i].IsSaved = 1
+		}
+
+		if entry.Mark == models.Read {
+			items[i].IsRead = 1
+		}
+	}
+
+	return items, nil
+}
+
+// feverMark applies the mark action of a request. Feeds and groups are
+// only marked up to the before timestamp so that entries added since
+// the client last refreshed stay unread.
+func (s *Server) feverMark(c echo.Context, feeds []models.Feed, resp map[string]interface{}, user *models.User) error {
+	id, err := strconv.ParseUint(c.FormValue("id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid id")
+	}
+
+	as := c.FormValue("as")
+
+	switch c.FormValue("mark") {
+	case "item":
+		entries, err := s.db.EntriesInRange(database.EntryRange{IDs: []uint{uint(id)}}, user)
+		if err != nil {
+			return err
+		}
+
+		if len(entries) == 0 {
+			return echo.NewHTTPError(http.StatusNotFound, "Item does not exist")
+		}
+
+		entryID := entries[0].APIID
+		switch as {
+		case "read":
+			err = s.db.MarkEntry(entryID, models.Read, user)
+		case "unread":
+			err = s.db.MarkEntry(entryID, models.Unread, user)
+		case "saved":
+			err = s.db.SaveEntry(entryID, user)
+		case "unsaved":
+			err = s.db.UnsaveEntry(entryID, user)
+		default:
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid 'as' parameter")
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if as == "read" || as == "unread" {
+			ids, err := s.db.EntryPrimaryKeys(models.Unread, false, user)
+			if err != nil {
+				return err
+			}
+			resp["unread_item_ids"] = joinFeverIDs(ids)
+		} else {
+			ids, err := s.db.EntryPrimaryKeys(models.Any, true, user)
+			if err != nil {
+				return err
+			}
+			resp["saved_item_ids"] = joinFeverIDs(ids)
+		}
+
+		return nil
+	case "feed", "group":
+		if as != "read" {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid 'as' parameter")
+		}
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid 'mark' parameter")
+	}
+
+	bounds := database.MarkBounds{Before: time.Now()}
+	if param := c.FormValue("before"); param != "" {
+		timestamp, err := strconv.ParseInt(param, 10, 64)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid 'before' parameter")
+		}
+		bounds.Before = time.Unix(timestamp, 0)
+	}
+
+	if c.FormValue("mark") == "feed" {
+		feedID := ""
+		for _, feed := range feeds {
+			if feed.ID == uint(id) {
+				feedID = feed.APIID
+			}
+		}
+
+		if feedID == "" {
+			return echo.NewHTTPError(http.StatusNotFound, "Feed does not exist")
+		}
+
+		_, err = s.db.MarkFeedEntries(feedID, models.Read, bounds, user)
+	} else if id == 0 {
+		// Group 0 holds every feed
+		_, err = s.db.MarkAllEntries(models.Read, bounds, user)
+	} else {
+		ctgID := ""
+		for _, ctg := range s.db.Categories(user) {
+			if ctg.ID == uint(id) {
+				ctgID = ctg.APIID
+			}
+		}
+
+		if ctgID == "" {
+			return echo.NewHTTPError(http.StatusNotFound, "Group does not exist")
+		}
+
+		_, err = s.db.MarkCategoryEntries(ctgID, models.Read, bounds, user)
+	}
+
+	return err
+}
+
+func joinFeverIDs(ids []uint) string {
+	strs := make([]string, len(ids))
+	for i, id := range ids {
+		strs[i] = strconv.FormatUint(uint64(id), 10)
+	}
+	return strings.Join(strs, ",")
+}
+
+func splitFeverIDs(ids string) ([]uint, error) {
+	strs := strings.Split(ids, ",")
+	parsed := make([]uint, len(strs))
+	for i, str := range strs {
+		id, err := strconv.ParseUint(strings.TrimSpace(str), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		parsed[i] = uint(id)
+	}
+	return parsed, nil
+}
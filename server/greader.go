@@ -0,0 +1,816 @@
+/*
+  Copyright (C) 2017 Jorge Martinez Hernandez
+
+  This program is free software: you can redistribute it and/or modify
+  it under the terms of the GNU Affero General Public License as published by
+  the Free Software Foundation, either version 3 of the License, or
+  (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU Affero General Public License for more details.
+
+  You should have received a copy of the GNU Affero General Public License
+  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package server
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/varddum/syndication/database"
+	"github.com/varddum/syndication/models"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/labstack/echo"
+	"github.com/labstack/echo/middleware"
+)
+
+// Identifiers used by the Google Reader API for items, streams and states.
+const (
+	readerAuthScheme  = "GoogleLogin auth="
+	readerItemPrefix  = "tag:google.com,2005:reader/item/"
+	readerFeedPrefix  = "feed/"
+	readerLabelPrefix = "user/-/label/"
+	readerReadingList = "user/-/state/com.google/reading-list"
+	readerRead        = "user/-/state/com.google/read"
+	readerKeptUnread  = "user/-/state/com.google/kept-unread"
+	readerStarred     = "user/-/state/com.google/starred"
+
+	// readerPageSize is the number of items returned when a client
+	// does not ask for a specific amount.
+	readerPageSize = 20
+)
+
+type (
+	readerUserInfo struct {
+		UserID        string `json:"userId"`
+		UserName      string `json:"userName"`
+		UserProfileID string `json:"userProfileId"`
+		UserEmail     string `json:"userEmail"`
+	}
+
+	readerLabel struct {
+		ID    string `json:"id"`
+		Label string `json:"label,omitempty"`
+		Type  string `json:"type,omitempty"`
+	}
+
+	readerSubscription struct {
+		ID         string        `json:"id"`
+		Title      string        `json:"title"`
+		Categories []readerLabel `json:"categories"`
+		URL        string        `json:"url"`
+		HTMLURL    string        `json:"htmlUrl"`
+		IconURL    string        `json:"iconUrl"`
+	}
+
+	readerUnreadCount struct {
+		ID    string `json:"id"`
+		Count int    `json:"count"`
+	}
+
+	readerLink struct {
+		Href string `json:"href"`
+		Type string `json:"type,omitempty"`
+	}
+
+	readerContent struct {
+		Direction string `json:"direction"`
+		Content   string `json:"content"`
+	}
+
+	readerOrigin struct {
+		StreamID string `json:"streamId"`
+		Title    string `json:"title"`
+		HTMLURL  string `json:"htmlUrl"`
+	}
+
+	readerItem struct {
+		ID            string        `json:"id"`
+		CrawlTimeMsec string        `json:"crawlTimeMsec"`
+		TimestampUsec string        `json:"timestampUsec"`
+		Published     int64         `json:"published"`
+		Updated       int64         `json:"updated"`
+		Title         string        `json:"title"`
+		Author        string        `json:"author,omitempty"`
+		Canonical     []readerLink  `json:"canonical"`
+		Alternate     []readerLink  `json:"alternate"`
+		Summary       readerContent `json:"summary"`
+		Categories    []string      `json:"categories"`
+		Origin        readerOrigin  `json:"origin"`
+	}
+
+	readerStream struct {
+		ID           string       `json:"id"`
+		Direction    string       `json:"direction"`
+		Updated      int64        `json:"updated"`
+		Items        []readerItem `json:"items"`
+		Continuation string       `json:"continuation,omitempty"`
+	}
+
+	readerItemRef struct {
+		ID            string `json:"id"`
+		TimestampUsec string `json:"timestampUsec"`
+	}
+)
+
+// registerReaderAPI adds the Google Reader compatible API used by
+// third party clients. It lives outside of the versioned groups since
+// clients expect it at fixed paths and authenticate with their own scheme.
+func (s *Server) registerReaderAPI() {
+	accounts := s.handle.Group("/accounts")
+	accounts.Use(s.recoverer())
+
+	reader := s.handle.Group("/reader/api/0")
+	reader.Use(middleware.CORS())
+	reader.Use(s.recoverer())
+	reader.Use(s.checkReaderAuth)
+
+	if s.config.EnableRequestLogs {
+		accounts.Use(middleware.Logger())
+		reader.Use(middleware.Logger())
+	}
+
+	accounts.GET("/ClientLogin", s.ReaderLogin)
+	accounts.POST("/ClientLogin", s.ReaderLogin)
+
+	reader.GET("/token", s.ReaderToken)
+	reader.GET("/user-info", s.ReaderUserInfo)
+	reader.GET("/subscription/list", s.ReaderSubscriptions)
+	reader.GET("/tag/list", s.ReaderTags)
+	reader.GET("/unread-count", s.ReaderUnreadCount)
+	reader.GET("/stream/contents", s.ReaderStreamContents)
+	reader.GET("/stream/contents/*", s.ReaderStreamContents)
+	reader.GET("/stream/items/ids", s.ReaderStreamItemIDs)
+	reader.GET("/stream/items/contents", s.ReaderStreamItemContents)
+	reader.POST("/stream/items/contents", s.ReaderStreamItemContents)
+	reader.POST("/edit-tag", s.ReaderEditTag)
+	reader.POST("/mark-all-as-read", s.ReaderMarkAllAsRead)
+}
+
+func (s *Server) checkReaderAuth(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if c.Request().Method == "OPTIONS" {
+			return next(c)
+		}
+
+		header := c.Request().Header.Get(echo.HeaderAuthorization)
+		if !strings.HasPrefix(header, readerAuthScheme) {
+			return readerUnauthorized(c)
+		}
+
+		token, err := jwt.Parse(strings.TrimPrefix(header, readerAuthScheme), func(token *jwt.Token) (interface{}, error) {
+			if token.Method.Alg() != jwt.SigningMethodHS256.Alg() {
+				return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+			}
+			return []byte(s.config.AuthSecret), nil
+		})
+		if err != nil || !token.Valid {
+			return readerUnauthorized(c)
+		}
+
+		user, ok := s.userWithKey(token)
+		if !ok {
+			return readerUnauthorized(c)
+		}
+
+		c.Set(echoSyndUserKey, user)
+
+		return next(c)
+	}
+}
+
+func readerUnauthorized(c echo.Context) error {
+	return c.JSON(http.StatusUnauthorized, ErrorResp{
+		Reason:  "Unauthorized",
+		Message: "Credentials are invalid",
+	})
+}
+
+// responseError converts err into a response, passing errors that were
+// already created for a response through untouched.
+func responseError(err error, c *echo.Context) error {
+	if httpErr, ok := err.(*echo.HTTPError); ok {
+		return httpErr
+	}
+
+	return newError(err, c)
+}
+
+// ReaderLogin authenticates a user through the ClientLogin protocol
+func (s *Server) ReaderLogin(c echo.Context) error {
+	user, err := s.db.Authenticate(c.FormValue("Email"), c.FormValue("Passwd"))
+	if err != nil {
+		return c.String(http.StatusUnauthorized, "Error=BadAuthentication\n")
+	}
+
+	key, err := s.db.NewAPIKey(s.config.AuthSecret, &user)
+	if err != nil {
+		return c.String(http.StatusInternalServerError, "Error=Unknown\n")
+	}
+
+	return c.String(http.StatusOK, "SID="+key.Key+"\nLSID=null\nAuth="+key.Key+"\n")
+}
+
+// ReaderToken returns the token clients send back with edit requests.
+// Every request is already authenticated through its Authorization
+// header so the token is only provided for compatibility.
+func (s *Server) ReaderToken(c echo.Context) error {
+	user := c.Get(echoSyndUserKey).(models.User)
+	return c.String(http.StatusOK, user.APIID)
+}
+
+// ReaderUserInfo returns information on the authenticated user
+func (s *Server) ReaderUserInfo(c echo.Context) error {
+	user := c.Get(echoSyndUserKey).(models.User)
+
+	return c.JSON(http.StatusOK, readerUserInfo{
+		UserID:        user.APIID,
+		UserName:      user.Username,
+		UserProfileID: user.APIID,
+		UserEmail:     user.Email,
+	})
+}
+
+// ReaderSubscriptions returns every Feed owned by the user
+func (s *Server) ReaderSubscriptions(c echo.Context) error {
+	user := c.Get(echoSyndUserKey).(models.User)
+
+	categories := s.readerCategoryNames(&user)
+
+	feeds := s.db.Feeds(&user)
+	subscriptions := make([]readerSubscription, len(feeds))
+	for i, feed := range feeds {
+		subscriptions[i] = readerSubscription{
+			ID:         readerFeedPrefix + feed.APIID,
+			Title:      feed.Title,
+			Categories: []readerLabel{},
+			URL:        feed.Subscription,
+			HTMLURL:    feed.Source,
+		}
+
+		if name, ok := categories[feed.CategoryID]; ok {
+			subscriptions[i].Categories = append(subscriptions[i].Categories, readerLabel{
+				ID:    readerLabelPrefix + name,
+				Label: name,
+			})
+		}
+	}
+
+	type Subscriptions struct {
+		Subscriptions []readerSubscription `json:"subscriptions"`
+	}
+
+	return c.JSON(http.StatusOK, Subscriptions{
+		Subscriptions: subscriptions,
+	})
+}
+
+// ReaderTags returns the starred state and a label for
+// every Category and Tag owned by the user
+func (s *Server) ReaderTags(c echo.Context) error {
+	user := c.Get(echoSyndUserKey).(models.User)
+
+	tags := []readerLabel{{ID: readerStarred}}
+	seen := map[string]bool{}
+
+	for _, ctg := range s.db.Categories(&user) {
+		if ctg.Name == models.Uncategorized || seen[ctg.Name] {
+			continue
+		}
+
+		seen[ctg.Name] = true
+		tags = append(tags, readerLabel{ID: readerLabelPrefix + ctg.Name, Type: "folder"})
+	}
+
+	for _, tag := range s.db.Tags(&user) {
+		if seen[tag.Name] {
+			continue
+		}
+
+		seen[tag.Name] = true
+		tags = append(tags, readerLabel{ID: readerLabelPrefix + tag.Name, Type: "tag"})
+	}
+
+	type Tags struct {
+		Tags []readerLabel `json:"tags"`
+	}
+
+	return c.JSON(http.StatusOK, Tags{
+		Tags: tags,
+	})
+}
+
+// ReaderUnreadCount returns the number of unread entries in
+// the reading list, every Feed and every Category
+func (s *Server) ReaderUnreadCount(c echo.Context) error {
+	user := c.Get(echoSyndUserKey).(models.User)
+
+	counts := []readerUnreadCount{{
+		ID:    readerReadingList,
+		Count: s.db.Stats(&user).Unread,
+	}}
+
+	for _, feed := range s.db.Feeds(&user) {
+		stats, err := s.db.FeedStats(feed.APIID, &user)
+		if err != nil {
+			return newError(err, &c)
+		}
+
+		counts = append(counts, readerUnreadCount{
+			ID:    readerFeedPrefix + feed.APIID,
+			Count: stats.Unread,
+		})
+	}
+
+	for _, ctg := range s.db.Categories(&user) {
+		if ctg.Name == models.Uncategorized {
+			continue
+		}
+
+		stats, err := s.db.CategoryStats(ctg.APIID, &user)
+		if err != nil {
+			return newError(err, &c)
+		}
+
+		counts = append(counts, readerUnreadCount{
+			ID:    readerLabelPrefix + ctg.Name,
+			Count: stats.Unread,
+		})
+	}
+
+	type UnreadCounts struct {
+		Max          int                 `json:"max"`
+		UnreadCounts []readerUnreadCount `json:"unreadcounts"`
+	}
+
+	return c.JSON(http.StatusOK, UnreadCounts{
+		Max:          maxEntryPageSize,
+		UnreadCounts: counts,
+	})
+}
+
+// ReaderStreamContents returns a page of entries from a stream
+func (s *Server) ReaderStreamContents(c echo.Context) error {
+	user := c.Get(echoSyndUserKey).(models.User)
+
+	streamID := c.QueryParam("s")
+	if param := c.Param("*"); param != "" {
+		unescaped, err := url.PathUnescape(param)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid stream id")
+		}
+		streamID = unescaped
+	}
+
+	if streamID == "" {
+		streamID = readerReadingList
+	}
+
+	entries, next, err := s.readerEntries(c, streamID, &user)
+	if err != nil {
+		return responseError(err, &c)
+	}
+
+	return c.JSON(http.StatusOK, readerStream{
+		ID:           streamID,
+		Direction:    "ltr",
+		Updated:      time.Now().Unix(),
+		Items:        s.readerItems(entries, &user),
+		Continuation: next,
+	})
+}
+
+// ReaderStreamItemIDs returns the ids of a page of entries from a stream
+func (s *Server) ReaderStreamItemIDs(c echo.Context) error {
+	user := c.Get(echoSyndUserKey).(models.User)
+
+	streamID := c.QueryParam("s")
+	if streamID == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "'s' parameter is required")
+	}
+
+	entries, next, err := s.readerEntries(c, streamID, &user)
+	if err != nil {
+		return responseError(err, &c)
+	}
+
+	refs := make([]readerItemRef, 0, len(entries))
+	for _, entry := range entries {
+		id, err := readerItemID(entry.APIID)
+		if err != nil {
+			continue
+		}
+
+		refs = append(refs, readerItemRef{
+			ID:            strconv.FormatInt(id, 10),
+			TimestampUsec: strconv.FormatInt(entry.Published.UnixNano()/int64(time.Microsecond), 10),
+		})
+	}
+
+	type ItemRefs struct {
+		ItemRefs     []readerItemRef `json:"itemRefs"`
+		Continuation string          `json:"continuation,omitempty"`
+	}
+
+	return c.JSON(http.StatusOK, ItemRefs{
+		ItemRefs:     refs,
+		Continuation: next,
+	})
+}
+
+// ReaderStreamItemContents returns the entries with the given item ids
+func (s *Server) ReaderStreamItemContents(c echo.Context) error {
+	user := c.Get(echoSyndUserKey).(models.User)
+
+	entryIDs, err := readerEntryIDs(c)
+	if err != nil {
+		return err
+	}
+
+	entries := make([]models.Entry, 0, len(entryIDs))
+	for _, id := range entryIDs {
+		entry, err := s.db.Entry(id, &user)
+		if err != nil {
+			continue
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return c.JSON(http.StatusOK, readerStream{
+		ID:        readerReadingList,
+		Direction: "ltr",
+		Updated:   time.Now().Unix(),
+		Items:     s.readerItems(entries, &user),
+	})
+}
+
+// ReaderEditTag adds and removes states and labels on entries
+func (s *Server) ReaderEditTag(c echo.Context) error {
+	user := c.Get(echoSyndUserKey).(models.User)
+
+	entryIDs, err := readerEntryIDs(c)
+	if err != nil {
+		return err
+	}
+
+	params, err := c.FormParams()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid form")
+	}
+
+	for _, tag := range params["a"] {
+		if err := s.readerTagEntries(normalizeReaderStream(tag), entryIDs, true, &user); err != nil {
+			return responseError(err, &c)
+		}
+	}
+
+	for _, tag := range params["r"] {
+		if err := s.readerTagEntries(normalizeReaderStream(tag), entryIDs, false, &user); err != nil {
+			return responseError(err, &c)
+		}
+	}
+
+	return c.String(http.StatusOK, "OK")
+}
+
+// ReaderMarkAllAsRead marks every entry in a stream as read. Clients
+// send ts, in microseconds, so that entries added since they last
+// refreshed the stream are left unread.
+func (s *Server) ReaderMarkAllAsRead(c echo.Context) error {
+	user := c.Get(echoSyndUserKey).(models.User)
+
+	streamID := normalizeReaderStream(c.FormValue("s"))
+
+	bounds := database.MarkBounds{}
+	if param := c.FormValue("ts"); param != "" {
+		timestamp, err := strconv.ParseInt(param, 10, 64)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid 'ts' parameter")
+		}
+		bounds.Before = time.Unix(0, timestamp*int64(time.Microsecond))
+	}
+
+	var err error
+	switch {
+	case streamID == readerReadingList:
+		_, err = s.db.MarkAllEntries(models.Read, bounds, &user)
+	case strings.HasPrefix(streamID, readerFeedPrefix):
+		_, err = s.db.MarkFeedEntries(strings.TrimPrefix(streamID, readerFeedPrefix), models.Read, bounds, &user)
+	case strings.HasPrefix(streamID, readerLabelPrefix):
+		ctgID, tagID := s.readerLabel(strings.TrimPrefix(streamID, readerLabelPrefix), &user)
+		if ctgID != "" {
+			_, err = s.db.MarkCategoryEntries(ctgID, models.Read, bounds, &user)
+		} else if tagID != "" {
+			_, err = s.db.MarkTagEntries(tagID, models.Read, bounds, &user)
+		} else {
+			err = echo.NewHTTPError(http.StatusNotFound, "Label does not exist")
+		}
+	default:
+		err = echo.NewHTTPError(http.StatusBadRequest, "Unknown stream")
+	}
+
+	if err != nil {
+		return responseError(err, &c)
+	}
+
+	return c.String(http.StatusOK, "OK")
+}
+
+// readerEntries returns a page of entries from the stream with streamID
+// filtered by the query parameters Google Reader clients send.
+func (s *Server) readerEntries(c echo.Context, streamID string, user *models.User) ([]models.Entry, string, error) {
+	page := database.Page{
+		Limit:  readerPageSize,
+		Cursor: c.QueryParam("c"),
+	}
+
+	if count, err := strconv.Atoi(c.QueryParam("n")); err == nil && count > 0 {
+		page.Limit = count
+	}
+
+	if page.Limit > maxEntryPageSize {
+		page.Limit = maxEntryPageSize
+	}
+
+	orderByNewest := c.QueryParam("r") != "o"
+
+	marker := models.Marker(models.Any)
+	savedOnly := false
+
+	switch normalizeReaderStream(c.QueryParam("it")) {
+	case readerRead:
+		marker = models.Read
+	case readerStarred:
+		savedOnly = true
+	}
+
+	if normalizeReaderStream(c.QueryParam("xt")) == readerRead {
+		if marker == models.Read {
+			return []models.Entry{}, "", nil
+		}
+		marker = models.Unread
+	}
+
+	streamID = normalizeReaderStream(streamID)
+	switch {
+	case streamID == readerReadingList:
+		return s.db.EntriesPage(page, orderByNewest, marker, savedOnly, user)
+	case streamID == readerStarred:
+		return s.db.EntriesPage(page, orderByNewest, marker, true, user)
+	case streamID == readerRead:
+		if marker == models.Unread {
+			return []models.Entry{}, "", nil
+		}
+		return s.db.EntriesPage(page, orderByNewest, models.Read, savedOnly, user)
+	case strings.HasPrefix(streamID, readerFeedPrefix):
+		feedID := strings.TrimPrefix(streamID, readerFeedPrefix)
+		return s.db.EntriesFromFeedPage(feedID, page, orderByNewest, marker, savedOnly, user)
+	case strings.HasPrefix(streamID, readerLabelPrefix):
+		ctgID, tagID := s.readerLabel(strings.TrimPrefix(streamID, readerLabelPrefix), user)
+		if ctgID != "" {
+			return s.db.EntriesFromCategoryPage(ctgID, page, orderByNewest, marker, savedOnly, user)
+		} else if tagID != "" {
+			return s.db.EntriesFromTagPage(tagID, page, marker, savedOnly, orderByNewest, user)
+		}
+		return nil, "", echo.NewHTTPError(http.StatusNotFound, "Label does not exist")
+	}
+
+	return nil, "", echo.NewHTTPError(http.StatusBadRequest, "Unknown stream")
+}
+
+// readerTagEntries applies or removes the state or label in streamID
+// to every entry in entryIDs. Unsupported states are ignored.
+func (s *Server) readerTagEntries(streamID string, entryIDs []string, add bool, user *models.User) error {
+	switch {
+	case streamID == readerRead:
+		marker := models.Marker(models.Unread)
+		if add {
+			marker = models.Read
+		}
+
+		return s.markReaderEntries(entryIDs, marker, user)
+	case streamID == readerKeptUnread:
+		if add {
+			return s.markReaderEntries(entryIDs, models.Unread, user)
+		}
+	case streamID == readerStarred:
+		for _, id := range entryIDs {
+			var err error
+			if add {
+				err = s.db.SaveEntry(id, user)
+			} else {
+				err = s.db.UnsaveEntry(id, user)
+			}
+
+			if err != nil {
+				return err
+			}
+		}
+	case strings.HasPrefix(streamID, readerLabelPrefix):
+		name := strings.TrimPrefix(streamID, readerLabelPrefix)
+
+		var tagID string
+		for _, tag := range s.db.Tags(user) {
+			if tag.Name == name {
+				tagID = tag.APIID
+				break
+			}
+		}
+
+		if !add {
+			if tagID == "" {
+				return nil
+			}
+			return s.db.UntagEntries(tagID, entryIDs, user)
+		}
+
+		if tagID == "" {
+			tag := models.Tag{Name: name}
+			if err := s.db.NewTag(&tag, user); err != nil {
+				return err
+			}
+			tagID = tag.APIID
+		}
+
+		return s.db.TagEntries(tagID, entryIDs, user)
+	}
+
+	return nil
+}
+
+func (s *Server) markReaderEntries(entryIDs []string, marker models.Marker, user *models.User) error {
+	for _, id := range entryIDs {
+		if err := s.db.MarkEntry(id, marker, user); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readerLabel returns the id of the Category or, when no Category
+// has the name, the id of the Tag that a label refers to.
+func (s *Server) readerLabel(name string, user *models.User) (ctgID, tagID string) {
+	for _, ctg := range s.db.Categories(user) {
+		if ctg.Name == name && ctg.Name != models.Uncategorized {
+			return ctg.APIID, ""
+		}
+	}
+
+	for _, tag := range s.db.Tags(user) {
+		if tag.Name == name {
+			return "", tag.APIID
+		}
+	}
+
+	return "", ""
+}
+
+// readerCategoryNames maps the primary key of every Category owned by user,
+// other than the uncategorized one, to its name.
+func (s *Server) readerCategoryNames(user *models.User) map[uint]string {
+	names := map[uint]string{}
+	for _, ctg := range s.db.Categories(user) {
+		if ctg.Name != models.Uncategorized {
+			names[ctg.ID] = ctg.Name
+		}
+	}
+	return names
+}
+
+func (s *Server) readerItems(entries []models.Entry, user *models.User) []readerItem {
+	feeds := map[uint]models.Feed{}
+	for _, feed := range s.db.Feeds(user) {
+		feeds[feed.ID] = feed
+	}
+
+	categories := s.readerCategoryNames(user)
+
+	items := make([]readerItem, 0, len(entries))
+	for _, entry := range entries {
+		id, err := readerItemID(entry.APIID)
+		if err != nil {
+			continue
+		}
+
+		feed := feeds[entry.FeedID]
+
+		states := []string{readerReadingList}
+		if entry.Mark == models.Read {
+			states = append(states, readerRead)
+		}
+
+		if entry.Saved {
+			states = append(states, readerStarred)
+		}
+
+		if name, ok := categories[feed.CategoryID]; ok {
+			states = append(states, readerLabelPrefix+name)
+		}
+
+		content := entry.Content
+		if content == "" {
+			content = entry.Summary
+		}
+
+		items = append(items, readerItem{
+			ID:            fmt.Sprintf("%s%016x", readerItemPrefix, uint64(id)),
+			CrawlTimeMsec: strconv.FormatInt(entry.CreatedAt.UnixNano()/int64(time.Millisecond), 10),
+			TimestampUsec: strconv.FormatInt(entry.Published.UnixNano()/int64(time.Microsecond), 10),
+			Published:     entry.Published.Unix(),
+			Updated:       entry.UpdatedAt.Unix(),
+			Title:         entry.Title,
+			Author:        entry.Author,
+			Canonical:     []readerLink{{Href: entry.Link}},
+			Alternate:     []readerLink{{Href: entry.Link, Type: "text/html"}},
+			Summary: readerContent{
+				Direction: "ltr",
+				Content:   content,
+			},
+			Categories: states,
+			Origin: readerOrigin{
+				StreamID: readerFeedPrefix + feed.APIID,
+				Title:    feed.Title,
+				HTMLURL:  feed.Source,
+			},
+		})
+	}
+
+	return items
+}
+
+// readerEntryIDs returns the Entry APIIDs for the item ids in the i parameters.
+func readerEntryIDs(c echo.Context) ([]string, error) {
+	params, err := c.FormParams()
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "Invalid form")
+	}
+
+	ids := make([]string, len(params["i"]))
+	for i, itemID := range params["i"] {
+		ids[i], err = entryAPIIDFromReaderItem(itemID)
+		if err != nil {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, "Invalid item id "+itemID)
+		}
+	}
+
+	return ids, nil
+}
+
+// normalizeReaderStream replaces the user id in a stream id with the
+// "-" placeholder which refers to the authenticated user.
+func normalizeReaderStream(streamID string) string {
+	if !strings.HasPrefix(streamID, "user/") {
+		return streamID
+	}
+
+	parts := strings.SplitN(streamID, "/", 3)
+	if len(parts) != 3 {
+		return streamID
+	}
+
+	return "user/-/" + parts[2]
+}
+
+// readerItemID converts an Entry APIID into the numeric item id
+// Google Reader clients expect. APIIDs encode a decimal number so
+// the conversion can be reversed by entryAPIIDFromReaderItem.
+func readerItemID(apiID string) (int64, error) {
+	decoded, err := base64.StdEncoding.DecodeString(apiID)
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseInt(string(decoded), 10, 64)
+}
+
+// entryAPIIDFromReaderItem converts an item id in either its long
+// hexadecimal form or its short decimal form into an Entry APIID.
+func entryAPIIDFromReaderItem(itemID string) (string, error) {
+	var id int64
+	if strings.HasPrefix(itemID, readerItemPrefix) {
+		parsed, err := strconv.ParseUint(strings.TrimPrefix(itemID, readerItemPrefix), 16, 64)
+		if err != nil {
+			return "", err
+		}
+		id = int64(parsed)
+	} else {
+		parsed, err := strconv.ParseInt(itemID, 10, 64)
+		if err != nil {
+			return "", err
+		}
+		id = parsed
+	}
+
+	return base64.StdEncoding.EncodeToString([]byte(strconv.FormatInt(id, 10))), nil
+}
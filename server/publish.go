@@ -0,0 +1,151 @@
+/*
+  Copyright (C) 2017 Jorge Martinez Hernandez
+
+  This program is free software: you can redistribute it and/or modify
+  it under the terms of the GNU Affero General Public License as published by
+  the Free Software Foundation, either version 3 of the License, or
+  (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU Affero General Public License for more details.
+
+  You should have received a copy of the GNU Affero General Public License
+  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package server
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/varddum/syndication/models"
+	"github.com/varddum/syndication/publish"
+
+	"github.com/labstack/echo"
+	"github.com/labstack/echo/middleware"
+)
+
+// publishedEntryLimit is the number of the newest Entries
+// included in a published document.
+const publishedEntryLimit = 50
+
+// registerPublications adds the routes that serve Publications.
+// Publications are read with their unguessable token instead of
+// credentials so they can be subscribed to by other readers.
+func (s *Server) registerPublications() {
+	published := s.handle.Group("/published")
+	published.Use(middleware.CORS())
+	published.Use(s.recoverer())
+
+	if s.config.EnableRequestLogs {
+		published.Use(middleware.Logger())
+	}
+
+	published.GET("/:token/:format", s.GetPublished)
+}
+
+// GetPublished renders the Entries of the Publication with a token
+// as an Atom, RSS or JSON Feed document
+func (s *Server) GetPublished(c echo.Context) error {
+	pub, user, err := s.db.PublicationWithToken(c.Param("token"))
+	if err != nil {
+		return newError(err, &c)
+	}
+
+	entries, err := s.db.PublishedEntries(&pub, publishedEntryLimit, &user)
+	if err != nil {
+		return newError(err, &c)
+	}
+
+	updated, err := s.lastUpdated(&pub, entries)
+	if err != nil {
+		return newError(err, &c)
+	}
+
+	body, contentType, err := publish.Render(c.Param("format"), publish.Feed{
+		Title:   pub.Title,
+		Link:    c.Scheme() + "://" + c.Request().Host + c.Request().URL.Path,
+		Updated: updated,
+		Entries: entries,
+	})
+	if err != nil {
+		return newError(err, &c)
+	}
+
+	sum := sha1.Sum(body)
+	etag := `"` + hex.EncodeToString(sum[:]) + `"`
+
+	c.Response().Header().Set("ETag", etag)
+	c.Response().Header().Set(echo.HeaderLastModified, updated.UTC().Format(http.TimeFormat))
+
+	if notModified(c.Request(), etag, updated) {
+		return c.NoContent(http.StatusNotModified)
+	}
+
+	return c.Blob(http.StatusOK, contentType, body)
+}
+
+// lastUpdated returns when the published Entries of pub last changed.
+// Entries joining or leaving the publication change it, while changes
+// that are not published, like marking an Entry as read, do not.
+func (s *Server) lastUpdated(pub *models.Publication, entries []models.Entry) (time.Time, error) {
+	digest := publishedDigest(entries)
+	if digest == pub.ContentDigest && !pub.ContentUpdatedAt.IsZero() {
+		return pub.ContentUpdatedAt, nil
+	}
+
+	err := s.db.EditPublicationContent(pub, digest, time.Now().Truncate(time.Second))
+	return pub.ContentUpdatedAt, err
+}
+
+// publishedDigest identifies entries by what is published of them.
+func publishedDigest(entries []models.Entry) string {
+	hash := sha1.New()
+	for _, entry := range entries {
+		for _, field := range []string{
+			entry.APIID,
+			entry.GUID,
+			entry.Title,
+			entry.Link,
+			entry.Author,
+			entry.Summary,
+			entry.Content,
+			entry.Published.UTC().Format(time.RFC3339Nano),
+		} {
+			io.WriteString(hash, field)
+			hash.Write([]byte{0})
+		}
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// notModified reports whether the client already has the document
+// tagged with etag. If-Modified-Since is only considered when the
+// request has no If-None-Match header.
+func notModified(req *http.Request, etag string, updated time.Time) bool {
+	if match := req.Header.Get("If-None-Match"); match != "" {
+		for _, tag := range strings.Split(match, ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			if tag == etag || tag == "*" {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	since, err := http.ParseTime(req.Header.Get(echo.HeaderIfModifiedSince))
+	if err != nil {
+		return false
+	}
+
+	return !updated.Truncate(time.Second).After(since)
+}
@@ -30,6 +30,7 @@ import (
 	"github.com/varddum/syndication/config"
 	"github.com/varddum/syndication/database"
 	"github.com/varddum/syndication/models"
+	"github.com/varddum/syndication/opml"
 	"github.com/varddum/syndication/sync"
 
 	"github.com/dgrijalva/jwt-go"
@@ -40,12 +41,31 @@ import (
 
 const echoSyndUserKey = "syndUser"
 
+// maxEntryPageSize bounds the number of entries returned in a single page
+const maxEntryPageSize = 1000
+
 type (
 	// EntryQueryParams maps query parameters used when GETting entries resources
 	EntryQueryParams struct {
-		Marker  string `query:"markedAs"`
-		Saved   bool   `query:"saved"`
-		OrderBy string `query:"orderBy"`
+		Marker         string `query:"markedAs"`
+		Saved          bool   `query:"saved"`
+		OrderBy        string `query:"orderBy"`
+		ExcludeContent bool   `query:"excludeContent"`
+		Limit          int    `query:"limit"`
+		Cursor         string `query:"cursor"`
+	}
+
+	// SearchQueryParams maps query parameters used when searching entries
+	SearchQueryParams struct {
+		Query          string `query:"q"`
+		FeedID         string `query:"feedID"`
+		CategoryID     string `query:"categoryID"`
+		TagID          string `query:"tagID"`
+		Marker         string `query:"markedAs"`
+		Saved          bool   `query:"saved"`
+		OrderBy        string `query:"orderBy"`
+		ExcludeContent bool   `query:"excludeContent"`
+		Limit          int    `query:"limit"`
 	}
 
 	// Server represents a echo server instance and holds references to other components
@@ -63,6 +83,16 @@ type (
 		Reason  string `json:"reason"`
 		Message string `json:"message"`
 	}
+
+	// Marked reports how many Entries a bulk mark changed
+	Marked struct {
+		Marked int64 `json:"marked"`
+	}
+
+	// Candidates lists the feeds found at a URL that was not a feed itself
+	Candidates struct {
+		Candidates []sync.Candidate `json:"candidates"`
+	}
 )
 
 // NewServer creates a new server instance
@@ -84,6 +114,10 @@ func NewServer(db *database.DB, sync *sync.Sync, config config.Server) *Server {
 
 	server.registerMiddleware()
 	server.registerHandlers()
+	server.registerReaderAPI()
+	server.registerFeverAPI()
+	server.registerWebSub()
+	server.registerPublications()
 
 	return &server
 }
@@ -109,7 +143,7 @@ func (s *Server) Start() error {
 
 func (s *Server) assumeJSONContentType(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		if !strings.HasSuffix(c.Path(), "/login") && !strings.HasSuffix(c.Path(), "/register") {
+		if !strings.HasSuffix(c.Path(), "/login") && !strings.HasSuffix(c.Path(), "/register") && !strings.HasSuffix(c.Path(), "/opml") {
 			if c.Request().Header.Get("Content-Type") == "" {
 				c.Request().Header.Set("Content-Type", "application/json")
 			} else if c.Request().Header.Get("Content-Type") != "application/json" {
@@ -134,21 +168,8 @@ func (s *Server) checkAuth(next echo.HandlerFunc) echo.HandlerFunc {
 			return next(c)
 		}
 
-		userClaim := c.Get("user").(*jwt.Token)
-		claims := userClaim.Claims.(jwt.MapClaims)
-		user, err := s.db.UserWithAPIID(claims["id"].(string))
-		if err != nil {
-			return c.JSON(http.StatusUnauthorized, ErrorResp{
-				Reason:  "Unauthorized",
-				Message: "Credentials are invalid",
-			})
-		}
-
-		key := &models.APIKey{
-			Key: userClaim.Raw,
-		}
-		found, err := s.db.KeyBelongsToUser(key, &user)
-		if err != nil || !found {
+		user, ok := s.userWithKey(c.Get("user").(*jwt.Token))
+		if !ok {
 			return c.JSON(http.StatusUnauthorized, ErrorResp{
 				Reason:  "Unauthorized",
 				Message: "Credentials are invalid",
@@ -161,6 +182,34 @@ func (s *Server) checkAuth(next echo.HandlerFunc) echo.HandlerFunc {
 	}
 }
 
+// userWithKey returns the User that owns the API key in token.
+func (s *Server) userWithKey(token *jwt.Token) (models.User, bool) {
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return models.User{}, false
+	}
+
+	userID, ok := claims["id"].(string)
+	if !ok {
+		return models.User{}, false
+	}
+
+	user, err := s.db.UserWithAPIID(userID)
+	if err != nil {
+		return models.User{}, false
+	}
+
+	key := &models.APIKey{
+		Key: token.Raw,
+	}
+	found, err := s.db.KeyBelongsToUser(key, &user)
+	if err != nil || !found {
+		return models.User{}, false
+	}
+
+	return user, true
+}
+
 // Stop the server gracefully
 func (s *Server) Stop() error {
 	ctx, cancel := context.WithTimeout(context.Background(), s.config.ShutdownTimeout.Duration*time.Second)
@@ -196,6 +245,40 @@ func (s *Server) Login(c echo.Context) error {
 	return c.JSON(http.StatusOK, key)
 }
 
+// EnableFever allows the user to sign in to the Fever API
+// with their username and the given password
+func (s *Server) EnableFever(c echo.Context) error {
+	user := c.Get(echoSyndUserKey).(models.User)
+
+	type FeverCredentials struct {
+		Password string `json:"password"`
+	}
+
+	creds := FeverCredentials{}
+	if err := c.Bind(&creds); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest)
+	}
+
+	err := s.db.EnableFever(user.APIID, creds.Password)
+	if err != nil {
+		return newError(err, &c)
+	}
+
+	return echo.NewHTTPError(http.StatusNoContent)
+}
+
+// DisableFever prevents the user from signing in to the Fever API
+func (s *Server) DisableFever(c echo.Context) error {
+	user := c.Get(echoSyndUserKey).(models.User)
+
+	err := s.db.DisableFever(user.APIID)
+	if err != nil {
+		return newError(err, &c)
+	}
+
+	return echo.NewHTTPError(http.StatusNoContent)
+}
+
 // Register a user
 func (s *Server) Register(c echo.Context) error {
 	err := s.db.NewUser(c.FormValue("username"), c.FormValue("password"))
@@ -215,12 +298,28 @@ func (s *Server) NewFeed(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest)
 	}
 
-	err := s.db.NewFeed(&feed, &user)
+	candidates, err := s.sync.Discover(c.Request().Context(), feed.Subscription)
 	if err != nil {
 		return newError(err, &c)
 	}
 
-	err = s.sync.SyncFeed(&feed, &user)
+	if len(candidates) > 1 {
+		return c.JSON(http.StatusMultipleChoices, Candidates{
+			Candidates: candidates,
+		})
+	}
+
+	feed.Subscription = candidates[0].URL
+	if feed.Title == "" {
+		feed.Title = candidates[0].Title
+	}
+
+	err = s.db.NewFeed(&feed, &user)
+	if err != nil {
+		return newError(err, &c)
+	}
+
+	err = s.sync.SyncCandidate(c.Request().Context(), &feed, &user, candidates[0])
 	if err != nil {
 		return newError(err, &c)
 	}
@@ -228,6 +327,18 @@ func (s *Server) NewFeed(c echo.Context) error {
 	return c.JSON(http.StatusCreated, feed)
 }
 
+// DiscoverFeeds returns the feeds found at a URL
+func (s *Server) DiscoverFeeds(c echo.Context) error {
+	candidates, err := s.sync.Discover(c.Request().Context(), c.QueryParam("url"))
+	if err != nil {
+		return newError(err, &c)
+	}
+
+	return c.JSON(http.StatusOK, Candidates{
+		Candidates: candidates,
+	})
+}
+
 // GetFeeds returns a list of subscribed feeds
 func (s *Server) GetFeeds(c echo.Context) error {
 	user := c.Get(echoSyndUserKey).(models.User)
@@ -288,6 +399,83 @@ func (s *Server) DeleteFeed(c echo.Context) error {
 	return echo.NewHTTPError(http.StatusNoContent)
 }
 
+// ResetFeed clears the failures of a feed with id and revives it if it is dead
+func (s *Server) ResetFeed(c echo.Context) error {
+	user := c.Get(echoSyndUserKey).(models.User)
+
+	feed, err := s.db.ResetFeed(c.Param("feedID"), &user)
+	if err != nil {
+		return newError(err, &c)
+	}
+
+	return c.JSON(http.StatusOK, feed)
+}
+
+// RefreshFeed queues a refresh of a feed with id
+func (s *Server) RefreshFeed(c echo.Context) error {
+	user := c.Get(echoSyndUserKey).(models.User)
+
+	job, err := s.sync.RefreshFeed(c.Param("feedID"), &user)
+	if err != nil {
+		return newError(err, &c)
+	}
+
+	return c.JSON(http.StatusAccepted, job)
+}
+
+// RefreshCategory queues a refresh of the feeds in a category with id
+func (s *Server) RefreshCategory(c echo.Context) error {
+	user := c.Get(echoSyndUserKey).(models.User)
+
+	job, err := s.sync.RefreshCategory(c.Param("categoryID"), &user)
+	if err != nil {
+		return newError(err, &c)
+	}
+
+	return c.JSON(http.StatusAccepted, job)
+}
+
+// RefreshAll queues a refresh of every feed of a user
+func (s *Server) RefreshAll(c echo.Context) error {
+	user := c.Get(echoSyndUserKey).(models.User)
+
+	job, err := s.sync.RefreshAll(&user)
+	if err != nil {
+		return newError(err, &c)
+	}
+
+	return c.JSON(http.StatusAccepted, job)
+}
+
+// GetRefresh returns the status of a refresh with id
+func (s *Server) GetRefresh(c echo.Context) error {
+	user := c.Get(echoSyndUserKey).(models.User)
+
+	job, err := s.sync.Refresh(c.Param("refreshID"), &user)
+	if err != nil {
+		return newError(err, &c)
+	}
+
+	return c.JSON(http.StatusOK, job)
+}
+
+// EditFeedRetention changes the retention policy of a feed with id
+func (s *Server) EditFeedRetention(c echo.Context) error {
+	user := c.Get(echoSyndUserKey).(models.User)
+
+	policy := models.RetentionPolicy{}
+	if err := c.Bind(&policy); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest)
+	}
+
+	err := s.db.EditFeedRetention(c.Param("feedID"), policy, &user)
+	if err != nil {
+		return newError(err, &c)
+	}
+
+	return echo.NewHTTPError(http.StatusNoContent)
+}
+
 // GetCategories returns a list of Categories owned by a user
 func (s *Server) GetCategories(c echo.Context) error {
 	user := c.Get(echoSyndUserKey).(models.User)
@@ -303,6 +491,23 @@ func (s *Server) GetCategories(c echo.Context) error {
 	})
 }
 
+// EditCategoryRetention changes the retention policy of a category with id
+func (s *Server) EditCategoryRetention(c echo.Context) error {
+	user := c.Get(echoSyndUserKey).(models.User)
+
+	policy := models.RetentionPolicy{}
+	if err := c.Bind(&policy); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest)
+	}
+
+	err := s.db.EditCategoryRetention(c.Param("categoryID"), policy, &user)
+	if err != nil {
+		return newError(err, &c)
+	}
+
+	return echo.NewHTTPError(http.StatusNoContent)
+}
+
 // GetCategory with id
 func (s *Server) GetCategory(c echo.Context) error {
 	user := c.Get(echoSyndUserKey).(models.User)
@@ -334,17 +539,23 @@ func (s *Server) GetEntriesFromFeed(c echo.Context) error {
 		markedAs = models.Any
 	}
 
-	entries, err := s.db.EntriesFromFeed(feed.APIID, convertOrderByParamToValue(params.OrderBy), markedAs, &user)
+	entries, next, err := s.db.EntriesFromFeedPage(feed.APIID, params.page(), convertOrderByParamToValue(params.OrderBy), markedAs, params.Saved, &user)
 	if err != nil {
 		return newError(err, &c)
 	}
 
+	if params.ExcludeContent {
+		stripEntryContent(entries)
+	}
+
 	type Entries struct {
-		Entries []models.Entry `json:"entries"`
+		Entries    []models.Entry `json:"entries"`
+		NextCursor string         `json:"nextCursor,omitempty"`
 	}
 
 	return c.JSON(http.StatusOK, Entries{
-		Entries: entries,
+		Entries:    entries,
+		NextCursor: next,
 	})
 }
 
@@ -368,17 +579,23 @@ func (s *Server) GetEntriesFromCategory(c echo.Context) error {
 		markedAs = models.Any
 	}
 
-	entries, err := s.db.EntriesFromCategory(ctg.APIID, convertOrderByParamToValue(params.OrderBy), markedAs, &user)
+	entries, next, err := s.db.EntriesFromCategoryPage(ctg.APIID, params.page(), convertOrderByParamToValue(params.OrderBy), markedAs, params.Saved, &user)
 	if err != nil {
 		return newError(err, &c)
 	}
 
+	if params.ExcludeContent {
+		stripEntryContent(entries)
+	}
+
 	type Entries struct {
-		Entries []models.Entry `json:"entries"`
+		Entries    []models.Entry `json:"entries"`
+		NextCursor string         `json:"nextCursor,omitempty"`
 	}
 
 	return c.JSON(http.StatusOK, Entries{
-		Entries: entries,
+		Entries:    entries,
+		NextCursor: next,
 	})
 }
 
@@ -472,6 +689,8 @@ func (s *Server) DeleteCategory(c echo.Context) error {
 		return newError(err, &c)
 	}
 
+	s.sync.RulesEdited(&user)
+
 	return echo.NewHTTPError(http.StatusNoContent)
 }
 
@@ -500,12 +719,19 @@ func (s *Server) MarkCategory(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "'as' parameter is required")
 	}
 
-	err := s.db.MarkCategory(ctgID, marker, &user)
+	bounds, err := markBounds(c)
+	if err != nil {
+		return err
+	}
+
+	count, err := s.db.MarkCategoryEntries(ctgID, marker, bounds, &user)
 	if err != nil {
 		return newError(err, &c)
 	}
 
-	return echo.NewHTTPError(http.StatusNoContent)
+	return c.JSON(http.StatusOK, Marked{
+		Marked: count,
+	})
 }
 
 // NewTag creates a new Tag
@@ -551,6 +777,8 @@ func (s *Server) DeleteTag(c echo.Context) error {
 		return newError(err, &c)
 	}
 
+	s.sync.RulesEdited(&user)
+
 	return echo.NewHTTPError(http.StatusNoContent)
 }
 
@@ -593,17 +821,23 @@ func (s *Server) GetEntriesFromTag(c echo.Context) error {
 		withMarker = models.Any
 	}
 
-	entries, err := s.db.EntriesFromTag(tag.APIID, withMarker, true, &user)
+	entries, next, err := s.db.EntriesFromTagPage(tag.APIID, params.page(), withMarker, params.Saved, convertOrderByParamToValue(params.OrderBy), &user)
 	if err != nil {
 		return newError(err, &c)
 	}
 
+	if params.ExcludeContent {
+		stripEntryContent(entries)
+	}
+
 	type Entries struct {
-		Entries []models.Entry `json:"entries"`
+		Entries    []models.Entry `json:"entries"`
+		NextCursor string         `json:"nextCursor,omitempty"`
 	}
 
 	return c.JSON(http.StatusOK, Entries{
-		Entries: entries,
+		Entries:    entries,
+		NextCursor: next,
 	})
 }
 
@@ -633,6 +867,30 @@ func (s *Server) TagEntries(c echo.Context) error {
 	return echo.NewHTTPError(http.StatusNoContent)
 }
 
+// MarkTag applies a Marker to the Entries of a Tag
+func (s *Server) MarkTag(c echo.Context) error {
+	user := c.Get(echoSyndUserKey).(models.User)
+
+	marker := models.MarkerFromString(c.FormValue("as"))
+	if marker == models.None {
+		return echo.NewHTTPError(http.StatusBadRequest, "'as' parameter is required")
+	}
+
+	bounds, err := markBounds(c)
+	if err != nil {
+		return err
+	}
+
+	count, err := s.db.MarkTagEntries(c.Param("tagID"), marker, bounds, &user)
+	if err != nil {
+		return newError(err, &c)
+	}
+
+	return c.JSON(http.StatusOK, Marked{
+		Marked: count,
+	})
+}
+
 // GetTag with id
 func (s *Server) GetTag(c echo.Context) error {
 	user := c.Get(echoSyndUserKey).(models.User)
@@ -656,12 +914,45 @@ func (s *Server) MarkFeed(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "'as' parameter is required")
 	}
 
-	err := s.db.MarkFeed(feedID, marker, &user)
+	bounds, err := markBounds(c)
+	if err != nil {
+		return err
+	}
+
+	count, err := s.db.MarkFeedEntries(feedID, marker, bounds, &user)
 	if err != nil {
 		return newError(err, &c)
 	}
 
-	return echo.NewHTTPError(http.StatusNoContent)
+	return c.JSON(http.StatusOK, Marked{
+		Marked: count,
+	})
+}
+
+// GetFeedIcon returns the cached icon of a Feed
+func (s *Server) GetFeedIcon(c echo.Context) error {
+	user := c.Get(echoSyndUserKey).(models.User)
+
+	feed, err := s.db.Feed(c.Param("feedID"), &user)
+	if err != nil {
+		return newError(err, &c)
+	}
+
+	icon, info, err := s.sync.FeedIcon(&feed)
+	if err != nil {
+		return newError(err, &c)
+	}
+	defer icon.Close()
+
+	// Icons are named after the hash of their content,
+	// which makes the name a strong validator.
+	header := c.Response().Header()
+	header.Set(echo.HeaderContentType, feed.IconType)
+	header.Set("Cache-Control", "private, max-age=86400")
+	header.Set("ETag", `"`+feed.Icon+`"`)
+
+	http.ServeContent(c.Response(), c.Request(), "", info.ModTime(), icon)
+	return nil
 }
 
 // GetStatsForFeed provides statistics related to a Feed
@@ -704,11 +995,63 @@ func (s *Server) GetEntries(c echo.Context) error {
 		markedAs = models.Any
 	}
 
-	entries, err := s.db.Entries(convertOrderByParamToValue(params.OrderBy), markedAs, &user)
+	entries, next, err := s.db.EntriesPage(params.page(), convertOrderByParamToValue(params.OrderBy), markedAs, params.Saved, &user)
 	if err != nil {
 		return newError(err, &c)
 	}
 
+	if params.ExcludeContent {
+		stripEntryContent(entries)
+	}
+
+	type Entries struct {
+		Entries    []models.Entry `json:"entries"`
+		NextCursor string         `json:"nextCursor,omitempty"`
+	}
+
+	return c.JSON(http.StatusOK, Entries{
+		Entries:    entries,
+		NextCursor: next,
+	})
+}
+
+// SearchEntries returns the entries that match a full-text query
+func (s *Server) SearchEntries(c echo.Context) error {
+	user := c.Get(echoSyndUserKey).(models.User)
+
+	params := new(SearchQueryParams)
+	if err := c.Bind(params); err != nil {
+		return newError(err, &c)
+	}
+
+	markedAs := models.MarkerFromString(params.Marker)
+	if markedAs == models.None {
+		markedAs = models.Any
+	}
+
+	limit := params.Limit
+	if limit > maxEntryPageSize {
+		limit = maxEntryPageSize
+	}
+
+	entries, err := s.db.SearchEntries(database.Search{
+		Text:       params.Query,
+		FeedID:     params.FeedID,
+		CategoryID: params.CategoryID,
+		TagID:      params.TagID,
+		Marker:     markedAs,
+		Saved:      params.Saved,
+		Order:      convertSearchOrderParamToValue(params.OrderBy),
+		Limit:      limit,
+	}, &user)
+	if err != nil {
+		return newError(err, &c)
+	}
+
+	if params.ExcludeContent {
+		stripEntryContent(entries)
+	}
+
 	type Entries struct {
 		Entries []models.Entry `json:"entries"`
 	}
@@ -737,6 +1080,82 @@ func (s *Server) MarkEntry(c echo.Context) error {
 	return echo.NewHTTPError(http.StatusNoContent)
 }
 
+// MarkEntries applies a Marker to a list of Entries
+func (s *Server) MarkEntries(c echo.Context) error {
+	user := c.Get(echoSyndUserKey).(models.User)
+
+	marker := models.MarkerFromString(c.FormValue("as"))
+	if marker == models.None {
+		return echo.NewHTTPError(http.StatusBadRequest, "'as' parameter is required")
+	}
+
+	type EntryIds struct {
+		Entries []string `json:"entries"`
+	}
+
+	entryIds := new(EntryIds)
+	if err := c.Bind(entryIds); err != nil {
+		return newError(err, &c)
+	}
+
+	count, err := s.db.MarkEntries(entryIds.Entries, marker, &user)
+	if err != nil {
+		return newError(err, &c)
+	}
+
+	return c.JSON(http.StatusOK, Marked{
+		Marked: count,
+	})
+}
+
+// MarkAllEntries applies a Marker to every Entry
+func (s *Server) MarkAllEntries(c echo.Context) error {
+	user := c.Get(echoSyndUserKey).(models.User)
+
+	marker := models.MarkerFromString(c.FormValue("as"))
+	if marker == models.None {
+		return echo.NewHTTPError(http.StatusBadRequest, "'as' parameter is required")
+	}
+
+	bounds, err := markBounds(c)
+	if err != nil {
+		return err
+	}
+
+	count, err := s.db.MarkAllEntries(marker, bounds, &user)
+	if err != nil {
+		return newError(err, &c)
+	}
+
+	return c.JSON(http.StatusOK, Marked{
+		Marked: count,
+	})
+}
+
+// SaveEntry marks an Entry as saved
+func (s *Server) SaveEntry(c echo.Context) error {
+	user := c.Get(echoSyndUserKey).(models.User)
+
+	err := s.db.SaveEntry(c.Param("entryID"), &user)
+	if err != nil {
+		return newError(err, &c)
+	}
+
+	return echo.NewHTTPError(http.StatusNoContent)
+}
+
+// UnsaveEntry removes the saved mark of an Entry
+func (s *Server) UnsaveEntry(c echo.Context) error {
+	user := c.Get(echoSyndUserKey).(models.User)
+
+	err := s.db.UnsaveEntry(c.Param("entryID"), &user)
+	if err != nil {
+		return newError(err, &c)
+	}
+
+	return echo.NewHTTPError(http.StatusNoContent)
+}
+
 // GetStatsForEntries provides statistics related to Entries
 func (s *Server) GetStatsForEntries(c echo.Context) error {
 	user := c.Get(echoSyndUserKey).(models.User)
@@ -744,6 +1163,236 @@ func (s *Server) GetStatsForEntries(c echo.Context) error {
 	return c.JSON(http.StatusOK, s.db.Stats(&user))
 }
 
+// NewRule creates a Rule that acts on Entries received by syncs
+func (s *Server) NewRule(c echo.Context) error {
+	user := c.Get(echoSyndUserKey).(models.User)
+
+	rule := models.Rule{}
+	if err := c.Bind(&rule); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest)
+	}
+
+	err := s.db.NewRule(&rule, &user)
+	if err != nil {
+		return newError(err, &c)
+	}
+
+	s.sync.RulesEdited(&user)
+
+	return c.JSON(http.StatusCreated, rule)
+}
+
+// GetRules returns a list of Rules owned by a user
+func (s *Server) GetRules(c echo.Context) error {
+	user := c.Get(echoSyndUserKey).(models.User)
+
+	rules := s.db.Rules(&user)
+
+	type Rules struct {
+		Rules []models.Rule `json:"rules"`
+	}
+
+	return c.JSON(http.StatusOK, Rules{
+		Rules: rules,
+	})
+}
+
+// GetRule with id
+func (s *Server) GetRule(c echo.Context) error {
+	user := c.Get(echoSyndUserKey).(models.User)
+
+	rule, err := s.db.Rule(c.Param("ruleID"), &user)
+	if err != nil {
+		return newError(err, &c)
+	}
+
+	return c.JSON(http.StatusOK, rule)
+}
+
+// EditRule with id
+func (s *Server) EditRule(c echo.Context) error {
+	user := c.Get(echoSyndUserKey).(models.User)
+
+	rule := models.Rule{}
+	if err := c.Bind(&rule); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest)
+	}
+
+	rule.APIID = c.Param("ruleID")
+
+	err := s.db.EditRule(&rule, &user)
+	if err != nil {
+		return newError(err, &c)
+	}
+
+	s.sync.RulesEdited(&user)
+
+	return echo.NewHTTPError(http.StatusNoContent)
+}
+
+// DeleteRule with id
+func (s *Server) DeleteRule(c echo.Context) error {
+	user := c.Get(echoSyndUserKey).(models.User)
+
+	err := s.db.DeleteRule(c.Param("ruleID"), &user)
+	if err != nil {
+		return newError(err, &c)
+	}
+
+	s.sync.RulesEdited(&user)
+
+	return echo.NewHTTPError(http.StatusNoContent)
+}
+
+// DryRunRule returns the existing Entries a Rule would have matched
+func (s *Server) DryRunRule(c echo.Context) error {
+	user := c.Get(echoSyndUserKey).(models.User)
+
+	entries, err := s.sync.DryRunRule(c.Param("ruleID"), &user)
+	if err != nil {
+		return newError(err, &c)
+	}
+
+	stripEntryContent(entries)
+
+	type Entries struct {
+		Entries []models.Entry `json:"entries"`
+	}
+
+	return c.JSON(http.StatusOK, Entries{
+		Entries: entries,
+	})
+}
+
+// GetEnclosuresInProgress returns the Enclosures that were started but not completed
+func (s *Server) GetEnclosuresInProgress(c echo.Context) error {
+	user := c.Get(echoSyndUserKey).(models.User)
+
+	enclosures := s.db.EnclosuresInProgress(&user)
+
+	type Enclosures struct {
+		Enclosures []models.Enclosure `json:"enclosures"`
+	}
+
+	return c.JSON(http.StatusOK, Enclosures{
+		Enclosures: enclosures,
+	})
+}
+
+// GetEnclosure with id
+func (s *Server) GetEnclosure(c echo.Context) error {
+	user := c.Get(echoSyndUserKey).(models.User)
+
+	enclosure, err := s.db.Enclosure(c.Param("enclosureID"), &user)
+	if err != nil {
+		return newError(err, &c)
+	}
+
+	return c.JSON(http.StatusOK, enclosure)
+}
+
+// EditEnclosureProgress records how much of an Enclosure was listened to
+func (s *Server) EditEnclosureProgress(c echo.Context) error {
+	user := c.Get(echoSyndUserKey).(models.User)
+
+	type Progress struct {
+		Position  int64 `json:"position"`
+		Completed bool  `json:"completed"`
+	}
+
+	progress := Progress{}
+	if err := c.Bind(&progress); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest)
+	}
+
+	enclosure, err := s.db.EditEnclosureProgress(c.Param("enclosureID"), progress.Position, progress.Completed, &user)
+	if err != nil {
+		return newError(err, &c)
+	}
+
+	return c.JSON(http.StatusOK, enclosure)
+}
+
+// NewPublication creates a Publication of a Category, a Tag or the saved Entries
+func (s *Server) NewPublication(c echo.Context) error {
+	user := c.Get(echoSyndUserKey).(models.User)
+
+	pub := models.Publication{}
+	if err := c.Bind(&pub); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest)
+	}
+
+	err := s.db.NewPublication(&pub, &user)
+	if err != nil {
+		return newError(err, &c)
+	}
+
+	return c.JSON(http.StatusCreated, pub)
+}
+
+// GetPublications returns a list of Publications owned by a user
+func (s *Server) GetPublications(c echo.Context) error {
+	user := c.Get(echoSyndUserKey).(models.User)
+
+	pubs := s.db.Publications(&user)
+
+	type Publications struct {
+		Publications []models.Publication `json:"publications"`
+	}
+
+	return c.JSON(http.StatusOK, Publications{
+		Publications: pubs,
+	})
+}
+
+// GetPublication with id
+func (s *Server) GetPublication(c echo.Context) error {
+	user := c.Get(echoSyndUserKey).(models.User)
+
+	pub, err := s.db.Publication(c.Param("publicationID"), &user)
+	if err != nil {
+		return newError(err, &c)
+	}
+
+	return c.JSON(http.StatusOK, pub)
+}
+
+// DeletePublication with id
+func (s *Server) DeletePublication(c echo.Context) error {
+	user := c.Get(echoSyndUserKey).(models.User)
+
+	err := s.db.DeletePublication(c.Param("publicationID"), &user)
+	if err != nil {
+		return newError(err, &c)
+	}
+
+	return echo.NewHTTPError(http.StatusNoContent)
+}
+
+// ImportOPML subscribes to the feeds in an OPML document
+func (s *Server) ImportOPML(c echo.Context) error {
+	user := c.Get(echoSyndUserKey).(models.User)
+
+	doc, err := opml.Parse(c.Request().Body)
+	if err != nil {
+		return newError(err, &c)
+	}
+
+	return c.JSON(http.StatusOK, opml.Import(s.db, doc, &user))
+}
+
+// ExportOPML returns an OPML document of all subscribed feeds
+func (s *Server) ExportOPML(c echo.Context) error {
+	user := c.Get(echoSyndUserKey).(models.User)
+
+	b, err := opml.Marshal(opml.Export(s.db, &user))
+	if err != nil {
+		return newError(err, &c)
+	}
+
+	return c.Blob(http.StatusOK, echo.MIMEApplicationXMLCharsetUTF8, b)
+}
+
 func (s *Server) registerMiddleware() {
 	for version, group := range s.versionGroups {
 		group.Use(s.assumeJSONContentType)
@@ -757,10 +1406,7 @@ func (s *Server) registerMiddleware() {
 			ContentSecurityPolicy: "default-src 'self'",
 		}))
 
-		group.Use(middleware.RecoverWithConfig(middleware.RecoverConfig{
-			StackSize:         1 << 10, // 1 KB
-			DisablePrintStack: s.config.EnablePanicPrintStack,
-		}))
+		group.Use(s.recoverer())
 
 		group.Use(middleware.JWTWithConfig(middleware.JWTConfig{
 			Skipper: func(c echo.Context) bool {
@@ -785,6 +1431,14 @@ func (s *Server) registerMiddleware() {
 	}
 }
 
+// recoverer returns the middleware every group uses to recover from panics in handlers.
+func (s *Server) recoverer() echo.MiddlewareFunc {
+	return middleware.RecoverWithConfig(middleware.RecoverConfig{
+		StackSize:         1 << 10, // 1 KB
+		DisablePrintStack: s.config.EnablePanicPrintStack,
+	})
+}
+
 func (s *Server) registerHandlers() {
 	v1 := s.versionGroups["v1"]
 
@@ -792,6 +1446,8 @@ func (s *Server) registerHandlers() {
 	v1.POST("/register", s.Register)
 
 	v1.POST("/feeds", s.NewFeed)
+	v1.GET("/discover", s.DiscoverFeeds)
+	v1.OPTIONS("/discover", s.OptionsHandler)
 	v1.GET("/feeds", s.GetFeeds)
 	v1.GET("/feeds/:feedID", s.GetFeed)
 	v1.PUT("/feeds/:feedID", s.EditFeed)
@@ -799,11 +1455,24 @@ func (s *Server) registerHandlers() {
 	v1.GET("/feeds/:feedID/entries", s.GetEntriesFromFeed)
 	v1.PUT("/feeds/:feedID/mark", s.MarkFeed)
 	v1.GET("/feeds/:feedID/stats", s.GetStatsForFeed)
+	v1.GET("/feeds/:feedID/icon", s.GetFeedIcon)
+	v1.POST("/feeds/:feedID/reset", s.ResetFeed)
+	v1.PUT("/feeds/:feedID/retention", s.EditFeedRetention)
+	v1.POST("/feeds/:feedID/refresh", s.RefreshFeed)
 	v1.OPTIONS("/feeds", s.OptionsHandler)
 	v1.OPTIONS("/feeds/:feedID", s.OptionsHandler)
 	v1.OPTIONS("/feeds/:feedID/mark", s.OptionsHandler)
 	v1.OPTIONS("/feeds/:feedID/entries", s.OptionsHandler)
 	v1.OPTIONS("/feeds/:feedID/stats", s.OptionsHandler)
+	v1.OPTIONS("/feeds/:feedID/icon", s.OptionsHandler)
+	v1.OPTIONS("/feeds/:feedID/reset", s.OptionsHandler)
+	v1.OPTIONS("/feeds/:feedID/retention", s.OptionsHandler)
+	v1.OPTIONS("/feeds/:feedID/refresh", s.OptionsHandler)
+
+	v1.POST("/refresh", s.RefreshAll)
+	v1.GET("/refresh/:refreshID", s.GetRefresh)
+	v1.OPTIONS("/refresh", s.OptionsHandler)
+	v1.OPTIONS("/refresh/:refreshID", s.OptionsHandler)
 
 	v1.POST("/tags", s.NewTag)
 	v1.GET("/tags", s.GetTags)
@@ -811,6 +1480,7 @@ func (s *Server) registerHandlers() {
 	v1.DELETE("/tags/:tagID", s.DeleteTag)
 	v1.PUT("/tags/:tagID", s.EditTag)
 	v1.GET("/tags/:tagID/entries", s.GetEntriesFromTag)
+	v1.PUT("/tags/:tagID/mark", s.MarkTag)
 	v1.PUT("/tags/:tagID/entries", s.TagEntries)
 
 	v1.OPTIONS("/tags", s.OptionsHandler)
@@ -819,6 +1489,7 @@ func (s *Server) registerHandlers() {
 	v1.OPTIONS("/tags/:tagID", s.OptionsHandler)
 	v1.OPTIONS("/tags/:tagID", s.OptionsHandler)
 	v1.OPTIONS("/tags/:tagID/entries", s.OptionsHandler)
+	v1.OPTIONS("/tags/:tagID/mark", s.OptionsHandler)
 
 	v1.POST("/categories", s.NewCategory)
 	v1.GET("/categories", s.GetCategories)
@@ -830,21 +1501,66 @@ func (s *Server) registerHandlers() {
 	v1.GET("/categories/:categoryID/entries", s.GetEntriesFromCategory)
 	v1.PUT("/categories/:categoryID/mark", s.MarkCategory)
 	v1.GET("/categories/:categoryID/stats", s.GetStatsForCategory)
+	v1.PUT("/categories/:categoryID/retention", s.EditCategoryRetention)
+	v1.POST("/categories/:categoryID/refresh", s.RefreshCategory)
 	v1.OPTIONS("/categories", s.OptionsHandler)
 	v1.OPTIONS("/categories/:categoryID", s.OptionsHandler)
 	v1.OPTIONS("/categories/:categoryID/mark", s.OptionsHandler)
 	v1.OPTIONS("/categories/:categoryID/feeds", s.OptionsHandler)
 	v1.OPTIONS("/categories/:categoryID/entries", s.OptionsHandler)
 	v1.OPTIONS("/categories/:categoryID/stats", s.OptionsHandler)
+	v1.OPTIONS("/categories/:categoryID/retention", s.OptionsHandler)
+	v1.OPTIONS("/categories/:categoryID/refresh", s.OptionsHandler)
 
 	v1.GET("/entries", s.GetEntries)
+	v1.PUT("/entries", s.MarkEntries)
+	v1.PUT("/entries/mark", s.MarkAllEntries)
 	v1.GET("/entries/:entryID", s.GetEntry)
 	v1.PUT("/entries/:entryID/mark", s.MarkEntry)
+	v1.PUT("/entries/:entryID/save", s.SaveEntry)
+	v1.DELETE("/entries/:entryID/save", s.UnsaveEntry)
 	v1.GET("/entries/stats", s.GetStatsForEntries)
 	v1.OPTIONS("/entries", s.OptionsHandler)
 	v1.OPTIONS("/entries/stats", s.OptionsHandler)
+	v1.OPTIONS("/entries/mark", s.OptionsHandler)
 	v1.OPTIONS("/entries/:entryID", s.OptionsHandler)
 	v1.OPTIONS("/entries/:entryID/mark", s.OptionsHandler)
+	v1.OPTIONS("/entries/:entryID/save", s.OptionsHandler)
+
+	v1.GET("/search", s.SearchEntries)
+	v1.OPTIONS("/search", s.OptionsHandler)
+
+	v1.PUT("/fever", s.EnableFever)
+	v1.DELETE("/fever", s.DisableFever)
+	v1.OPTIONS("/fever", s.OptionsHandler)
+
+	v1.POST("/rules", s.NewRule)
+	v1.GET("/rules", s.GetRules)
+	v1.GET("/rules/:ruleID", s.GetRule)
+	v1.PUT("/rules/:ruleID", s.EditRule)
+	v1.DELETE("/rules/:ruleID", s.DeleteRule)
+	v1.GET("/rules/:ruleID/dryrun", s.DryRunRule)
+	v1.OPTIONS("/rules", s.OptionsHandler)
+	v1.OPTIONS("/rules/:ruleID", s.OptionsHandler)
+	v1.OPTIONS("/rules/:ruleID/dryrun", s.OptionsHandler)
+
+	v1.GET("/enclosures", s.GetEnclosuresInProgress)
+	v1.GET("/enclosures/:enclosureID", s.GetEnclosure)
+	v1.PUT("/enclosures/:enclosureID/progress", s.EditEnclosureProgress)
+	v1.OPTIONS("/enclosures", s.OptionsHandler)
+	v1.OPTIONS("/enclosures/:enclosureID", s.OptionsHandler)
+	v1.OPTIONS("/enclosures/:enclosureID/progress", s.OptionsHandler)
+
+	v1.POST("/publications", s.NewPublication)
+	v1.GET("/publications", s.GetPublications)
+	v1.GET("/publications/:publicationID", s.GetPublication)
+	v1.DELETE("/publications/:publicationID", s.DeletePublication)
+	v1.OPTIONS("/publications", s.OptionsHandler)
+	v1.OPTIONS("/publications/:publicationID", s.OptionsHandler)
+
+	v1.POST("/opml", s.ImportOPML)
+	v1.GET("/opml", s.ExportOPML)
+	v1.OPTIONS("/opml", s.OptionsHandler)
 }
 
 func newError(err error, c *echo.Context) error {
@@ -868,6 +1584,18 @@ func newError(err error, c *echo.Context) error {
 	})
 }
 
+func (p EntryQueryParams) page() database.Page {
+	limit := p.Limit
+	if limit > maxEntryPageSize {
+		limit = maxEntryPageSize
+	}
+
+	return database.Page{
+		Limit:  limit,
+		Cursor: p.Cursor,
+	}
+}
+
 func convertOrderByParamToValue(param string) bool {
 	if param != "" && strings.ToLower(param) == "oldest" {
 		return false
@@ -876,3 +1604,47 @@ func convertOrderByParamToValue(param string) bool {
 	// Default behavior is to return by newest
 	return true
 }
+
+func convertSearchOrderParamToValue(param string) database.SearchOrder {
+	switch strings.ToLower(param) {
+	case "newest":
+		return database.ByNewest
+	case "oldest":
+		return database.ByOldest
+	}
+
+	// Default behavior is to return by relevance
+	return database.ByRelevance
+}
+
+// markBounds reads the bounds of a bulk mark. before is either a Unix
+// timestamp or an RFC 3339 time, and max_id is the id of an Entry.
+func markBounds(c echo.Context) (database.MarkBounds, error) {
+	bounds := database.MarkBounds{
+		MaxID: c.FormValue("max_id"),
+	}
+
+	before := c.FormValue("before")
+	if before == "" {
+		return bounds, nil
+	}
+
+	if timestamp, err := strconv.ParseInt(before, 10, 64); err == nil {
+		bounds.Before = time.Unix(timestamp, 0)
+	} else if t, err := time.Parse(time.RFC3339, before); err == nil {
+		bounds.Before = t
+	} else {
+		return bounds, echo.NewHTTPError(http.StatusBadRequest, "Invalid 'before' parameter")
+	}
+
+	return bounds, nil
+}
+
+// stripEntryContent clears the content and summary of entries
+// so that list responses stay small.
+func stripEntryContent(entries []models.Entry) {
+	for i := range entries {
+		entries[i].Content = ""
+		entries[i].Summary = ""
+	}
+}
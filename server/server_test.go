@@ -19,14 +19,23 @@ package server
 
 import (
 	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"io"
+	"io/ioutil"
 	"math/rand"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
 	"strconv"
+	"strings"
+	gosync "sync"
 	"testing"
 	"time"
 
@@ -36,11 +45,14 @@ import (
 	"github.com/varddum/syndication/config"
 	"github.com/varddum/syndication/database"
 	"github.com/varddum/syndication/models"
+	"github.com/varddum/syndication/opml"
 	"github.com/varddum/syndication/sync"
 )
 
 const TestDBPath = "/tmp/syndication-test-server.db"
 
+const TestIconCacheDir = "/tmp/syndication-test-server-icons"
+
 type (
 	ServerTestSuite struct {
 		suite.Suite
@@ -149,6 +161,54 @@ func (suite *ServerTestSuite) TestNewFeed() {
 	suite.Equal(dbFeed.Title, respFeed.Title)
 }
 
+func (suite *ServerTestSuite) TestNewFeedIsFetchedOnce() {
+	var lock gosync.Mutex
+	hits := 0
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+
+		lock.Lock()
+		hits++
+		lock.Unlock()
+
+		resp, err := http.Get(suite.ts.URL)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		defer resp.Body.Close()
+
+		io.Copy(w, resp.Body)
+	}))
+	defer ts.Close()
+
+	payload := []byte(`{"subscription": "` + ts.URL + `"}`)
+	req, err := http.NewRequest("POST", "http://localhost:9876/v1/feeds", bytes.NewBuffer(payload))
+	suite.Require().Nil(err)
+	req.Header.Set("Authorization", "Bearer "+suite.token)
+
+	resp, err := http.DefaultClient.Do(req)
+	suite.Require().Nil(err)
+	defer resp.Body.Close()
+	suite.Equal(201, resp.StatusCode)
+
+	respFeed := new(models.Feed)
+	err = json.NewDecoder(resp.Body).Decode(respFeed)
+	suite.Require().Nil(err)
+
+	entries, err := suite.db.EntriesFromFeed(respFeed.APIID, true, models.Any, &suite.user)
+	suite.Require().Nil(err)
+	suite.Len(entries, 5)
+
+	lock.Lock()
+	suite.Equal(1, hits)
+	lock.Unlock()
+}
+
 func (suite *ServerTestSuite) TestNewUnretrivableFeed() {
 	payload := []byte(`{"title":"EFF", "subscription": "https://localhost:17170/rss/updates.xml"}`)
 	req, err := http.NewRequest("POST", "http://localhost:9876/v1/feeds", bytes.NewBuffer(payload))
@@ -163,6 +223,110 @@ func (suite *ServerTestSuite) TestNewUnretrivableFeed() {
 	suite.Equal(400, resp.StatusCode)
 }
 
+func (suite *ServerTestSuite) discoverySite(links ...string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			io.WriteString(w, "<html><head>"+strings.Join(links, "")+"</head></html>")
+		case "/rss.xml":
+			io.WriteString(w, `<rss version="2.0"><channel><title>RSS Feed</title></channel></rss>`)
+		case "/atom.xml":
+			io.WriteString(w, `<feed xmlns="http://www.w3.org/2005/Atom"><title>Atom Feed</title></feed>`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func (suite *ServerTestSuite) TestNewFeedFromWebsite() {
+	site := suite.discoverySite(`<link rel="alternate" type="application/rss+xml" href="/rss.xml">`)
+	defer site.Close()
+
+	payload := []byte(`{"subscription": "` + site.URL + `"}`)
+	req, err := http.NewRequest("POST", "http://localhost:9876/v1/feeds", bytes.NewBuffer(payload))
+	suite.Require().Nil(err)
+	req.Header.Set("Authorization", "Bearer "+suite.token)
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	suite.Require().Nil(err)
+	defer resp.Body.Close()
+
+	suite.Equal(201, resp.StatusCode)
+
+	respFeed := new(models.Feed)
+	err = json.NewDecoder(resp.Body).Decode(respFeed)
+	suite.Require().Nil(err)
+
+	suite.Equal(site.URL+"/rss.xml", respFeed.Subscription)
+	suite.Equal("RSS Feed", respFeed.Title)
+}
+
+func (suite *ServerTestSuite) TestNewFeedWithMultipleCandidates() {
+	site := suite.discoverySite(
+		`<link rel="alternate" type="application/rss+xml" title="Posts" href="/rss.xml">`,
+		`<link rel="alternate" type="application/atom+xml" href="/atom.xml">`,
+	)
+	defer site.Close()
+
+	payload := []byte(`{"subscription": "` + site.URL + `"}`)
+	req, err := http.NewRequest("POST", "http://localhost:9876/v1/feeds", bytes.NewBuffer(payload))
+	suite.Require().Nil(err)
+	req.Header.Set("Authorization", "Bearer "+suite.token)
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	suite.Require().Nil(err)
+	defer resp.Body.Close()
+
+	suite.Equal(300, resp.StatusCode)
+
+	type Candidates struct {
+		Candidates []map[string]string `json:"candidates"`
+	}
+
+	var candidates Candidates
+	err = json.NewDecoder(resp.Body).Decode(&candidates)
+	suite.Require().Nil(err)
+	suite.Require().Len(candidates.Candidates, 2)
+	suite.Equal(site.URL+"/rss.xml", candidates.Candidates[0]["url"])
+	suite.Equal("Posts", candidates.Candidates[0]["title"])
+	suite.Equal("atom", candidates.Candidates[1]["type"])
+
+	suite.Empty(suite.db.Feeds(&suite.user))
+}
+
+func (suite *ServerTestSuite) TestDiscoverFeeds() {
+	site := suite.discoverySite(`<link rel="alternate" type="application/atom+xml" href="/atom.xml">`)
+	defer site.Close()
+
+	req, err := http.NewRequest("GET", "http://localhost:9876/v1/discover?url="+url.QueryEscape(site.URL), nil)
+	suite.Require().Nil(err)
+	req.Header.Set("Authorization", "Bearer "+suite.token)
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	suite.Require().Nil(err)
+	defer resp.Body.Close()
+
+	suite.Equal(200, resp.StatusCode)
+
+	body, err := ioutil.ReadAll(resp.Body)
+	suite.Require().Nil(err)
+	suite.Contains(string(body), `"url":"`+site.URL+`/atom.xml"`)
+	suite.Contains(string(body), `"title":"Atom Feed"`)
+
+	req, err = http.NewRequest("GET", "http://localhost:9876/v1/discover", nil)
+	suite.Require().Nil(err)
+	req.Header.Set("Authorization", "Bearer "+suite.token)
+
+	resp, err = client.Do(req)
+	suite.Require().Nil(err)
+	defer resp.Body.Close()
+
+	suite.Equal(400, resp.StatusCode)
+}
+
 func (suite *ServerTestSuite) TestGetFeeds() {
 	for i := 0; i < 5; i++ {
 		feed := models.Feed{
@@ -227,6 +391,238 @@ func (suite *ServerTestSuite) TestGetFeed() {
 	suite.Equal(respFeed.APIID, feed.APIID)
 }
 
+func (suite *ServerTestSuite) TestGetFeedHealth() {
+	feed := models.Feed{
+		Subscription: suite.ts.URL,
+	}
+
+	err := suite.db.NewFeed(&feed, &suite.user)
+	suite.Require().Nil(err)
+
+	feed.Failures = 3
+	feed.LastStatusCode = 500
+	feed.LastError = "Feed responded with 500 Internal Server Error"
+	err = suite.db.EditFeedSyncState(&feed, &suite.user)
+	suite.Require().Nil(err)
+
+	req, err := http.NewRequest("GET", "http://localhost:9876/v1/feeds/"+feed.APIID, nil)
+	suite.Nil(err)
+
+	req.Header.Set("Authorization", "Bearer "+suite.token)
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	suite.Require().Nil(err)
+	defer resp.Body.Close()
+
+	suite.Equal(200, resp.StatusCode)
+
+	respFeed := new(models.Feed)
+	err = json.NewDecoder(resp.Body).Decode(respFeed)
+	suite.Require().Nil(err)
+
+	suite.Equal(3, respFeed.Failures)
+	suite.Equal(500, respFeed.LastStatusCode)
+	suite.Equal(feed.LastError, respFeed.LastError)
+}
+
+func (suite *ServerTestSuite) TestResetFeed() {
+	feed := models.Feed{
+		Subscription: suite.ts.URL,
+	}
+
+	err := suite.db.NewFeed(&feed, &suite.user)
+	suite.Require().Nil(err)
+
+	feed.Status = models.Dead
+	feed.Failures = 10
+	feed.LastError = "Feed responded with 404 Not Found"
+	feed.NextSync = time.Now().Add(time.Hour * 24 * 7)
+	err = suite.db.EditFeedSyncState(&feed, &suite.user)
+	suite.Require().Nil(err)
+
+	req, err := http.NewRequest("POST", "http://localhost:9876/v1/feeds/"+feed.APIID+"/reset", nil)
+	suite.Nil(err)
+
+	req.Header.Set("Authorization", "Bearer "+suite.token)
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	suite.Require().Nil(err)
+	defer resp.Body.Close()
+
+	suite.Equal(200, resp.StatusCode)
+
+	respFeed := new(models.Feed)
+	err = json.NewDecoder(resp.Body).Decode(respFeed)
+	suite.Require().Nil(err)
+	suite.Empty(respFeed.Status)
+	suite.Zero(respFeed.Failures)
+
+	resetFeed, err := suite.db.Feed(feed.APIID, &suite.user)
+	suite.Require().Nil(err)
+	suite.Empty(resetFeed.Status)
+	suite.Zero(resetFeed.Failures)
+	suite.Empty(resetFeed.LastError)
+	suite.True(resetFeed.NextSync.IsZero())
+}
+
+func (suite *ServerTestSuite) TestResetMissingFeed() {
+	req, err := http.NewRequest("POST", "http://localhost:9876/v1/feeds/bogus/reset", nil)
+	suite.Nil(err)
+
+	req.Header.Set("Authorization", "Bearer "+suite.token)
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	suite.Require().Nil(err)
+	defer resp.Body.Close()
+
+	suite.Equal(404, resp.StatusCode)
+}
+
+func (suite *ServerTestSuite) TestRefreshFeed() {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Millisecond * 300)
+
+		resp, err := http.Get(suite.ts.URL)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		defer resp.Body.Close()
+
+		io.Copy(w, resp.Body)
+	}))
+	defer slow.Close()
+
+	feed := models.Feed{
+		Subscription: slow.URL,
+	}
+
+	err := suite.db.NewFeed(&feed, &suite.user)
+	suite.Require().Nil(err)
+
+	code, job := suite.refresh("/v1/feeds/" + feed.APIID + "/refresh")
+	suite.Require().Equal(202, code)
+	suite.NotEmpty(job.ID)
+	suite.Equal(1, job.Feeds)
+
+	code, coalesced := suite.refresh("/v1/feeds/" + feed.APIID + "/refresh")
+	suite.Require().Equal(202, code)
+	suite.Equal(job.ID, coalesced.ID)
+
+	job = suite.waitForRefresh(job.ID)
+	suite.Equal(sync.RefreshDone, job.Status)
+	suite.Equal(5, job.NewEntries)
+	suite.Zero(job.Failed)
+	suite.NotNil(job.FinishedAt)
+
+	entries, err := suite.db.EntriesFromFeed(feed.APIID, true, models.Any, &suite.user)
+	suite.Require().Nil(err)
+	suite.Len(entries, 5)
+
+	code, _ = suite.refresh("/v1/feeds/bogus/refresh")
+	suite.Equal(404, code)
+}
+
+func (suite *ServerTestSuite) TestRefreshCategory() {
+	ctg := models.Category{
+		Name: "News",
+	}
+
+	err := suite.db.NewCategory(&ctg, &suite.user)
+	suite.Require().Nil(err)
+
+	feed := models.Feed{
+		Subscription: suite.ts.URL,
+		Category:     ctg,
+	}
+
+	err = suite.db.NewFeed(&feed, &suite.user)
+	suite.Require().Nil(err)
+
+	code, job := suite.refresh("/v1/categories/" + ctg.APIID + "/refresh")
+	suite.Require().Equal(202, code)
+	suite.Equal(1, job.Feeds)
+
+	job = suite.waitForRefresh(job.ID)
+	suite.Equal(5, job.NewEntries)
+
+	code, _ = suite.refresh("/v1/categories/bogus/refresh")
+	suite.Equal(404, code)
+}
+
+func (suite *ServerTestSuite) TestRefreshAllIsRateLimited() {
+	for i := 0; i < config.DefaultSyncConfig.MaxRefreshes; i++ {
+		code, job := suite.refresh("/v1/refresh")
+		suite.Require().Equal(202, code)
+		suite.Equal(sync.RefreshDone, suite.waitForRefresh(job.ID).Status)
+	}
+
+	code, _ := suite.refresh("/v1/refresh")
+	suite.Equal(429, code)
+}
+
+func (suite *ServerTestSuite) TestGetMissingRefresh() {
+	req, err := http.NewRequest("GET", "http://localhost:9876/v1/refresh/bogus", nil)
+	suite.Require().Nil(err)
+
+	req.Header.Set("Authorization", "Bearer "+suite.token)
+
+	resp, err := http.DefaultClient.Do(req)
+	suite.Require().Nil(err)
+	defer resp.Body.Close()
+
+	suite.Equal(404, resp.StatusCode)
+}
+
+func (suite *ServerTestSuite) TestEditFeedRetention() {
+	feed := models.Feed{
+		Subscription: suite.ts.URL,
+	}
+
+	err := suite.db.NewFeed(&feed, &suite.user)
+	suite.Require().Nil(err)
+
+	payload := []byte(`{"keep_read_days": 30, "max_entries": 100}`)
+	req, err := http.NewRequest("PUT", "http://localhost:9876/v1/feeds/"+feed.APIID+"/retention", bytes.NewBuffer(payload))
+	suite.Require().Nil(err)
+
+	req.Header.Set("Authorization", "Bearer "+suite.token)
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	suite.Require().Nil(err)
+	defer resp.Body.Close()
+
+	suite.Equal(204, resp.StatusCode)
+
+	editedFeed, err := suite.db.Feed(feed.APIID, &suite.user)
+	suite.Require().Nil(err)
+	suite.Equal(30, editedFeed.KeepReadDays)
+	suite.Equal(100, editedFeed.MaxEntries)
+}
+
+func (suite *ServerTestSuite) TestEditCategoryRetentionWithBadPolicy() {
+	ctg := models.Category{Name: "News"}
+	err := suite.db.NewCategory(&ctg, &suite.user)
+	suite.Require().Nil(err)
+
+	payload := []byte(`{"keep_read_days": -5}`)
+	req, err := http.NewRequest("PUT", "http://localhost:9876/v1/categories/"+ctg.APIID+"/retention", bytes.NewBuffer(payload))
+	suite.Require().Nil(err)
+
+	req.Header.Set("Authorization", "Bearer "+suite.token)
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	suite.Require().Nil(err)
+	defer resp.Body.Close()
+
+	suite.Equal(400, resp.StatusCode)
+}
+
 func (suite *ServerTestSuite) TestEditFeed() {
 	feed := models.Feed{Subscription: suite.ts.URL}
 	err := suite.db.NewFeed(&feed, &suite.user)
@@ -286,7 +682,7 @@ func (suite *ServerTestSuite) TestGetEntriesFromFeed() {
 	suite.Require().NotZero(feed.ID)
 	suite.Require().NotEmpty(feed.APIID)
 
-	err = suite.server.sync.SyncFeed(&feed, &suite.user)
+	err = suite.server.sync.SyncFeed(context.Background(), &feed, &suite.user)
 	suite.Require().Nil(err)
 
 	req, err := http.NewRequest("GET", "http://localhost:9876/v1/feeds/"+feed.APIID+"/entries", nil)
@@ -321,7 +717,7 @@ func (suite *ServerTestSuite) TestMarkFeed() {
 	suite.Require().NotZero(feed.ID)
 	suite.Require().NotEmpty(feed.APIID)
 
-	err = suite.server.sync.SyncFeed(&feed, &suite.user)
+	err = suite.server.sync.SyncFeed(context.Background(), &feed, &suite.user)
 	suite.Require().Nil(err)
 
 	entries, err := suite.db.EntriesFromFeed(feed.APIID, true, models.Unread, &suite.user)
@@ -343,7 +739,12 @@ func (suite *ServerTestSuite) TestMarkFeed() {
 	suite.Require().Nil(err)
 	defer resp.Body.Close()
 
-	suite.Equal(204, resp.StatusCode)
+	suite.Equal(200, resp.StatusCode)
+
+	marked := Marked{}
+	err = json.NewDecoder(resp.Body).Decode(&marked)
+	suite.Require().Nil(err)
+	suite.Equal(int64(5), marked.Marked)
 
 	entries, err = suite.db.EntriesFromFeed(feed.APIID, true, models.Unread, &suite.user)
 	suite.Require().Nil(err)
@@ -354,21 +755,105 @@ func (suite *ServerTestSuite) TestMarkFeed() {
 	suite.Require().Len(entries, 5)
 }
 
-func (suite *ServerTestSuite) TestNewCategory() {
-	payload := []byte(`{"name": "News"}`)
-	req, err := http.NewRequest("POST", "http://localhost:9876/v1/categories", bytes.NewBuffer(payload))
-	suite.Require().Nil(err)
-	req.Header.Set("Authorization", "Bearer "+suite.token)
+func (suite *ServerTestSuite) TestMarkWithinBounds() {
+	feed := models.Feed{
+		Subscription: suite.ts.URL,
+	}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	err := suite.db.NewFeed(&feed, &suite.user)
 	suite.Require().Nil(err)
-	defer resp.Body.Close()
 
-	suite.Equal(201, resp.StatusCode)
+	err = suite.server.sync.SyncFeed(context.Background(), &feed, &suite.user)
+	suite.Require().Nil(err)
 
-	respCtg := new(models.Category)
-	err = json.NewDecoder(resp.Body).Decode(respCtg)
+	entries, err := suite.db.EntriesFromFeed(feed.APIID, false, models.Unread, &suite.user)
+	suite.Require().Nil(err)
+	suite.Require().Len(entries, 5)
+
+	client := &http.Client{}
+	mark := func(method, path string, body []byte) (int, Marked) {
+		req, err := http.NewRequest(method, "http://localhost:9876/v1"+path, bytes.NewBuffer(body))
+		suite.Require().Nil(err)
+		req.Header.Set("Authorization", "Bearer "+suite.token)
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+
+		resp, err := client.Do(req)
+		suite.Require().Nil(err)
+		defer resp.Body.Close()
+
+		marked := Marked{}
+		if resp.StatusCode == 200 {
+			err = json.NewDecoder(resp.Body).Decode(&marked)
+			suite.Require().Nil(err)
+		}
+
+		return resp.StatusCode, marked
+	}
+
+	// Entries added after the client last listed them are left alone
+	before := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	status, marked := mark("PUT", "/entries/mark?as=read&before="+before, nil)
+	suite.Equal(200, status)
+	suite.Zero(marked.Marked)
+
+	status, marked = mark("PUT", "/feeds/"+feed.APIID+"/mark?as=read&max_id="+entries[1].APIID, nil)
+	suite.Equal(200, status)
+	suite.Equal(int64(2), marked.Marked)
+
+	read, err := suite.db.EntriesFromFeed(feed.APIID, false, models.Read, &suite.user)
+	suite.Require().Nil(err)
+	suite.Require().Len(read, 2)
+
+	unread, err := suite.db.EntriesFromFeed(feed.APIID, false, models.Unread, &suite.user)
+	suite.Require().Nil(err)
+	suite.Require().Len(unread, 3)
+
+	status, marked = mark("PUT", "/entries?as=unread", []byte(`{"entries": ["`+read[0].APIID+`", "`+unread[0].APIID+`"]}`))
+	suite.Equal(200, status)
+	suite.Equal(int64(1), marked.Marked)
+
+	status, marked = mark("PUT", "/entries/mark?as=read&before="+url.QueryEscape(time.Now().Add(time.Hour).Format(time.RFC3339)), nil)
+	suite.Equal(200, status)
+	suite.Equal(int64(4), marked.Marked)
+
+	tag := models.Tag{Name: "Later"}
+	err = suite.db.NewTag(&tag, &suite.user)
+	suite.Require().Nil(err)
+
+	err = suite.db.TagEntries(tag.APIID, []string{entries[2].APIID}, &suite.user)
+	suite.Require().Nil(err)
+
+	status, marked = mark("PUT", "/tags/"+tag.APIID+"/mark?as=unread", nil)
+	suite.Equal(200, status)
+	suite.Equal(int64(1), marked.Marked)
+
+	status, _ = mark("PUT", "/entries/mark?as=read&before=yesterday", nil)
+	suite.Equal(400, status)
+
+	status, _ = mark("PUT", "/entries/mark?as=read&max_id=bogus", nil)
+	suite.Equal(404, status)
+
+	status, _ = mark("PUT", "/entries?as=read", []byte(`{"entries": []}`))
+	suite.Equal(400, status)
+}
+
+func (suite *ServerTestSuite) TestNewCategory() {
+	payload := []byte(`{"name": "News"}`)
+	req, err := http.NewRequest("POST", "http://localhost:9876/v1/categories", bytes.NewBuffer(payload))
+	suite.Require().Nil(err)
+	req.Header.Set("Authorization", "Bearer "+suite.token)
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	suite.Require().Nil(err)
+	defer resp.Body.Close()
+
+	suite.Equal(201, resp.StatusCode)
+
+	respCtg := new(models.Category)
+	err = json.NewDecoder(resp.Body).Decode(respCtg)
 	suite.Require().Nil(err)
 
 	suite.Require().NotEmpty(respCtg.APIID)
@@ -687,7 +1172,7 @@ func (suite *ServerTestSuite) TestGetEntriesFromCategory() {
 	suite.Require().NotZero(feed.ID)
 	suite.Require().NotEmpty(feed.APIID)
 
-	err = suite.server.sync.SyncFeed(&feed, &suite.user)
+	err = suite.server.sync.SyncFeed(context.Background(), &feed, &suite.user)
 	suite.Require().Nil(err)
 
 	req, err := http.NewRequest("GET", "http://localhost:9876/v1/categories/"+category.APIID+"/entries", nil)
@@ -733,7 +1218,7 @@ func (suite *ServerTestSuite) TestGetEntriesFromTag() {
 	suite.Require().NotZero(feed.ID)
 	suite.Require().NotEmpty(feed.APIID)
 
-	err = suite.server.sync.SyncFeed(&feed, &suite.user)
+	err = suite.server.sync.SyncFeed(context.Background(), &feed, &suite.user)
 	suite.Require().Nil(err)
 
 	entries, err := suite.db.EntriesFromFeed(feed.APIID, true, models.Any, &suite.user)
@@ -859,7 +1344,7 @@ func (suite *ServerTestSuite) TestMarkCategory() {
 	suite.Require().NotZero(feed.ID)
 	suite.Require().NotEmpty(feed.APIID)
 
-	err = suite.server.sync.SyncFeed(&feed, &suite.user)
+	err = suite.server.sync.SyncFeed(context.Background(), &feed, &suite.user)
 	suite.Require().Nil(err)
 
 	entries, err := suite.db.EntriesFromCategory(category.APIID, true, models.Unread, &suite.user)
@@ -881,7 +1366,12 @@ func (suite *ServerTestSuite) TestMarkCategory() {
 	suite.Require().Nil(err)
 	defer resp.Body.Close()
 
-	suite.Equal(204, resp.StatusCode)
+	suite.Equal(200, resp.StatusCode)
+
+	marked := Marked{}
+	err = json.NewDecoder(resp.Body).Decode(&marked)
+	suite.Require().Nil(err)
+	suite.Equal(int64(5), marked.Marked)
 
 	entries, err = suite.db.EntriesFromCategory(category.APIID, true, models.Unread, &suite.user)
 	suite.Require().Nil(err)
@@ -902,7 +1392,7 @@ func (suite *ServerTestSuite) TestGetEntries() {
 	suite.Require().NotZero(feed.ID)
 	suite.Require().NotEmpty(feed.APIID)
 
-	err = suite.server.sync.SyncFeed(&feed, &suite.user)
+	err = suite.server.sync.SyncFeed(context.Background(), &feed, &suite.user)
 	suite.Require().Nil(err)
 
 	req, err := http.NewRequest("GET", "http://localhost:9876/v1/entries", nil)
@@ -927,30 +1417,70 @@ func (suite *ServerTestSuite) TestGetEntries() {
 	suite.Len(respEntries.Entries, 5)
 }
 
-func (suite *ServerTestSuite) TestGetEntry() {
+func (suite *ServerTestSuite) TestGetEntriesWithCursor() {
 	feed := models.Feed{
 		Subscription: suite.ts.URL,
 	}
 
 	err := suite.db.NewFeed(&feed, &suite.user)
 	suite.Require().Nil(err)
-	suite.Require().NotZero(feed.ID)
 	suite.Require().NotEmpty(feed.APIID)
 
-	entry := models.Entry{
-		Title:  "Item 1",
-		Link:   "http://localhost:9876/item_1",
-		Feed:   feed,
-		FeedID: feed.ID,
+	err = suite.server.sync.SyncFeed(context.Background(), &feed, &suite.user)
+	suite.Require().Nil(err)
+
+	type Entries struct {
+		Entries    []models.Entry `json:"entries"`
+		NextCursor string         `json:"nextCursor"`
 	}
 
-	err = suite.db.NewEntry(&entry, &suite.user)
+	client := &http.Client{}
+	cursor := ""
+	seen := 0
+	for i := 0; i < 3; i++ {
+		req, err := http.NewRequest("GET", "http://localhost:9876/v1/feeds/"+feed.APIID+"/entries?limit=2&cursor="+cursor, nil)
+		suite.Require().Nil(err)
+
+		req.Header.Set("Authorization", "Bearer "+suite.token)
+
+		resp, err := client.Do(req)
+		suite.Require().Nil(err)
+		suite.Equal(200, resp.StatusCode)
+
+		respEntries := new(Entries)
+		err = json.NewDecoder(resp.Body).Decode(respEntries)
+		resp.Body.Close()
+		suite.Require().Nil(err)
+
+		seen += len(respEntries.Entries)
+		cursor = respEntries.NextCursor
+	}
+
+	suite.Equal(5, seen)
+	suite.Empty(cursor)
+}
+
+func (suite *ServerTestSuite) TestSearchEntries() {
+	feed := models.Feed{
+		Subscription: suite.ts.URL,
+	}
+
+	err := suite.db.NewFeed(&feed, &suite.user)
 	suite.Require().Nil(err)
-	suite.Require().NotZero(entry.ID)
-	suite.Require().NotEmpty(entry.APIID)
 
-	req, err := http.NewRequest("GET", "http://localhost:9876/v1/entries/"+entry.APIID, nil)
-	suite.Nil(err)
+	for _, title := range []string{"Searchable entry", "Another entry"} {
+		entry := models.Entry{
+			Title: title,
+			Mark:  models.Unread,
+			Feed:  feed,
+		}
+
+		err = suite.db.NewEntry(&entry, &suite.user)
+		suite.Require().Nil(err)
+	}
+
+	req, err := http.NewRequest("GET", "http://localhost:9876/v1/search?q=searchable&feedID="+feed.APIID, nil)
+	suite.Require().Nil(err)
 
 	req.Header.Set("Authorization", "Bearer "+suite.token)
 
@@ -961,156 +1491,167 @@ func (suite *ServerTestSuite) TestGetEntry() {
 
 	suite.Equal(200, resp.StatusCode)
 
-	respEntry := new(models.Entry)
-	err = json.NewDecoder(resp.Body).Decode(respEntry)
+	type Entries struct {
+		Entries []models.Entry `json:"entries"`
+	}
+
+	respEntries := new(Entries)
+	err = json.NewDecoder(resp.Body).Decode(respEntries)
 	suite.Require().Nil(err)
+	suite.Require().Len(respEntries.Entries, 1)
+	suite.Equal("Searchable entry", respEntries.Entries[0].Title)
+}
 
-	suite.Equal(entry.Title, respEntry.Title)
-	suite.Equal(entry.APIID, respEntry.APIID)
+func (suite *ServerTestSuite) TestSearchEntriesWithoutQuery() {
+	req, err := http.NewRequest("GET", "http://localhost:9876/v1/search", nil)
+	suite.Require().Nil(err)
+
+	req.Header.Set("Authorization", "Bearer "+suite.token)
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	suite.Require().Nil(err)
+	defer resp.Body.Close()
+
+	suite.Equal(400, resp.StatusCode)
 }
 
-func (suite *ServerTestSuite) TestMarkEntry() {
+func (suite *ServerTestSuite) TestEnclosureProgress() {
 	feed := models.Feed{
 		Subscription: suite.ts.URL,
 	}
 
 	err := suite.db.NewFeed(&feed, &suite.user)
 	suite.Require().Nil(err)
-	suite.Require().NotZero(feed.ID)
-	suite.Require().NotEmpty(feed.APIID)
-
-	err = suite.server.sync.SyncFeed(&feed, &suite.user)
-	suite.Require().Nil(err)
 
-	entries, err := suite.db.EntriesFromFeed(feed.APIID, true, models.Read, &suite.user)
-	suite.Require().Nil(err)
-	suite.Require().Len(entries, 0)
+	entry := models.Entry{
+		Title: "Episode",
+		Feed:  feed,
+		Enclosures: []models.Enclosure{
+			{URL: "http://localhost:9876/episode.mp3", Type: "audio/mpeg", Duration: 600},
+		},
+	}
 
-	entries, err = suite.db.EntriesFromFeed(feed.APIID, true, models.Unread, &suite.user)
+	err = suite.db.NewEntry(&entry, &suite.user)
 	suite.Require().Nil(err)
-	suite.Require().Len(entries, 5)
+	enclosureID := entry.Enclosures[0].APIID
+	suite.Require().NotEmpty(enclosureID)
 
-	req, err := http.NewRequest("PUT", "http://localhost:9876/v1/entries/"+entries[0].APIID+"/mark?as=read", nil)
-
-	suite.Nil(err)
+	client := &http.Client{}
 
+	req, err := http.NewRequest("GET", "http://localhost:9876/v1/entries/"+entry.APIID, nil)
+	suite.Require().Nil(err)
 	req.Header.Set("Authorization", "Bearer "+suite.token)
 
-	client := &http.Client{}
 	resp, err := client.Do(req)
 	suite.Require().Nil(err)
 	defer resp.Body.Close()
 
-	suite.Equal(204, resp.StatusCode)
+	suite.Equal(200, resp.StatusCode)
 
-	entries, err = suite.db.EntriesFromFeed(feed.APIID, true, models.Unread, &suite.user)
+	respEntry := new(models.Entry)
+	err = json.NewDecoder(resp.Body).Decode(respEntry)
 	suite.Require().Nil(err)
-	suite.Require().Len(entries, 4)
+	suite.Require().Len(respEntry.Enclosures, 1)
+	suite.Equal(enclosureID, respEntry.Enclosures[0].APIID)
+	suite.Equal(int64(600), respEntry.Enclosures[0].Duration)
 
-	entries, err = suite.db.EntriesFromFeed(feed.APIID, true, models.Read, &suite.user)
+	payload := []byte(`{"position": 42}`)
+	req, err = http.NewRequest("PUT", "http://localhost:9876/v1/enclosures/"+enclosureID+"/progress", bytes.NewBuffer(payload))
 	suite.Require().Nil(err)
-	suite.Require().Len(entries, 1)
-}
+	req.Header.Set("Authorization", "Bearer "+suite.token)
+	req.Header.Set("Content-Type", "application/json")
 
-func (suite *ServerTestSuite) TestGetStatsForFeed() {
-	feed := models.Feed{
-		Title:        "News",
-		Subscription: "http://example.com",
-	}
+	resp, err = client.Do(req)
+	suite.Require().Nil(err)
+	defer resp.Body.Close()
 
-	err := suite.db.NewFeed(&feed, &suite.user)
+	suite.Equal(200, resp.StatusCode)
+
+	req, err = http.NewRequest("GET", "http://localhost:9876/v1/enclosures", nil)
 	suite.Require().Nil(err)
-	suite.Require().NotEmpty(feed.APIID)
+	req.Header.Set("Authorization", "Bearer "+suite.token)
 
-	for i := 0; i < 3; i++ {
-		entry := models.Entry{
-			Title:  "Item",
-			Link:   "http://example.com",
-			Feed:   feed,
-			FeedID: feed.ID,
-			Mark:   models.Read,
-			Saved:  true,
-		}
+	resp, err = client.Do(req)
+	suite.Require().Nil(err)
+	defer resp.Body.Close()
 
-		err = suite.db.NewEntry(&entry, &suite.user)
-		suite.Require().Nil(err)
+	suite.Equal(200, resp.StatusCode)
+
+	type Enclosures struct {
+		Enclosures []models.Enclosure `json:"enclosures"`
 	}
 
-	for i := 0; i < 7; i++ {
-		entry := models.Entry{
-			Title:  "Item",
-			Link:   "http://example.com",
-			Feed:   feed,
-			FeedID: feed.ID,
-			Mark:   models.Unread,
-		}
+	var inProgress Enclosures
+	err = json.NewDecoder(resp.Body).Decode(&inProgress)
+	suite.Require().Nil(err)
+	suite.Require().Len(inProgress.Enclosures, 1)
+	suite.Equal(int64(42), inProgress.Enclosures[0].Position)
 
-		err = suite.db.NewEntry(&entry, &suite.user)
-		suite.Require().Nil(err)
-	}
+	payload = []byte(`{"position": 600, "completed": true}`)
+	req, err = http.NewRequest("PUT", "http://localhost:9876/v1/enclosures/"+enclosureID+"/progress", bytes.NewBuffer(payload))
+	suite.Require().Nil(err)
+	req.Header.Set("Authorization", "Bearer "+suite.token)
+	req.Header.Set("Content-Type", "application/json")
 
-	req, err := http.NewRequest("GET", "http://localhost:9876/v1/feeds/"+feed.APIID+"/stats", nil)
+	resp, err = client.Do(req)
+	suite.Require().Nil(err)
+	defer resp.Body.Close()
 
-	suite.Nil(err)
+	suite.Equal(200, resp.StatusCode)
 
+	req, err = http.NewRequest("GET", "http://localhost:9876/v1/enclosures/"+enclosureID, nil)
+	suite.Require().Nil(err)
 	req.Header.Set("Authorization", "Bearer "+suite.token)
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err = client.Do(req)
 	suite.Require().Nil(err)
 	defer resp.Body.Close()
 
 	suite.Equal(200, resp.StatusCode)
 
-	respStats := new(models.Stats)
-	err = json.NewDecoder(resp.Body).Decode(respStats)
+	enclosure := models.Enclosure{}
+	err = json.NewDecoder(resp.Body).Decode(&enclosure)
 	suite.Require().Nil(err)
+	suite.True(enclosure.Completed)
+	suite.Equal(int64(600), enclosure.Position)
 
-	suite.Equal(7, respStats.Unread)
-	suite.Equal(3, respStats.Read)
-	suite.Equal(3, respStats.Saved)
-	suite.Equal(10, respStats.Total)
+	req, err = http.NewRequest("GET", "http://localhost:9876/v1/enclosures/bogus", nil)
+	suite.Require().Nil(err)
+	req.Header.Set("Authorization", "Bearer "+suite.token)
+
+	resp, err = client.Do(req)
+	suite.Require().Nil(err)
+	defer resp.Body.Close()
+
+	suite.Equal(404, resp.StatusCode)
 }
 
-func (suite *ServerTestSuite) TestGetStats() {
+func (suite *ServerTestSuite) TestGetEntry() {
 	feed := models.Feed{
-		Title:        "News",
-		Subscription: "http://example.com",
+		Subscription: suite.ts.URL,
 	}
 
 	err := suite.db.NewFeed(&feed, &suite.user)
 	suite.Require().Nil(err)
+	suite.Require().NotZero(feed.ID)
 	suite.Require().NotEmpty(feed.APIID)
 
-	for i := 0; i < 3; i++ {
-		entry := models.Entry{
-			Title:  "Item",
-			Link:   "http://example.com",
-			Feed:   feed,
-			FeedID: feed.ID,
-			Mark:   models.Read,
-			Saved:  true,
-		}
-
-		err = suite.db.NewEntry(&entry, &suite.user)
-		suite.Require().Nil(err)
-	}
-
-	for i := 0; i < 7; i++ {
-		entry := models.Entry{
-			Title:  "Item",
-			Link:   "http://example.com",
-			Feed:   feed,
-			FeedID: feed.ID,
-			Mark:   models.Unread,
-		}
-
-		err = suite.db.NewEntry(&entry, &suite.user)
-		suite.Require().Nil(err)
+	entry := models.Entry{
+		Title:   "Item 1",
+		Link:    "http://localhost:9876/item_1",
+		Summary: "Single test item",
+		Content: "<p>Single test item</p>",
+		Feed:    feed,
+		FeedID:  feed.ID,
 	}
 
-	req, err := http.NewRequest("GET", "http://localhost:9876/v1/entries/stats", nil)
+	err = suite.db.NewEntry(&entry, &suite.user)
+	suite.Require().Nil(err)
+	suite.Require().NotZero(entry.ID)
+	suite.Require().NotEmpty(entry.APIID)
 
+	req, err := http.NewRequest("GET", "http://localhost:9876/v1/entries/"+entry.APIID, nil)
 	suite.Nil(err)
 
 	req.Header.Set("Authorization", "Bearer "+suite.token)
@@ -1122,26 +1663,307 @@ func (suite *ServerTestSuite) TestGetStats() {
 
 	suite.Equal(200, resp.StatusCode)
 
-	respStats := new(models.Stats)
-	err = json.NewDecoder(resp.Body).Decode(respStats)
+	respEntry := new(models.Entry)
+	err = json.NewDecoder(resp.Body).Decode(respEntry)
 	suite.Require().Nil(err)
 
-	suite.Equal(7, respStats.Unread)
-	suite.Equal(3, respStats.Read)
-	suite.Equal(3, respStats.Saved)
-	suite.Equal(10, respStats.Total)
+	suite.Equal(entry.Title, respEntry.Title)
+	suite.Equal(entry.APIID, respEntry.APIID)
+	suite.Equal(entry.Summary, respEntry.Summary)
+	suite.Equal(entry.Content, respEntry.Content)
 }
 
-func (suite *ServerTestSuite) TestGetStatsForCategory() {
-	category := models.Category{
-		Name: "World",
+func (suite *ServerTestSuite) TestGetEntriesWithoutContent() {
+	feed := models.Feed{
+		Subscription: suite.ts.URL,
 	}
 
-	err := suite.db.NewCategory(&category, &suite.user)
+	err := suite.db.NewFeed(&feed, &suite.user)
 	suite.Require().Nil(err)
-	suite.Require().NotEmpty(category.APIID)
+	suite.Require().NotEmpty(feed.APIID)
 
-	feed := models.Feed{
+	err = suite.server.sync.SyncFeed(context.Background(), &feed, &suite.user)
+	suite.Require().Nil(err)
+
+	req, err := http.NewRequest("GET", "http://localhost:9876/v1/entries?excludeContent=true", nil)
+	suite.Nil(err)
+
+	req.Header.Set("Authorization", "Bearer "+suite.token)
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	suite.Require().Nil(err)
+	defer resp.Body.Close()
+
+	suite.Equal(200, resp.StatusCode)
+
+	type Entries struct {
+		Entries []models.Entry `json:"entries"`
+	}
+
+	respEntries := new(Entries)
+	err = json.NewDecoder(resp.Body).Decode(respEntries)
+	suite.Require().Nil(err)
+	suite.Require().Len(respEntries.Entries, 5)
+
+	for _, entry := range respEntries.Entries {
+		suite.Empty(entry.Summary)
+		suite.Empty(entry.Content)
+	}
+}
+
+func (suite *ServerTestSuite) TestMarkEntry() {
+	feed := models.Feed{
+		Subscription: suite.ts.URL,
+	}
+
+	err := suite.db.NewFeed(&feed, &suite.user)
+	suite.Require().Nil(err)
+	suite.Require().NotZero(feed.ID)
+	suite.Require().NotEmpty(feed.APIID)
+
+	err = suite.server.sync.SyncFeed(context.Background(), &feed, &suite.user)
+	suite.Require().Nil(err)
+
+	entries, err := suite.db.EntriesFromFeed(feed.APIID, true, models.Read, &suite.user)
+	suite.Require().Nil(err)
+	suite.Require().Len(entries, 0)
+
+	entries, err = suite.db.EntriesFromFeed(feed.APIID, true, models.Unread, &suite.user)
+	suite.Require().Nil(err)
+	suite.Require().Len(entries, 5)
+
+	req, err := http.NewRequest("PUT", "http://localhost:9876/v1/entries/"+entries[0].APIID+"/mark?as=read", nil)
+
+	suite.Nil(err)
+
+	req.Header.Set("Authorization", "Bearer "+suite.token)
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	suite.Require().Nil(err)
+	defer resp.Body.Close()
+
+	suite.Equal(204, resp.StatusCode)
+
+	entries, err = suite.db.EntriesFromFeed(feed.APIID, true, models.Unread, &suite.user)
+	suite.Require().Nil(err)
+	suite.Require().Len(entries, 4)
+
+	entries, err = suite.db.EntriesFromFeed(feed.APIID, true, models.Read, &suite.user)
+	suite.Require().Nil(err)
+	suite.Require().Len(entries, 1)
+}
+
+func (suite *ServerTestSuite) TestSaveEntry() {
+	feed := models.Feed{
+		Subscription: suite.ts.URL,
+	}
+
+	err := suite.db.NewFeed(&feed, &suite.user)
+	suite.Require().Nil(err)
+
+	err = suite.server.sync.SyncFeed(context.Background(), &feed, &suite.user)
+	suite.Require().Nil(err)
+
+	entries, err := suite.db.EntriesFromFeed(feed.APIID, true, models.Any, &suite.user)
+	suite.Require().Nil(err)
+	suite.Require().Len(entries, 5)
+
+	client := &http.Client{}
+
+	req, err := http.NewRequest("PUT", "http://localhost:9876/v1/entries/"+entries[0].APIID+"/save", nil)
+	suite.Require().Nil(err)
+	req.Header.Set("Authorization", "Bearer "+suite.token)
+
+	resp, err := client.Do(req)
+	suite.Require().Nil(err)
+	resp.Body.Close()
+	suite.Equal(204, resp.StatusCode)
+
+	type Entries struct {
+		Entries []models.Entry `json:"entries"`
+	}
+
+	for _, url := range []string{
+		"http://localhost:9876/v1/entries?saved=true",
+		"http://localhost:9876/v1/feeds/" + feed.APIID + "/entries?saved=true",
+		"http://localhost:9876/v1/categories/" + feed.Category.APIID + "/entries?saved=true",
+	} {
+		req, err = http.NewRequest("GET", url, nil)
+		suite.Require().Nil(err)
+		req.Header.Set("Authorization", "Bearer "+suite.token)
+
+		resp, err = client.Do(req)
+		suite.Require().Nil(err)
+		suite.Equal(200, resp.StatusCode)
+
+		respEntries := new(Entries)
+		err = json.NewDecoder(resp.Body).Decode(respEntries)
+		resp.Body.Close()
+		suite.Require().Nil(err)
+		suite.Require().Len(respEntries.Entries, 1, url)
+		suite.Equal(entries[0].APIID, respEntries.Entries[0].APIID)
+		suite.True(respEntries.Entries[0].Saved)
+	}
+
+	suite.Equal(1, suite.db.Stats(&suite.user).Saved)
+
+	req, err = http.NewRequest("DELETE", "http://localhost:9876/v1/entries/"+entries[0].APIID+"/save", nil)
+	suite.Require().Nil(err)
+	req.Header.Set("Authorization", "Bearer "+suite.token)
+
+	resp, err = client.Do(req)
+	suite.Require().Nil(err)
+	resp.Body.Close()
+	suite.Equal(204, resp.StatusCode)
+
+	suite.Equal(0, suite.db.Stats(&suite.user).Saved)
+}
+
+func (suite *ServerTestSuite) TestSaveUnknownEntry() {
+	req, err := http.NewRequest("PUT", "http://localhost:9876/v1/entries/bogus/save", nil)
+	suite.Require().Nil(err)
+	req.Header.Set("Authorization", "Bearer "+suite.token)
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	suite.Require().Nil(err)
+	defer resp.Body.Close()
+
+	suite.Equal(404, resp.StatusCode)
+}
+
+func (suite *ServerTestSuite) TestGetStatsForFeed() {
+	feed := models.Feed{
+		Title:        "News",
+		Subscription: "http://example.com",
+	}
+
+	err := suite.db.NewFeed(&feed, &suite.user)
+	suite.Require().Nil(err)
+	suite.Require().NotEmpty(feed.APIID)
+
+	for i := 0; i < 3; i++ {
+		entry := models.Entry{
+			Title:  "Item",
+			Link:   "http://example.com",
+			Feed:   feed,
+			FeedID: feed.ID,
+			Mark:   models.Read,
+			Saved:  true,
+		}
+
+		err = suite.db.NewEntry(&entry, &suite.user)
+		suite.Require().Nil(err)
+	}
+
+	for i := 0; i < 7; i++ {
+		entry := models.Entry{
+			Title:  "Item",
+			Link:   "http://example.com",
+			Feed:   feed,
+			FeedID: feed.ID,
+			Mark:   models.Unread,
+		}
+
+		err = suite.db.NewEntry(&entry, &suite.user)
+		suite.Require().Nil(err)
+	}
+
+	req, err := http.NewRequest("GET", "http://localhost:9876/v1/feeds/"+feed.APIID+"/stats", nil)
+
+	suite.Nil(err)
+
+	req.Header.Set("Authorization", "Bearer "+suite.token)
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	suite.Require().Nil(err)
+	defer resp.Body.Close()
+
+	suite.Equal(200, resp.StatusCode)
+
+	respStats := new(models.Stats)
+	err = json.NewDecoder(resp.Body).Decode(respStats)
+	suite.Require().Nil(err)
+
+	suite.Equal(7, respStats.Unread)
+	suite.Equal(3, respStats.Read)
+	suite.Equal(3, respStats.Saved)
+	suite.Equal(10, respStats.Total)
+}
+
+func (suite *ServerTestSuite) TestGetStats() {
+	feed := models.Feed{
+		Title:        "News",
+		Subscription: "http://example.com",
+	}
+
+	err := suite.db.NewFeed(&feed, &suite.user)
+	suite.Require().Nil(err)
+	suite.Require().NotEmpty(feed.APIID)
+
+	for i := 0; i < 3; i++ {
+		entry := models.Entry{
+			Title:  "Item",
+			Link:   "http://example.com",
+			Feed:   feed,
+			FeedID: feed.ID,
+			Mark:   models.Read,
+			Saved:  true,
+		}
+
+		err = suite.db.NewEntry(&entry, &suite.user)
+		suite.Require().Nil(err)
+	}
+
+	for i := 0; i < 7; i++ {
+		entry := models.Entry{
+			Title:  "Item",
+			Link:   "http://example.com",
+			Feed:   feed,
+			FeedID: feed.ID,
+			Mark:   models.Unread,
+		}
+
+		err = suite.db.NewEntry(&entry, &suite.user)
+		suite.Require().Nil(err)
+	}
+
+	req, err := http.NewRequest("GET", "http://localhost:9876/v1/entries/stats", nil)
+
+	suite.Nil(err)
+
+	req.Header.Set("Authorization", "Bearer "+suite.token)
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	suite.Require().Nil(err)
+	defer resp.Body.Close()
+
+	suite.Equal(200, resp.StatusCode)
+
+	respStats := new(models.Stats)
+	err = json.NewDecoder(resp.Body).Decode(respStats)
+	suite.Require().Nil(err)
+
+	suite.Equal(7, respStats.Unread)
+	suite.Equal(3, respStats.Read)
+	suite.Equal(3, respStats.Saved)
+	suite.Equal(10, respStats.Total)
+}
+
+func (suite *ServerTestSuite) TestGetStatsForCategory() {
+	category := models.Category{
+		Name: "World",
+	}
+
+	err := suite.db.NewCategory(&category, &suite.user)
+	suite.Require().Nil(err)
+	suite.Require().NotEmpty(category.APIID)
+
+	feed := models.Feed{
 		Title:        "News",
 		Subscription: "http://example.com",
 		Category:     category,
@@ -1245,16 +2067,69 @@ func (suite *ServerTestSuite) TestAddFeedsToCategory() {
 	suite.Equal(ctg.ID, feed.CategoryID)
 }
 
-func (suite *ServerTestSuite) TestRegister() {
-	suite.db.DeleteUser(suite.user.APIID)
+func (suite *ServerTestSuite) TestImportOPML() {
+	payload := []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<opml version="2.0">
+  <body>
+    <outline text="News">
+      <outline text="RSS Test" type="rss" xmlUrl="` + suite.ts.URL + `"/>
+    </outline>
+  </body>
+</opml>`)
 
-	randUserName := RandStringRunes(8)
-	regResp, err := http.PostForm("http://localhost:9876/v1/register",
-		url.Values{"username": {randUserName}, "password": {"testtesttest"}})
+	req, err := http.NewRequest("POST", "http://localhost:9876/v1/opml", bytes.NewBuffer(payload))
 	suite.Require().Nil(err)
+	req.Header.Set("Authorization", "Bearer "+suite.token)
+	req.Header.Set("Content-Type", "text/xml")
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	suite.Require().Nil(err)
+	defer resp.Body.Close()
+
+	suite.Equal(200, resp.StatusCode)
+
+	feeds := suite.db.Feeds(&suite.user)
+	suite.Require().Len(feeds, 1)
+	suite.Equal(suite.ts.URL, feeds[0].Subscription)
+}
+
+func (suite *ServerTestSuite) TestExportOPML() {
+	feed := models.Feed{
+		Title:        "RSS Test",
+		Subscription: suite.ts.URL,
+	}
+
+	err := suite.db.NewFeed(&feed, &suite.user)
+	suite.Require().Nil(err)
+
+	req, err := http.NewRequest("GET", "http://localhost:9876/v1/opml", nil)
+	suite.Require().Nil(err)
+	req.Header.Set("Authorization", "Bearer "+suite.token)
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	suite.Require().Nil(err)
+	defer resp.Body.Close()
+
+	suite.Equal(200, resp.StatusCode)
+
+	doc, err := opml.Parse(resp.Body)
+	suite.Require().Nil(err)
+	suite.Require().Len(doc.Body.Outlines, 1)
+	suite.Equal(suite.ts.URL, doc.Body.Outlines[0].XMLURL)
+}
+
+func (suite *ServerTestSuite) TestRegister() {
+	suite.db.DeleteUser(suite.user.APIID)
+
+	randUserName := RandStringRunes(8)
+	regResp, err := http.PostForm("http://localhost:9876/v1/register",
+		url.Values{"username": {randUserName}, "password": {"testtesttest"}})
+	suite.Require().Nil(err)
+
+	suite.Equal(204, regResp.StatusCode)
 
-	suite.Equal(204, regResp.StatusCode)
-
 	users := suite.db.Users("username")
 	suite.Len(users, 1)
 
@@ -1339,6 +2214,920 @@ func (suite *ServerTestSuite) TestLoginWithBadPassword() {
 	suite.Nil(err)
 }
 
+func (suite *ServerTestSuite) TestReaderLogin() {
+	resp, err := http.PostForm("http://localhost:9876/accounts/ClientLogin",
+		url.Values{"Email": {suite.user.Username}, "Passwd": {"testtesttest"}})
+	suite.Require().Nil(err)
+	defer resp.Body.Close()
+
+	suite.Equal(200, resp.StatusCode)
+
+	body, err := ioutil.ReadAll(resp.Body)
+	suite.Require().Nil(err)
+
+	var token string
+	for _, line := range strings.Split(string(body), "\n") {
+		if strings.HasPrefix(line, "Auth=") {
+			token = strings.TrimPrefix(line, "Auth=")
+		}
+	}
+	suite.Require().NotEmpty(token)
+
+	req, err := http.NewRequest("GET", "http://localhost:9876/reader/api/0/user-info", nil)
+	suite.Require().Nil(err)
+	req.Header.Set("Authorization", "GoogleLogin auth="+token)
+
+	infoResp, err := http.DefaultClient.Do(req)
+	suite.Require().Nil(err)
+	defer infoResp.Body.Close()
+
+	suite.Equal(200, infoResp.StatusCode)
+
+	info := new(readerUserInfo)
+	err = json.NewDecoder(infoResp.Body).Decode(info)
+	suite.Require().Nil(err)
+	suite.Equal(suite.user.Username, info.UserName)
+	suite.Equal(suite.user.APIID, info.UserID)
+}
+
+func (suite *ServerTestSuite) TestReaderLoginWithBadPassword() {
+	resp, err := http.PostForm("http://localhost:9876/accounts/ClientLogin",
+		url.Values{"Email": {suite.user.Username}, "Passwd": {"bogus"}})
+	suite.Require().Nil(err)
+	defer resp.Body.Close()
+
+	suite.Equal(401, resp.StatusCode)
+}
+
+func (suite *ServerTestSuite) TestReaderRequestWithoutAuth() {
+	resp, err := http.Get("http://localhost:9876/reader/api/0/subscription/list")
+	suite.Require().Nil(err)
+	defer resp.Body.Close()
+
+	suite.Equal(401, resp.StatusCode)
+
+	req, err := http.NewRequest("GET", "http://localhost:9876/reader/api/0/subscription/list", nil)
+	suite.Require().Nil(err)
+	req.Header.Set("Authorization", "GoogleLogin auth=bogus")
+
+	resp, err = http.DefaultClient.Do(req)
+	suite.Require().Nil(err)
+	defer resp.Body.Close()
+
+	suite.Equal(401, resp.StatusCode)
+}
+
+func (suite *ServerTestSuite) TestReaderSubscriptionList() {
+	ctg := models.Category{
+		Name: "News",
+	}
+
+	err := suite.db.NewCategory(&ctg, &suite.user)
+	suite.Require().Nil(err)
+
+	feed := models.Feed{
+		Title:        "Example",
+		Subscription: "http://example.com/feed",
+		Category:     ctg,
+	}
+
+	err = suite.db.NewFeed(&feed, &suite.user)
+	suite.Require().Nil(err)
+
+	resp := suite.readerRequest("GET", "/subscription/list?output=json", nil)
+	defer resp.Body.Close()
+	suite.Equal(200, resp.StatusCode)
+
+	type Subscriptions struct {
+		Subscriptions []readerSubscription `json:"subscriptions"`
+	}
+
+	subscriptions := new(Subscriptions)
+	err = json.NewDecoder(resp.Body).Decode(subscriptions)
+	suite.Require().Nil(err)
+	suite.Require().Len(subscriptions.Subscriptions, 1)
+
+	sub := subscriptions.Subscriptions[0]
+	suite.Equal("feed/"+feed.APIID, sub.ID)
+	suite.Equal(feed.Title, sub.Title)
+	suite.Equal(feed.Subscription, sub.URL)
+	suite.Require().Len(sub.Categories, 1)
+	suite.Equal("user/-/label/News", sub.Categories[0].ID)
+	suite.Equal("News", sub.Categories[0].Label)
+
+	tagResp := suite.readerRequest("GET", "/tag/list?output=json", nil)
+	defer tagResp.Body.Close()
+	suite.Equal(200, tagResp.StatusCode)
+
+	type Tags struct {
+		Tags []readerLabel `json:"tags"`
+	}
+
+	tags := new(Tags)
+	err = json.NewDecoder(tagResp.Body).Decode(tags)
+	suite.Require().Nil(err)
+	suite.Require().Len(tags.Tags, 2)
+	suite.Equal(readerStarred, tags.Tags[0].ID)
+	suite.Equal("user/-/label/News", tags.Tags[1].ID)
+}
+
+func (suite *ServerTestSuite) TestReaderStreamContents() {
+	feed := models.Feed{
+		Subscription: suite.ts.URL,
+	}
+
+	err := suite.db.NewFeed(&feed, &suite.user)
+	suite.Require().Nil(err)
+
+	err = suite.server.sync.SyncFeed(context.Background(), &feed, &suite.user)
+	suite.Require().Nil(err)
+
+	type Stream struct {
+		Items        []readerItem `json:"items"`
+		Continuation string       `json:"continuation"`
+	}
+
+	resp := suite.readerRequest("GET", "/stream/contents/"+url.PathEscape("feed/"+feed.APIID)+"?n=3", nil)
+	defer resp.Body.Close()
+	suite.Equal(200, resp.StatusCode)
+
+	stream := new(Stream)
+	err = json.NewDecoder(resp.Body).Decode(stream)
+	suite.Require().Nil(err)
+	suite.Len(stream.Items, 3)
+	suite.Require().NotEmpty(stream.Continuation)
+
+	for _, item := range stream.Items {
+		suite.True(strings.HasPrefix(item.ID, readerItemPrefix))
+		suite.Equal("feed/"+feed.APIID, item.Origin.StreamID)
+		suite.Contains(item.Categories, readerReadingList)
+		suite.NotContains(item.Categories, readerRead)
+	}
+
+	resp = suite.readerRequest("GET", "/stream/contents/"+url.PathEscape("feed/"+feed.APIID)+"?n=3&c="+stream.Continuation, nil)
+	defer resp.Body.Close()
+	suite.Equal(200, resp.StatusCode)
+
+	stream = new(Stream)
+	err = json.NewDecoder(resp.Body).Decode(stream)
+	suite.Require().Nil(err)
+	suite.Len(stream.Items, 2)
+	suite.Empty(stream.Continuation)
+
+	resp = suite.readerRequest("GET", "/stream/contents/feed%2Fbogus", nil)
+	defer resp.Body.Close()
+	suite.Equal(404, resp.StatusCode)
+}
+
+func (suite *ServerTestSuite) TestReaderEditTag() {
+	feed := models.Feed{
+		Subscription: suite.ts.URL,
+	}
+
+	err := suite.db.NewFeed(&feed, &suite.user)
+	suite.Require().Nil(err)
+
+	err = suite.server.sync.SyncFeed(context.Background(), &feed, &suite.user)
+	suite.Require().Nil(err)
+
+	type ItemRefs struct {
+		ItemRefs []readerItemRef `json:"itemRefs"`
+	}
+
+	resp := suite.readerRequest("GET", "/stream/items/ids?s="+url.QueryEscape(readerReadingList), nil)
+	defer resp.Body.Close()
+	suite.Equal(200, resp.StatusCode)
+
+	refs := new(ItemRefs)
+	err = json.NewDecoder(resp.Body).Decode(refs)
+	suite.Require().Nil(err)
+	suite.Require().Len(refs.ItemRefs, 5)
+
+	itemID := refs.ItemRefs[0].ID
+	entryID, err := entryAPIIDFromReaderItem(itemID)
+	suite.Require().Nil(err)
+
+	resp = suite.readerRequest("POST", "/edit-tag", url.Values{
+		"i": {itemID},
+		"a": {"user/-/state/com.google/read", "user/-/state/com.google/starred", "user/-/label/Later"},
+	})
+	defer resp.Body.Close()
+	suite.Equal(200, resp.StatusCode)
+
+	entry, err := suite.db.Entry(entryID, &suite.user)
+	suite.Require().Nil(err)
+	suite.Equal(models.Marker(models.Read), entry.Mark)
+	suite.True(entry.Saved)
+
+	tags := suite.db.Tags(&suite.user)
+	suite.Require().Len(tags, 1)
+	suite.Equal("Later", tags[0].Name)
+
+	tagged, err := suite.db.EntriesFromTag(tags[0].APIID, models.Any, true, &suite.user)
+	suite.Require().Nil(err)
+	suite.Require().Len(tagged, 1)
+	suite.Equal(entryID, tagged[0].APIID)
+
+	resp = suite.readerRequest("GET", "/stream/items/ids?s="+url.QueryEscape(readerReadingList)+"&xt="+url.QueryEscape(readerRead), nil)
+	defer resp.Body.Close()
+
+	refs = new(ItemRefs)
+	err = json.NewDecoder(resp.Body).Decode(refs)
+	suite.Require().Nil(err)
+	suite.Len(refs.ItemRefs, 4)
+
+	resp = suite.readerRequest("POST", "/edit-tag", url.Values{
+		"i": {itemID},
+		"r": {"user/-/state/com.google/read", "user/-/state/com.google/starred", "user/-/label/Later"},
+	})
+	defer resp.Body.Close()
+	suite.Equal(200, resp.StatusCode)
+
+	entry, err = suite.db.Entry(entryID, &suite.user)
+	suite.Require().Nil(err)
+	suite.Equal(models.Marker(models.Unread), entry.Mark)
+	suite.False(entry.Saved)
+
+	tagged, err = suite.db.EntriesFromTag(tags[0].APIID, models.Any, true, &suite.user)
+	suite.Require().Nil(err)
+	suite.Empty(tagged)
+
+	resp = suite.readerRequest("POST", "/edit-tag", url.Values{
+		"i": {"bogus"},
+		"a": {"user/-/state/com.google/read"},
+	})
+	defer resp.Body.Close()
+	suite.Equal(400, resp.StatusCode)
+}
+
+func (suite *ServerTestSuite) TestReaderMarkAllAsRead() {
+	feed := models.Feed{
+		Subscription: suite.ts.URL,
+	}
+
+	err := suite.db.NewFeed(&feed, &suite.user)
+	suite.Require().Nil(err)
+
+	err = suite.server.sync.SyncFeed(context.Background(), &feed, &suite.user)
+	suite.Require().Nil(err)
+
+	// Entries added after ts are left unread
+	ts := time.Now().Add(-time.Hour).UnixNano() / int64(time.Microsecond)
+	resp := suite.readerRequest("POST", "/mark-all-as-read", url.Values{
+		"s":  {"feed/" + feed.APIID},
+		"ts": {strconv.FormatInt(ts, 10)},
+	})
+	resp.Body.Close()
+	suite.Equal(200, resp.StatusCode)
+
+	stats, err := suite.db.FeedStats(feed.APIID, &suite.user)
+	suite.Require().Nil(err)
+	suite.Equal(5, stats.Unread)
+
+	resp = suite.readerRequest("POST", "/mark-all-as-read", url.Values{
+		"s":  {"feed/" + feed.APIID},
+		"ts": {"bogus"},
+	})
+	resp.Body.Close()
+	suite.Equal(400, resp.StatusCode)
+
+	resp = suite.readerRequest("POST", "/mark-all-as-read", url.Values{
+		"s": {"feed/" + feed.APIID},
+	})
+	defer resp.Body.Close()
+	suite.Equal(200, resp.StatusCode)
+
+	stats, err = suite.db.FeedStats(feed.APIID, &suite.user)
+	suite.Require().Nil(err)
+	suite.Equal(0, stats.Unread)
+	suite.Equal(5, stats.Read)
+}
+
+func (suite *ServerTestSuite) TestReaderItemIDs() {
+	apiID := base64.StdEncoding.EncodeToString([]byte("1504805077"))
+
+	id, err := readerItemID(apiID)
+	suite.Require().Nil(err)
+	suite.Equal(int64(1504805077), id)
+
+	for _, itemID := range []string{
+		"1504805077",
+		"tag:google.com,2005:reader/item/0000000059b180d5",
+	} {
+		entryID, err := entryAPIIDFromReaderItem(itemID)
+		suite.Nil(err)
+		suite.Equal(apiID, entryID)
+	}
+
+	_, err = entryAPIIDFromReaderItem("tag:google.com,2005:reader/item/zz")
+	suite.NotNil(err)
+}
+
+func (suite *ServerTestSuite) TestWebSubCallback() {
+	feed := models.Feed{
+		Subscription: suite.ts.URL,
+	}
+
+	err := suite.db.NewFeed(&feed, &suite.user)
+	suite.Require().Nil(err)
+
+	feed.Hub = "http://hub.example.com"
+	feed.HubTopic = suite.ts.URL
+	feed.HubCallback = RandStringRunes(16)
+	feed.HubSecret = "secret"
+	err = suite.db.EditFeedSyncState(&feed, &suite.user)
+	suite.Require().Nil(err)
+
+	callback := "http://localhost:9876/websub/" + feed.HubCallback
+
+	resp, err := http.Get(callback + "?hub.mode=subscribe&hub.topic=" + url.QueryEscape("http://example.com") + "&hub.challenge=abc")
+	suite.Require().Nil(err)
+	resp.Body.Close()
+	suite.Equal(404, resp.StatusCode)
+
+	resp, err = http.Get("http://localhost:9876/websub/bogus?hub.mode=subscribe&hub.topic=" + url.QueryEscape(suite.ts.URL) + "&hub.challenge=abc")
+	suite.Require().Nil(err)
+	resp.Body.Close()
+	suite.Equal(404, resp.StatusCode)
+
+	resp, err = http.Get(callback + "?hub.mode=subscribe&hub.topic=" + url.QueryEscape(suite.ts.URL) + "&hub.challenge=abc&hub.lease_seconds=3600")
+	suite.Require().Nil(err)
+	suite.Equal(200, resp.StatusCode)
+
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	suite.Require().Nil(err)
+	suite.Equal("abc", string(body))
+
+	dbFeed, _, err := suite.db.FeedWithHubCallback(feed.HubCallback)
+	suite.Require().Nil(err)
+	suite.True(dbFeed.HubLeaseExpires.After(time.Now()))
+
+	resp, err = http.Get(suite.ts.URL)
+	suite.Require().Nil(err)
+	content, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	suite.Require().Nil(err)
+
+	mac := hmac.New(sha1.New, []byte("bogus"))
+	mac.Write(content)
+
+	req, err := http.NewRequest("POST", callback, bytes.NewReader(content))
+	suite.Require().Nil(err)
+	req.Header.Set("Content-Type", "application/rss+xml")
+	req.Header.Set("X-Hub-Signature", "sha1="+hex.EncodeToString(mac.Sum(nil)))
+
+	resp, err = http.DefaultClient.Do(req)
+	suite.Require().Nil(err)
+	resp.Body.Close()
+	suite.Equal(202, resp.StatusCode)
+
+	entries, err := suite.db.EntriesFromFeed(feed.APIID, true, models.Any, &suite.user)
+	suite.Require().Nil(err)
+	suite.Empty(entries)
+
+	mac = hmac.New(sha1.New, []byte("secret"))
+	mac.Write(content)
+
+	req, err = http.NewRequest("POST", callback, bytes.NewReader(content))
+	suite.Require().Nil(err)
+	req.Header.Set("Content-Type", "application/rss+xml")
+	req.Header.Set("X-Hub-Signature", "sha1="+hex.EncodeToString(mac.Sum(nil)))
+
+	resp, err = http.DefaultClient.Do(req)
+	suite.Require().Nil(err)
+	resp.Body.Close()
+	suite.Equal(202, resp.StatusCode)
+
+	entries, err = suite.db.EntriesFromFeed(feed.APIID, true, models.Any, &suite.user)
+	suite.Require().Nil(err)
+	suite.Len(entries, 5)
+}
+
+type feverResponse struct {
+	APIVersion    int               `json:"api_version"`
+	Auth          int               `json:"auth"`
+	Groups        []feverGroup      `json:"groups"`
+	Feeds         []feverFeed       `json:"feeds"`
+	FeedsGroups   []feverFeedsGroup `json:"feeds_groups"`
+	Items         []feverItem       `json:"items"`
+	Favicons      []feverFavicon    `json:"favicons"`
+	TotalItems    int               `json:"total_items"`
+	UnreadItemIDs string            `json:"unread_item_ids"`
+	SavedItemIDs  string            `json:"saved_item_ids"`
+}
+
+func (suite *ServerTestSuite) TestFeverAuth() {
+	resp := suite.feverRequest("", url.Values{"api_key": {"bogus"}})
+	suite.Equal(feverAPIVersion, resp.APIVersion)
+	suite.Equal(0, resp.Auth)
+
+	req, err := http.NewRequest("PUT", "http://localhost:9876/v1/fever", strings.NewReader(`{"password": "fever"}`))
+	suite.Require().Nil(err)
+	req.Header.Set("Authorization", "Bearer "+suite.token)
+
+	httpResp, err := http.DefaultClient.Do(req)
+	suite.Require().Nil(err)
+	httpResp.Body.Close()
+	suite.Equal(204, httpResp.StatusCode)
+
+	sum := md5.Sum([]byte(suite.user.Username + ":fever"))
+	apiKey := hex.EncodeToString(sum[:])
+
+	resp = suite.feverRequest("", url.Values{"api_key": {apiKey}})
+	suite.Equal(1, resp.Auth)
+
+	req, err = http.NewRequest("DELETE", "http://localhost:9876/v1/fever", nil)
+	suite.Require().Nil(err)
+	req.Header.Set("Authorization", "Bearer "+suite.token)
+
+	httpResp, err = http.DefaultClient.Do(req)
+	suite.Require().Nil(err)
+	httpResp.Body.Close()
+	suite.Equal(204, httpResp.StatusCode)
+
+	resp = suite.feverRequest("", url.Values{"api_key": {apiKey}})
+	suite.Equal(0, resp.Auth)
+}
+
+func (suite *ServerTestSuite) TestFeverGroupsAndFeeds() {
+	err := suite.db.EnableFever(suite.user.APIID, "fever")
+	suite.Require().Nil(err)
+
+	ctg := models.Category{
+		Name: "News",
+	}
+
+	err = suite.db.NewCategory(&ctg, &suite.user)
+	suite.Require().Nil(err)
+
+	feed := models.Feed{
+		Title:        "Example",
+		Subscription: "http://example.com/feed",
+		Category:     ctg,
+	}
+
+	err = suite.db.NewFeed(&feed, &suite.user)
+	suite.Require().Nil(err)
+
+	resp := suite.feverRequest("groups&feeds", suite.feverKey())
+	suite.Equal(1, resp.Auth)
+
+	suite.Require().Len(resp.Groups, 1)
+	suite.Equal("News", resp.Groups[0].Title)
+
+	suite.Require().Len(resp.Feeds, 1)
+	suite.Equal(feed.Title, resp.Feeds[0].Title)
+	suite.Equal(feed.Subscription, resp.Feeds[0].URL)
+
+	suite.Require().Len(resp.FeedsGroups, 1)
+	suite.Equal(resp.Groups[0].ID, resp.FeedsGroups[0].GroupID)
+	suite.Equal(strconv.Itoa(int(resp.Feeds[0].ID)), resp.FeedsGroups[0].FeedIDs)
+}
+
+func (suite *ServerTestSuite) TestFeverItems() {
+	err := suite.db.EnableFever(suite.user.APIID, "fever")
+	suite.Require().Nil(err)
+
+	feed := models.Feed{
+		Subscription: suite.ts.URL,
+	}
+
+	err = suite.db.NewFeed(&feed, &suite.user)
+	suite.Require().Nil(err)
+
+	err = suite.server.sync.SyncFeed(context.Background(), &feed, &suite.user)
+	suite.Require().Nil(err)
+
+	resp := suite.feverRequest("items", suite.feverKey())
+	suite.Require().Len(resp.Items, 5)
+	suite.Equal(5, resp.TotalItems)
+
+	since := strconv.Itoa(int(resp.Items[2].ID))
+	resp = suite.feverRequest("items&since_id="+since, suite.feverKey())
+	suite.Require().Len(resp.Items, 2)
+
+	resp = suite.feverRequest("items&max_id="+since, suite.feverKey())
+	suite.Require().Len(resp.Items, 2)
+
+	resp = suite.feverRequest("items&with_ids="+since, suite.feverKey())
+	suite.Require().Len(resp.Items, 1)
+	suite.Equal(since, strconv.Itoa(int(resp.Items[0].ID)))
+	suite.Equal(0, resp.Items[0].IsRead)
+
+	resp = suite.feverRequest("unread_item_ids&saved_item_ids", suite.feverKey())
+	suite.Len(strings.Split(resp.UnreadItemIDs, ","), 5)
+	suite.Empty(resp.SavedItemIDs)
+
+	form := suite.feverKey()
+	form.Set("mark", "item")
+	form.Set("as", "read")
+	form.Set("id", since)
+	resp = suite.feverRequest("", form)
+	suite.Len(strings.Split(resp.UnreadItemIDs, ","), 4)
+	suite.NotContains(strings.Split(resp.UnreadItemIDs, ","), since)
+
+	form.Set("as", "saved")
+	resp = suite.feverRequest("", form)
+	suite.Equal(since, resp.SavedItemIDs)
+
+	resp = suite.feverRequest("items&with_ids="+since, suite.feverKey())
+	suite.Require().Len(resp.Items, 1)
+	suite.Equal(1, resp.Items[0].IsRead)
+	suite.Equal(1, resp.Items[0].IsSaved)
+
+	form = suite.feverKey()
+	form.Set("mark", "feed")
+	form.Set("as", "read")
+	form.Set("id", strconv.Itoa(int(feed.ID)))
+	form.Set("before", strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10))
+	resp = suite.feverRequest("", form)
+
+	stats, err := suite.db.FeedStats(feed.APIID, &suite.user)
+	suite.Require().Nil(err)
+	suite.Equal(4, stats.Unread)
+
+	form.Set("before", strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10))
+	resp = suite.feverRequest("", form)
+
+	stats, err = suite.db.FeedStats(feed.APIID, &suite.user)
+	suite.Require().Nil(err)
+	suite.Equal(0, stats.Unread)
+}
+
+func (suite *ServerTestSuite) TestGetFeedIcon() {
+	icon := "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/icon.png" {
+			io.WriteString(w, icon)
+			return
+		}
+
+		io.WriteString(w, `<rss version="2.0"><channel><title>Icon Test</title>
+<image><url>/icon.png</url><title>Icon Test</title><link>http://example.com</link></image>
+<item><title>One</title><guid>one@icon</guid></item></channel></rss>`)
+	}))
+	defer ts.Close()
+
+	feed := models.Feed{
+		Subscription: ts.URL + "/feed.xml",
+	}
+
+	err := suite.db.NewFeed(&feed, &suite.user)
+	suite.Require().Nil(err)
+
+	req, err := http.NewRequest("GET", "http://localhost:9876/v1/feeds/"+feed.APIID+"/icon", nil)
+	suite.Require().Nil(err)
+	req.Header.Set("Authorization", "Bearer "+suite.token)
+
+	resp, err := http.DefaultClient.Do(req)
+	suite.Require().Nil(err)
+	resp.Body.Close()
+	suite.Equal(404, resp.StatusCode)
+
+	err = suite.sync.SyncFeed(context.Background(), &feed, &suite.user)
+	suite.Require().Nil(err)
+
+	resp, err = http.DefaultClient.Do(req)
+	suite.Require().Nil(err)
+	suite.Equal(200, resp.StatusCode)
+	suite.Equal("image/png", resp.Header.Get("Content-Type"))
+	suite.Equal("private, max-age=86400", resp.Header.Get("Cache-Control"))
+	suite.NotEmpty(resp.Header.Get("Last-Modified"))
+
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	suite.Require().Nil(err)
+	suite.Equal(icon, string(body))
+
+	etag := resp.Header.Get("ETag")
+	suite.NotEmpty(etag)
+
+	req.Header.Set("If-None-Match", etag)
+
+	resp, err = http.DefaultClient.Do(req)
+	suite.Require().Nil(err)
+	resp.Body.Close()
+	suite.Equal(304, resp.StatusCode)
+
+	req, err = http.NewRequest("GET", "http://localhost:9876/v1/feeds/"+feed.APIID, nil)
+	suite.Require().Nil(err)
+	req.Header.Set("Authorization", "Bearer "+suite.token)
+
+	resp, err = http.DefaultClient.Do(req)
+	suite.Require().Nil(err)
+
+	respFeed := models.Feed{}
+	err = json.NewDecoder(resp.Body).Decode(&respFeed)
+	resp.Body.Close()
+	suite.Require().Nil(err)
+	suite.Equal(ts.URL+"/icon.png", respFeed.ImageURL)
+
+	err = suite.db.EnableFever(suite.user.APIID, "fever")
+	suite.Require().Nil(err)
+
+	fever := suite.feverRequest("favicons&feeds", suite.feverKey())
+	suite.Require().Len(fever.Favicons, 1)
+	suite.Equal("image/png;base64,"+base64.StdEncoding.EncodeToString([]byte(icon)), fever.Favicons[0].Data)
+	suite.Require().Len(fever.Feeds, 1)
+	suite.Equal(fever.Favicons[0].ID, fever.Feeds[0].FaviconID)
+}
+
+func (suite *ServerTestSuite) TestRules() {
+	feed := models.Feed{
+		Title:        "Test site",
+		Subscription: "http://example.com",
+	}
+
+	err := suite.db.NewFeed(&feed, &suite.user)
+	suite.Require().Nil(err)
+
+	err = suite.db.NewEntries([]models.Entry{
+		{Title: "Sponsored: Buy now", Mark: models.Unread},
+		{Title: "Other Entry", Mark: models.Unread},
+	}, &feed, &suite.user)
+	suite.Require().Nil(err)
+
+	body := `{"feed_id": "` + feed.APIID + `", "field": "title", "pattern": "(?i)^sponsored", "action": "mark", "value": "read"}`
+	req, err := http.NewRequest("POST", "http://localhost:9876/v1/rules", strings.NewReader(body))
+	suite.Require().Nil(err)
+	req.Header.Set("Authorization", "Bearer "+suite.token)
+
+	resp, err := http.DefaultClient.Do(req)
+	suite.Require().Nil(err)
+	suite.Equal(201, resp.StatusCode)
+
+	rule := models.Rule{}
+	err = json.NewDecoder(resp.Body).Decode(&rule)
+	resp.Body.Close()
+	suite.Require().Nil(err)
+	suite.NotEmpty(rule.APIID)
+
+	req, err = http.NewRequest("GET", "http://localhost:9876/v1/rules/"+rule.APIID+"/dryrun", nil)
+	suite.Require().Nil(err)
+	req.Header.Set("Authorization", "Bearer "+suite.token)
+
+	resp, err = http.DefaultClient.Do(req)
+	suite.Require().Nil(err)
+	suite.Equal(200, resp.StatusCode)
+
+	type Entries struct {
+		Entries []models.Entry `json:"entries"`
+	}
+
+	matched := Entries{}
+	err = json.NewDecoder(resp.Body).Decode(&matched)
+	resp.Body.Close()
+	suite.Require().Nil(err)
+	suite.Require().Len(matched.Entries, 1)
+	suite.Equal("Sponsored: Buy now", matched.Entries[0].Title)
+
+	req, err = http.NewRequest("PUT", "http://localhost:9876/v1/rules/"+rule.APIID, strings.NewReader(`{"field": "title", "pattern": "(", "action": "discard"}`))
+	suite.Require().Nil(err)
+	req.Header.Set("Authorization", "Bearer "+suite.token)
+
+	resp, err = http.DefaultClient.Do(req)
+	suite.Require().Nil(err)
+	resp.Body.Close()
+	suite.Equal(400, resp.StatusCode)
+
+	req, err = http.NewRequest("DELETE", "http://localhost:9876/v1/rules/"+rule.APIID, nil)
+	suite.Require().Nil(err)
+	req.Header.Set("Authorization", "Bearer "+suite.token)
+
+	resp, err = http.DefaultClient.Do(req)
+	suite.Require().Nil(err)
+	resp.Body.Close()
+	suite.Equal(204, resp.StatusCode)
+
+	req, err = http.NewRequest("GET", "http://localhost:9876/v1/rules/"+rule.APIID+"/dryrun", nil)
+	suite.Require().Nil(err)
+	req.Header.Set("Authorization", "Bearer "+suite.token)
+
+	resp, err = http.DefaultClient.Do(req)
+	suite.Require().Nil(err)
+	resp.Body.Close()
+	suite.Equal(404, resp.StatusCode)
+}
+
+func (suite *ServerTestSuite) TestPublications() {
+	feed := models.Feed{
+		Title:        "Test site",
+		Subscription: "http://example.com",
+	}
+
+	err := suite.db.NewFeed(&feed, &suite.user)
+	suite.Require().Nil(err)
+
+	err = suite.db.NewEntries([]models.Entry{
+		{Title: "Saved Entry", Mark: models.Unread, Saved: true},
+		{Title: "Other Entry", Mark: models.Unread},
+	}, &feed, &suite.user)
+	suite.Require().Nil(err)
+
+	req, err := http.NewRequest("POST", "http://localhost:9876/v1/publications", strings.NewReader(`{"source": "saved"}`))
+	suite.Require().Nil(err)
+	req.Header.Set("Authorization", "Bearer "+suite.token)
+
+	resp, err := http.DefaultClient.Do(req)
+	suite.Require().Nil(err)
+	suite.Equal(201, resp.StatusCode)
+
+	pub := models.Publication{}
+	err = json.NewDecoder(resp.Body).Decode(&pub)
+	resp.Body.Close()
+	suite.Require().Nil(err)
+	suite.NotEmpty(pub.Token)
+	suite.Equal("Saved entries", pub.Title)
+
+	published := "http://localhost:9876/published/" + pub.Token
+
+	resp, err = http.Get(published + "/atom")
+	suite.Require().Nil(err)
+	suite.Equal(200, resp.StatusCode)
+	suite.Equal("application/atom+xml; charset=UTF-8", resp.Header.Get("Content-Type"))
+
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	suite.Require().Nil(err)
+	suite.Contains(string(body), "Saved Entry")
+	suite.NotContains(string(body), "Other Entry")
+
+	etag := resp.Header.Get("ETag")
+	lastModified := resp.Header.Get("Last-Modified")
+	suite.NotEmpty(etag)
+	suite.NotEmpty(lastModified)
+
+	req, err = http.NewRequest("GET", published+"/atom", nil)
+	suite.Require().Nil(err)
+	req.Header.Set("If-None-Match", etag)
+
+	resp, err = http.DefaultClient.Do(req)
+	suite.Require().Nil(err)
+	resp.Body.Close()
+	suite.Equal(304, resp.StatusCode)
+
+	req, err = http.NewRequest("GET", published+"/atom", nil)
+	suite.Require().Nil(err)
+	req.Header.Set("If-Modified-Since", lastModified)
+
+	resp, err = http.DefaultClient.Do(req)
+	suite.Require().Nil(err)
+	resp.Body.Close()
+	suite.Equal(304, resp.StatusCode)
+
+	// Marking an Entry does not change what is published
+	time.Sleep(time.Millisecond * 1100)
+	saved, _, err := suite.db.EntriesPage(database.Page{Limit: 10}, true, models.Any, true, &suite.user)
+	suite.Require().Nil(err)
+	suite.Require().Len(saved, 1)
+
+	err = suite.db.MarkEntry(saved[0].APIID, models.Read, &suite.user)
+	suite.Require().Nil(err)
+
+	resp, err = http.DefaultClient.Do(req)
+	suite.Require().Nil(err)
+	resp.Body.Close()
+	suite.Equal(304, resp.StatusCode)
+	suite.Equal(lastModified, resp.Header.Get("Last-Modified"))
+
+	// Removing an Entry does
+	err = suite.db.UnsaveEntry(saved[0].APIID, &suite.user)
+	suite.Require().Nil(err)
+
+	resp, err = http.DefaultClient.Do(req)
+	suite.Require().Nil(err)
+	body, err = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	suite.Require().Nil(err)
+	suite.Equal(200, resp.StatusCode)
+	suite.NotContains(string(body), "Saved Entry")
+	suite.NotEqual(lastModified, resp.Header.Get("Last-Modified"))
+
+	err = suite.db.SaveEntry(saved[0].APIID, &suite.user)
+	suite.Require().Nil(err)
+
+	resp, err = http.Get(published + "/rss")
+	suite.Require().Nil(err)
+	resp.Body.Close()
+	suite.Equal(200, resp.StatusCode)
+	suite.NotEqual(etag, resp.Header.Get("ETag"))
+
+	resp, err = http.Get(published + "/json")
+	suite.Require().Nil(err)
+	resp.Body.Close()
+	suite.Equal(200, resp.StatusCode)
+	suite.Equal("application/feed+json; charset=UTF-8", resp.Header.Get("Content-Type"))
+
+	resp, err = http.Get(published + "/html")
+	suite.Require().Nil(err)
+	resp.Body.Close()
+	suite.Equal(400, resp.StatusCode)
+
+	resp, err = http.Get("http://localhost:9876/published/bogus/atom")
+	suite.Require().Nil(err)
+	resp.Body.Close()
+	suite.Equal(404, resp.StatusCode)
+
+	req, err = http.NewRequest("DELETE", "http://localhost:9876/v1/publications/"+pub.APIID, nil)
+	suite.Require().Nil(err)
+	req.Header.Set("Authorization", "Bearer "+suite.token)
+
+	resp, err = http.DefaultClient.Do(req)
+	suite.Require().Nil(err)
+	resp.Body.Close()
+	suite.Equal(204, resp.StatusCode)
+
+	resp, err = http.Get(published + "/atom")
+	suite.Require().Nil(err)
+	resp.Body.Close()
+	suite.Equal(404, resp.StatusCode)
+}
+
+func (suite *ServerTestSuite) feverKey() url.Values {
+	sum := md5.Sum([]byte(suite.user.Username + ":fever"))
+	return url.Values{"api_key": {hex.EncodeToString(sum[:])}}
+}
+
+func (suite *ServerTestSuite) feverRequest(query string, form url.Values) feverResponse {
+	resp, err := http.PostForm("http://localhost:9876/fever/?api&"+query, form)
+	suite.Require().Nil(err)
+	defer resp.Body.Close()
+	suite.Require().Equal(200, resp.StatusCode)
+
+	feverResp := feverResponse{}
+	err = json.NewDecoder(resp.Body).Decode(&feverResp)
+	suite.Require().Nil(err)
+	return feverResp
+}
+
+func (suite *ServerTestSuite) readerRequest(method, path string, form url.Values) *http.Response {
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+
+	req, err := http.NewRequest(method, "http://localhost:9876/reader/api/0"+path, body)
+	suite.Require().Nil(err)
+	req.Header.Set("Authorization", "GoogleLogin auth="+suite.token)
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	suite.Require().Nil(err)
+	return resp
+}
+
+// refresh requests a refresh at path and returns the status
+// code of the response and the refresh it returned.
+func (suite *ServerTestSuite) refresh(path string) (int, sync.RefreshJob) {
+	req, err := http.NewRequest("POST", "http://localhost:9876"+path, nil)
+	suite.Require().Nil(err)
+
+	req.Header.Set("Authorization", "Bearer "+suite.token)
+
+	resp, err := http.DefaultClient.Do(req)
+	suite.Require().Nil(err)
+	defer resp.Body.Close()
+
+	var job sync.RefreshJob
+	if resp.StatusCode == http.StatusAccepted {
+		err = json.NewDecoder(resp.Body).Decode(&job)
+		suite.Require().Nil(err)
+	}
+
+	return resp.StatusCode, job
+}
+
+// waitForRefresh polls the refresh with id until it is done.
+func (suite *ServerTestSuite) waitForRefresh(id string) sync.RefreshJob {
+	for i := 0; i < 100; i++ {
+		req, err := http.NewRequest("GET", "http://localhost:9876/v1/refresh/"+id, nil)
+		suite.Require().Nil(err)
+
+		req.Header.Set("Authorization", "Bearer "+suite.token)
+
+		resp, err := http.DefaultClient.Do(req)
+		suite.Require().Nil(err)
+		suite.Require().Equal(200, resp.StatusCode)
+
+		var job sync.RefreshJob
+		err = json.NewDecoder(resp.Body).Decode(&job)
+		resp.Body.Close()
+		suite.Require().Nil(err)
+
+		if job.Status != sync.RefreshQueued && job.Status != sync.RefreshRunning {
+			return job
+		}
+
+		time.Sleep(time.Millisecond * 50)
+	}
+
+	suite.FailNow("Refresh " + id + " did not finish")
+	return sync.RefreshJob{}
+}
+
 func (suite *ServerTestSuite) startServer() {
 	conf := config.DefaultConfig
 	conf.Server.HTTPPort = 9876
@@ -1357,6 +3146,7 @@ func (suite *ServerTestSuite) startServer() {
 
 	suite.sync = sync.NewSync(suite.db, config.Sync{
 		SyncInterval: config.Duration{Duration: time.Second * 5},
+		IconCacheDir: TestIconCacheDir,
 	})
 
 	if suite.server == nil {
@@ -1433,5 +3223,6 @@ func TestServerTestSuite(t *testing.T) {
 	suite.Run(t, serverSuite)
 	serverSuite.server.Stop()
 	os.Remove(TestDBPath)
+	os.RemoveAll(TestIconCacheDir)
 	serverSuite.ts.Close()
 }
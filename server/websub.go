@@ -0,0 +1,100 @@
+/*
+  Copyright (C) 2017 Jorge Martinez Hernandez
+
+  This program is free software: you can redistribute it and/or modify
+  it under the terms of the GNU Affero General Public License as published by
+  the Free Software Foundation, either version 3 of the License, or
+  (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU Affero General Public License for more details.
+
+  You should have received a copy of the GNU Affero General Public License
+  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package server
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/varddum/syndication/sync"
+
+	"github.com/labstack/echo"
+	"github.com/labstack/echo/middleware"
+)
+
+// registerWebSub adds the callback WebSub hubs use to verify
+// subscriptions and push updates. Callbacks are identified by
+// an unguessable id instead of credentials.
+func (s *Server) registerWebSub() {
+	websub := s.handle.Group("/websub")
+	websub.Use(middleware.BodyLimit("10M"))
+	websub.Use(s.recoverer())
+
+	if s.config.EnableRequestLogs {
+		websub.Use(middleware.Logger())
+	}
+
+	websub.GET("/:callbackID", s.VerifyHubSubscription)
+	websub.POST("/:callbackID", s.HubPush)
+}
+
+// VerifyHubSubscription answers a hub verifying a subscription
+// by echoing its challenge
+func (s *Server) VerifyHubSubscription(c echo.Context) error {
+	subscribers, err := s.db.HubSubscribers(c.Param("callbackID"))
+	if err != nil {
+		return newError(err, &c)
+	}
+
+	lease, _ := strconv.Atoi(c.QueryParam("hub.lease_seconds"))
+
+	verified := false
+	for i := range subscribers {
+		subscriber := &subscribers[i]
+		ok, err := s.sync.VerifyHubSubscription(&subscriber.Feed, &subscriber.User, c.QueryParam("hub.mode"), c.QueryParam("hub.topic"), lease)
+		if err != nil {
+			return newError(err, &c)
+		}
+
+		verified = verified || ok
+	}
+
+	if !verified {
+		return echo.NewHTTPError(http.StatusNotFound)
+	}
+
+	return c.String(http.StatusOK, c.QueryParam("hub.challenge"))
+}
+
+// HubPush receives the content of a feed pushed by a hub
+// and stores it for every feed that shares the callback
+func (s *Server) HubPush(c echo.Context) error {
+	subscribers, err := s.db.HubSubscribers(c.Param("callbackID"))
+	if err != nil {
+		return newError(err, &c)
+	}
+
+	content, err := ioutil.ReadAll(c.Request().Body)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest)
+	}
+
+	for i := range subscribers {
+		subscriber := &subscribers[i]
+		err = s.sync.Push(&subscriber.Feed, &subscriber.User, c.Request().Header.Get("X-Hub-Signature"), content)
+
+		// Hubs are told that content they should not retry was received,
+		// including content with an invalid signature, as WebSub requires.
+		if _, ok := err.(sync.BadRequest); err != nil && !ok {
+			return newError(err, &c)
+		}
+	}
+
+	return c.NoContent(http.StatusAccepted)
+}
@@ -0,0 +1,184 @@
+/*
+  Copyright (C) 2017 Jorge Martinez Hernandez
+
+  This program is free software: you can redistribute it and/or modify
+  it under the terms of the GNU Affero General Public License as published by
+  the Free Software Foundation, either version 3 of the License, or
+  (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU Affero General Public License for more details.
+
+  You should have received a copy of the GNU Affero General Public License
+  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package sync
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/varddum/syndication/models"
+)
+
+// maxDiscoverySize bounds how much of a page is read while looking for feeds.
+const maxDiscoverySize = 2 << 20
+
+// maxCandidates bounds the number of feeds linked from a page that are checked.
+const maxCandidates = 10
+
+// feedTypes maps the content types of alternate links to feed types.
+var feedTypes = map[string]string{
+	"application/rss+xml":   "rss",
+	"application/atom+xml":  "atom",
+	"application/feed+json": "json",
+	"application/json":      "json",
+}
+
+// commonFeedPaths are tried, in order, on sites that do not link to their feeds.
+var commonFeedPaths = []string{
+	"/feed",
+	"/rss",
+	"/feed.xml",
+	"/rss.xml",
+	"/atom.xml",
+	"/index.xml",
+	"/feed.json",
+}
+
+// Candidate is a feed found by Discover.
+type Candidate struct {
+	URL   string `json:"url"`
+	Title string `json:"title"`
+	Type  string `json:"type"`
+
+	// fetched is the fetch of a URL that pointed to a feed,
+	// which SyncCandidate stores instead of fetching it again.
+	fetched *fetched
+}
+
+// Discover returns the feeds found at rawURL. A URL that points to a feed
+// has that feed as its only candidate. Otherwise the feeds an HTML page
+// links to with <link rel="alternate"> are returned or, when there are none,
+// the first feed found at a common path of the site. Discovery stops
+// once ctx is done.
+func (s *Sync) Discover(ctx context.Context, rawURL string) ([]Candidate, error) {
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "http://" + rawURL
+	}
+
+	pageURL, err := url.Parse(rawURL)
+	if err != nil || (pageURL.Scheme != "http" && pageURL.Scheme != "https") || pageURL.Host == "" {
+		return nil, BadRequest{"URL should be an http or https URL"}
+	}
+
+	result := s.fetch(ctx, &models.Feed{Subscription: pageURL.String()})
+	if result.err != nil {
+		return nil, result.err
+	}
+
+	content, location := result.body, result.location
+	result.parse()
+	if result.feed != nil {
+		return []Candidate{{
+			URL:     location.String(),
+			Title:   result.feed.Title,
+			Type:    result.feed.FeedType,
+			fetched: result,
+		}}, nil
+	}
+
+	candidates := []Candidate{}
+	found := map[string]bool{}
+	for _, link := range pageLinks(bytes.NewReader(content), location) {
+		linkType, ok := feedTypes[strings.TrimSpace(strings.Split(link.linkType, ";")[0])]
+		if !ok || !hasRel(link.rel, "alternate") || found[link.href] {
+			continue
+		}
+
+		found[link.href] = true
+		if len(found) > maxCandidates {
+			break
+		}
+
+		candidate, ok := s.checkCandidate(ctx, link.href)
+		if !ok {
+			continue
+		}
+
+		if link.title != "" {
+			candidate.Title = link.title
+		}
+
+		if candidate.Type == "" {
+			candidate.Type = linkType
+		}
+
+		candidates = append(candidates, candidate)
+	}
+
+	if len(candidates) != 0 {
+		return candidates, nil
+	}
+
+	for _, path := range commonFeedPaths {
+		if candidate, ok := s.checkCandidate(ctx, location.ResolveReference(&url.URL{Path: path}).String()); ok {
+			return []Candidate{candidate}, nil
+		}
+	}
+
+	return nil, BadRequest{"No feeds were found at " + pageURL.String()}
+}
+
+// fetchPage returns the content at rawURL and the URL
+// it was served from once redirects were followed.
+func (s *Sync) fetchPage(ctx context.Context, rawURL string) ([]byte, *url.URL, error) {
+	resp, err := (&redirects{transport: s.hosts}).get(ctx, rawURL)
+	if err != nil {
+		return nil, nil, BadRequest{err.Error()}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return nil, nil, BadRequest{rawURL + " responded with " + resp.Status}
+	}
+
+	content, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxDiscoverySize))
+	if err != nil {
+		return nil, nil, BadRequest{err.Error()}
+	}
+
+	return content, resp.Request.URL, nil
+}
+
+// checkCandidate fetches rawURL and reports whether it is a feed.
+func (s *Sync) checkCandidate(ctx context.Context, rawURL string) (Candidate, bool) {
+	content, location, err := s.fetchPage(ctx, rawURL)
+	if err != nil {
+		return Candidate{}, false
+	}
+
+	return parseCandidate(content, location)
+}
+
+func parseCandidate(content []byte, location *url.URL) (Candidate, bool) {
+	hints := newScheduleHints()
+	feed, err := parseFeed(content, &hints)
+	if err != nil || feed == nil {
+		return Candidate{}, false
+	}
+
+	return Candidate{
+		URL:   location.String(),
+		Title: feed.Title,
+		Type:  feed.FeedType,
+	}, true
+}
@@ -0,0 +1,136 @@
+/*
+  Copyright (C) 2017 Jorge Martinez Hernandez
+
+  This program is free software: you can redistribute it and/or modify
+  it under the terms of the GNU Affero General Public License as published by
+  the Free Software Foundation, either version 3 of the License, or
+  (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU Affero General Public License for more details.
+
+  You should have received a copy of the GNU Affero General Public License
+  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package sync
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/varddum/syndication/models"
+
+	"github.com/mmcdole/gofeed"
+	ext "github.com/mmcdole/gofeed/extensions"
+)
+
+// convertEnclosures collects the enclosures of an item and the media:content
+// elements it includes, directly or in a media:group. iTunes metadata applies
+// to every enclosure of the item.
+func convertEnclosures(item *gofeed.Item) []models.Enclosure {
+	var enclosures []models.Enclosure
+	seen := map[string]int{}
+
+	add := func(enclosure models.Enclosure) {
+		if enclosure.URL == "" {
+			return
+		}
+
+		// The same file is often listed as an enclosure and as
+		// media:content, each with part of its metadata.
+		if i, ok := seen[enclosure.URL]; ok {
+			merged := &enclosures[i]
+			if merged.Type == "" {
+				merged.Type = enclosure.Type
+			}
+			if merged.Length == 0 {
+				merged.Length = enclosure.Length
+			}
+			if merged.Duration == 0 {
+				merged.Duration = enclosure.Duration
+			}
+			return
+		}
+
+		seen[enclosure.URL] = len(enclosures)
+		enclosures = append(enclosures, enclosure)
+	}
+
+	for _, enclosure := range item.Enclosures {
+		length, _ := strconv.ParseInt(strings.TrimSpace(enclosure.Length), 10, 64)
+		add(models.Enclosure{
+			URL:    enclosure.URL,
+			Type:   enclosure.Type,
+			Length: length,
+		})
+	}
+
+	if media, ok := item.Extensions["media"]; ok {
+		for _, content := range media["content"] {
+			add(mediaContent(content))
+		}
+
+		for _, group := range media["group"] {
+			for _, content := range group.Children["content"] {
+				add(mediaContent(content))
+			}
+		}
+	}
+
+	if item.ITunesExt != nil {
+		duration := parseDuration(item.ITunesExt.Duration)
+		explicit := isExplicit(item.ITunesExt.Explicit)
+		for i := range enclosures {
+			if enclosures[i].Duration == 0 {
+				enclosures[i].Duration = duration
+			}
+			enclosures[i].Image = item.ITunesExt.Image
+			enclosures[i].Subtitle = item.ITunesExt.Subtitle
+			enclosures[i].Explicit = explicit
+		}
+	}
+
+	return enclosures
+}
+
+func mediaContent(content ext.Extension) models.Enclosure {
+	length, _ := strconv.ParseInt(content.Attrs["fileSize"], 10, 64)
+	duration, _ := strconv.ParseFloat(content.Attrs["duration"], 64)
+	return models.Enclosure{
+		URL:      content.Attrs["url"],
+		Type:     content.Attrs["type"],
+		Length:   length,
+		Duration: int64(duration),
+	}
+}
+
+// parseDuration parses an itunes:duration, which is either a number of
+// seconds or a time in the form of HH:MM:SS or MM:SS.
+func parseDuration(value string) int64 {
+	parts := strings.Split(strings.TrimSpace(value), ":")
+	if len(parts) > 3 {
+		return 0
+	}
+
+	var duration float64
+	for _, part := range parts {
+		n, err := strconv.ParseFloat(part, 64)
+		if err != nil || n < 0 {
+			return 0
+		}
+		duration = duration*60 + n
+	}
+
+	return int64(duration)
+}
+
+func isExplicit(value string) bool {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "yes", "true", "explicit":
+		return true
+	}
+	return false
+}
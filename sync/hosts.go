@@ -0,0 +1,259 @@
+/*
+  Copyright (C) 2017 Jorge Martinez Hernandez
+
+  This program is free software: you can redistribute it and/or modify
+  it under the terms of the GNU Affero General Public License as published by
+  the Free Software Foundation, either version 3 of the License, or
+  (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU Affero General Public License for more details.
+
+  You should have received a copy of the GNU Affero General Public License
+  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package sync
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// hostLimiter is the transport of the fetches made by a Sync. It limits
+// the number of concurrent requests to each host, spaces the requests
+// made to a host and holds off hosts that asked to be retried later.
+// Requests that take longer than readTimeout, counted from when they
+// are sent rather than queued, are cancelled.
+type hostLimiter struct {
+	transport     http.RoundTripper
+	maxRequests   int
+	spacing       time.Duration
+	maxRetryAfter time.Duration
+	readTimeout   time.Duration
+
+	lock  sync.Mutex
+	hosts map[string]*hostState
+}
+
+type hostState struct {
+	// slots holds a token for every request in flight.
+	slots chan struct{}
+
+	// next is the earliest time the next request may start.
+	next time.Time
+
+	// retryAfter is when a host that answered with a
+	// Retry-After header may be requested again.
+	retryAfter time.Time
+}
+
+// retryLater is returned for requests to a host that asked to be
+// left alone until a later time.
+type retryLater struct {
+	host  string
+	until time.Time
+}
+
+func (e retryLater) Error() string {
+	return e.host + " asked to be retried after " + e.until.Format(time.RFC1123)
+}
+
+func newHostLimiter(maxRequests int, spacing, maxRetryAfter time.Duration) *hostLimiter {
+	return &hostLimiter{
+		transport:     http.DefaultTransport,
+		maxRequests:   maxRequests,
+		spacing:       spacing,
+		maxRetryAfter: maxRetryAfter,
+		hosts:         map[string]*hostState{},
+	}
+}
+
+func (l *hostLimiter) host(host string) *hostState {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	state, ok := l.hosts[host]
+	if !ok {
+		state = &hostState{slots: make(chan struct{}, l.maxRequests)}
+		l.hosts[host] = state
+	}
+
+	return state
+}
+
+// blocked returns a retryLater error if host must not be requested yet.
+func (l *hostLimiter) blocked(host string, now time.Time) error {
+	state := l.host(strings.ToLower(host))
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if state.retryAfter.After(now) {
+		return retryLater{host: host, until: state.retryAfter}
+	}
+
+	return nil
+}
+
+// RoundTrip waits for a free slot and for the spacing of the host of req
+// before sending it. The slot is held until the body of the response is closed.
+func (l *hostLimiter) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := strings.ToLower(req.URL.Host)
+	if err := l.blocked(host, time.Now()); err != nil {
+		return nil, err
+	}
+
+	state := l.host(host)
+
+	select {
+	case state.slots <- struct{}{}:
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	}
+
+	l.lock.Lock()
+	start := time.Now()
+	if state.next.After(start) {
+		start = state.next
+	}
+	state.next = start.Add(l.spacing)
+	l.lock.Unlock()
+
+	if wait := time.Until(start); wait > 0 {
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			<-state.slots
+			return nil, req.Context().Err()
+		}
+	}
+
+	cancel := func() {}
+	if l.readTimeout > 0 {
+		var ctx context.Context
+		ctx, cancel = context.WithTimeout(req.Context(), l.readTimeout)
+		req = req.WithContext(ctx)
+	}
+
+	resp, err := l.transport.RoundTrip(req)
+	if err != nil {
+		cancel()
+		<-state.slots
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+		if until, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now(), l.maxRetryAfter); ok {
+			l.lock.Lock()
+			if until.After(state.retryAfter) {
+				state.retryAfter = until
+			}
+			l.lock.Unlock()
+		}
+	}
+
+	resp.Body = &slotBody{ReadCloser: resp.Body, release: func() {
+		cancel()
+		<-state.slots
+	}}
+	return resp, nil
+}
+
+// slotBody releases the slot of a request once its body is closed.
+type slotBody struct {
+	io.ReadCloser
+	release func()
+	once    sync.Once
+}
+
+func (b *slotBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
+
+// parseRetryAfter parses a Retry-After header, which holds either a number
+// of seconds or an HTTP date. Delays are capped at max.
+func parseRetryAfter(value string, now time.Time, max time.Duration) (time.Time, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, false
+	}
+
+	var until time.Time
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds <= 0 {
+			return time.Time{}, false
+		}
+		until = now.Add(time.Duration(seconds) * time.Second)
+	} else if date, err := http.ParseTime(value); err == nil {
+		until = date
+	} else {
+		return time.Time{}, false
+	}
+
+	if !until.After(now) {
+		return time.Time{}, false
+	}
+
+	if until.Sub(now) > max {
+		until = now.Add(max)
+	}
+
+	return until, true
+}
+
+// newTransport returns a transport that gives up on
+// hosts that don't accept a connection within connectTimeout.
+func newTransport(connectTimeout time.Duration) *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   connectTimeout,
+		KeepAlive: 30 * time.Second,
+	}
+
+	return &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   connectTimeout,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+}
+
+// readBody reads body, failing once it turns out to be larger than max bytes.
+func readBody(body io.Reader, max int64) ([]byte, error) {
+	content, err := ioutil.ReadAll(io.LimitReader(body, max+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(content)) > max {
+		return nil, BadRequest{"Feed is larger than " + strconv.FormatInt(max, 10) + " bytes"}
+	}
+
+	return content, nil
+}
+
+// unwrapRetryLater returns the retryLater error a request failed with, if any.
+func unwrapRetryLater(err error) (retryLater, bool) {
+	if urlErr, ok := err.(*url.Error); ok {
+		err = urlErr.Err
+	}
+
+	retry, ok := err.(retryLater)
+	return retry, ok
+}
@@ -0,0 +1,100 @@
+/*
+  Copyright (C) 2017 Jorge Martinez Hernandez
+
+  This program is free software: you can redistribute it and/or modify
+  it under the terms of the GNU Affero General Public License as published by
+  the Free Software Foundation, either version 3 of the License, or
+  (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU Affero General Public License for more details.
+
+  You should have received a copy of the GNU Affero General Public License
+  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package sync
+
+import (
+	"io"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// pageLink is a <link> element of an HTML page.
+type pageLink struct {
+	rel      string
+	href     string
+	linkType string
+	title    string
+}
+
+// pageLinks returns the <link> elements in the head of the HTML page read
+// from r. Their hrefs are resolved against the <base> of the page or, when
+// it has none, against base.
+func pageLinks(r io.Reader, base *url.URL) (links []pageLink) {
+	tokenizer := html.NewTokenizer(r)
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			return
+		case html.EndTagToken:
+			if name, _ := tokenizer.TagName(); string(name) == "head" {
+				return
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := tokenizer.TagName()
+			if string(name) == "body" {
+				return
+			}
+
+			if !hasAttr || (string(name) != "link" && string(name) != "base") {
+				continue
+			}
+
+			link := pageLink{}
+			for hasAttr {
+				var key, val []byte
+				key, val, hasAttr = tokenizer.TagAttr()
+				switch string(key) {
+				case "rel":
+					link.rel = strings.ToLower(strings.TrimSpace(string(val)))
+				case "href":
+					link.href = strings.TrimSpace(string(val))
+				case "type":
+					link.linkType = strings.ToLower(strings.TrimSpace(string(val)))
+				case "title":
+					link.title = string(val)
+				}
+			}
+
+			href, err := base.Parse(link.href)
+			if link.href == "" || err != nil {
+				continue
+			}
+
+			if string(name) == "base" {
+				base = href
+				continue
+			}
+
+			link.href = href.String()
+			links = append(links, link)
+		}
+	}
+}
+
+// hasRel reports whether rel, a space separated list of link types, contains linkType.
+func hasRel(rel, linkType string) bool {
+	for _, value := range strings.Fields(rel) {
+		if value == linkType {
+			return true
+		}
+	}
+
+	return false
+}
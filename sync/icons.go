@@ -0,0 +1,286 @@
+/*
+  Copyright (C) 2017 Jorge Martinez Hernandez
+
+  This program is free software: you can redistribute it and/or modify
+  it under the terms of the GNU Affero General Public License as published by
+  the Free Software Foundation, either version 3 of the License, or
+  (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU Affero General Public License for more details.
+
+  You should have received a copy of the GNU Affero General Public License
+  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package sync
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/varddum/syndication/models"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// iconRefresh is how long the icon of a feed, or the lack of one,
+// is kept before the icon is looked up again.
+const iconRefresh = time.Hour * 24 * 7
+
+// maxIconPageSize bounds how much of a site's page is read
+// while looking for the icons it links to.
+const maxIconPageSize = 1 << 20
+
+// iconCache stores feed icons as files named after the hash of their
+// content, so feeds that share an icon share a file. The icons stored
+// least recently are removed once the cache holds more than capacity bytes.
+type iconCache struct {
+	dir      string
+	maxSize  int64
+	capacity int64
+	lock     sync.Mutex
+
+	transport http.RoundTripper
+}
+
+func newIconCache(dir string, maxSize, capacity int64) *iconCache {
+	return &iconCache{
+		dir:      dir,
+		maxSize:  maxSize,
+		capacity: capacity,
+	}
+}
+
+func (c *iconCache) path(key string) string {
+	return filepath.Join(c.dir, key)
+}
+
+// has reports whether the icon with key is still cached.
+func (c *iconCache) has(key string) bool {
+	if key == "" {
+		return false
+	}
+
+	_, err := os.Stat(c.path(key))
+	return err == nil
+}
+
+// open returns the cached icon with key.
+func (c *iconCache) open(key string) (*os.File, os.FileInfo, error) {
+	if key == "" || strings.ContainsAny(key, `/\.`) {
+		return nil, nil, NotFound{"Feed has no icon"}
+	}
+
+	file, err := os.Open(c.path(key))
+	if err != nil {
+		return nil, nil, NotFound{"Feed has no icon"}
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, NotFound{"Feed has no icon"}
+	}
+
+	return file, info, nil
+}
+
+// store adds content to the cache and returns its key.
+func (c *iconCache) store(content []byte) (string, error) {
+	if int64(len(content)) > c.maxSize {
+		return "", BadRequest{"Icon is too large"}
+	}
+
+	sum := sha256.Sum256(content)
+	key := hex.EncodeToString(sum[:])
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	if err := os.Chtimes(c.path(key), now, now); err != nil {
+		tmp, err := ioutil.TempFile(c.dir, ".icon")
+		if err != nil {
+			return "", err
+		}
+
+		_, err = tmp.Write(content)
+		if closeErr := tmp.Close(); err == nil {
+			err = closeErr
+		}
+
+		if err == nil {
+			err = os.Rename(tmp.Name(), c.path(key))
+		}
+
+		if err != nil {
+			os.Remove(tmp.Name())
+			return "", err
+		}
+	}
+
+	c.evict(key)
+	return key, nil
+}
+
+// evict removes the least recently stored icons, other than
+// the icon with key, until the cache fits its capacity.
+func (c *iconCache) evict(key string) {
+	files, err := ioutil.ReadDir(c.dir)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	var size int64
+	for _, file := range files {
+		size += file.Size()
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ModTime().Before(files[j].ModTime())
+	})
+
+	for _, file := range files {
+		if size <= c.capacity {
+			return
+		}
+
+		if file.Name() == key || file.IsDir() {
+			continue
+		}
+
+		if err := os.Remove(c.path(file.Name())); err != nil {
+			log.Error(err)
+			continue
+		}
+
+		size -= file.Size()
+	}
+}
+
+// fetch downloads the image at rawURL into the cache and
+// returns its key and content type.
+func (c *iconCache) fetch(ctx context.Context, rawURL string) (key string, contentType string, err error) {
+	resp, err := (&redirects{transport: c.transport}).get(ctx, rawURL)
+	if err != nil {
+		return "", "", BadRequest{err.Error()}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", "", BadRequest{"Icon responded with " + resp.Status}
+	}
+
+	content, err := ioutil.ReadAll(io.LimitReader(resp.Body, c.maxSize+1))
+	if err != nil {
+		return "", "", BadRequest{err.Error()}
+	}
+
+	contentType = http.DetectContentType(content)
+	if !strings.HasPrefix(contentType, "image/") {
+		// Content sniffing does not recognize every image format, such as SVG.
+		contentType = strings.TrimSpace(strings.Split(resp.Header.Get("Content-Type"), ";")[0])
+		if !strings.HasPrefix(contentType, "image/") {
+			return "", "", BadRequest{"Icon is not an image"}
+		}
+	}
+
+	key, err = c.store(content)
+	return
+}
+
+// updateIcon looks up the icon of feed when it has none or its icon is old
+// enough. The image the feed links to is preferred over the site's favicon.
+func (s *Sync) updateIcon(ctx context.Context, feed *models.Feed, image string, now time.Time) {
+	if s.icons == nil {
+		return
+	}
+
+	if now.Before(feed.IconCheckedAt.Add(iconRefresh)) && (feed.Icon == "" || s.icons.has(feed.Icon)) {
+		return
+	}
+
+	feed.IconCheckedAt = now
+
+	for _, candidate := range iconCandidates(ctx, feed, image, s.hosts) {
+		key, contentType, err := s.icons.fetch(ctx, candidate)
+		if err != nil {
+			log.Debug("Skipping icon ", candidate, ": ", err)
+			continue
+		}
+
+		feed.ImageURL = candidate
+		feed.Icon = key
+		feed.IconType = contentType
+		return
+	}
+
+	if feed.Icon == "" || !s.icons.has(feed.Icon) {
+		feed.Icon = ""
+		feed.IconType = ""
+	}
+}
+
+// iconCandidates returns the URLs that may hold the icon of feed, in order
+// of preference: its image, the icons linked from its site and the site's
+// favicon.ico.
+func iconCandidates(ctx context.Context, feed *models.Feed, image string, transport http.RoundTripper) (candidates []string) {
+	if image == "" {
+		image = feed.ImageURL
+	}
+
+	if subscription, err := url.Parse(feed.Subscription); err == nil && image != "" {
+		if imageURL, err := subscription.Parse(image); err == nil {
+			candidates = append(candidates, imageURL.String())
+		}
+	}
+
+	site, err := url.Parse(feed.Source)
+	if err != nil || (site.Scheme != "http" && site.Scheme != "https") {
+		return
+	}
+
+	resp, err := (&redirects{transport: transport}).get(ctx, site.String())
+	if err == nil {
+		if resp.StatusCode == http.StatusOK {
+			for _, link := range pageLinks(io.LimitReader(resp.Body, maxIconPageSize), resp.Request.URL) {
+				if hasRel(link.rel, "icon") || hasRel(link.rel, "apple-touch-icon") {
+					candidates = append(candidates, link.href)
+				}
+			}
+		}
+
+		resp.Body.Close()
+	}
+
+	favicon := url.URL{Scheme: site.Scheme, Host: site.Host, Path: "/favicon.ico"}
+	return append(candidates, favicon.String())
+}
+
+// FeedIcon returns the cached icon of feed.
+func (s *Sync) FeedIcon(feed *models.Feed) (*os.File, os.FileInfo, error) {
+	if s.icons == nil {
+		return nil, nil, NotFound{"Feed has no icon"}
+	}
+
+	return s.icons.open(feed.Icon)
+}
@@ -0,0 +1,268 @@
+/*
+  Copyright (C) 2017 Jorge Martinez Hernandez
+
+  This program is free software: you can redistribute it and/or modify
+  it under the terms of the GNU Affero General Public License as published by
+  the Free Software Foundation, either version 3 of the License, or
+  (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU Affero General Public License for more details.
+
+  You should have received a copy of the GNU Affero General Public License
+  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package sync
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+	ext "github.com/mmcdole/gofeed/extensions"
+)
+
+// jsonFeedVersion prefixes the version URL of every JSON Feed document.
+const jsonFeedVersion = "https://jsonfeed.org/version/"
+
+var errNotJSONFeed = errors.New("Document is not a JSON Feed")
+
+type jsonFeed struct {
+	Version     string         `json:"version"`
+	Title       string         `json:"title"`
+	HomePageURL string         `json:"home_page_url"`
+	FeedURL     string         `json:"feed_url"`
+	Description string         `json:"description"`
+	Icon        string         `json:"icon"`
+	Favicon     string         `json:"favicon"`
+	Language    string         `json:"language"`
+	Author      *jsonAuthor    `json:"author"`
+	Authors     []jsonAuthor   `json:"authors"`
+	Hubs        []jsonHub      `json:"hubs"`
+	Items       []jsonFeedItem `json:"items"`
+}
+
+type jsonFeedItem struct {
+	ID            jsonID           `json:"id"`
+	URL           string           `json:"url"`
+	ExternalURL   string           `json:"external_url"`
+	Title         string           `json:"title"`
+	ContentHTML   string           `json:"content_html"`
+	ContentText   string           `json:"content_text"`
+	Summary       string           `json:"summary"`
+	Image         string           `json:"image"`
+	DatePublished string           `json:"date_published"`
+	DateModified  string           `json:"date_modified"`
+	Author        *jsonAuthor      `json:"author"`
+	Authors       []jsonAuthor     `json:"authors"`
+	Tags          []string         `json:"tags"`
+	Attachments   []jsonAttachment `json:"attachments"`
+}
+
+type jsonAuthor struct {
+	Name string `json:"name"`
+}
+
+type jsonHub struct {
+	Type string `json:"type"`
+	URL  string `json:"url"`
+}
+
+type jsonAttachment struct {
+	URL               string  `json:"url"`
+	MimeType          string  `json:"mime_type"`
+	Title             string  `json:"title"`
+	SizeInBytes       int64   `json:"size_in_bytes"`
+	DurationInSeconds float64 `json:"duration_in_seconds"`
+}
+
+// jsonID accepts item ids encoded as numbers, which the specification
+// forbids but which some publishers emit anyway.
+type jsonID string
+
+func (id *jsonID) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	switch value := value.(type) {
+	case string:
+		*id = jsonID(value)
+	case float64:
+		*id = jsonID(strconv.FormatFloat(value, 'f', -1, 64))
+	}
+
+	return nil
+}
+
+// isJSONFeed reports whether content looks like a JSON document
+// rather than XML.
+func isJSONFeed(content []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(content), []byte("{"))
+}
+
+// parseFeed parses an RSS, Atom or JSON Feed document, recording
+// the scheduling, hub and image hints found in it.
+func parseFeed(content []byte, hints *scheduleHints) (*gofeed.Feed, error) {
+	if isJSONFeed(content) {
+		return parseJSONFeed(content, hints)
+	}
+
+	fp := gofeed.NewParser()
+	fp.RSSTranslator = &rssTranslator{hints: hints}
+	fp.AtomTranslator = &atomTranslator{hints: hints}
+	return fp.Parse(bytes.NewReader(content))
+}
+
+// parseJSONFeed converts a JSON Feed 1.0 or 1.1 document into the
+// feed representation used for RSS and Atom.
+func parseJSONFeed(content []byte, hints *scheduleHints) (*gofeed.Feed, error) {
+	var doc jsonFeed
+	if err := json.Unmarshal(content, &doc); err != nil {
+		return nil, err
+	}
+
+	if !strings.HasPrefix(doc.Version, jsonFeedVersion) {
+		return nil, errNotJSONFeed
+	}
+
+	for _, hub := range doc.Hubs {
+		if strings.EqualFold(hub.Type, "websub") || strings.EqualFold(hub.Type, "pubsubhubbub") {
+			hints.hub.readLink("hub", hub.URL)
+		}
+	}
+
+	if len(doc.Hubs) > 0 {
+		hints.hub.readLink("self", doc.FeedURL)
+	}
+
+	feed := &gofeed.Feed{
+		Title:       doc.Title,
+		Description: doc.Description,
+		Link:        doc.HomePageURL,
+		FeedLink:    doc.FeedURL,
+		Language:    doc.Language,
+		Author:      jsonPerson(doc.Author, doc.Authors),
+		FeedType:    "json",
+		FeedVersion: strings.TrimPrefix(doc.Version, jsonFeedVersion),
+	}
+
+	if doc.Icon != "" {
+		feed.Image = &gofeed.Image{URL: doc.Icon}
+	} else if doc.Favicon != "" {
+		feed.Image = &gofeed.Image{URL: doc.Favicon}
+	}
+
+	for _, item := range doc.Items {
+		feed.Items = append(feed.Items, convertJSONFeedItem(item, feed.Author))
+	}
+
+	return feed, nil
+}
+
+func convertJSONFeedItem(item jsonFeedItem, feedAuthor *gofeed.Person) *gofeed.Item {
+	converted := &gofeed.Item{
+		GUID:        string(item.ID),
+		Title:       item.Title,
+		Link:        item.URL,
+		Description: item.Summary,
+		Content:     item.ContentHTML,
+		Published:   item.DatePublished,
+		Updated:     item.DateModified,
+		Author:      jsonPerson(item.Author, item.Authors),
+		Categories:  item.Tags,
+	}
+
+	if converted.Link == "" {
+		converted.Link = item.ExternalURL
+	}
+
+	if converted.Content == "" {
+		converted.Content = item.ContentText
+	}
+
+	if converted.Author == nil {
+		converted.Author = feedAuthor
+	}
+
+	if item.Image != "" {
+		converted.Image = &gofeed.Image{URL: item.Image}
+	}
+
+	if published, err := time.Parse(time.RFC3339, item.DatePublished); err == nil {
+		converted.PublishedParsed = &published
+	}
+
+	if updated, err := time.Parse(time.RFC3339, item.DateModified); err == nil {
+		converted.UpdatedParsed = &updated
+		if converted.PublishedParsed == nil {
+			converted.PublishedParsed = &updated
+		}
+	}
+
+	for _, attachment := range item.Attachments {
+		if attachment.URL == "" {
+			continue
+		}
+
+		enclosure := &gofeed.Enclosure{
+			URL:  attachment.URL,
+			Type: attachment.MimeType,
+		}
+
+		if attachment.SizeInBytes > 0 {
+			enclosure.Length = strconv.FormatInt(attachment.SizeInBytes, 10)
+		}
+
+		converted.Enclosures = append(converted.Enclosures, enclosure)
+
+		// Durations have no place in gofeed's enclosures, so they
+		// are carried the way media:content elements carry them.
+		if attachment.DurationInSeconds > 0 {
+			if converted.Extensions == nil {
+				converted.Extensions = ext.Extensions{"media": {}}
+			}
+
+			media := converted.Extensions["media"]
+			media["content"] = append(media["content"], ext.Extension{
+				Name: "content",
+				Attrs: map[string]string{
+					"url":      attachment.URL,
+					"duration": strconv.FormatFloat(attachment.DurationInSeconds, 'f', -1, 64),
+				},
+			})
+		}
+	}
+
+	// Items that only carry an attachment, like podcast episodes
+	// without a web page, link to their first attachment.
+	if converted.Link == "" && len(converted.Enclosures) > 0 {
+		converted.Link = converted.Enclosures[0].URL
+	}
+
+	return converted
+}
+
+// jsonPerson returns the first author of a JSON Feed 1.1 authors list,
+// falling back to the JSON Feed 1.0 author object.
+func jsonPerson(author *jsonAuthor, authors []jsonAuthor) *gofeed.Person {
+	for _, a := range authors {
+		if a.Name != "" {
+			return &gofeed.Person{Name: a.Name}
+		}
+	}
+
+	if author != nil && author.Name != "" {
+		return &gofeed.Person{Name: author.Name}
+	}
+
+	return nil
+}
@@ -0,0 +1,203 @@
+/*
+  Copyright (C) 2017 Jorge Martinez Hernandez
+
+  This program is free software: you can redistribute it and/or modify
+  it under the terms of the GNU Affero General Public License as published by
+  the Free Software Foundation, either version 3 of the License, or
+  (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU Affero General Public License for more details.
+
+  You should have received a copy of the GNU Affero General Public License
+  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package sync
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/varddum/syndication/database"
+	"github.com/varddum/syndication/models"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// queueSize bounds the queues between the stages of a pipeline.
+const queueSize = 64
+
+// job is a subscription going through a pipeline together with
+// the feeds subscribed to it that are due for a sync.
+type job struct {
+	subscribers []*database.Subscriber
+	result      *fetched
+}
+
+// update is what a sync of a single feed has to persist.
+type update struct {
+	feed    *models.Feed
+	user    *models.User
+	entries []models.Entry
+	err     error
+
+	// retry is set when the host of the feed asked to be retried later
+	// and cancelled when the sync was cancelled before it completed.
+	retry     bool
+	cancelled bool
+}
+
+// outcome counts the feeds a pipeline synced and the entries it stored.
+type outcome struct {
+	synced     int
+	failed     int
+	newEntries int
+}
+
+// pipeline syncs the feeds out of shared that are due for a sync.
+func (s *Sync) pipeline(ctx context.Context, shared []database.SharedFeed) outcome {
+	now := time.Now()
+
+	var jobs []*job
+	for i := range shared {
+		if subscribers := dueSubscribers(shared[i].Subscribers, now); len(subscribers) != 0 {
+			jobs = append(jobs, &job{subscribers: subscribers})
+		}
+	}
+
+	return s.run(ctx, jobs)
+}
+
+// run syncs jobs in four stages connected by bounded queues.
+// Up to maxThreads subscriptions are fetched at once and their content is
+// parsed on every CPU. The entries every subscriber doesn't have yet are
+// then found with a lookup per feed, and the updates are persisted by
+// the calling thread. Other runs, such as refreshes, may persist updates
+// of the same feeds at once, so save serializes the updates of each feed.
+// Once ctx is done, the subscriptions that weren't fetched yet are skipped.
+func (s *Sync) run(ctx context.Context, jobs []*job) (done outcome) {
+	if len(jobs) == 0 {
+		return
+	}
+
+	due := make(chan *job, queueSize)
+	fetchedJobs := make(chan *job, queueSize)
+	parsedJobs := make(chan *job, queueSize)
+	updates := make(chan update, queueSize)
+
+	go func() {
+		defer close(due)
+
+		for _, job := range jobs {
+			select {
+			case due <- job:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	fetchers := maxThreads
+	if len(jobs) < fetchers {
+		fetchers = len(jobs)
+	}
+
+	stage(fetchers, func() {
+		for job := range due {
+			feeds := make([]*models.Feed, 0, len(job.subscribers))
+			for _, subscriber := range job.subscribers {
+				feeds = append(feeds, &subscriber.Feed)
+			}
+
+			job.result = s.fetch(ctx, feeds...)
+			fetchedJobs <- job
+		}
+	}, func() { close(fetchedJobs) })
+
+	stage(runtime.NumCPU(), func() {
+		for job := range fetchedJobs {
+			job.result.parse()
+			parsedJobs <- job
+		}
+	}, func() { close(parsedJobs) })
+
+	stage(runtime.NumCPU(), func() {
+		for job := range parsedJobs {
+			// Only the first subscriber looks up the hub subscription and
+			// icon, which are then shared with the other subscribers.
+			for i, subscriber := range job.subscribers {
+				var first *models.Feed
+				if i > 0 {
+					first = &job.subscribers[0].Feed
+				}
+
+				updates <- s.check(ctx, job.result, &subscriber.Feed, &subscriber.User, first)
+			}
+		}
+	}, func() { close(updates) })
+
+	rules := s.newRuleCache()
+	for update := range updates {
+		stored, err := s.persist(update, rules)
+		switch {
+		case update.cancelled:
+		case err != nil:
+			log.Error(err)
+			done.failed++
+		default:
+			done.synced++
+			done.newEntries += stored
+		}
+	}
+
+	return
+}
+
+// stage runs work on threads threads and calls done once all of them returned.
+func stage(threads int, work func(), done func()) {
+	var wg sync.WaitGroup
+	wg.Add(threads)
+
+	for i := 0; i < threads; i++ {
+		go func() {
+			defer wg.Done()
+			work()
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		done()
+	}()
+}
+
+// dueSubscribers returns every subscriber of a subscription once the feed
+// of any of them is due for a sync at now. Subscribers keep schedules of
+// their own, so syncing them together keeps the subscription fetched once
+// even after their schedules drifted apart.
+func dueSubscribers(subscribers []database.Subscriber, now time.Time) []*database.Subscriber {
+	due := false
+	for i := range subscribers {
+		feed := &subscribers[i].Feed
+		if !feed.NextSync.After(now) && now.After(feed.LastUpdated.Add(time.Minute)) {
+			due = true
+			break
+		}
+	}
+
+	if !due {
+		return nil
+	}
+
+	all := make([]*database.Subscriber, 0, len(subscribers))
+	for i := range subscribers {
+		all = append(all, &subscribers[i])
+	}
+
+	return all
+}
@@ -0,0 +1,250 @@
+/*
+  Copyright (C) 2017 Jorge Martinez Hernandez
+
+  This program is free software: you can redistribute it and/or modify
+  it under the terms of the GNU Affero General Public License as published by
+  the Free Software Foundation, either version 3 of the License, or
+  (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU Affero General Public License for more details.
+
+  You should have received a copy of the GNU Affero General Public License
+  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package sync
+
+import (
+	"sync"
+	"time"
+
+	"github.com/varddum/syndication/database"
+	"github.com/varddum/syndication/models"
+)
+
+// Statuses of a RefreshJob.
+const (
+	RefreshQueued    = "queued"
+	RefreshRunning   = "running"
+	RefreshDone      = "done"
+	RefreshCancelled = "cancelled"
+)
+
+const (
+	// maxRunningRefreshes bounds the refreshes that run at once.
+	maxRunningRefreshes = 4
+
+	// refreshRetention is how long a finished refresh can be polled.
+	refreshRetention = time.Hour
+)
+
+// RefreshJob is a refresh of feeds that a user asked for. Refreshes
+// sync their feeds right away, whether they are due or not.
+type RefreshJob struct {
+	ID         string     `json:"id"`
+	Status     string     `json:"status"`
+	Feeds      int        `json:"feeds"`
+	Failed     int        `json:"failed"`
+	NewEntries int        `json:"new_entries"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+type refreshJob struct {
+	RefreshJob
+	userID uint
+
+	// feeds are the API IDs of the feeds the job syncs.
+	feeds []string
+}
+
+// refreshQueue keeps the refreshes of all users and limits users to
+// maxRefreshes refreshes within window. Each feed is synced by at most
+// one refresh at a time.
+type refreshQueue struct {
+	lock         sync.Mutex
+	jobs         map[string]*refreshJob
+	active       map[string]*refreshJob // by the API ID of the feeds they sync
+	started      map[uint][]time.Time
+	running      chan struct{}
+	maxRefreshes int
+	window       time.Duration
+}
+
+func newRefreshQueue(maxRefreshes int, window time.Duration) *refreshQueue {
+	return &refreshQueue{
+		jobs:         map[string]*refreshJob{},
+		active:       map[string]*refreshJob{},
+		started:      map[uint][]time.Time{},
+		running:      make(chan struct{}, maxRunningRefreshes),
+		maxRefreshes: maxRefreshes,
+		window:       window,
+	}
+}
+
+// RefreshFeed queues a refresh of the feed with feedID owned by user.
+func (s *Sync) RefreshFeed(feedID string, user *models.User) (RefreshJob, error) {
+	feed, err := s.db.Feed(feedID, user)
+	if err != nil {
+		return RefreshJob{}, err
+	}
+
+	return s.refresh([]models.Feed{feed}, user)
+}
+
+// RefreshCategory queues a refresh of the feeds in the category with categoryID owned by user.
+func (s *Sync) RefreshCategory(categoryID string, user *models.User) (RefreshJob, error) {
+	feeds, err := s.db.FeedsFromCategory(categoryID, user)
+	if err != nil {
+		return RefreshJob{}, err
+	}
+
+	return s.refresh(feeds, user)
+}
+
+// RefreshAll queues a refresh of every feed owned by user.
+func (s *Sync) RefreshAll(user *models.User) (RefreshJob, error) {
+	return s.refresh(s.db.Feeds(user), user)
+}
+
+// Refresh returns the refresh with id owned by user.
+func (s *Sync) Refresh(id string, user *models.User) (RefreshJob, error) {
+	q := s.refreshes
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	job, ok := q.jobs[id]
+	if !ok || job.userID != user.ID {
+		return RefreshJob{}, NotFound{"Refresh does not exist"}
+	}
+
+	return job.RefreshJob, nil
+}
+
+// refresh queues a refresh of the feeds that no other refresh syncs yet.
+// When another refresh already syncs all of feeds, the refresh of the
+// first one is returned instead of queueing another one.
+func (s *Sync) refresh(feeds []models.Feed, user *models.User) (RefreshJob, error) {
+	q := s.refreshes
+	now := time.Now()
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.prune(now)
+
+	var idle []models.Feed
+	for _, feed := range feeds {
+		if _, ok := q.active[feed.APIID]; !ok {
+			idle = append(idle, feed)
+		}
+	}
+
+	if len(feeds) != 0 && len(idle) == 0 {
+		return q.active[feeds[0].APIID].RefreshJob, nil
+	}
+
+	if len(q.started[user.ID]) >= q.maxRefreshes {
+		retryIn := q.started[user.ID][0].Add(q.window).Sub(now)
+		return RefreshJob{}, TooManyRequests{"Too many refreshes, retry in " + retryIn.Round(time.Second).String()}
+	}
+
+	id, err := randomToken()
+	if err != nil {
+		return RefreshJob{}, err
+	}
+
+	refresh := &refreshJob{
+		RefreshJob: RefreshJob{
+			ID:        id,
+			Status:    RefreshQueued,
+			Feeds:     len(idle),
+			CreatedAt: now,
+		},
+		userID: user.ID,
+	}
+
+	q.jobs[id] = refresh
+	q.started[user.ID] = append(q.started[user.ID], now)
+
+	jobs := make([]*job, 0, len(idle))
+	for _, feed := range idle {
+		q.active[feed.APIID] = refresh
+		refresh.feeds = append(refresh.feeds, feed.APIID)
+		jobs = append(jobs, &job{subscribers: []*database.Subscriber{{Feed: feed, User: *user}}})
+	}
+
+	s.waitGroup.Add(1)
+	go func() {
+		defer s.waitGroup.Done()
+		s.runRefresh(refresh, jobs)
+	}()
+
+	return refresh.RefreshJob, nil
+}
+
+// runRefresh runs a refresh once fewer than maxRunningRefreshes are running.
+func (s *Sync) runRefresh(refresh *refreshJob, jobs []*job) {
+	q := s.refreshes
+
+	select {
+	case q.running <- struct{}{}:
+		defer func() { <-q.running }()
+		q.update(func() { refresh.Status = RefreshRunning })
+	case <-s.ctx.Done():
+	}
+
+	var done outcome
+	if s.ctx.Err() == nil {
+		done = s.run(s.ctx, jobs)
+	}
+
+	q.update(func() {
+		refresh.Status = RefreshDone
+		if s.ctx.Err() != nil {
+			refresh.Status = RefreshCancelled
+		}
+
+		refresh.Failed = done.failed
+		refresh.NewEntries = done.newEntries
+
+		now := time.Now()
+		refresh.FinishedAt = &now
+		for _, feedID := range refresh.feeds {
+			delete(q.active, feedID)
+		}
+	})
+}
+
+// update changes a refresh while no one else looks at it.
+func (q *refreshQueue) update(change func()) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	change()
+}
+
+// prune forgets the refreshes that finished more than refreshRetention ago
+// and the refreshes that no longer count towards the limits of users.
+func (q *refreshQueue) prune(now time.Time) {
+	for id, job := range q.jobs {
+		if job.FinishedAt != nil && now.Sub(*job.FinishedAt) > refreshRetention {
+			delete(q.jobs, id)
+		}
+	}
+
+	for userID, started := range q.started {
+		for len(started) != 0 && now.Sub(started[0]) >= q.window {
+			started = started[1:]
+		}
+
+		if len(started) == 0 {
+			delete(q.started, userID)
+		} else {
+			q.started[userID] = started
+		}
+	}
+}
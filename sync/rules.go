@@ -0,0 +1,230 @@
+/*
+  Copyright (C) 2017 Jorge Martinez Hernandez
+
+  This program is free software: you can redistribute it and/or modify
+  it under the terms of the GNU Affero General Public License as published by
+  the Free Software Foundation, either version 3 of the License, or
+  (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU Affero General Public License for more details.
+
+  You should have received a copy of the GNU Affero General Public License
+  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package sync
+
+import (
+	"regexp"
+
+	"github.com/varddum/syndication/models"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// compiledRule is a Rule with its references resolved so it can be
+// matched against Entries that have not been saved yet.
+type compiledRule struct {
+	models.Rule
+
+	pattern    *regexp.Regexp
+	categoryID uint
+	marker     models.Marker
+	tag        models.Tag
+}
+
+// compileRule resolves the Category and Tag rule refers to and compiles its pattern.
+func (s *Sync) compileRule(rule models.Rule, user *models.User) (compiled compiledRule, err error) {
+	compiled.Rule = rule
+
+	compiled.pattern, err = regexp.Compile(rule.Pattern)
+	if err != nil {
+		err = BadRequest{"Rule pattern is not a valid regular expression"}
+		return
+	}
+
+	if rule.CategoryID != "" {
+		ctg, ctgErr := s.db.Category(rule.CategoryID, user)
+		if ctgErr != nil {
+			err = ctgErr
+			return
+		}
+
+		compiled.categoryID = ctg.ID
+	}
+
+	switch rule.Action {
+	case models.MarkAction:
+		compiled.marker = models.MarkerFromString(rule.Value)
+	case models.TagAction:
+		compiled.tag, err = s.db.Tag(rule.Value, user)
+	}
+
+	return
+}
+
+// rules returns the compiled Rules of user. Rules that refer to a
+// deleted Category or Tag are skipped.
+func (s *Sync) rules(user *models.User) (rules []compiledRule) {
+	for _, rule := range s.db.Rules(user) {
+		compiled, err := s.compileRule(rule, user)
+		if err != nil {
+			log.Warn("Skipping rule ", rule.APIID, ": ", err)
+			continue
+		}
+
+		rules = append(rules, compiled)
+	}
+
+	return
+}
+
+// ruleCache keeps the compiled Rules of the users a run stores entries
+// for, so that they are loaded and compiled once per run instead of once
+// per feed. The Rules of a user are compiled again once they were edited.
+type ruleCache struct {
+	sync  *Sync
+	rules map[uint]cachedRules
+}
+
+type cachedRules struct {
+	version int
+	rules   []compiledRule
+}
+
+func (s *Sync) newRuleCache() *ruleCache {
+	return &ruleCache{
+		sync:  s,
+		rules: map[uint]cachedRules{},
+	}
+}
+
+// get returns the compiled Rules of user.
+func (c *ruleCache) get(user *models.User) []compiledRule {
+	version := c.sync.rulesVersion(user)
+	if cached, ok := c.rules[user.ID]; ok && cached.version == version {
+		return cached.rules
+	}
+
+	rules := c.sync.rules(user)
+	c.rules[user.ID] = cachedRules{version: version, rules: rules}
+	return rules
+}
+
+// RulesEdited makes runs compile the Rules of user again. It should be
+// called whenever a Rule of user, or a Category or Tag they refer to, changes.
+func (s *Sync) RulesEdited(user *models.User) {
+	s.ruleVersionsLock.Lock()
+	defer s.ruleVersionsLock.Unlock()
+
+	s.ruleVersions[user.ID]++
+}
+
+func (s *Sync) rulesVersion(user *models.User) int {
+	s.ruleVersionsLock.Lock()
+	defer s.ruleVersionsLock.Unlock()
+
+	return s.ruleVersions[user.ID]
+}
+
+// matches reports whether entry, received from feed, is matched by r.
+func (r compiledRule) matches(feed *models.Feed, entry *models.Entry) bool {
+	if r.FeedID != "" && r.FeedID != feed.APIID {
+		return false
+	}
+
+	if r.CategoryID != "" && r.categoryID != feed.CategoryID {
+		return false
+	}
+
+	switch r.Field {
+	case models.TitleField:
+		return r.pattern.MatchString(entry.Title)
+	case models.AuthorField:
+		return r.pattern.MatchString(entry.Author)
+	case models.ContentField:
+		return r.pattern.MatchString(entry.Content) || r.pattern.MatchString(entry.Summary)
+	case models.LinkField:
+		return r.pattern.MatchString(entry.Link)
+	}
+
+	return true
+}
+
+// apply applies the action of r to entry and reports whether entry should be kept.
+func (r compiledRule) apply(entry *models.Entry) bool {
+	switch r.Action {
+	case models.MarkAction:
+		entry.Mark = r.marker
+	case models.SaveAction:
+		entry.Saved = true
+	case models.TagAction:
+		entry.Tags = append(entry.Tags, r.tag)
+	case models.DiscardAction:
+		return false
+	}
+
+	return true
+}
+
+// applyRules applies rules, in order, to the entries received from feed
+// and returns the entries that were not discarded.
+func applyRules(rules []compiledRule, feed *models.Feed, entries []models.Entry) []models.Entry {
+	if len(rules) == 0 {
+		return entries
+	}
+
+	kept := entries[:0]
+	for _, entry := range entries {
+		keep := true
+		for _, rule := range rules {
+			if rule.matches(feed, &entry) && !rule.apply(&entry) {
+				keep = false
+				break
+			}
+		}
+
+		if keep {
+			kept = append(kept, entry)
+		}
+	}
+
+	return kept
+}
+
+// DryRunRule returns the Entries owned by user that the Rule with
+// ruleID would have matched had they been received by a sync.
+func (s *Sync) DryRunRule(ruleID string, user *models.User) ([]models.Entry, error) {
+	rule, err := s.db.Rule(ruleID, user)
+	if err != nil {
+		return nil, err
+	}
+
+	compiled, err := s.compileRule(rule, user)
+	if err != nil {
+		return nil, err
+	}
+
+	feeds := map[uint]models.Feed{}
+	for _, feed := range s.db.Feeds(user) {
+		feeds[feed.ID] = feed
+	}
+
+	entries, err := s.db.Entries(true, models.Any, user)
+	if err != nil {
+		return nil, err
+	}
+
+	matched := []models.Entry{}
+	for _, entry := range entries {
+		feed := feeds[entry.FeedID]
+		if compiled.matches(&feed, &entry) {
+			matched = append(matched, entry)
+		}
+	}
+
+	return matched, nil
+}
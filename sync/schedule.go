@@ -0,0 +1,224 @@
+/*
+  Copyright (C) 2017 Jorge Martinez Hernandez
+
+  This program is free software: you can redistribute it and/or modify
+  it under the terms of the GNU Affero General Public License as published by
+  the Free Software Foundation, either version 3 of the License, or
+  (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU Affero General Public License for more details.
+
+  You should have received a copy of the GNU Affero General Public License
+  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package sync
+
+import (
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/varddum/syndication/models"
+
+	"github.com/mmcdole/gofeed"
+	"github.com/mmcdole/gofeed/atom"
+	"github.com/mmcdole/gofeed/rss"
+)
+
+// deadBackoff is how many times the maximum sync interval
+// a dead feed waits between syncs.
+const deadBackoff = 7
+
+// scheduleHints collects what a feed and the response it was served
+// with say about how long it should be left alone before the next fetch.
+type scheduleHints struct {
+	ttl       time.Duration
+	expires   time.Time
+	skipHours map[int]bool
+	skipDays  map[time.Weekday]bool
+
+	// hub is the WebSub hub the feed advertises. Feeds that are
+	// pushed by a hub only need to be polled occasionally.
+	hub hubLinks
+
+	// image is the URL of the image the feed links to, which is
+	// preferred over the site's favicon as the feed's icon.
+	image string
+}
+
+// rssTranslator wraps gofeed's default RSS translator to capture
+// the RSS elements related to scheduling, which gofeed drops.
+type rssTranslator struct {
+	gofeed.DefaultRSSTranslator
+	hints *scheduleHints
+}
+
+func newScheduleHints() scheduleHints {
+	return scheduleHints{
+		skipHours: map[int]bool{},
+		skipDays:  map[time.Weekday]bool{},
+	}
+}
+
+// atomTranslator wraps gofeed's default Atom translator to capture
+// the link relations of an Atom feed, which gofeed drops.
+type atomTranslator struct {
+	gofeed.DefaultAtomTranslator
+	hints *scheduleHints
+}
+
+// Translate records the ttl, skipHours and skipDays of an RSS feed
+// before translating it with the default translator.
+func (t *rssTranslator) Translate(feed interface{}) (*gofeed.Feed, error) {
+	if rssFeed, ok := feed.(*rss.Feed); ok {
+		t.hints.readRSS(rssFeed)
+		t.hints.hub.readRSS(rssFeed)
+	}
+
+	return t.DefaultRSSTranslator.Translate(feed)
+}
+
+// Translate records the hub links of an Atom feed
+// before translating it with the default translator.
+func (t *atomTranslator) Translate(feed interface{}) (*gofeed.Feed, error) {
+	if atomFeed, ok := feed.(*atom.Feed); ok {
+		t.hints.hub.readAtom(atomFeed)
+	}
+
+	return t.DefaultAtomTranslator.Translate(feed)
+}
+
+func (h *scheduleHints) readRSS(feed *rss.Feed) {
+	if ttl, err := strconv.Atoi(strings.TrimSpace(feed.TTL)); err == nil && ttl > 0 {
+		h.ttl = time.Duration(ttl) * time.Minute
+	}
+
+	for _, hour := range feed.SkipHours {
+		if value, err := strconv.Atoi(strings.TrimSpace(hour)); err == nil && value >= 0 && value < 24 {
+			h.skipHours[value] = true
+		}
+	}
+
+	for _, day := range feed.SkipDays {
+		for weekday := time.Sunday; weekday <= time.Saturday; weekday++ {
+			if strings.EqualFold(strings.TrimSpace(day), weekday.String()) {
+				h.skipDays[weekday] = true
+			}
+		}
+	}
+}
+
+// readResponse records when a response expires according to its
+// Cache-Control max-age directive or, if there is none, its Expires header.
+func (h *scheduleHints) readResponse(resp *http.Response, now time.Time) {
+	for _, directive := range strings.Split(resp.Header.Get("Cache-Control"), ",") {
+		directive = strings.ToLower(strings.TrimSpace(directive))
+		if !strings.HasPrefix(directive, "max-age=") {
+			continue
+		}
+
+		if seconds, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age=")); err == nil && seconds > 0 {
+			h.expires = now.Add(time.Duration(seconds) * time.Second)
+			return
+		}
+	}
+
+	if expires, err := http.ParseTime(resp.Header.Get("Expires")); err == nil {
+		h.expires = expires
+	}
+}
+
+// skip moves next forward to the start of the first hour that
+// is not excluded by the feed's skipHours and skipDays.
+func (h scheduleHints) skip(next time.Time) time.Time {
+	for i := 0; i < 24*7; i++ {
+		utc := next.UTC()
+		if !h.skipHours[utc.Hour()] && !h.skipDays[utc.Weekday()] {
+			break
+		}
+
+		next = next.Truncate(time.Hour).Add(time.Hour)
+	}
+
+	return next
+}
+
+// schedule sets when feed should be synced next. The feed's polling interval
+// shrinks when a sync finds new entries and grows when it does not, within the
+// configured bounds. The next sync is never earlier than the feed's TTL or the
+// expiry of its last response allow. Feeds pushed by a WebSub hub are only
+// polled at the maximum interval to notice when the hub stops delivering,
+// or earlier when their lease has to be renewed before then.
+func (s *Sync) schedule(feed *models.Feed, hints scheduleHints, newEntries int, now time.Time) {
+	if feed.Status == models.Dead {
+		wait := s.maxInterval * deadBackoff
+		feed.NextSync = now.Add(wait + jitter(wait))
+		return
+	}
+
+	if pushed(feed, now) {
+		feed.NextSync = now.Add(s.maxInterval + jitter(s.maxInterval))
+
+		// Once its renewal is due, the feed is polled again
+		// when the lease expires at the latest.
+		renewAt := feed.HubLeaseRenews
+		if !renewAt.After(now) {
+			renewAt = feed.HubLeaseExpires
+		}
+
+		if renewAt.Before(feed.NextSync) {
+			feed.NextSync = renewAt
+		}
+		return
+	}
+
+	interval := feed.SyncInterval
+	if interval == 0 {
+		interval = s.interval
+	}
+
+	if newEntries > 0 {
+		interval /= 2
+	} else {
+		interval = interval * 3 / 2
+	}
+
+	if interval < s.minInterval {
+		interval = s.minInterval
+	} else if interval > s.maxInterval {
+		interval = s.maxInterval
+	}
+
+	feed.SyncInterval = interval
+
+	if hints.ttl > 0 {
+		feed.TTL = int(hints.ttl / time.Minute)
+	}
+
+	wait := interval
+	if hints.ttl > wait {
+		wait = hints.ttl
+	}
+
+	if until := hints.expires.Sub(now); until > wait {
+		wait = until
+	}
+
+	if wait > s.maxInterval {
+		wait = s.maxInterval
+	}
+
+	feed.NextSync = hints.skip(now.Add(wait + jitter(wait)))
+}
+
+// jitter spreads feeds that share an interval so they
+// do not all come due on the same tick.
+func jitter(wait time.Duration) time.Duration {
+	return time.Duration(rand.Int63n(int64(wait)/10 + 1))
+}
@@ -18,9 +18,12 @@
 package sync
 
 import (
+	"context"
 	"crypto/md5"
 	"net/http"
+	"net/url"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/varddum/syndication/config"
@@ -33,9 +36,52 @@ import (
 
 const maxThreads = 100
 
-type userPool struct {
-	users []models.User
-	lock  sync.Mutex
+const maxRedirects = 10
+
+var (
+	defaultDeadAfter     = config.DefaultSyncConfig.DeadAfter
+	defaultPurgeInterval = config.DefaultSyncConfig.PurgeInterval.Duration
+	defaultIconMaxSize   = config.DefaultSyncConfig.IconMaxSize
+
+	defaultMaxRequestsPerHost = config.DefaultSyncConfig.MaxRequestsPerHost
+	defaultMaxRetryAfter      = config.DefaultSyncConfig.MaxRetryAfter.Duration
+
+	defaultConnectTimeout = config.DefaultSyncConfig.ConnectTimeout.Duration
+	defaultReadTimeout    = config.DefaultSyncConfig.ReadTimeout.Duration
+	defaultMaxFeedSize    = config.DefaultSyncConfig.MaxFeedSize
+	defaultStopTimeout    = config.DefaultSyncConfig.StopTimeout.Duration
+
+	defaultMaxRefreshes  = config.DefaultSyncConfig.MaxRefreshes
+	defaultRefreshWindow = config.DefaultSyncConfig.RefreshWindow.Duration
+
+	// defaultTransport is the transport of fetches made outside of a Sync.
+	defaultTransport = newTransport(defaultConnectTimeout)
+)
+
+// fetched is the outcome of a single fetch of a subscription, which
+// is applied to the feed of every user subscribed to it.
+type fetched struct {
+	err         error
+	statusCode  int
+	redirects   *redirects
+	notModified bool
+	cancelled   bool
+
+	etag          string
+	lastModified  string
+	contentLength int64
+
+	// location is where the content was served from once redirects were followed.
+	location *url.URL
+
+	hints scheduleHints
+
+	// body is the content of a successful fetch until it is parsed
+	// into feed, which stays nil if the content could not be parsed
+	// and parseErr says why.
+	body     []byte
+	feed     *gofeed.Feed
+	parseErr error
 }
 
 const (
@@ -60,6 +106,18 @@ type (
 	BadRequest struct {
 		msg string
 	}
+
+	// NotFound is a SyncError returned when a resource kept by the sync
+	// component, such as a feed icon, does not exist.
+	NotFound struct {
+		msg string
+	}
+
+	// TooManyRequests is a SyncError returned when a user asked
+	// for more refreshes than they are allowed to for a while.
+	TooManyRequests struct {
+		msg string
+	}
 )
 
 func (e BadRequest) Error() string {
@@ -75,102 +133,368 @@ func (e BadRequest) Code() int {
 	return 400
 }
 
+func (e NotFound) Error() string {
+	return e.msg
+}
+
+func (e NotFound) String() string {
+	return "Not Found"
+}
+
+// Code returns NotFound's corresponding error code
+func (e NotFound) Code() int {
+	return 404
+}
+
+func (e TooManyRequests) Error() string {
+	return e.msg
+}
+
+func (e TooManyRequests) String() string {
+	return "Too Many Requests"
+}
+
+// Code returns TooManyRequests's corresponding error code
+func (e TooManyRequests) Code() int {
+	return 429
+}
+
+// Stats summarizes the fetches made by a Sync since it was created.
+type Stats struct {
+	Fetches       int64 `json:"fetches"`
+	NotModified   int64 `json:"notModified"`
+	BytesReceived int64 `json:"bytesReceived"`
+	BytesSaved    int64 `json:"bytesSaved"`
+}
+
 // Sync represents a syncing worker.
 type Sync struct {
 	ticker        *time.Ticker
+	purgeTicker   *time.Ticker
 	db            *database.DB
-	userPool      userPool
-	userWaitGroup sync.WaitGroup
+	waitGroup     sync.WaitGroup
+	running       int32
 	status        chan syncStatus
 	interval      time.Duration
-	dbLock        sync.Mutex
+	minInterval   time.Duration
+	maxInterval   time.Duration
+	deadAfter     int
+	retention     models.RetentionPolicy
+	purgeInterval time.Duration
+	callbackURL   string
+	icons         *iconCache
+	hosts         *hostLimiter
+	stats         Stats
+	statsLock     sync.Mutex
+	maxFeedSize   int64
+	stopTimeout   time.Duration
+	refreshes     *refreshQueue
+
+	ruleVersions     map[uint]int
+	ruleVersionsLock sync.Mutex
+
+	// feedLocks serialize storing the syncs of each feed since the
+	// scheduler and refreshes may sync the same feed at once. Locks
+	// are only kept while someone holds or waits for them.
+	feedLocks     map[string]*feedLock
+	feedLocksLock sync.Mutex
+
+	// ctx is cancelled when the Sync stops.
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
-func (p *userPool) get() models.User {
-	p.lock.Lock()
-	defer p.lock.Unlock()
+// redirects follows up to maxRedirects redirects for a single fetch and
+// remembers where a chain made only of permanent redirects ended.
+type redirects struct {
+	followed  int
+	permanent bool
+	location  string
+	transport http.RoundTripper
+}
 
-	if len(p.users) == 0 {
-		return models.User{}
+// client returns a client that follows redirects with r. Without a
+// transport, the client uses the default connect and read timeouts.
+func (r *redirects) client() *http.Client {
+	r.permanent = true
+	if r.transport == nil {
+		return &http.Client{CheckRedirect: r.check, Transport: defaultTransport, Timeout: defaultReadTimeout}
 	}
 
-	user := p.users[0]
-	p.users = p.users[1:]
-	return user
+	return &http.Client{CheckRedirect: r.check, Transport: r.transport}
 }
 
-func (p *userPool) put(user models.User) {
-	p.lock.Lock()
-	defer p.lock.Unlock()
+// get requests rawURL until ctx is done, following redirects with r.
+func (r *redirects) get(ctx context.Context, rawURL string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
 
-	p.users = append(p.users, user)
+	return r.client().Do(req.WithContext(ctx))
 }
 
-func (s *Sync) checkForUpdates(feed *models.Feed, user *models.User) ([]models.Entry, error) {
-	client := &http.Client{
-		CheckRedirect: (func(r *http.Request, v []*http.Request) error { return http.ErrUseLastResponse }),
+func (r *redirects) check(req *http.Request, via []*http.Request) error {
+	if len(via) >= maxRedirects {
+		return BadRequest{"Feed redirected too many times"}
+	}
+
+	switch req.Response.StatusCode {
+	case http.StatusMovedPermanently, http.StatusPermanentRedirect:
+	default:
+		r.permanent = false
+	}
+
+	r.followed++
+	r.location = req.URL.String()
+	return nil
+}
+
+// update points feed at its new location if it was permanently moved.
+func (r *redirects) update(feed *models.Feed) {
+	if r.followed == 0 || !r.permanent || r.location == feed.Subscription {
+		return
+	}
+
+	feed.Status = "Moved permanently from " + feed.Subscription
+	feed.Subscription = r.location
+}
+
+// fetch fetches the subscription of feeds, which all share it. The
+// request is conditional only when every feed saw the same response last.
+func (s *Sync) fetch(ctx context.Context, feeds ...*models.Feed) *fetched {
+	result := &fetched{hints: newScheduleHints()}
+
+	feed := feeds[0]
+	etag, lastModified := feed.Etag, feed.LastModified
+	for _, other := range feeds[1:] {
+		if other.Etag != etag || other.LastModified != lastModified {
+			etag, lastModified = "", ""
+			break
+		}
 	}
 
 	req, err := http.NewRequest("GET", feed.Subscription, nil)
 	if err != nil {
-		return nil, err
+		result.err = err
+		return result
+	}
+	req = req.WithContext(ctx)
+
+	if etag != "" {
+		req.Header.Add("If-None-Match", etag)
 	}
 
-	if feed.Etag != "" {
-		req.Header.Add("If-None-Match", feed.Etag)
+	if lastModified != "" {
+		req.Header.Add("If-Modified-Since", lastModified)
 	}
 
-	resp, err := client.Do(req)
+	result.redirects = &redirects{transport: s.hosts}
+	resp, err := result.redirects.client().Do(req)
 	if err != nil {
-		return nil, BadRequest{err.Error()}
+		if retry, ok := unwrapRetryLater(err); ok {
+			result.err = retry
+			return result
+		}
+
+		result.fail(ctx, err)
+		return result
 	}
 
-	fp := gofeed.NewParser()
-	fetchedFeed, err := fp.Parse(resp.Body)
+	result.statusCode = resp.StatusCode
+	result.location = resp.Request.URL
+	result.hints.readResponse(resp, time.Now())
 
-	if err != nil {
-		// If there was an error by the feed parser and the
-		// content length is zero or less, it implies that
-		// we got an empty request so we swallow the error.
-		if req.ContentLength <= 0 {
-			return nil, nil
+	if resp.StatusCode >= http.StatusBadRequest {
+		err = resp.Body.Close()
+		if err != nil {
+			log.Error(err)
 		}
 
+		// Hosts that asked to be retried later are not failing.
+		if err := s.hosts.blocked(resp.Request.URL.Host, time.Now()); err != nil {
+			result.err = err
+			return result
+		}
+
+		result.err = BadRequest{"Feed responded with " + resp.Status}
+		return result
+	}
+
+	if resp.StatusCode == http.StatusNotModified {
+		s.recordNotModified(feed.ContentLength)
+
+		err = resp.Body.Close()
+		if err != nil {
+			log.Error(err)
+		}
+
+		result.notModified = true
+		return result
+	}
+
+	body, err := readBody(resp.Body, s.maxFeedSize)
+	if closeErr := resp.Body.Close(); closeErr != nil {
+		log.Error(closeErr)
+	}
+
+	if err != nil {
+		result.fail(ctx, err)
+		return result
+	}
+
+	s.recordFetch(int64(len(body)))
+
+	result.etag = resp.Header.Get("ETag")
+	result.lastModified = resp.Header.Get("Last-Modified")
+	result.contentLength = int64(len(body))
+
+	result.hints.hub.readHeader(resp.Header)
+	result.body = body
+
+	return result
+}
+
+// fail records the error a fetch failed with. Fetches that failed because
+// ctx is done are cancelled instead, which doesn't make feeds any less healthy.
+func (r *fetched) fail(ctx context.Context, err error) {
+	if ctx.Err() != nil {
+		r.err = ctx.Err()
+		r.cancelled = true
+		return
+	}
+
+	if _, ok := err.(BadRequest); ok {
+		r.err = err
+		return
+	}
+
+	r.err = BadRequest{err.Error()}
+}
+
+// parse parses the body of a successful fetch. Content the
+// parser can't read is recorded as a failure of the fetch.
+func (r *fetched) parse() {
+	if r.body == nil {
+		return
+	}
+
+	feed, err := parseContent(r.body, &r.hints)
+	if err != nil {
+		r.parseErr = BadRequest{"Could not parse feed: " + err.Error()}
+	}
+
+	r.feed = feed
+	r.body = nil
+}
+
+// checkForUpdates applies result to feed and returns the entries
+// of the fetched feed that user does not have yet.
+func (s *Sync) checkForUpdates(result *fetched, feed *models.Feed, user *models.User) ([]models.Entry, error) {
+	if _, ok := result.err.(retryLater); ok && result.statusCode == 0 {
+		return nil, result.err
+	}
+
+	feed.LastStatusCode = result.statusCode
+	if result.err != nil {
+		return nil, result.err
+	}
+
+	// Feeds only move to where they were redirected once a feed was found there.
+	if result.redirects != nil && (result.notModified || result.feed != nil) {
+		result.redirects.update(feed)
+	}
+
+	if result.notModified {
+		return nil, nil
+	}
+
+	if result.parseErr != nil {
+		return nil, result.parseErr
+	}
+
+	feed.Etag = result.etag
+	feed.LastModified = result.lastModified
+	feed.ContentLength = result.contentLength
+
+	if result.feed == nil {
+		return nil, nil
+	}
+
+	return s.newEntries(feed, user, result.feed), nil
+}
+
+// parseContent parses the content of a feed and records what it says
+// about the feed in hints. Items without a GUID are given one.
+func parseContent(content []byte, hints *scheduleHints) (*gofeed.Feed, error) {
+	fetchedFeed, err := parseFeed(content, hints)
+	if err != nil {
 		return nil, err
 	}
 
+	hints.hub.parsed = true
+
 	if fetchedFeed == nil {
 		return nil, nil
 	}
 
+	if fetchedFeed.Image != nil {
+		hints.image = fetchedFeed.Image.URL
+	}
+
+	for _, item := range fetchedFeed.Items {
+		if item.GUID == "" {
+			itemHash := md5.Sum([]byte(item.Title + item.Link))
+			item.GUID = string(itemHash[:md5.Size])
+		}
+	}
+
+	return fetchedFeed, nil
+}
+
+// parseEntries parses the content of feed and returns the entries
+// in it that are not stored yet.
+func (s *Sync) parseEntries(feed *models.Feed, user *models.User, content []byte, hints *scheduleHints) ([]models.Entry, error) {
+	fetchedFeed, err := parseContent(content, hints)
+	if err != nil || fetchedFeed == nil {
+		return nil, err
+	}
+
+	return s.newEntries(feed, user, fetchedFeed), nil
+}
+
+// newEntries returns the entries of fetchedFeed that user does not have
+// yet and updates the metadata of feed from it.
+func (s *Sync) newEntries(feed *models.Feed, user *models.User, fetchedFeed *gofeed.Feed) []models.Entry {
 	if fetchedFeed.UpdatedParsed != nil {
 		if !fetchedFeed.UpdatedParsed.After(feed.LastUpdated) {
-			return nil, nil
+			return nil
 		}
 	}
 
 	if fetchedFeed.Items == nil || len(fetchedFeed.Items) == 0 {
-		return nil, nil
+		return nil
 	}
 
-	var entries []models.Entry
+	guids := make([]string, 0, len(fetchedFeed.Items))
 	for _, item := range fetchedFeed.Items {
-		var itemGUID string
-		if item.GUID != "" {
-			itemGUID = item.GUID
-		} else {
-			itemHash := md5.Sum([]byte(item.Title + item.Link))
-			itemGUID = string(itemHash[:md5.Size])
-			item.GUID = itemGUID
-		}
+		guids = append(guids, item.GUID)
+	}
+
+	found, err := s.db.EntryGUIDs(guids, feed.APIID, user)
+	if err != nil {
+		log.Error(err)
+		return nil
+	}
 
-		s.dbLock.Lock()
-		if found, err := s.db.EntryWithGUIDExists(itemGUID, feed.APIID, user); err != nil || found {
-			s.dbLock.Unlock()
+	var entries []models.Entry
+	for _, item := range fetchedFeed.Items {
+		if found[item.GUID] {
 			continue
 		}
-		s.dbLock.Unlock()
 
+		// Feeds sometimes repeat an item, which is only stored once.
+		found[item.GUID] = true
 		entries = append(entries, convertItemsToEntries(*feed, item))
 	}
 
@@ -179,20 +503,46 @@ func (s *Sync) checkForUpdates(feed *models.Feed, user *models.User) ([]models.E
 	feed.Source = fetchedFeed.Link
 	feed.LastUpdated = time.Now()
 
-	err = resp.Body.Close()
-	if err != nil {
-		log.Error(err)
-	}
+	return entries
+}
+
+func (s *Sync) recordFetch(received int64) {
+	s.statsLock.Lock()
+	defer s.statsLock.Unlock()
+
+	s.stats.Fetches++
+	s.stats.BytesReceived += received
+}
+
+// recordNotModified counts a 304 response, assuming it saved
+// as many bytes as the last full response for the feed.
+func (s *Sync) recordNotModified(saved int64) {
+	s.statsLock.Lock()
+	defer s.statsLock.Unlock()
+
+	s.stats.Fetches++
+	s.stats.NotModified++
+	s.stats.BytesSaved += saved
+}
+
+// Stats returns the fetch statistics collected since the Sync was created.
+func (s *Sync) Stats() Stats {
+	s.statsLock.Lock()
+	defer s.statsLock.Unlock()
 
-	return entries, nil
+	return s.stats
 }
 
 func convertItemsToEntries(feed models.Feed, item *gofeed.Item) models.Entry {
 	entry := models.Entry{
-		Title: item.Title,
-		Link:  item.Link,
-		GUID:  item.GUID,
-		Mark:  models.Unread,
+		Title:   item.Title,
+		Link:    item.Link,
+		GUID:    item.GUID,
+		Summary: item.Description,
+		Content: item.Content,
+		Mark:    models.Unread,
+
+		Enclosures: convertEnclosures(item),
 	}
 
 	if item.Author != nil {
@@ -209,117 +559,290 @@ func convertItemsToEntries(feed models.Feed, item *gofeed.Item) models.Entry {
 }
 
 // FetchFeed fetches a feed and populates a Feed model.
-func FetchFeed(feed *models.Feed) error {
-	client := &http.Client{
-		CheckRedirect: (func(r *http.Request, v []*http.Request) error { return http.ErrUseLastResponse }),
+func FetchFeed(ctx context.Context, feed *models.Feed) error {
+	fetcher := &Sync{
+		hosts:       newHostLimiter(defaultMaxRequestsPerHost, 0, defaultMaxRetryAfter),
+		maxFeedSize: defaultMaxFeedSize,
 	}
-	req, err := http.NewRequest("GET", feed.Subscription, nil)
-	if err != nil {
-		return err
+
+	result := fetcher.fetch(ctx, feed)
+	if result.err != nil {
+		return result.err
 	}
 
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
+	result.parse()
+	if result.parseErr != nil {
+		return result.parseErr
 	}
 
-	fp := gofeed.NewParser()
-	fetchedFeed, err := fp.Parse(resp.Body)
-	if err != nil {
-		return err
+	if result.feed == nil {
+		return nil
 	}
 
+	result.redirects.update(feed)
+
 	if feed.Title == "" {
-		feed.Title = fetchedFeed.Title
+		feed.Title = result.feed.Title
 	}
 
-	feed.Description = fetchedFeed.Description
-	feed.Source = fetchedFeed.Link
+	feed.Description = result.feed.Description
+	feed.Source = result.feed.Link
+	if result.feed.Image != nil {
+		feed.ImageURL = result.feed.Image.URL
+	}
 
-	err = resp.Body.Close()
-	if err != nil {
-		log.Error(err)
-		// Only report this error
-		err = nil
+	return nil
+}
+
+// SyncUsers sync's all user's feeds in the background until ctx is done.
+// Feeds that several users subscribed to are fetched once for all of them.
+// A new sync isn't started while the previous one is still running.
+func (s *Sync) SyncUsers(ctx context.Context) {
+	if !atomic.CompareAndSwapInt32(&s.running, 0, 1) {
+		return
 	}
 
+	shared := s.db.SharedFeeds()
+
+	s.waitGroup.Add(1)
+	go func() {
+		defer s.waitGroup.Done()
+		defer atomic.StoreInt32(&s.running, 0)
+
+		s.pipeline(ctx, shared)
+	}()
+}
+
+// SyncFeed owned by user
+func (s *Sync) SyncFeed(ctx context.Context, feed *models.Feed, user *models.User) error {
+	if !time.Now().After(feed.LastUpdated.Add(time.Minute)) {
+		return nil
+	}
+
+	result := s.fetch(ctx, feed)
+	result.parse()
+
+	_, err := s.persist(s.check(ctx, result, feed, user, nil), s.newRuleCache())
 	return err
 }
 
-// SyncUsers sync's all user's feeds.
-func (s *Sync) SyncUsers() {
-	users := s.db.Users()
+// SyncCandidate syncs feed, which was just subscribed to candidate. The
+// content Discover fetched for a candidate that is the feed itself is
+// stored instead of being fetched again.
+func (s *Sync) SyncCandidate(ctx context.Context, feed *models.Feed, user *models.User, candidate Candidate) error {
+	if candidate.fetched == nil || candidate.URL != feed.Subscription {
+		return s.SyncFeed(ctx, feed, user)
+	}
 
-	for _, user := range users {
-		s.userPool.put(user)
+	_, err := s.persist(s.check(ctx, candidate.fetched, feed, user, nil), s.newRuleCache())
+	return err
+}
+
+// check applies the outcome of a fetch of feed's subscription to feed
+// and returns the update that has to be persisted for it. The requests
+// made to update the hub subscription and icon of feed stop once ctx is done.
+// When shared is set, those are copied from shared, a feed with the same
+// subscription that was checked already, instead of being looked up again.
+func (s *Sync) check(ctx context.Context, result *fetched, feed *models.Feed, user *models.User, shared *models.Feed) update {
+	if result.cancelled {
+		return update{feed: feed, user: user, err: result.err, cancelled: true}
 	}
 
-	var numThreads int
-	if len(users) > maxThreads {
-		numThreads = maxThreads
-	} else {
-		numThreads = len(users)
+	hints := result.hints
+	entries, err := s.checkForUpdates(result, feed, user)
+
+	if retry, ok := err.(retryLater); ok {
+		feed.NextSync = retry.until
+		return update{feed: feed, user: user, retry: true}
 	}
 
-	for i := 0; i < numThreads; i++ {
-		s.userWaitGroup.Add(1)
-		go func() {
-			user := s.userPool.get()
-			for user.ID != 0 {
-				if err := s.SyncUser(&user); err != nil {
-					log.Error(err)
-				}
-				user = s.userPool.get()
-			}
+	now := time.Now()
+	s.checkHealth(feed, err, now)
+	if err == nil && shared != nil {
+		share(shared, feed)
+	} else if err == nil {
+		s.updateHubSubscription(ctx, feed, user, hints.hub, len(entries), now)
+		s.updateIcon(ctx, feed, hints.image, now)
+	}
+	s.schedule(feed, hints, len(entries), now)
+
+	return update{feed: feed, user: user, entries: entries, err: err}
+}
+
+// share copies the hub subscription and icon of from to feed.
+func share(from, feed *models.Feed) {
+	feed.Hub = from.Hub
+	feed.HubTopic = from.HubTopic
+	feed.HubCallback = from.HubCallback
+	feed.HubSecret = from.HubSecret
+	feed.HubLeaseExpires = from.HubLeaseExpires
+	feed.HubLeaseRenews = from.HubLeaseRenews
+	feed.HubRetryAt = from.HubRetryAt
+
+	feed.ImageURL = from.ImageURL
+	feed.Icon = from.Icon
+	feed.IconType = from.IconType
+	feed.IconCheckedAt = from.IconCheckedAt
+}
+
+// persist stores an update, applying the Rules out of rules to its entries,
+// and returns the number of new entries it stored. Only the sync state is
+// stored for feeds that failed or that have to be retried later, and nothing
+// at all for cancelled syncs.
+func (s *Sync) persist(u update, rules *ruleCache) (int, error) {
+	if u.cancelled {
+		return 0, u.err
+	}
+
+	if u.retry {
+		return 0, s.db.EditFeedSyncState(u.feed, u.user)
+	}
+
+	if u.err != nil {
+		if stateErr := s.db.EditFeedSyncState(u.feed, u.user); stateErr != nil {
+			log.Error(stateErr)
+		}
 
-			s.userWaitGroup.Done()
-		}()
+		return 0, u.err
 	}
+
+	return s.save(u.feed, u.entries, rules.get(u.user), u.user)
 }
 
-// SyncFeed owned by user
-func (s *Sync) SyncFeed(feed *models.Feed, user *models.User) error {
-	if !time.Now().After(feed.LastUpdated.Add(time.Minute)) {
-		return nil
+// save stores the sync state of feed and, if that succeeded, the entries
+// that are left once rules were applied. It returns how many it stored.
+// Entries that another sync of feed stored since they were found are skipped.
+func (s *Sync) save(feed *models.Feed, entries []models.Entry, rules []compiledRule, user *models.User) (int, error) {
+	unlock := s.lockFeed(feed)
+	defer unlock()
+
+	// The sync state is saved first since NewEntries reloads feed from the database.
+	if err := s.db.EditFeedSyncState(feed, user); err != nil {
+		return 0, err
 	}
 
-	entries, err := s.checkForUpdates(feed, user)
+	entries, err := s.unstored(feed, entries, user)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	s.dbLock.Lock()
-	defer s.dbLock.Unlock()
-	err = s.db.NewEntries(entries, feed, user)
+	entries = applyRules(rules, feed, entries)
+
+	if err := s.db.NewEntries(entries, feed, user); err != nil {
+		return 0, err
+	}
+
+	return len(entries), s.db.EditFeed(feed, user)
+}
+
+// feedLock is the lock of a feed and the number of its holders and waiters.
+type feedLock struct {
+	sync.Mutex
+	users int
+}
+
+// lockFeed locks the storage of the syncs of feed and returns its unlock.
+func (s *Sync) lockFeed(feed *models.Feed) func() {
+	id := feed.APIID
+
+	s.feedLocksLock.Lock()
+	lock, ok := s.feedLocks[id]
+	if !ok {
+		lock = &feedLock{}
+		s.feedLocks[id] = lock
+	}
+	lock.users++
+	s.feedLocksLock.Unlock()
+
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+
+		s.feedLocksLock.Lock()
+		defer s.feedLocksLock.Unlock()
+
+		lock.users--
+		if lock.users == 0 {
+			delete(s.feedLocks, id)
+		}
+	}
+}
+
+// unstored returns the entries, out of entries, that user does not have yet.
+func (s *Sync) unstored(feed *models.Feed, entries []models.Entry, user *models.User) ([]models.Entry, error) {
+	if len(entries) == 0 {
+		return entries, nil
+	}
+
+	guids := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		guids = append(guids, entry.GUID)
+	}
+
+	found, err := s.db.EntryGUIDs(guids, feed.APIID, user)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return s.db.EditFeed(feed, user)
+	unstored := make([]models.Entry, 0, len(entries))
+	for _, entry := range entries {
+		if !found[entry.GUID] {
+			unstored = append(unstored, entry)
+		}
+	}
+
+	return unstored, nil
 }
 
-// SyncUser sync's all feeds owned by user
-func (s *Sync) SyncUser(user *models.User) error {
-	s.dbLock.Lock()
-	feeds := s.db.Feeds(user)
-	s.dbLock.Unlock()
-	for _, feed := range feeds {
-		if err := s.SyncFeed(&feed, user); err != nil {
-			log.Error(err)
+// checkHealth updates the health of feed after a sync that ended with err.
+// Feeds that fail deadAfter consecutive syncs are marked as dead.
+func (s *Sync) checkHealth(feed *models.Feed, err error, now time.Time) {
+	feed.LastFetched = now
+
+	if err == nil {
+		feed.LastSuccess = now
+		feed.Failures = 0
+		feed.LastError = ""
+		if feed.Status == models.Dead {
+			feed.Status = ""
 		}
+		return
 	}
 
-	return nil
+	feed.Failures++
+	feed.LastError = err.Error()
+	if feed.Failures >= s.deadAfter {
+		feed.Status = models.Dead
+	}
+}
+
+// SyncUser sync's all feeds owned by user
+func (s *Sync) SyncUser(ctx context.Context, user *models.User) error {
+	var shared []database.SharedFeed
+	for _, feed := range s.db.Feeds(user) {
+		shared = append(shared, database.SharedFeed{
+			Subscription: feed.Subscription,
+			Subscribers:  []database.Subscriber{{Feed: feed, User: *user}},
+		})
+	}
+
+	s.pipeline(ctx, shared)
+	return ctx.Err()
 }
 
-func (s *Sync) scheduleTask() {
+func (s *Sync) scheduleTask(ctx context.Context) {
 	go func() {
 		for {
 			select {
 			case <-s.ticker.C:
-				s.SyncUsers()
+				s.SyncUsers(ctx)
+			case <-s.purgeTicker.C:
+				if _, err := s.Purge(ctx, false); err != nil && ctx.Err() == nil {
+					log.Error(err)
+				}
 			case <-s.status:
 				s.ticker.Stop()
+				s.purgeTicker.Stop()
 				s.status <- stopped
 				return
 			}
@@ -327,25 +850,159 @@ func (s *Sync) scheduleTask() {
 	}()
 }
 
+// Purge removes the entries that fall outside of their retention policy
+// or, if dryRun is set, only reports how many entries it would remove.
+// Purging stops once ctx is done.
+func (s *Sync) Purge(ctx context.Context, dryRun bool) (database.PurgeResult, error) {
+	return s.db.PurgeEntries(ctx, s.retention, dryRun, time.Now())
+}
+
 // Start a syncer
 func (s *Sync) Start() {
-	s.ticker = time.NewTicker(s.interval)
-	s.scheduleTask()
+	s.ticker = time.NewTicker(s.minInterval)
+	s.purgeTicker = time.NewTicker(s.purgeInterval)
+	s.scheduleTask(s.ctx)
 }
 
-// Stop a syncer
+// Stop a syncer. Syncs, purges and refreshes in progress are cancelled
+// first and Stop waits for them, and for the scheduler to stop, for at
+// most the stop timeout.
 func (s *Sync) Stop() {
 	s.ticker.Stop()
-	s.status <- stopping
-	<-s.status
-	s.userWaitGroup.Wait()
+	s.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		s.status <- stopping
+		<-s.status
+		s.waitGroup.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(s.stopTimeout):
+		log.Warn("Syncs did not stop within ", s.stopTimeout)
+	}
 }
 
 // NewSync creates a new Sync object
 func NewSync(db *database.DB, config config.Sync) *Sync {
+	interval := config.SyncInterval.Duration
+
+	minInterval := config.MinInterval.Duration
+	if minInterval == 0 || minInterval > interval {
+		minInterval = interval
+	}
+
+	maxInterval := config.MaxInterval.Duration
+	if maxInterval < interval {
+		maxInterval = interval
+	}
+
+	deadAfter := config.DeadAfter
+	if deadAfter <= 0 {
+		deadAfter = defaultDeadAfter
+	}
+
+	purgeInterval := config.PurgeInterval.Duration
+	if purgeInterval == 0 {
+		purgeInterval = defaultPurgeInterval
+	}
+
+	retention := models.RetentionPolicy{
+		KeepReadDays: config.KeepReadDays,
+		MaxEntries:   config.MaxEntriesPerFeed,
+	}
+
+	var icons *iconCache
+	if config.IconCacheDir != "" {
+		iconMaxSize := config.IconMaxSize
+		if iconMaxSize <= 0 {
+			iconMaxSize = defaultIconMaxSize
+		}
+
+		iconCacheSize := config.IconCacheSize
+		if iconCacheSize < iconMaxSize {
+			iconCacheSize = iconMaxSize
+		}
+
+		icons = newIconCache(config.IconCacheDir, iconMaxSize, iconCacheSize)
+	}
+
+	maxRequestsPerHost := config.MaxRequestsPerHost
+	if maxRequestsPerHost <= 0 {
+		maxRequestsPerHost = defaultMaxRequestsPerHost
+	}
+
+	maxRetryAfter := config.MaxRetryAfter.Duration
+	if maxRetryAfter <= 0 {
+		maxRetryAfter = defaultMaxRetryAfter
+	}
+
+	connectTimeout := config.ConnectTimeout.Duration
+	if connectTimeout <= 0 {
+		connectTimeout = defaultConnectTimeout
+	}
+
+	readTimeout := config.ReadTimeout.Duration
+	if readTimeout <= 0 {
+		readTimeout = defaultReadTimeout
+	}
+
+	maxFeedSize := config.MaxFeedSize
+	if maxFeedSize <= 0 {
+		maxFeedSize = defaultMaxFeedSize
+	}
+
+	stopTimeout := config.StopTimeout.Duration
+	if stopTimeout <= 0 {
+		stopTimeout = defaultStopTimeout
+	}
+
+	var spacing time.Duration
+	if config.HostRequestSpacing != nil {
+		spacing = config.HostRequestSpacing.Duration
+	}
+
+	hosts := newHostLimiter(maxRequestsPerHost, spacing, maxRetryAfter)
+	hosts.transport = newTransport(connectTimeout)
+	hosts.readTimeout = readTimeout
+	if icons != nil {
+		icons.transport = hosts
+	}
+
+	maxRefreshes := config.MaxRefreshes
+	if maxRefreshes <= 0 {
+		maxRefreshes = defaultMaxRefreshes
+	}
+
+	refreshWindow := config.RefreshWindow.Duration
+	if refreshWindow <= 0 {
+		refreshWindow = defaultRefreshWindow
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
 	return &Sync{
-		db:       db,
-		status:   make(chan syncStatus),
-		interval: config.SyncInterval.Duration,
+		db:          db,
+		status:      make(chan syncStatus),
+		interval:    interval,
+		minInterval: minInterval,
+		maxInterval: maxInterval,
+		deadAfter:   deadAfter,
+		retention:   retention,
+		callbackURL: config.WebSubCallback,
+		icons:       icons,
+		hosts:       hosts,
+		maxFeedSize: maxFeedSize,
+		stopTimeout: stopTimeout,
+		refreshes:   newRefreshQueue(maxRefreshes, refreshWindow),
+		ctx:         ctx,
+		cancel:      cancel,
+
+		purgeInterval: purgeInterval,
+		ruleVersions:  map[uint]int{},
+		feedLocks:     map[string]*feedLock{},
 	}
 }
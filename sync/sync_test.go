@@ -19,15 +19,27 @@ package sync
 
 import (
 	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"io"
 	"io/ioutil"
 	"math/rand"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+	gosync "sync"
 	"testing"
 	"time"
 
 	"github.com/mmcdole/gofeed"
+	"github.com/mmcdole/gofeed/rss"
 
 	"github.com/stretchr/testify/suite"
 	"github.com/varddum/syndication/config"
@@ -61,9 +73,41 @@ func RandStringRunes(n int) string {
 }
 
 func (suite *SyncTestSuite) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if r.Header.Get("If-None-Match") != RSSFeedEtag {
-		http.FileServer(http.Dir(os.Getenv("GOPATH")+"/src/github.com/varddum/syndication/sync/")).ServeHTTP(w, r)
+	switch r.URL.Path {
+	case "/moved.xml":
+		http.Redirect(w, r, "/moved_again.xml", http.StatusMovedPermanently)
+		return
+	case "/moved_again.xml":
+		http.Redirect(w, r, "/rss.xml", http.StatusPermanentRedirect)
+		return
+	case "/temporary.xml":
+		http.Redirect(w, r, "/rss.xml", http.StatusFound)
+		return
+	case "/moved_temporary.xml":
+		http.Redirect(w, r, "/temporary.xml", http.StatusMovedPermanently)
+		return
+	case "/loop.xml":
+		http.Redirect(w, r, "/loop.xml", http.StatusMovedPermanently)
+		return
+	case "/moved_missing.xml":
+		http.Redirect(w, r, "/missing.xml", http.StatusMovedPermanently)
+		return
+	case "/moved_page.xml":
+		http.Redirect(w, r, "/page.html", http.StatusMovedPermanently)
+		return
+	case "/page.html":
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html><body>Not a feed</body></html>"))
+		return
 	}
+
+	if r.Header.Get("If-None-Match") == RSSFeedEtag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("ETag", RSSFeedEtag)
+	http.FileServer(http.Dir(os.Getenv("GOPATH")+"/src/github.com/varddum/syndication/sync/")).ServeHTTP(w, r)
 }
 
 func (suite *SyncTestSuite) SetupTest() {
@@ -91,7 +135,7 @@ func (suite *SyncTestSuite) TestFetchFeed() {
 	feed := &models.Feed{
 		Subscription: "http://localhost:9090/rss.xml",
 	}
-	err = FetchFeed(feed)
+	err = FetchFeed(context.Background(), feed)
 	suite.Require().Nil(err)
 
 	suite.Equal(originalFeed.Title, feed.Title)
@@ -108,7 +152,7 @@ func (suite *SyncTestSuite) TestFeedWithNonMatchingEtag() {
 	suite.Require().Nil(err)
 	suite.Require().NotEmpty(feed.APIID)
 
-	err = suite.sync.SyncFeed(&feed, &suite.user)
+	err = suite.sync.SyncFeed(context.Background(), &feed, &suite.user)
 	suite.Require().Nil(err)
 
 	entries, err := suite.db.EntriesFromFeed(feed.APIID, true, models.Any, &suite.user)
@@ -127,12 +171,256 @@ func (suite *SyncTestSuite) TestFeedWithMatchingEtag() {
 	suite.Require().Nil(err)
 	suite.Require().NotEmpty(feed.APIID)
 
-	err = suite.sync.SyncFeed(&feed, &suite.user)
+	stats := suite.sync.Stats()
+
+	err = suite.sync.SyncFeed(context.Background(), &feed, &suite.user)
 	suite.Require().Nil(err)
 
 	entries, err := suite.db.EntriesFromFeed(feed.APIID, true, models.Any, &suite.user)
 	suite.Require().Nil(err)
 	suite.Len(entries, 0)
+
+	suite.Equal(stats.NotModified+1, suite.sync.Stats().NotModified)
+}
+
+func (suite *SyncTestSuite) TestFeedStoresValidators() {
+	feed := models.Feed{
+		Title:        "Sync Test",
+		Subscription: "http://localhost:9090/rss.xml",
+	}
+
+	err := suite.db.NewFeed(&feed, &suite.user)
+	suite.Require().Nil(err)
+
+	stats := suite.sync.Stats()
+
+	err = suite.sync.SyncFeed(context.Background(), &feed, &suite.user)
+	suite.Require().Nil(err)
+
+	syncedFeed, err := suite.db.Feed(feed.APIID, &suite.user)
+	suite.Require().Nil(err)
+	suite.Equal(RSSFeedEtag, syncedFeed.Etag)
+	suite.NotEmpty(syncedFeed.LastModified)
+	suite.NotZero(syncedFeed.ContentLength)
+
+	received := suite.sync.Stats().BytesReceived - stats.BytesReceived
+	suite.Equal(syncedFeed.ContentLength, received)
+
+	syncedFeed.LastUpdated = time.Time{}
+	syncedFeed.Etag = ""
+
+	err = suite.sync.SyncFeed(context.Background(), &syncedFeed, &suite.user)
+	suite.Require().Nil(err)
+
+	newStats := suite.sync.Stats()
+	suite.Equal(stats.Fetches+2, newStats.Fetches)
+	suite.Equal(stats.NotModified+1, newStats.NotModified)
+	suite.Equal(stats.BytesSaved+syncedFeed.ContentLength, newStats.BytesSaved)
+
+	entries, err := suite.db.EntriesFromFeed(feed.APIID, true, models.Any, &suite.user)
+	suite.Require().Nil(err)
+	suite.Len(entries, 5)
+}
+
+func (suite *SyncTestSuite) TestFeedWithPermanentRedirects() {
+	feed := models.Feed{
+		Title:        "Sync Test",
+		Subscription: "http://localhost:9090/moved.xml",
+	}
+
+	err := suite.db.NewFeed(&feed, &suite.user)
+	suite.Require().Nil(err)
+
+	err = suite.sync.SyncFeed(context.Background(), &feed, &suite.user)
+	suite.Require().Nil(err)
+
+	syncedFeed, err := suite.db.Feed(feed.APIID, &suite.user)
+	suite.Require().Nil(err)
+	suite.Equal("http://localhost:9090/rss.xml", syncedFeed.Subscription)
+	suite.Contains(syncedFeed.Status, "http://localhost:9090/moved.xml")
+
+	entries, err := suite.db.EntriesFromFeed(feed.APIID, true, models.Any, &suite.user)
+	suite.Require().Nil(err)
+	suite.Len(entries, 5)
+}
+
+func (suite *SyncTestSuite) TestFeedWithTemporaryRedirects() {
+	for _, subscription := range []string{
+		"http://localhost:9090/temporary.xml",
+		"http://localhost:9090/moved_temporary.xml",
+	} {
+		feed := models.Feed{
+			Title:        "Sync Test",
+			Subscription: subscription,
+		}
+
+		err := suite.db.NewFeed(&feed, &suite.user)
+		suite.Require().Nil(err)
+
+		err = suite.sync.SyncFeed(context.Background(), &feed, &suite.user)
+		suite.Require().Nil(err)
+
+		syncedFeed, err := suite.db.Feed(feed.APIID, &suite.user)
+		suite.Require().Nil(err)
+		suite.Equal(subscription, syncedFeed.Subscription)
+		suite.Empty(syncedFeed.Status)
+
+		entries, err := suite.db.EntriesFromFeed(feed.APIID, true, models.Any, &suite.user)
+		suite.Require().Nil(err)
+		suite.Len(entries, 5)
+	}
+}
+
+func (suite *SyncTestSuite) TestFeedWithBrokenPermanentRedirects() {
+	for _, subscription := range []string{
+		"http://localhost:9090/moved_missing.xml",
+		"http://localhost:9090/moved_page.xml",
+	} {
+		feed := models.Feed{
+			Title:        "Sync Test",
+			Subscription: subscription,
+		}
+
+		err := suite.db.NewFeed(&feed, &suite.user)
+		suite.Require().Nil(err)
+
+		suite.sync.SyncFeed(context.Background(), &feed, &suite.user)
+
+		syncedFeed, err := suite.db.Feed(feed.APIID, &suite.user)
+		suite.Require().Nil(err)
+		suite.Equal(subscription, syncedFeed.Subscription)
+		suite.NotContains(syncedFeed.Status, "Moved permanently")
+	}
+}
+
+func (suite *SyncTestSuite) TestFeedWithRedirectLoop() {
+	feed := models.Feed{
+		Title:        "Sync Test",
+		Subscription: "http://localhost:9090/loop.xml",
+	}
+
+	err := suite.db.NewFeed(&feed, &suite.user)
+	suite.Require().Nil(err)
+
+	err = suite.sync.SyncFeed(context.Background(), &feed, &suite.user)
+	suite.NotNil(err)
+}
+
+func (suite *SyncTestSuite) TestFetchFeedFailures() {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `<html><head><title>Not a feed</title></head></html>`)
+	}))
+	defer ts.Close()
+
+	err := FetchFeed(context.Background(), &models.Feed{Subscription: "http://localhost:9090/missing.xml"})
+	suite.IsType(BadRequest{}, err)
+
+	feed := &models.Feed{Subscription: ts.URL}
+	err = FetchFeed(context.Background(), feed)
+	suite.IsType(BadRequest{}, err)
+	suite.Empty(feed.Title)
+}
+
+func (suite *SyncTestSuite) TestFetchFeedWithPermanentRedirects() {
+	feed := &models.Feed{
+		Subscription: "http://localhost:9090/moved.xml",
+	}
+
+	err := FetchFeed(context.Background(), feed)
+	suite.Require().Nil(err)
+	suite.Equal("http://localhost:9090/rss.xml", feed.Subscription)
+}
+
+func (suite *SyncTestSuite) TestFeedHealthAfterSuccess() {
+	feed := models.Feed{
+		Title:        "Sync Test",
+		Subscription: "http://localhost:9090/rss.xml",
+	}
+
+	err := suite.db.NewFeed(&feed, &suite.user)
+	suite.Require().Nil(err)
+
+	err = suite.sync.SyncFeed(context.Background(), &feed, &suite.user)
+	suite.Require().Nil(err)
+
+	syncedFeed, err := suite.db.Feed(feed.APIID, &suite.user)
+	suite.Require().Nil(err)
+	suite.False(syncedFeed.LastFetched.IsZero())
+	suite.Equal(syncedFeed.LastFetched.Unix(), syncedFeed.LastSuccess.Unix())
+	suite.Equal(http.StatusOK, syncedFeed.LastStatusCode)
+	suite.Zero(syncedFeed.Failures)
+	suite.Empty(syncedFeed.LastError)
+}
+
+func (suite *SyncTestSuite) TestFeedHealthAfterFailures() {
+	sync := NewSync(suite.db, config.Sync{
+		SyncInterval: config.Duration{Duration: time.Second * 5},
+		DeadAfter:    2,
+	})
+
+	feed := models.Feed{
+		Title:        "Sync Test",
+		Subscription: "http://localhost:9090/missing.xml",
+	}
+
+	err := suite.db.NewFeed(&feed, &suite.user)
+	suite.Require().Nil(err)
+
+	err = sync.SyncFeed(context.Background(), &feed, &suite.user)
+	suite.Require().NotNil(err)
+
+	syncedFeed, err := suite.db.Feed(feed.APIID, &suite.user)
+	suite.Require().Nil(err)
+	suite.Equal(1, syncedFeed.Failures)
+	suite.Equal(http.StatusNotFound, syncedFeed.LastStatusCode)
+	suite.NotEmpty(syncedFeed.LastError)
+	suite.True(syncedFeed.LastSuccess.IsZero())
+	suite.NotEqual(models.Dead, syncedFeed.Status)
+
+	err = sync.SyncFeed(context.Background(), &syncedFeed, &suite.user)
+	suite.Require().NotNil(err)
+
+	syncedFeed, err = suite.db.Feed(feed.APIID, &suite.user)
+	suite.Require().Nil(err)
+	suite.Equal(2, syncedFeed.Failures)
+	suite.Equal(models.Dead, syncedFeed.Status)
+	suite.True(syncedFeed.NextSync.After(time.Now().Add(time.Second * 5 * deadBackoff)))
+
+	sync.checkHealth(&syncedFeed, nil, time.Now())
+	suite.Zero(syncedFeed.Failures)
+	suite.Empty(syncedFeed.Status)
+}
+
+func (suite *SyncTestSuite) TestFeedHealthAfterUnparsableContent() {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `<html><head><title>Sign in to the network</title></head></html>`)
+	}))
+	defer ts.Close()
+
+	sync := NewSync(suite.db, config.Sync{
+		SyncInterval: config.Duration{Duration: time.Second * 5},
+		DeadAfter:    2,
+	})
+
+	feed := models.Feed{
+		Title:        "Sync Test",
+		Subscription: ts.URL,
+	}
+
+	err := suite.db.NewFeed(&feed, &suite.user)
+	suite.Require().Nil(err)
+
+	for i := 0; i < 2; i++ {
+		err = sync.SyncFeed(context.Background(), &feed, &suite.user)
+		suite.Require().NotNil(err)
+	}
+
+	syncedFeed, err := suite.db.Feed(feed.APIID, &suite.user)
+	suite.Require().Nil(err)
+	suite.Equal(2, syncedFeed.Failures)
+	suite.Equal(http.StatusOK, syncedFeed.LastStatusCode)
+	suite.Contains(syncedFeed.LastError, "Could not parse feed")
+	suite.Equal(models.Dead, syncedFeed.Status)
 }
 
 func (suite *SyncTestSuite) TestFeedWithRecentLastUpdateDate() {
@@ -146,7 +434,7 @@ func (suite *SyncTestSuite) TestFeedWithRecentLastUpdateDate() {
 	suite.Require().Nil(err)
 	suite.Require().NotEmpty(feed.APIID)
 
-	err = suite.sync.SyncFeed(&feed, &suite.user)
+	err = suite.sync.SyncFeed(context.Background(), &feed, &suite.user)
 	suite.Require().Nil(err)
 
 	entries, err := suite.db.EntriesFromFeed(feed.APIID, true, models.Any, &suite.user)
@@ -164,7 +452,7 @@ func (suite *SyncTestSuite) TestFeedWithNewEntriesWithGUIDs() {
 	suite.Require().Nil(err)
 	suite.Require().NotEmpty(feed.APIID)
 
-	err = suite.sync.SyncFeed(&feed, &suite.user)
+	err = suite.sync.SyncFeed(context.Background(), &feed, &suite.user)
 	suite.Require().Nil(err)
 
 	entries, err := suite.db.EntriesFromFeed(feed.APIID, true, models.Any, &suite.user)
@@ -172,8 +460,10 @@ func (suite *SyncTestSuite) TestFeedWithNewEntriesWithGUIDs() {
 	suite.Len(entries, 5)
 
 	feed.LastUpdated = time.Time{}
+	feed.Etag = ""
+	feed.LastModified = ""
 
-	err = suite.sync.SyncFeed(&feed, &suite.user)
+	err = suite.sync.SyncFeed(context.Background(), &feed, &suite.user)
 	suite.Require().Nil(err)
 
 	entries, err = suite.db.EntriesFromFeed(feed.APIID, true, models.Any, &suite.user)
@@ -191,7 +481,7 @@ func (suite *SyncTestSuite) TestFeedWithNewEntriesWithoutGUIDs() {
 	suite.Require().Nil(err)
 	suite.Require().NotEmpty(feed.APIID)
 
-	err = suite.sync.SyncFeed(&feed, &suite.user)
+	err = suite.sync.SyncFeed(context.Background(), &feed, &suite.user)
 	suite.Require().Nil(err)
 
 	entries, err := suite.db.EntriesFromFeed(feed.APIID, true, models.Any, &suite.user)
@@ -199,6 +489,28 @@ func (suite *SyncTestSuite) TestFeedWithNewEntriesWithoutGUIDs() {
 	suite.Len(entries, 5)
 }
 
+func (suite *SyncTestSuite) TestFeedEntriesWithSummaries() {
+	feed := models.Feed{
+		Title:        "Sync Test",
+		Subscription: "http://localhost:9090/rss.xml",
+	}
+
+	err := suite.db.NewFeed(&feed, &suite.user)
+	suite.Require().Nil(err)
+	suite.Require().NotEmpty(feed.APIID)
+
+	err = suite.sync.SyncFeed(context.Background(), &feed, &suite.user)
+	suite.Require().Nil(err)
+
+	entries, err := suite.db.EntriesFromFeed(feed.APIID, true, models.Any, &suite.user)
+	suite.Require().Nil(err)
+	suite.Require().Len(entries, 5)
+
+	for _, entry := range entries {
+		suite.Equal("Single test item", entry.Summary)
+	}
+}
+
 func (suite *SyncTestSuite) TestSyncUser() {
 	feed := models.Feed{
 		Title:        "Sync Test",
@@ -209,7 +521,7 @@ func (suite *SyncTestSuite) TestSyncUser() {
 	suite.Require().Nil(err)
 	suite.Require().NotEmpty(feed.APIID)
 
-	err = suite.sync.SyncUser(&suite.user)
+	err = suite.sync.SyncUser(context.Background(), &suite.user)
 	suite.Require().Nil(err)
 
 	entries, err := suite.db.EntriesFromFeed(feed.APIID, true, models.Any, &suite.user)
@@ -240,66 +552,1751 @@ func (suite *SyncTestSuite) TestSyncUsers() {
 	suite.Len(entries, 5)
 }
 
-func (suite *SyncTestSuite) TestUserThreadAllocation() {
-	for i := 0; i < 150; i++ {
-		err := suite.db.NewUser("test"+strconv.Itoa(i), "test"+strconv.Itoa(i))
-		suite.Require().Nil(err)
+func (suite *SyncTestSuite) TestSyncUsersFetchesSharedFeedsOnce() {
+	var lock gosync.Mutex
+	hits := 0
 
-		user, err := suite.db.UserWithName("test" + strconv.Itoa(i))
-		suite.Require().Nil(err)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		hits++
+		lock.Unlock()
 
-		feed := models.Feed{
-			Title:        "Sync Test",
-			Subscription: "http://localhost:9090/rss_minimal.xml",
-		}
+		io.WriteString(w, testPodcastFeed)
+	}))
+	defer ts.Close()
 
-		err = suite.db.NewFeed(&feed, &user)
+	otherName := RandStringRunes(8)
+	err := suite.db.NewUser(otherName, "golang")
+	suite.Require().Nil(err)
+
+	other, err := suite.db.UserWithName(otherName)
+	suite.Require().Nil(err)
+	defer suite.db.DeleteUser(other.APIID)
+
+	feed := models.Feed{Title: "Shared", Subscription: ts.URL}
+	err = suite.db.NewFeed(&feed, &suite.user)
+	suite.Require().Nil(err)
+
+	otherFeed := models.Feed{Title: "Shared", Subscription: ts.URL}
+	err = suite.db.NewFeed(&otherFeed, &other)
+	suite.Require().Nil(err)
+
+	sync := NewSync(suite.db, config.Sync{SyncInterval: config.Duration{Duration: time.Minute}})
+	sync.SyncUsers(context.Background())
+	sync.waitGroup.Wait()
+
+	suite.Equal(1, hits)
+
+	entries, err := suite.db.EntriesFromFeed(feed.APIID, true, models.Any, &suite.user)
+	suite.Require().Nil(err)
+	suite.Require().Len(entries, 3)
+
+	otherEntries, err := suite.db.EntriesFromFeed(otherFeed.APIID, true, models.Any, &other)
+	suite.Require().Nil(err)
+	suite.Require().Len(otherEntries, 3)
+
+	err = suite.db.MarkEntry(entries[0].APIID, models.Read, &suite.user)
+	suite.Require().Nil(err)
+
+	otherEntries, err = suite.db.EntriesFromFeed(otherFeed.APIID, true, models.Unread, &other)
+	suite.Require().Nil(err)
+	suite.Len(otherEntries, 3)
+
+	// Subscribers are synced together once their schedules drifted apart
+	now := time.Now()
+	for _, subscriber := range []struct {
+		feed     models.Feed
+		user     *models.User
+		nextSync time.Time
+	}{
+		{feed, &suite.user, now.Add(-time.Minute)},
+		{otherFeed, &other, now.Add(time.Hour)},
+	} {
+		synced, err := suite.db.Feed(subscriber.feed.APIID, subscriber.user)
+		suite.Require().Nil(err)
+
+		synced.NextSync = subscriber.nextSync
+		synced.LastUpdated = now.Add(-time.Hour)
+		err = suite.db.EditFeedSyncState(&synced, subscriber.user)
 		suite.Require().Nil(err)
 	}
 
-	suite.sync = NewSync(suite.db, config.Sync{SyncInterval: config.Duration{Duration: time.Second * 2}})
+	sync.SyncUsers(context.Background())
+	sync.waitGroup.Wait()
 
-	suite.sync.Start()
+	suite.Equal(2, hits)
 
-	time.Sleep(time.Second * 3)
+	synced, err := suite.db.Feed(otherFeed.APIID, &other)
+	suite.Require().Nil(err)
+	suite.True(synced.LastFetched.After(now))
 
-	suite.sync.Stop()
+	// Neither is synced again until one of them is due
+	sync.SyncUsers(context.Background())
+	sync.waitGroup.Wait()
 
-	users := suite.db.Users()
-	users = users[1:]
+	suite.Equal(2, hits)
+}
 
-	for _, user := range users {
-		entries, err := suite.db.Entries(true, models.Any, &user)
-		suite.Require().Nil(err)
-		suite.Len(entries, 5)
+func (suite *SyncTestSuite) TestSyncUserSkipsFeedsNotDue() {
+	feed := models.Feed{
+		Title:        "Sync Test",
+		Subscription: "http://localhost:9090/rss_minimal.xml",
 	}
+
+	err := suite.db.NewFeed(&feed, &suite.user)
+	suite.Require().Nil(err)
+	suite.Require().NotEmpty(feed.APIID)
+
+	feed.NextSync = time.Now().Add(time.Hour)
+	err = suite.db.EditFeedSyncState(&feed, &suite.user)
+	suite.Require().Nil(err)
+
+	err = suite.sync.SyncUser(context.Background(), &suite.user)
+	suite.Require().Nil(err)
+
+	entries, err := suite.db.EntriesFromFeed(feed.APIID, true, models.Any, &suite.user)
+	suite.Require().Nil(err)
+	suite.Empty(entries)
 }
 
-func (suite *SyncTestSuite) startServer() {
-	suite.db, _ = database.NewDB(config.Database{
-		Type:       "sqlite3",
-		Connection: TestDatabasePath,
-	})
+func (suite *SyncTestSuite) TestRefreshIgnoresSchedule() {
+	feed := models.Feed{
+		Title:        "Sync Test",
+		Subscription: "http://localhost:9090/rss_minimal.xml",
+	}
 
-	suite.server = &http.Server{
-		Addr:    ":9090",
-		Handler: suite,
+	err := suite.db.NewFeed(&feed, &suite.user)
+	suite.Require().Nil(err)
+
+	feed.NextSync = time.Now().Add(time.Hour)
+	err = suite.db.EditFeedSyncState(&feed, &suite.user)
+	suite.Require().Nil(err)
+
+	other := models.Feed{
+		Title:        "Other",
+		Subscription: "http://localhost:9090/rss.xml",
 	}
 
-	go suite.server.ListenAndServe()
+	err = suite.db.NewFeed(&other, &suite.user)
+	suite.Require().Nil(err)
 
-	time.Sleep(time.Second)
+	sync := NewSync(suite.db, config.Sync{
+		SyncInterval: config.Duration{Duration: time.Minute},
+		MaxRefreshes: 1,
+	})
 
-	suite.sync = NewSync(suite.db, config.Sync{SyncInterval: config.Duration{Duration: time.Second * 5}})
+	job, err := sync.RefreshFeed(feed.APIID, &suite.user)
+	suite.Require().Nil(err)
+
+	_, err = sync.RefreshAll(&suite.user)
+	suite.IsType(TooManyRequests{}, err)
+
+	sync.waitGroup.Wait()
+
+	job, err = sync.Refresh(job.ID, &suite.user)
+	suite.Require().Nil(err)
+	suite.Equal(RefreshDone, job.Status)
+	suite.Equal(5, job.NewEntries)
+
+	_, err = sync.Refresh(job.ID, &models.User{ID: suite.user.ID + 1})
+	suite.IsType(NotFound{}, err)
 }
 
-func TestSyncTestSuite(t *testing.T) {
-	syncSuite := SyncTestSuite{}
-	syncSuite.startServer()
+func (suite *SyncTestSuite) TestRefreshesCoalescePerFeed() {
+	feed := models.Feed{
+		Title:        "Sync Test",
+		Subscription: "http://localhost:9090/rss_minimal.xml",
+	}
 
-	suite.Run(t, &syncSuite)
+	err := suite.db.NewFeed(&feed, &suite.user)
+	suite.Require().Nil(err)
 
-	syncSuite.db.Close()
-	syncSuite.server.Close()
-	os.Remove(TestDatabasePath)
+	sync := NewSync(suite.db, config.Sync{
+		SyncInterval: config.Duration{Duration: time.Minute},
+		MaxRefreshes: 2,
+	})
+
+	job, err := sync.RefreshFeed(feed.APIID, &suite.user)
+	suite.Require().Nil(err)
+
+	// The only feed of the user is already being refreshed.
+	all, err := sync.RefreshAll(&suite.user)
+	suite.Require().Nil(err)
+	suite.Equal(job.ID, all.ID)
+
+	sync.waitGroup.Wait()
+
+	entries, err := suite.db.EntriesFromFeed(feed.APIID, true, models.Any, &suite.user)
+	suite.Require().Nil(err)
+	suite.Len(entries, 5)
+}
+
+func (suite *SyncTestSuite) TestOverlappingSyncsStoreEntriesOnce() {
+	feed := models.Feed{
+		Title:        "Sync Test",
+		Subscription: "http://localhost:9090/rss_minimal.xml",
+	}
+
+	err := suite.db.NewFeed(&feed, &suite.user)
+	suite.Require().Nil(err)
+
+	// Both syncs find the new entries before either of them stores them.
+	var updates []update
+	for i := 0; i < 2; i++ {
+		synced := feed
+		result := suite.sync.fetch(context.Background(), &synced)
+		result.parse()
+		updates = append(updates, suite.sync.check(context.Background(), result, &synced, &suite.user, nil))
+	}
+
+	stored := 0
+	for _, u := range updates {
+		n, err := suite.sync.persist(u, suite.sync.newRuleCache())
+		suite.Require().Nil(err)
+		stored += n
+	}
+	suite.Equal(5, stored)
+	suite.Empty(suite.sync.feedLocks)
+
+	entries, err := suite.db.EntriesFromFeed(feed.APIID, true, models.Any, &suite.user)
+	suite.Require().Nil(err)
+	suite.Len(entries, 5)
+}
+
+func (suite *SyncTestSuite) TestRefreshDuringSyncUsers() {
+	var lock gosync.Mutex
+	arrived := 0
+	both := make(chan struct{})
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/feed" {
+			http.NotFound(w, r)
+			return
+		}
+
+		// Both syncs fetch the feed before either of them stores it.
+		lock.Lock()
+		arrived++
+		if arrived == 2 {
+			close(both)
+		}
+		lock.Unlock()
+
+		select {
+		case <-both:
+		case <-time.After(time.Second * 5):
+		}
+
+		resp, err := http.Get("http://localhost:9090/rss_minimal.xml")
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		defer resp.Body.Close()
+
+		io.Copy(w, resp.Body)
+	}))
+	defer ts.Close()
+
+	feed := models.Feed{
+		Title:        "Sync Test",
+		Subscription: ts.URL + "/feed",
+	}
+
+	err := suite.db.NewFeed(&feed, &suite.user)
+	suite.Require().Nil(err)
+
+	sync := NewSync(suite.db, config.Sync{
+		SyncInterval:       config.Duration{Duration: time.Minute},
+		MaxRequestsPerHost: 2,
+	})
+
+	_, err = sync.RefreshFeed(feed.APIID, &suite.user)
+	suite.Require().Nil(err)
+
+	sync.SyncUsers(context.Background())
+	sync.waitGroup.Wait()
+
+	lock.Lock()
+	suite.Equal(2, arrived)
+	lock.Unlock()
+
+	entries, err := suite.db.EntriesFromFeed(feed.APIID, true, models.Any, &suite.user)
+	suite.Require().Nil(err)
+	suite.Len(entries, 5)
+}
+
+func (suite *SyncTestSuite) TestSyncFeedSchedulesNextSync() {
+	feed := models.Feed{
+		Title:        "Sync Test",
+		Subscription: "http://localhost:9090/rss_minimal.xml",
+	}
+
+	err := suite.db.NewFeed(&feed, &suite.user)
+	suite.Require().Nil(err)
+
+	err = suite.sync.SyncFeed(context.Background(), &feed, &suite.user)
+	suite.Require().Nil(err)
+
+	syncedFeed, err := suite.db.Feed(feed.APIID, &suite.user)
+	suite.Require().Nil(err)
+	suite.True(syncedFeed.NextSync.After(time.Now()))
+	suite.NotZero(syncedFeed.SyncInterval)
+}
+
+func (suite *SyncTestSuite) TestScheduleAdaptsInterval() {
+	sync := NewSync(suite.db, config.Sync{
+		SyncInterval: config.Duration{Duration: time.Minute * 15},
+		MinInterval:  config.Duration{Duration: time.Minute * 5},
+		MaxInterval:  config.Duration{Duration: time.Hour},
+	})
+
+	now := time.Date(2017, time.June, 1, 12, 0, 0, 0, time.UTC)
+	feed := models.Feed{}
+
+	sync.schedule(&feed, newScheduleHints(), 3, now)
+	suite.Equal(time.Minute*7+time.Second*30, feed.SyncInterval)
+
+	sync.schedule(&feed, newScheduleHints(), 3, now)
+	suite.Equal(time.Minute*5, feed.SyncInterval)
+	suite.True(!feed.NextSync.Before(now.Add(time.Minute * 5)))
+
+	for i := 0; i < 10; i++ {
+		sync.schedule(&feed, newScheduleHints(), 0, now)
+	}
+	suite.Equal(time.Hour, feed.SyncInterval)
+	suite.True(feed.NextSync.Before(now.Add(time.Hour * 2)))
+}
+
+func (suite *SyncTestSuite) TestScheduleHonorsHints() {
+	sync := NewSync(suite.db, config.Sync{
+		SyncInterval: config.Duration{Duration: time.Minute * 15},
+		MinInterval:  config.Duration{Duration: time.Minute * 5},
+		MaxInterval:  config.Duration{Duration: time.Hour * 24},
+	})
+
+	now := time.Date(2017, time.June, 1, 12, 0, 0, 0, time.UTC)
+
+	hints := newScheduleHints()
+	hints.readRSS(&rss.Feed{
+		TTL:       "120",
+		SkipHours: []string{"14", "15"},
+		SkipDays:  []string{"Saturday"},
+	})
+	suite.Equal(time.Hour*2, hints.ttl)
+
+	feed := models.Feed{}
+	sync.schedule(&feed, hints, 0, now)
+	suite.Equal(120, feed.TTL)
+	suite.Equal(now.Add(time.Hour*4), feed.NextSync)
+
+	saturday := time.Date(2017, time.June, 3, 9, 0, 0, 0, time.UTC)
+	suite.Equal(time.Date(2017, time.June, 4, 0, 0, 0, 0, time.UTC), hints.skip(saturday))
+
+	hints = newScheduleHints()
+	resp := &http.Response{Header: http.Header{}}
+	resp.Header.Set("Cache-Control", "public, max-age=10800")
+	resp.Header.Set("Expires", now.Add(time.Hour).Format(http.TimeFormat))
+	hints.readResponse(resp, now)
+	suite.Equal(now.Add(time.Hour*3), hints.expires)
+
+	sync.schedule(&feed, hints, 0, now)
+	suite.True(!feed.NextSync.Before(now.Add(time.Hour * 3)))
+}
+
+func (suite *SyncTestSuite) TestPurgedEntriesAreNotImportedAgain() {
+	sync := NewSync(suite.db, config.Sync{
+		SyncInterval:      config.Duration{Duration: time.Second * 5},
+		MaxEntriesPerFeed: 2,
+	})
+
+	feed := models.Feed{
+		Title:        "Sync Test",
+		Subscription: "http://localhost:9090/rss.xml",
+	}
+
+	err := suite.db.NewFeed(&feed, &suite.user)
+	suite.Require().Nil(err)
+
+	err = sync.SyncFeed(context.Background(), &feed, &suite.user)
+	suite.Require().Nil(err)
+
+	result, err := sync.Purge(context.Background(), true)
+	suite.Require().Nil(err)
+	suite.True(result.Entries >= 3)
+
+	entries, err := suite.db.EntriesFromFeed(feed.APIID, true, models.Any, &suite.user)
+	suite.Require().Nil(err)
+	suite.Len(entries, 5)
+
+	_, err = sync.Purge(context.Background(), false)
+	suite.Require().Nil(err)
+
+	entries, err = suite.db.EntriesFromFeed(feed.APIID, true, models.Any, &suite.user)
+	suite.Require().Nil(err)
+	suite.Len(entries, 2)
+
+	syncedFeed, err := suite.db.Feed(feed.APIID, &suite.user)
+	suite.Require().Nil(err)
+
+	syncedFeed.LastUpdated = time.Time{}
+	syncedFeed.Etag = ""
+	syncedFeed.LastModified = ""
+
+	err = sync.SyncFeed(context.Background(), &syncedFeed, &suite.user)
+	suite.Require().Nil(err)
+
+	entries, err = suite.db.EntriesFromFeed(feed.APIID, true, models.Any, &suite.user)
+	suite.Require().Nil(err)
+	suite.Len(entries, 2)
+}
+
+// hubFeed serves an RSS feed that advertises a stand-in WebSub hub
+// and records the subscription requests the hub receives.
+type hubFeed struct {
+	items    []string
+	requests []url.Values
+	lock     gosync.Mutex
+
+	// verify is called with every subscription request before the hub answers it.
+	verify func(url.Values)
+}
+
+func (h *hubFeed) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if r.URL.Path == "/hub" {
+		r.ParseForm()
+		h.requests = append(h.requests, r.PostForm)
+		if h.verify != nil {
+			h.verify(r.PostForm)
+		}
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	w.Write(h.content("http://" + r.Host))
+}
+
+func (h *hubFeed) content(base string) []byte {
+	var items string
+	for _, item := range h.items {
+		items += "<item><title>" + item + "</title><guid>" + item + "@test</guid></item>"
+	}
+
+	return []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0" xmlns:atom="http://www.w3.org/2005/Atom">
+<channel>
+<title>Hub Test</title>
+<link>` + base + `</link>
+<atom:link rel="hub" href="` + base + `/hub"/>
+<atom:link rel="self" href="` + base + `/feed.xml"/>
+` + items + `
+</channel>
+</rss>`)
+}
+
+func (h *hubFeed) subscriptions() []url.Values {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	return append([]url.Values{}, h.requests...)
+}
+
+func signContent(secret string, content []byte) string {
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write(content)
+	return "sha1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (suite *SyncTestSuite) TestWebSubSubscription() {
+	hub := &hubFeed{items: []string{"one", "two"}}
+	ts := httptest.NewServer(hub)
+	defer ts.Close()
+
+	syncConfig := config.DefaultSyncConfig
+	syncConfig.IconCacheDir = ""
+	syncConfig.HostRequestSpacing = &config.Duration{}
+	syncConfig.WebSubCallback = "https://syndication.example.com/"
+	sync := NewSync(suite.db, syncConfig)
+
+	feed := models.Feed{
+		Title:        "Hub Test",
+		Subscription: ts.URL + "/feed.xml",
+	}
+
+	err := suite.db.NewFeed(&feed, &suite.user)
+	suite.Require().Nil(err)
+
+	err = sync.SyncFeed(context.Background(), &feed, &suite.user)
+	suite.Require().Nil(err)
+
+	requests := hub.subscriptions()
+	suite.Require().Len(requests, 1)
+	suite.Equal("subscribe", requests[0].Get("hub.mode"))
+	suite.Equal(ts.URL+"/feed.xml", requests[0].Get("hub.topic"))
+	suite.Equal("https://syndication.example.com/websub/"+feed.HubCallback, requests[0].Get("hub.callback"))
+	suite.Equal(feed.HubSecret, requests[0].Get("hub.secret"))
+	suite.NotEmpty(feed.HubSecret)
+	suite.False(pushed(&feed, time.Now()))
+
+	dbFeed, user, err := suite.db.FeedWithHubCallback(feed.HubCallback)
+	suite.Require().Nil(err)
+	suite.Equal(feed.APIID, dbFeed.APIID)
+	suite.Equal(suite.user.APIID, user.APIID)
+	suite.Equal(ts.URL+"/hub", dbFeed.Hub)
+
+	ok, err := sync.VerifyHubSubscription(&dbFeed, &user, "subscribe", "http://example.com/other.xml", 3600)
+	suite.Nil(err)
+	suite.False(ok)
+
+	ok, err = sync.VerifyHubSubscription(&dbFeed, &user, "unsubscribe", dbFeed.HubTopic, 0)
+	suite.Nil(err)
+	suite.False(ok)
+
+	ok, err = sync.VerifyHubSubscription(&dbFeed, &user, "subscribe", dbFeed.HubTopic, 10*24*3600)
+	suite.Require().Nil(err)
+	suite.True(ok)
+	suite.True(pushed(&dbFeed, time.Now()))
+
+	// Pushed feeds are only polled at the maximum interval
+	now := time.Now()
+	sync.schedule(&dbFeed, newScheduleHints(), 1, now)
+	suite.True(!dbFeed.NextSync.Before(now.Add(sync.maxInterval)))
+
+	// A poll of a pushed feed does not subscribe again
+	dbFeed.LastUpdated = time.Now().Add(-time.Hour)
+	err = sync.SyncFeed(context.Background(), &dbFeed, &suite.user)
+	suite.Require().Nil(err)
+	suite.Len(hub.subscriptions(), 1)
+	suite.True(pushed(&dbFeed, time.Now()))
+
+	// Subscriptions are renewed before their lease expires
+	dbFeed.HubLeaseExpires = time.Now().Add(time.Hour)
+	dbFeed.HubLeaseRenews = time.Now().Add(-time.Hour)
+	dbFeed.LastUpdated = time.Now().Add(-time.Hour)
+	err = sync.SyncFeed(context.Background(), &dbFeed, &suite.user)
+	suite.Require().Nil(err)
+
+	requests = hub.subscriptions()
+	suite.Require().Len(requests, 2)
+	suite.Equal("https://syndication.example.com/websub/"+feed.HubCallback, requests[1].Get("hub.callback"))
+}
+
+func (suite *SyncTestSuite) TestWebSubLeaseRenewedBeforeItExpires() {
+	hub := &hubFeed{items: []string{"one", "two"}}
+	ts := httptest.NewServer(hub)
+	defer ts.Close()
+
+	syncConfig := config.DefaultSyncConfig
+	syncConfig.IconCacheDir = ""
+	syncConfig.HostRequestSpacing = &config.Duration{}
+	syncConfig.WebSubCallback = "https://syndication.example.com/"
+	sync := NewSync(suite.db, syncConfig)
+
+	feed := models.Feed{
+		Title:        "Hub Test",
+		Subscription: ts.URL + "/feed.xml",
+	}
+
+	err := suite.db.NewFeed(&feed, &suite.user)
+	suite.Require().Nil(err)
+
+	err = sync.SyncFeed(context.Background(), &feed, &suite.user)
+	suite.Require().Nil(err)
+	suite.Require().Len(hub.subscriptions(), 1)
+
+	dbFeed, user, err := suite.db.FeedWithHubCallback(feed.HubCallback)
+	suite.Require().Nil(err)
+
+	// A lease of a day is shorter than the maximum sync interval with jitter
+	ok, err := sync.VerifyHubSubscription(&dbFeed, &user, "subscribe", dbFeed.HubTopic, 24*3600)
+	suite.Require().Nil(err)
+	suite.Require().True(ok)
+	suite.True(dbFeed.NextSync.Before(dbFeed.HubLeaseExpires))
+
+	now := time.Now()
+	sync.schedule(&dbFeed, newScheduleHints(), 0, now)
+	suite.True(dbFeed.NextSync.Before(dbFeed.HubLeaseExpires))
+
+	// The poll at the scheduled time asks the hub to renew the lease
+	links := hubLinks{hub: dbFeed.Hub, topic: dbFeed.HubTopic, parsed: true}
+	sync.updateHubSubscription(context.Background(), &dbFeed, &user, links, 0, dbFeed.NextSync)
+
+	requests := hub.subscriptions()
+	suite.Require().Len(requests, 2)
+	suite.Equal("subscribe", requests[1].Get("hub.mode"))
+	suite.True(pushed(&dbFeed, dbFeed.NextSync))
+}
+
+func (suite *SyncTestSuite) TestWebSubCallbackStoredBeforeSubscribing() {
+	var verified models.Feed
+	var verifyErr error
+
+	hub := &hubFeed{items: []string{"one"}}
+	hub.verify = func(request url.Values) {
+		callback := request.Get("hub.callback")
+		verified, _, verifyErr = suite.db.FeedWithHubCallback(callback[strings.LastIndex(callback, "/")+1:])
+	}
+
+	ts := httptest.NewServer(hub)
+	defer ts.Close()
+
+	syncConfig := config.DefaultSyncConfig
+	syncConfig.IconCacheDir = ""
+	syncConfig.HostRequestSpacing = &config.Duration{}
+	syncConfig.WebSubCallback = "https://syndication.example.com"
+	sync := NewSync(suite.db, syncConfig)
+
+	feed := models.Feed{
+		Title:        "Hub Test",
+		Subscription: ts.URL + "/feed.xml",
+	}
+
+	err := suite.db.NewFeed(&feed, &suite.user)
+	suite.Require().Nil(err)
+
+	err = sync.SyncFeed(context.Background(), &feed, &suite.user)
+	suite.Require().Nil(err)
+
+	suite.Require().Len(hub.subscriptions(), 1)
+	suite.Require().Nil(verifyErr)
+	suite.Equal(feed.APIID, verified.APIID)
+	suite.Equal(feed.HubSecret, verified.HubSecret)
+	suite.Equal(ts.URL+"/feed.xml", verified.HubTopic)
+}
+
+func (suite *SyncTestSuite) TestWebSubPush() {
+	hub := &hubFeed{items: []string{"one", "two"}}
+	ts := httptest.NewServer(hub)
+	defer ts.Close()
+
+	syncConfig := config.DefaultSyncConfig
+	syncConfig.IconCacheDir = ""
+	syncConfig.HostRequestSpacing = &config.Duration{}
+	syncConfig.WebSubCallback = "https://syndication.example.com"
+	sync := NewSync(suite.db, syncConfig)
+
+	feed := models.Feed{
+		Title:        "Hub Test",
+		Subscription: ts.URL + "/feed.xml",
+	}
+
+	err := suite.db.NewFeed(&feed, &suite.user)
+	suite.Require().Nil(err)
+
+	err = sync.SyncFeed(context.Background(), &feed, &suite.user)
+	suite.Require().Nil(err)
+
+	ok, err := sync.VerifyHubSubscription(&feed, &suite.user, "subscribe", feed.HubTopic, 10*24*3600)
+	suite.Require().Nil(err)
+	suite.Require().True(ok)
+
+	hub.items = append(hub.items, "three")
+	content := hub.content(ts.URL)
+
+	err = sync.Push(&feed, &suite.user, signContent("bogus", content), content)
+	suite.IsType(BadRequest{}, err)
+
+	err = sync.Push(&feed, &suite.user, "", content)
+	suite.IsType(BadRequest{}, err)
+
+	entries, err := suite.db.EntriesFromFeed(feed.APIID, true, models.Any, &suite.user)
+	suite.Require().Nil(err)
+	suite.Len(entries, 2)
+
+	err = sync.Push(&feed, &suite.user, signContent(feed.HubSecret, content), content)
+	suite.Require().Nil(err)
+
+	entries, err = suite.db.EntriesFromFeed(feed.APIID, true, models.Any, &suite.user)
+	suite.Require().Nil(err)
+	suite.Len(entries, 3)
+
+	// A poll that finds entries the hub did not push falls back to polling
+	hub.items = append(hub.items, "four")
+
+	suite.True(pushed(&feed, time.Now()))
+
+	feed.LastUpdated = time.Now().Add(-time.Hour)
+	err = sync.SyncFeed(context.Background(), &feed, &suite.user)
+	suite.Require().Nil(err)
+
+	suite.False(pushed(&feed, time.Now()))
+	suite.True(feed.HubRetryAt.After(time.Now().Add(sync.maxInterval)))
+	suite.Len(hub.subscriptions(), 1)
+
+	entries, err = suite.db.EntriesFromFeed(feed.APIID, true, models.Any, &suite.user)
+	suite.Require().Nil(err)
+	suite.Len(entries, 4)
+}
+
+func (suite *SyncTestSuite) TestSharedFeedsSubscribeAndLookUpIconsOnce() {
+	hub := &hubFeed{items: []string{"one", "two"}}
+	site := &iconSite{requests: map[string]int{}}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/feed.xml" || r.URL.Path == "/hub" {
+			hub.ServeHTTP(w, r)
+			return
+		}
+
+		site.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "syndication-icons")
+	suite.Require().Nil(err)
+	defer os.RemoveAll(dir)
+
+	syncConfig := config.DefaultSyncConfig
+	syncConfig.IconCacheDir = dir
+	syncConfig.HostRequestSpacing = &config.Duration{}
+	syncConfig.WebSubCallback = "https://syndication.example.com/"
+	sync := NewSync(suite.db, syncConfig)
+
+	subscribers := []*models.User{&suite.user}
+	for i := 0; i < 2; i++ {
+		name := RandStringRunes(8)
+		err = suite.db.NewUser(name, "golang")
+		suite.Require().Nil(err)
+
+		user, err := suite.db.UserWithName(name)
+		suite.Require().Nil(err)
+		defer suite.db.DeleteUser(user.APIID)
+
+		subscribers = append(subscribers, &user)
+	}
+
+	for _, user := range subscribers {
+		feed := models.Feed{Title: "Shared", Subscription: ts.URL + "/feed.xml"}
+		err = suite.db.NewFeed(&feed, user)
+		suite.Require().Nil(err)
+	}
+
+	sync.SyncUsers(context.Background())
+	sync.waitGroup.Wait()
+
+	suite.Len(hub.subscriptions(), 1)
+	suite.Equal(1, site.count("/"))
+	suite.Equal(1, site.count("/favicon.ico"))
+
+	var callback string
+	for _, user := range subscribers {
+		feeds := suite.db.Feeds(user)
+		suite.Require().Len(feeds, 1)
+
+		feed := feeds[0]
+		suite.NotEmpty(feed.HubCallback)
+		suite.Equal("image/x-icon", feed.IconType)
+		if callback == "" {
+			callback = feed.HubCallback
+		}
+		suite.Equal(callback, feed.HubCallback)
+	}
+
+	shared, err := suite.db.HubSubscribers(callback)
+	suite.Require().Nil(err)
+	suite.Len(shared, len(subscribers))
+}
+
+func (suite *SyncTestSuite) TestHubLinks() {
+	links := hubLinks{}
+	links.readHeader(http.Header{"Link": {`<https://hub.example.com/>; rel="hub", <https://example.com/feed>; rel="self"`}})
+	suite.Equal("https://hub.example.com/", links.hub)
+	suite.Equal("https://example.com/feed", links.topic)
+
+	// Links in the feed do not override those in the header
+	links.readLink("hub", "https://other.example.com/")
+	suite.Equal("https://hub.example.com/", links.hub)
+
+	suite.True(validSignature("secret", signContent("secret", []byte("content")), []byte("content")))
+	suite.False(validSignature("secret", signContent("secret", []byte("content")), []byte("other")))
+	suite.False(validSignature("secret", "md5=abcd", []byte("content")))
+}
+
+// iconSite serves feeds and the pages and images they use as icons
+// and counts the requests made for each path.
+type iconSite struct {
+	requests map[string]int
+	lock     gosync.Mutex
+}
+
+const (
+	testPNG = "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"
+	testGIF = "GIF89a\x01\x00\x01\x00"
+	testICO = "\x00\x00\x01\x00\x01\x00\x10\x10"
+)
+
+func (site *iconSite) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	site.lock.Lock()
+	site.requests[r.URL.Path]++
+	site.lock.Unlock()
+
+	base := "http://" + r.Host
+	switch r.URL.Path {
+	case "/image.xml":
+		w.Write([]byte(`<rss version="2.0"><channel><title>Image</title><link>` + base + `/page</link>
+<image><url>/logo.gif</url><title>Image</title><link>` + base + `</link></image>
+<item><title>One</title><guid>one@image</guid></item></channel></rss>`))
+	case "/linked.xml":
+		w.Write([]byte(`<rss version="2.0"><channel><title>Linked</title><link>` + base + `/page</link>
+<item><title>One</title><guid>one@linked</guid></item></channel></rss>`))
+	case "/plain.xml":
+		w.Write([]byte(`<rss version="2.0"><channel><title>Plain</title><link>` + base + `/missing</link>
+<item><title>One</title><guid>one@plain</guid></item></channel></rss>`))
+	case "/page":
+		w.Write([]byte(`<html><head><base href="/static/"><link rel="stylesheet" href="style.css">
+<link rel="shortcut icon" href="icon.png"></head><body><link rel="icon" href="/ignored.png"></body></html>`))
+	case "/static/icon.png":
+		w.Write([]byte(testPNG))
+	case "/logo.gif":
+		w.Write([]byte(testGIF))
+	case "/favicon.ico":
+		w.Write([]byte(testICO))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (site *iconSite) count(path string) int {
+	site.lock.Lock()
+	defer site.lock.Unlock()
+
+	return site.requests[path]
+}
+
+func (suite *SyncTestSuite) TestFeedIcons() {
+	site := &iconSite{requests: map[string]int{}}
+	ts := httptest.NewServer(site)
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "syndication-icons")
+	suite.Require().Nil(err)
+	defer os.RemoveAll(dir)
+
+	sync := NewSync(suite.db, config.Sync{
+		SyncInterval: config.Duration{Duration: time.Minute * 15},
+		IconCacheDir: dir,
+	})
+
+	icons := map[string]struct {
+		image       string
+		contentType string
+		content     string
+	}{
+		"/image.xml":  {ts.URL + "/logo.gif", "image/gif", testGIF},
+		"/linked.xml": {ts.URL + "/static/icon.png", "image/png", testPNG},
+		"/plain.xml":  {ts.URL + "/favicon.ico", "image/x-icon", testICO},
+	}
+
+	for path, icon := range icons {
+		feed := models.Feed{
+			Subscription: ts.URL + path,
+		}
+
+		err = suite.db.NewFeed(&feed, &suite.user)
+		suite.Require().Nil(err)
+
+		err = sync.SyncFeed(context.Background(), &feed, &suite.user)
+		suite.Require().Nil(err)
+
+		suite.Equal(icon.image, feed.ImageURL, path)
+		suite.Equal(icon.contentType, feed.IconType, path)
+
+		file, _, err := sync.FeedIcon(&feed)
+		suite.Require().Nil(err, path)
+		content, err := ioutil.ReadAll(file)
+		file.Close()
+		suite.Require().Nil(err)
+		suite.Equal(icon.content, string(content), path)
+
+		dbFeed, err := suite.db.Feed(feed.APIID, &suite.user)
+		suite.Require().Nil(err)
+		suite.Equal(feed.Icon, dbFeed.Icon)
+	}
+
+	suite.Zero(site.count("/ignored.png"))
+	suite.Equal(1, site.count("/static/icon.png"))
+
+	feed := models.Feed{
+		Subscription: ts.URL + "/linked.xml",
+		Icon:         "bogus",
+	}
+
+	_, _, err = sync.FeedIcon(&feed)
+	suite.IsType(NotFound{}, err)
+
+	feed.Icon = "../" + filepath.Base(dir)
+	_, _, err = sync.FeedIcon(&feed)
+	suite.IsType(NotFound{}, err)
+}
+
+func (suite *SyncTestSuite) TestIconCacheLimits() {
+	dir, err := ioutil.TempDir("", "syndication-icons")
+	suite.Require().Nil(err)
+	defer os.RemoveAll(dir)
+
+	cache := newIconCache(dir, 8, 16)
+
+	_, err = cache.store([]byte("too large"))
+	suite.IsType(BadRequest{}, err)
+
+	first, err := cache.store([]byte("aaaaaaaa"))
+	suite.Require().Nil(err)
+
+	past := time.Now().Add(-time.Hour)
+	err = os.Chtimes(cache.path(first), past, past)
+	suite.Require().Nil(err)
+
+	second, err := cache.store([]byte("bbbbbbbb"))
+	suite.Require().Nil(err)
+	suite.True(cache.has(first))
+	suite.True(cache.has(second))
+
+	third, err := cache.store([]byte("cccccccc"))
+	suite.Require().Nil(err)
+	suite.False(cache.has(first))
+	suite.True(cache.has(second))
+	suite.True(cache.has(third))
+}
+
+const testPodcastFeed = `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0" xmlns:itunes="http://www.itunes.com/dtds/podcast-1.0.dtd" xmlns:media="http://search.yahoo.com/mrss/">
+<channel>
+	<title>Podcast</title>
+	<link>http://localhost:9090/</link>
+	<item>
+		<title>Episode 1</title>
+		<guid>episode-1</guid>
+		<enclosure url="http://localhost:9090/episode-1.mp3" type="audio/mpeg" length="2048"/>
+		<media:content url="http://localhost:9090/episode-1.mp3" fileSize="2048" duration="1830"/>
+		<itunes:duration>30:30</itunes:duration>
+		<itunes:explicit>yes</itunes:explicit>
+		<itunes:subtitle>The first one</itunes:subtitle>
+		<itunes:image href="http://localhost:9090/episode-1.jpg"/>
+	</item>
+	<item>
+		<title>Video</title>
+		<guid>video</guid>
+		<media:group>
+			<media:content url="http://localhost:9090/video-720.mp4" type="video/mp4" duration="95.5"/>
+			<media:content url="http://localhost:9090/video-1080.mp4" type="video/mp4" fileSize="4096"/>
+		</media:group>
+	</item>
+	<item>
+		<title>Notes</title>
+		<guid>notes</guid>
+	</item>
+</channel>
+</rss>`
+
+func (suite *SyncTestSuite) TestFeedEntriesWithEnclosures() {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, testPodcastFeed)
+	}))
+	defer ts.Close()
+
+	feed := models.Feed{
+		Title:        "Podcast",
+		Subscription: ts.URL,
+	}
+
+	err := suite.db.NewFeed(&feed, &suite.user)
+	suite.Require().Nil(err)
+
+	err = suite.sync.SyncFeed(context.Background(), &feed, &suite.user)
+	suite.Require().Nil(err)
+
+	entries, err := suite.db.EntriesFromFeed(feed.APIID, true, models.Any, &suite.user)
+	suite.Require().Nil(err)
+	suite.Require().Len(entries, 3)
+
+	byTitle := map[string]models.Entry{}
+	for _, entry := range entries {
+		byTitle[entry.Title] = entry
+	}
+
+	episode := byTitle["Episode 1"].Enclosures
+	suite.Require().Len(episode, 1)
+	suite.Equal("http://localhost:9090/episode-1.mp3", episode[0].URL)
+	suite.Equal("audio/mpeg", episode[0].Type)
+	suite.Equal(int64(2048), episode[0].Length)
+	suite.Equal(int64(1830), episode[0].Duration)
+	suite.Equal("http://localhost:9090/episode-1.jpg", episode[0].Image)
+	suite.Equal("The first one", episode[0].Subtitle)
+	suite.True(episode[0].Explicit)
+
+	video := byTitle["Video"].Enclosures
+	suite.Require().Len(video, 2)
+	suite.Equal("video/mp4", video[0].Type)
+	suite.Equal(int64(95), video[0].Duration)
+	suite.Equal(int64(4096), video[1].Length)
+
+	suite.Empty(byTitle["Notes"].Enclosures)
+}
+
+func (suite *SyncTestSuite) TestParseDuration() {
+	suite.Equal(int64(90), parseDuration("90"))
+	suite.Equal(int64(90), parseDuration("1:30"))
+	suite.Equal(int64(3690), parseDuration("01:01:30"))
+	suite.Equal(int64(0), parseDuration(""))
+	suite.Equal(int64(0), parseDuration("1:2:3:4"))
+	suite.Equal(int64(0), parseDuration("an hour"))
+}
+
+const testJSONFeed = `{
+	"version": "https://jsonfeed.org/version/1.1",
+	"title": "JSON Test",
+	"home_page_url": "http://localhost:9090/",
+	"feed_url": "http://localhost:9090/feed.json",
+	"description": "A JSON Feed",
+	"icon": "http://localhost:9090/icon.png",
+	"authors": [{"name": "Feed Author"}],
+	"items": [
+		{
+			"id": "http://localhost:9090/first",
+			"url": "http://localhost:9090/first",
+			"title": "First",
+			"content_html": "<p>First item</p>",
+			"summary": "The first item",
+			"date_published": "2018-01-02T15:04:05Z",
+			"authors": [{"name": ""}, {"name": "Item Author"}]
+		},
+		{
+			"id": 2,
+			"external_url": "http://example.com/second",
+			"title": "Second",
+			"content_text": "Second item",
+			"date_modified": "2018-01-03T15:04:05+02:00"
+		},
+		{
+			"title": "Episode",
+			"content_text": "An episode",
+			"author": {"name": "Old Author"},
+			"attachments": [
+				{"url": "http://localhost:9090/episode.mp3", "mime_type": "audio/mpeg", "size_in_bytes": 1024, "duration_in_seconds": 300}
+			]
+		}
+	]
+}`
+
+func (suite *SyncTestSuite) TestJSONFeed() {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/feed+json")
+		io.WriteString(w, testJSONFeed)
+	}))
+	defer ts.Close()
+
+	feed := models.Feed{
+		Title:        "JSON Test",
+		Subscription: ts.URL,
+	}
+
+	err := suite.db.NewFeed(&feed, &suite.user)
+	suite.Require().Nil(err)
+
+	err = suite.sync.SyncFeed(context.Background(), &feed, &suite.user)
+	suite.Require().Nil(err)
+
+	suite.Equal("A JSON Feed", feed.Description)
+	suite.Equal("http://localhost:9090/", feed.Source)
+
+	entries, err := suite.db.EntriesFromFeed(feed.APIID, true, models.Any, &suite.user)
+	suite.Require().Nil(err)
+	suite.Require().Len(entries, 3)
+
+	byTitle := map[string]models.Entry{}
+	for _, entry := range entries {
+		byTitle[entry.Title] = entry
+	}
+
+	first := byTitle["First"]
+	suite.Equal("http://localhost:9090/first", first.Link)
+	suite.Equal("<p>First item</p>", first.Content)
+	suite.Equal("The first item", first.Summary)
+	suite.Equal("Item Author", first.Author)
+	suite.True(first.Published.Equal(time.Date(2018, 1, 2, 15, 4, 5, 0, time.UTC)))
+
+	second := byTitle["Second"]
+	suite.Equal("http://example.com/second", second.Link)
+	suite.Equal("Second item", second.Content)
+	suite.Equal("Feed Author", second.Author)
+	suite.True(second.Published.Equal(time.Date(2018, 1, 3, 13, 4, 5, 0, time.UTC)))
+
+	episode := byTitle["Episode"]
+	suite.Equal("http://localhost:9090/episode.mp3", episode.Link)
+	suite.Equal("Old Author", episode.Author)
+	suite.Require().Len(episode.Enclosures, 1)
+	suite.Equal("audio/mpeg", episode.Enclosures[0].Type)
+	suite.Equal(int64(1024), episode.Enclosures[0].Length)
+	suite.Equal(int64(300), episode.Enclosures[0].Duration)
+
+	// Items are identified by their id, or by their title and link
+	// when they have none, so syncing again adds nothing.
+	err = suite.sync.SyncFeed(context.Background(), &feed, &suite.user)
+	suite.Require().Nil(err)
+
+	entries, err = suite.db.EntriesFromFeed(feed.APIID, true, models.Any, &suite.user)
+	suite.Require().Nil(err)
+	suite.Len(entries, 3)
+}
+
+func (suite *SyncTestSuite) TestFetchJSONFeed() {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, testJSONFeed)
+	}))
+	defer ts.Close()
+
+	feed := &models.Feed{
+		Subscription: ts.URL,
+	}
+	err := FetchFeed(context.Background(), feed)
+	suite.Require().Nil(err)
+
+	suite.Equal("JSON Test", feed.Title)
+	suite.Equal("http://localhost:9090/", feed.Source)
+	suite.Equal("http://localhost:9090/icon.png", feed.ImageURL)
+}
+
+func (suite *SyncTestSuite) TestParseJSONFeed() {
+	hints := newScheduleHints()
+
+	_, err := parseJSONFeed([]byte(`{"title": "Not a feed"}`), &hints)
+	suite.NotNil(err)
+
+	_, err = parseJSONFeed([]byte(`{"version": "https://jsonfeed.org/version/1", "items": [`), &hints)
+	suite.NotNil(err)
+
+	parsed, err := parseJSONFeed([]byte(`{
+		"version": "https://jsonfeed.org/version/1",
+		"title": "Hubs",
+		"feed_url": "http://localhost:9090/feed.json",
+		"favicon": "http://localhost:9090/favicon.ico",
+		"hubs": [{"type": "WebSub", "url": "http://localhost:9090/hub"}]
+	}`), &hints)
+	suite.Require().Nil(err)
+	suite.Equal("json", parsed.FeedType)
+	suite.Equal("1", parsed.FeedVersion)
+	suite.Equal("http://localhost:9090/favicon.ico", parsed.Image.URL)
+	suite.Equal("http://localhost:9090/hub", hints.hub.hub)
+	suite.Equal("http://localhost:9090/feed.json", hints.hub.topic)
+}
+
+func discoverySite(w http.ResponseWriter, r *http.Request) {
+	base := "http://" + r.Host
+	switch r.URL.Path {
+	case "/rss.xml":
+		io.WriteString(w, `<rss version="2.0"><channel><title>RSS Feed</title><link>`+base+`</link></channel></rss>`)
+	case "/atom.xml":
+		io.WriteString(w, `<feed xmlns="http://www.w3.org/2005/Atom"><title>Atom Feed</title><id>`+base+`/atom.xml</id></feed>`)
+	case "/feed.json":
+		io.WriteString(w, `{"version": "https://jsonfeed.org/version/1.1", "title": "JSON Feed", "items": []}`)
+	case "/":
+		io.WriteString(w, `<html><head><title>Site</title>
+<link rel="stylesheet" href="/style.css">
+<link rel="alternate" type="application/rss+xml" title="Posts" href="/rss.xml">
+<link rel="alternate" type="application/atom+xml" href="atom.xml">
+<link rel="alternate" type="application/rss+xml" title="Broken" href="/missing.xml">
+<link rel="alternate" type="application/rss+xml" title="Duplicate" href="/rss.xml">
+</head><body></body></html>`)
+	case "/single":
+		io.WriteString(w, `<html><head><link rel="alternate" type="application/feed+json" href="/feed.json"></head></html>`)
+	case "/blog/post":
+		io.WriteString(w, `<html><head><title>Post</title></head><body></body></html>`)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (suite *SyncTestSuite) TestDiscover() {
+	ts := httptest.NewServer(http.HandlerFunc(discoverySite))
+	defer ts.Close()
+
+	candidates, err := suite.sync.Discover(context.Background(), ts.URL+"/rss.xml")
+	suite.Require().Nil(err)
+	suite.Require().Len(candidates, 1)
+	suite.Equal(ts.URL+"/rss.xml", candidates[0].URL)
+	suite.Equal("RSS Feed", candidates[0].Title)
+	suite.Equal("rss", candidates[0].Type)
+	suite.NotNil(candidates[0].fetched)
+
+	candidates, err = suite.sync.Discover(context.Background(), strings.TrimPrefix(ts.URL, "http://")+"/rss.xml")
+	suite.Require().Nil(err)
+	suite.Len(candidates, 1)
+
+	candidates, err = suite.sync.Discover(context.Background(), ts.URL)
+	suite.Require().Nil(err)
+	suite.Equal([]Candidate{
+		{URL: ts.URL + "/rss.xml", Title: "Posts", Type: "rss"},
+		{URL: ts.URL + "/atom.xml", Title: "Atom Feed", Type: "atom"},
+	}, candidates)
+
+	candidates, err = suite.sync.Discover(context.Background(), ts.URL+"/single")
+	suite.Require().Nil(err)
+	suite.Equal([]Candidate{{URL: ts.URL + "/feed.json", Title: "JSON Feed", Type: "json"}}, candidates)
+
+	// Pages without alternate links fall back to the common paths of the site
+	candidates, err = suite.sync.Discover(context.Background(), ts.URL+"/blog/post")
+	suite.Require().Nil(err)
+	suite.Equal([]Candidate{{URL: ts.URL + "/rss.xml", Title: "RSS Feed", Type: "rss"}}, candidates)
+
+	_, err = suite.sync.Discover(context.Background(), ts.URL+"/missing")
+	suite.IsType(BadRequest{}, err)
+
+	_, err = suite.sync.Discover(context.Background(), "ftp://example.com/feed.xml")
+	suite.IsType(BadRequest{}, err)
+}
+
+func (suite *SyncTestSuite) TestDiscoverWithoutFeeds() {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+
+		io.WriteString(w, `<html><head><title>Nothing</title></head></html>`)
+	}))
+	defer ts.Close()
+
+	_, err := suite.sync.Discover(context.Background(), ts.URL)
+	suite.IsType(BadRequest{}, err)
+}
+
+func (suite *SyncTestSuite) TestHostConcurrencyLimit() {
+	var lock gosync.Mutex
+	inFlight, maxInFlight := 0, 0
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		lock.Unlock()
+
+		time.Sleep(time.Millisecond * 50)
+
+		lock.Lock()
+		inFlight--
+		lock.Unlock()
+	}))
+	defer ts.Close()
+
+	client := &http.Client{Transport: newHostLimiter(2, 0, time.Hour)}
+
+	var wg gosync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.Get(ts.URL)
+			if suite.Nil(err) {
+				resp.Body.Close()
+			}
+		}()
+	}
+	wg.Wait()
+
+	suite.Equal(2, maxInFlight)
+}
+
+func (suite *SyncTestSuite) TestHostRequestSpacing() {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	client := &http.Client{Transport: newHostLimiter(2, time.Millisecond*200, time.Hour)}
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		resp, err := client.Get(ts.URL)
+		suite.Require().Nil(err)
+		resp.Body.Close()
+	}
+
+	suite.True(time.Since(start) >= time.Millisecond*400)
+}
+
+func (suite *SyncTestSuite) TestRetryAfter() {
+	var lock gosync.Mutex
+	hits := 0
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		hits++
+		lock.Unlock()
+
+		w.Header().Set("Retry-After", "120")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer ts.Close()
+
+	sync := NewSync(suite.db, config.Sync{SyncInterval: config.Duration{Duration: time.Minute}})
+
+	feed := models.Feed{
+		Title:        "Busy",
+		Subscription: ts.URL + "/feed.xml",
+	}
+
+	err := suite.db.NewFeed(&feed, &suite.user)
+	suite.Require().Nil(err)
+
+	err = sync.SyncFeed(context.Background(), &feed, &suite.user)
+	suite.Require().Nil(err)
+
+	syncedFeed, err := suite.db.Feed(feed.APIID, &suite.user)
+	suite.Require().Nil(err)
+	suite.Zero(syncedFeed.Failures)
+	suite.Empty(syncedFeed.LastError)
+	suite.WithinDuration(time.Now().Add(time.Minute*2), syncedFeed.NextSync, time.Second*5)
+
+	err = sync.SyncFeed(context.Background(), &feed, &suite.user)
+	suite.Require().Nil(err)
+	suite.Equal(1, hits)
+}
+
+// hangingServer serves requests that never complete, until they are cancelled.
+func hangingServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second * 30):
+		}
+	}))
+}
+
+func (suite *SyncTestSuite) TestReadTimeout() {
+	ts := hangingServer()
+	defer ts.Close()
+
+	sync := NewSync(suite.db, config.Sync{
+		SyncInterval: config.Duration{Duration: time.Minute},
+		ReadTimeout:  config.Duration{Duration: time.Millisecond * 200},
+	})
+
+	feed := models.Feed{
+		Title:        "Hanging",
+		Subscription: ts.URL,
+	}
+
+	err := suite.db.NewFeed(&feed, &suite.user)
+	suite.Require().Nil(err)
+
+	start := time.Now()
+	err = sync.SyncFeed(context.Background(), &feed, &suite.user)
+	suite.IsType(BadRequest{}, err)
+	suite.True(time.Since(start) < time.Second*5)
+
+	syncedFeed, err := suite.db.Feed(feed.APIID, &suite.user)
+	suite.Require().Nil(err)
+	suite.Equal(1, syncedFeed.Failures)
+}
+
+func (suite *SyncTestSuite) TestMaxFeedSize() {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, testPodcastFeed)
+	}))
+	defer ts.Close()
+
+	sync := NewSync(suite.db, config.Sync{
+		SyncInterval: config.Duration{Duration: time.Minute},
+		MaxFeedSize:  100,
+	})
+
+	feed := models.Feed{
+		Title:        "Large",
+		Subscription: ts.URL,
+	}
+
+	err := suite.db.NewFeed(&feed, &suite.user)
+	suite.Require().Nil(err)
+
+	err = sync.SyncFeed(context.Background(), &feed, &suite.user)
+	suite.Require().IsType(BadRequest{}, err)
+	suite.Contains(err.Error(), "larger than 100 bytes")
+
+	entries, err := suite.db.EntriesFromFeed(feed.APIID, true, models.Any, &suite.user)
+	suite.Require().Nil(err)
+	suite.Empty(entries)
+}
+
+func (suite *SyncTestSuite) TestCancelledSyncFeed() {
+	ts := hangingServer()
+	defer ts.Close()
+
+	feed := models.Feed{
+		Title:        "Hanging",
+		Subscription: ts.URL,
+	}
+
+	err := suite.db.NewFeed(&feed, &suite.user)
+	suite.Require().Nil(err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*200)
+	defer cancel()
+
+	err = suite.sync.SyncFeed(ctx, &feed, &suite.user)
+	suite.Equal(context.DeadlineExceeded, err)
+
+	syncedFeed, err := suite.db.Feed(feed.APIID, &suite.user)
+	suite.Require().Nil(err)
+	suite.Zero(syncedFeed.Failures)
+	suite.True(syncedFeed.LastFetched.IsZero())
+}
+
+func (suite *SyncTestSuite) TestStopCancelsSyncs() {
+	ts := hangingServer()
+	defer ts.Close()
+
+	feed := models.Feed{
+		Title:        "Hanging",
+		Subscription: ts.URL,
+	}
+
+	err := suite.db.NewFeed(&feed, &suite.user)
+	suite.Require().Nil(err)
+
+	sync := NewSync(suite.db, config.Sync{
+		SyncInterval: config.Duration{Duration: time.Second},
+		StopTimeout:  config.Duration{Duration: time.Second * 5},
+	})
+
+	sync.Start()
+	time.Sleep(time.Millisecond * 1500)
+
+	start := time.Now()
+	sync.Stop()
+	suite.True(time.Since(start) < time.Second*2)
+
+	syncedFeed, err := suite.db.Feed(feed.APIID, &suite.user)
+	suite.Require().Nil(err)
+	suite.Zero(syncedFeed.Failures)
+}
+
+// stuckTransport never answers, even once the request is cancelled.
+type stuckTransport struct{}
+
+func (stuckTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	time.Sleep(time.Second * 30)
+	return nil, errors.New("stuck")
+}
+
+func (suite *SyncTestSuite) TestStopReturnsWithinStopTimeout() {
+	feed := models.Feed{
+		Title:        "Stuck",
+		Subscription: "http://localhost:9090/rss.xml",
+	}
+
+	err := suite.db.NewFeed(&feed, &suite.user)
+	suite.Require().Nil(err)
+
+	sync := NewSync(suite.db, config.Sync{
+		SyncInterval: config.Duration{Duration: time.Second},
+		StopTimeout:  config.Duration{Duration: time.Millisecond * 500},
+	})
+	sync.hosts.transport = stuckTransport{}
+
+	sync.Start()
+	time.Sleep(time.Millisecond * 1500)
+
+	start := time.Now()
+	sync.Stop()
+	suite.True(time.Since(start) < time.Second)
+}
+
+func (suite *SyncTestSuite) TestParseRetryAfter() {
+	now := time.Date(2018, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+	until, ok := parseRetryAfter("90", now, time.Hour)
+	suite.True(ok)
+	suite.Equal(now.Add(time.Second*90), until)
+
+	until, ok = parseRetryAfter("Thu, 01 Mar 2018 12:30:00 GMT", now, time.Hour)
+	suite.True(ok)
+	suite.Equal(now.Add(time.Minute*30), until.UTC())
+
+	until, ok = parseRetryAfter("86400", now, time.Hour)
+	suite.True(ok)
+	suite.Equal(now.Add(time.Hour), until)
+
+	_, ok = parseRetryAfter("Thu, 01 Mar 2018 11:00:00 GMT", now, time.Hour)
+	suite.False(ok)
+
+	_, ok = parseRetryAfter("soon", now, time.Hour)
+	suite.False(ok)
+}
+
+func (suite *SyncTestSuite) TestRulesApplyDuringSync() {
+	feed := models.Feed{
+		Title:        "Sync Test",
+		Subscription: "http://localhost:9090/rss.xml",
+	}
+
+	err := suite.db.NewFeed(&feed, &suite.user)
+	suite.Require().Nil(err)
+
+	ctg := models.Category{
+		Name: "Other",
+	}
+
+	err = suite.db.NewCategory(&ctg, &suite.user)
+	suite.Require().Nil(err)
+
+	tag := models.Tag{
+		Name: "varddum",
+	}
+
+	err = suite.db.NewTag(&tag, &suite.user)
+	suite.Require().Nil(err)
+
+	rules := []models.Rule{
+		{Field: models.TitleField, Pattern: "Item [12]$", Action: models.MarkAction, Value: "read"},
+		{Field: models.LinkField, Pattern: "item_3", Action: models.DiscardAction},
+		{FeedID: feed.APIID, Field: models.AuthorField, Pattern: "^varddum$", Action: models.TagAction, Value: tag.APIID},
+		{CategoryID: ctg.APIID, Action: models.SaveAction},
+		{Field: models.TitleField, Pattern: "Item 5", Action: models.SaveAction},
+	}
+
+	for i := range rules {
+		err = suite.db.NewRule(&rules[i], &suite.user)
+		suite.Require().Nil(err)
+	}
+
+	err = suite.sync.SyncFeed(context.Background(), &feed, &suite.user)
+	suite.Require().Nil(err)
+
+	entries, err := suite.db.EntriesFromFeed(feed.APIID, true, models.Any, &suite.user)
+	suite.Require().Nil(err)
+	suite.Require().Len(entries, 4)
+
+	for _, entry := range entries {
+		suite.NotEqual("Item 3", entry.Title)
+
+		if entry.Title == "Item 1" || entry.Title == "Item 2" {
+			suite.Equal(models.Marker(models.Read), entry.Mark)
+		} else {
+			suite.Equal(models.Marker(models.Unread), entry.Mark)
+		}
+
+		suite.Equal(entry.Title == "Item 5", entry.Saved)
+	}
+
+	tagged, err := suite.db.EntriesFromTag(tag.APIID, models.Any, true, &suite.user)
+	suite.Require().Nil(err)
+	suite.Len(tagged, 4)
+}
+
+func (suite *SyncTestSuite) TestRuleCache() {
+	rule := models.Rule{Field: models.TitleField, Pattern: "Item 1$", Action: models.DiscardAction}
+	err := suite.db.NewRule(&rule, &suite.user)
+	suite.Require().Nil(err)
+
+	rules := suite.sync.newRuleCache()
+	suite.Len(rules.get(&suite.user), 1)
+
+	other := models.Rule{Field: models.TitleField, Pattern: "Item 2$", Action: models.DiscardAction}
+	err = suite.db.NewRule(&other, &suite.user)
+	suite.Require().Nil(err)
+
+	// Rules are compiled once per run until they are edited
+	suite.Len(rules.get(&suite.user), 1)
+
+	suite.sync.RulesEdited(&suite.user)
+	suite.Len(rules.get(&suite.user), 2)
+}
+
+func (suite *SyncTestSuite) TestDryRunRule() {
+	feed := models.Feed{
+		Title:        "Sync Test",
+		Subscription: "http://localhost:9090/rss.xml",
+	}
+
+	err := suite.db.NewFeed(&feed, &suite.user)
+	suite.Require().Nil(err)
+
+	err = suite.sync.SyncFeed(context.Background(), &feed, &suite.user)
+	suite.Require().Nil(err)
+
+	rule := models.Rule{
+		FeedID:  feed.APIID,
+		Field:   models.TitleField,
+		Pattern: "Item [45]",
+		Action:  models.DiscardAction,
+	}
+
+	err = suite.db.NewRule(&rule, &suite.user)
+	suite.Require().Nil(err)
+
+	matched, err := suite.sync.DryRunRule(rule.APIID, &suite.user)
+	suite.Require().Nil(err)
+	suite.Len(matched, 2)
+
+	entries, err := suite.db.EntriesFromFeed(feed.APIID, true, models.Any, &suite.user)
+	suite.Require().Nil(err)
+	suite.Len(entries, 5)
+
+	_, err = suite.sync.DryRunRule("bogus", &suite.user)
+	suite.NotNil(err)
+}
+
+func (suite *SyncTestSuite) TestUserThreadAllocation() {
+	for i := 0; i < 150; i++ {
+		err := suite.db.NewUser("test"+strconv.Itoa(i), "test"+strconv.Itoa(i))
+		suite.Require().Nil(err)
+
+		user, err := suite.db.UserWithName("test" + strconv.Itoa(i))
+		suite.Require().Nil(err)
+
+		feed := models.Feed{
+			Title:        "Sync Test",
+			Subscription: "http://localhost:9090/rss_minimal.xml",
+		}
+
+		err = suite.db.NewFeed(&feed, &user)
+		suite.Require().Nil(err)
+	}
+
+	suite.sync = NewSync(suite.db, config.Sync{SyncInterval: config.Duration{Duration: time.Second * 2}})
+
+	suite.sync.Start()
+
+	time.Sleep(time.Second * 3)
+
+	suite.sync.Stop()
+
+	users := suite.db.Users()
+	users = users[1:]
+
+	for _, user := range users {
+		entries, err := suite.db.Entries(true, models.Any, &user)
+		suite.Require().Nil(err)
+		suite.Len(entries, 5)
+	}
+}
+
+func (suite *SyncTestSuite) startServer() {
+	suite.db, _ = database.NewDB(config.Database{
+		Type:       "sqlite3",
+		Connection: TestDatabasePath,
+	})
+
+	suite.server = &http.Server{
+		Addr:    ":9090",
+		Handler: suite,
+	}
+
+	go suite.server.ListenAndServe()
+
+	time.Sleep(time.Second)
+
+	suite.sync = NewSync(suite.db, config.Sync{SyncInterval: config.Duration{Duration: time.Second * 5}})
+}
+
+func TestSyncTestSuite(t *testing.T) {
+	syncSuite := SyncTestSuite{}
+	syncSuite.startServer()
+
+	suite.Run(t, &syncSuite)
+
+	syncSuite.db.Close()
+	syncSuite.server.Close()
+	os.Remove(TestDatabasePath)
+}
+
+const (
+	benchmarkDatabasePath = "/tmp/syndication-bench-sync.db"
+	benchmarkUsers        = 10
+	benchmarkFeeds        = 10
+	benchmarkItems        = 20
+	benchmarkLatency      = time.Millisecond * 20
+)
+
+// benchmarkFeed returns an RSS feed whose items are unique to path.
+func benchmarkFeed(path string) string {
+	var feed bytes.Buffer
+	feed.WriteString(`<?xml version="1.0" encoding="UTF-8"?><rss version="2.0"><channel><title>Benchmark</title>`)
+	for i := 0; i < benchmarkItems; i++ {
+		item := path + "/" + strconv.Itoa(i)
+		feed.WriteString("<item><title>" + item + "</title><guid>" + item + "</guid><description>Benchmark item</description></item>")
+	}
+	feed.WriteString("</channel></rss>")
+	return feed.String()
+}
+
+// benchmarkSync times run syncing the feeds of benchmarkUsers users, each
+// with benchmarkFeeds feeds of their own, into a new database every time.
+func benchmarkSync(b *testing.B, run func(s *Sync, db *database.DB)) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(benchmarkLatency)
+		io.WriteString(w, benchmarkFeed(r.URL.Path))
+	}))
+	defer ts.Close()
+
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		os.Remove(benchmarkDatabasePath)
+
+		db, err := database.NewDB(config.Database{
+			Type:       "sqlite3",
+			Connection: benchmarkDatabasePath,
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		for u := 0; u < benchmarkUsers; u++ {
+			name := "bench" + strconv.Itoa(u)
+			if err := db.NewUser(name, "golang"); err != nil {
+				b.Fatal(err)
+			}
+
+			user, err := db.UserWithName(name)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			for f := 0; f < benchmarkFeeds; f++ {
+				feed := models.Feed{
+					Title:        "Benchmark",
+					Subscription: ts.URL + "/" + name + "/" + strconv.Itoa(f),
+				}
+
+				if err := db.NewFeed(&feed, &user); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+
+		sync := NewSync(db, config.Sync{
+			SyncInterval:       config.Duration{Duration: time.Minute},
+			MaxRequestsPerHost: maxThreads,
+		})
+
+		b.StartTimer()
+		run(sync, db)
+		b.StopTimer()
+
+		db.Close()
+	}
+
+	os.Remove(benchmarkDatabasePath)
+}
+
+// BenchmarkSyncFeedByFeed syncs every feed after the other, like
+// syncs did before feeds went through a pipeline.
+func BenchmarkSyncFeedByFeed(b *testing.B) {
+	benchmarkSync(b, func(s *Sync, db *database.DB) {
+		for _, user := range db.Users() {
+			for _, feed := range db.Feeds(&user) {
+				if err := s.SyncFeed(context.Background(), &feed, &user); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+}
+
+func BenchmarkSyncPipeline(b *testing.B) {
+	benchmarkSync(b, func(s *Sync, db *database.DB) {
+		s.pipeline(context.Background(), db.SharedFeeds())
+	})
 }
@@ -0,0 +1,318 @@
+/*
+  Copyright (C) 2017 Jorge Martinez Hernandez
+
+  This program is free software: you can redistribute it and/or modify
+  it under the terms of the GNU Affero General Public License as published by
+  the Free Software Foundation, either version 3 of the License, or
+  (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU Affero General Public License for more details.
+
+  You should have received a copy of the GNU Affero General Public License
+  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package sync
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"hash"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/varddum/syndication/models"
+
+	"github.com/mmcdole/gofeed/atom"
+	"github.com/mmcdole/gofeed/rss"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// hubRenewal sets when a WebSub subscription is renewed: once
+	// 1/hubRenewal of its lease is left.
+	hubRenewal = 10
+
+	// defaultHubLease is used for subscriptions the hub
+	// verified without giving a lease.
+	defaultHubLease = 24 * time.Hour
+)
+
+// hubLinks are the WebSub hub and topic advertised by a feed.
+// HTTP Link headers take precedence over links in the feed itself.
+type hubLinks struct {
+	hub    string
+	topic  string
+	parsed bool
+}
+
+func (l *hubLinks) readLink(rels, href string) {
+	href = strings.TrimSpace(href)
+	if href == "" {
+		return
+	}
+
+	for _, rel := range strings.Fields(rels) {
+		switch strings.ToLower(rel) {
+		case "hub":
+			if l.hub == "" {
+				l.hub = href
+			}
+		case "self":
+			if l.topic == "" {
+				l.topic = href
+			}
+		}
+	}
+}
+
+// readHeader records the hub and self links of a response's Link headers.
+func (l *hubLinks) readHeader(header http.Header) {
+	for _, value := range header["Link"] {
+		for _, link := range strings.Split(value, ",") {
+			parts := strings.Split(link, ";")
+			href := strings.TrimSpace(parts[0])
+			if !strings.HasPrefix(href, "<") || !strings.HasSuffix(href, ">") {
+				continue
+			}
+
+			for _, param := range parts[1:] {
+				param = strings.TrimSpace(param)
+				if strings.HasPrefix(strings.ToLower(param), "rel=") {
+					l.readLink(strings.Trim(param[len("rel="):], `"`), strings.Trim(href, "<>"))
+				}
+			}
+		}
+	}
+}
+
+// readRSS records the hub and self links an RSS feed includes as atom:link elements.
+func (l *hubLinks) readRSS(feed *rss.Feed) {
+	for _, extension := range feed.Extensions {
+		for _, link := range extension["link"] {
+			l.readLink(link.Attrs["rel"], link.Attrs["href"])
+		}
+	}
+}
+
+func (l *hubLinks) readAtom(feed *atom.Feed) {
+	for _, link := range feed.Links {
+		l.readLink(link.Rel, link.Href)
+	}
+}
+
+// pushed reports whether feed has a verified WebSub subscription.
+func pushed(feed *models.Feed, now time.Time) bool {
+	return feed.HubLeaseExpires.After(now)
+}
+
+// updateHubSubscription subscribes feed to the hub it advertises and renews
+// subscriptions whose lease is about to expire. A hub that did not push the
+// new entries a poll found is assumed to have stopped delivering, so the feed
+// falls back to polling for a while before subscribing again.
+func (s *Sync) updateHubSubscription(ctx context.Context, feed *models.Feed, user *models.User, links hubLinks, newEntries int, now time.Time) {
+	if s.callbackURL == "" || !links.parsed {
+		return
+	}
+
+	if links.hub == "" {
+		feed.Hub = ""
+		feed.HubTopic = ""
+		feed.HubLeaseExpires = time.Time{}
+		feed.HubLeaseRenews = time.Time{}
+		return
+	}
+
+	if pushed(feed, now) && newEntries > 0 {
+		log.Warn("Hub " + feed.Hub + " stopped delivering " + feed.Subscription)
+		feed.HubLeaseExpires = time.Time{}
+		feed.HubLeaseRenews = time.Time{}
+		feed.HubRetryAt = now.Add(s.maxInterval * deadBackoff)
+		return
+	}
+
+	if now.Before(feed.HubRetryAt) {
+		return
+	}
+
+	topic := links.topic
+	if topic == "" {
+		topic = feed.Subscription
+	}
+
+	if feed.Hub == links.hub && feed.HubTopic == topic && pushed(feed, now) && now.Before(feed.HubLeaseRenews) {
+		return
+	}
+
+	// Retry later if the hub never verifies the subscription
+	feed.HubRetryAt = now.Add(s.maxInterval)
+
+	if err := s.subscribe(ctx, feed, user, links.hub, topic); err != nil {
+		log.Error(err)
+	}
+}
+
+// subscribe asks hub to push updates of topic to the callback of feed.
+// The hub verifies the subscription with a separate request to the callback,
+// which can arrive before the sync is persisted, so the callback and its
+// secret are stored before the hub is asked.
+func (s *Sync) subscribe(ctx context.Context, feed *models.Feed, user *models.User, hub, topic string) error {
+	if feed.HubCallback == "" || feed.Hub != hub {
+		callback, err := randomToken()
+		if err != nil {
+			return err
+		}
+
+		secret, err := randomToken()
+		if err != nil {
+			return err
+		}
+
+		feed.HubCallback = callback
+		feed.HubSecret = secret
+		feed.HubLeaseExpires = time.Time{}
+		feed.HubLeaseRenews = time.Time{}
+	}
+
+	feed.Hub = hub
+	feed.HubTopic = topic
+
+	if err := s.db.EditFeedSyncState(feed, user); err != nil {
+		return err
+	}
+
+	form := url.Values{
+		"hub.mode":     {"subscribe"},
+		"hub.topic":    {topic},
+		"hub.callback": {strings.TrimSuffix(s.callbackURL, "/") + "/websub/" + feed.HubCallback},
+		"hub.secret":   {feed.HubSecret},
+	}
+
+	req, err := http.NewRequest("POST", hub, strings.NewReader(form.Encode()))
+	if err != nil {
+		return BadRequest{err.Error()}
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := (&redirects{transport: s.hosts}).client().Do(req.WithContext(ctx))
+	if err != nil {
+		return BadRequest{err.Error()}
+	}
+
+	if err = resp.Body.Close(); err != nil {
+		log.Error(err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return BadRequest{"Hub responded with " + resp.Status}
+	}
+
+	return nil
+}
+
+// VerifyHubSubscription answers a hub that verifies a subscription request
+// for feed. Only subscriptions that were requested are confirmed and a
+// denied subscription makes the feed fall back to polling.
+func (s *Sync) VerifyHubSubscription(feed *models.Feed, user *models.User, mode, topic string, leaseSeconds int) (bool, error) {
+	if feed.Hub == "" || topic != feed.HubTopic {
+		return false, nil
+	}
+
+	now := time.Now()
+	switch mode {
+	case "subscribe":
+		lease := time.Duration(leaseSeconds) * time.Second
+		if lease <= 0 {
+			lease = defaultHubLease
+		}
+
+		feed.HubLeaseExpires = now.Add(lease)
+		feed.HubLeaseRenews = feed.HubLeaseExpires.Add(-lease / hubRenewal)
+		feed.HubRetryAt = time.Time{}
+
+		// The feed has to be polled in time to renew the lease.
+		if feed.NextSync.After(feed.HubLeaseRenews) {
+			feed.NextSync = feed.HubLeaseRenews
+		}
+	case "denied":
+		feed.HubLeaseExpires = time.Time{}
+		feed.HubLeaseRenews = time.Time{}
+		feed.HubRetryAt = now.Add(s.maxInterval * deadBackoff)
+	default:
+		return false, nil
+	}
+
+	if err := s.db.EditFeedSyncState(feed, user); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Push stores the entries in content that a hub delivered for feed,
+// the same way SyncFeed stores the entries of a fetched feed. Content
+// that is not signed with the secret of the feed is rejected.
+func (s *Sync) Push(feed *models.Feed, user *models.User, signature string, content []byte) error {
+	if feed.HubSecret == "" || !validSignature(feed.HubSecret, signature, content) {
+		return BadRequest{"Content has an invalid signature"}
+	}
+
+	hints := newScheduleHints()
+	entries, err := s.parseEntries(feed, user, content, &hints)
+	if err != nil {
+		return BadRequest{err.Error()}
+	}
+
+	_, err = s.save(feed, entries, s.rules(user), user)
+	return err
+}
+
+// validSignature checks an X-Hub-Signature header of
+// the form method=hex against the HMAC of content.
+func validSignature(secret, signature string, content []byte) bool {
+	parts := strings.SplitN(signature, "=", 2)
+	if len(parts) != 2 {
+		return false
+	}
+
+	var hasher func() hash.Hash
+	switch parts[0] {
+	case "sha1":
+		hasher = sha1.New
+	case "sha256":
+		hasher = sha256.New
+	case "sha384":
+		hasher = sha512.New384
+	case "sha512":
+		hasher = sha512.New
+	default:
+		return false
+	}
+
+	expected, err := hex.DecodeString(parts[1])
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(hasher, []byte(secret))
+	mac.Write(content)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+func randomToken() (string, error) {
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(token), nil
+}